@@ -2,6 +2,7 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"os"
 	"reflect"
@@ -18,6 +19,8 @@ type ServerProperties struct {
 	AppendOnly        bool   `cfg:"appendonly"`
 	AppendFilename    string `cfg:"appendfilename"`
 	AppendFsync       string `cfg:"appendfsync"`
+	AppendDirname     string `cfg:"appenddirname"`
+	AofUseRdbPreamble bool   `cfg:"aof-use-rdb-preamble"`
 	MaxClients        int    `cfg:"maxclients"`
 	RequirePass       string `cfg:"requirepass"`
 	Databases         int    `cfg:"databases"`
@@ -27,8 +30,61 @@ type ServerProperties struct {
 	SlaveAnnounceIP   string `cfg:"slave-announce-ip"`
 	ReplTimeout       int    `cfg:"repl-timeout"`
 
+	// Save are rules to save rdb automatically, "900 1 300 10" means saving if there is at least 1 change
+	// in 900 seconds or at least 10 changes in 300 seconds, empty means disabled, see ParseSaveParams
+	Save string `cfg:"save"`
+
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
+
+	// NodeID is embedded in ids generated by ID.NEXT, negative means derive it from hash of self
+	NodeID int `cfg:"node-id"`
+	// IDMaxClockBackward is how many milliseconds ID.NEXT waits when clock moved backwards before returning error
+	IDMaxClockBackward int `cfg:"id-max-clock-backward"`
+
+	// small collections use compact encodings until they grow past these thresholds
+	HashMaxListpackEntries int `cfg:"hash-max-listpack-entries"`
+	HashMaxListpackValue   int `cfg:"hash-max-listpack-value"`
+	SetMaxIntsetEntries    int `cfg:"set-max-intset-entries"`
+	SetMaxListpackEntries  int `cfg:"set-max-listpack-entries"`
+	SetMaxListpackValue    int `cfg:"set-max-listpack-value"`
+	ZSetMaxListpackEntries int `cfg:"zset-max-listpack-entries"`
+	ZSetMaxListpackValue   int `cfg:"zset-max-listpack-value"`
+
+	// classes of keyspace events to be notified, such as "KEA", empty means disabled
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+
+	// messages published to channels matching these patterns are retained, subscribers can replay them
+	DurableTopics []string `cfg:"durable-topics"`
+	// TopicRetentionSize is the max number of messages retained for each durable topic
+	TopicRetentionSize int `cfg:"topic-retention-size"`
+	// TopicRetentionTime is how many seconds a retained message lives, 0 means no time limit
+	TopicRetentionTime int `cfg:"topic-retention-time"`
+
+	// MaxMemory limits memory used by data such as "2gb", 0 means no limit, see ParseMemory
+	MaxMemory string `cfg:"maxmemory"`
+	// MaxMemoryPolicy decides which key to evict when MaxMemory is reached, such as allkeys-lru
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	// MaxMemorySamples is the number of keys sampled from each db to find a key to evict
+	MaxMemorySamples int `cfg:"maxmemory-samples"`
+	// ActiveExpireEffort from 1 to 10, the greater the more cpu active expire cycle uses to remove expired keys
+	ActiveExpireEffort int `cfg:"active-expire-effort"`
+	// LfuLogFactor decides how many hits are needed to saturate the lfu counter
+	LfuLogFactor int `cfg:"lfu-log-factor"`
+	// LfuDecayTime is minutes to decrement the lfu counter by one, 0 means never decay
+	LfuDecayTime int `cfg:"lfu-decay-time"`
+
+	// LazyfreeLazyEviction frees values of keys evicted by maxmemory in background
+	LazyfreeLazyEviction bool `cfg:"lazyfree-lazy-eviction"`
+	// LazyfreeLazyExpire frees values of expired keys in background
+	LazyfreeLazyExpire bool `cfg:"lazyfree-lazy-expire"`
+	// LazyfreeLazyServerDel frees values deleted as side effect of commands such as RENAME in background
+	LazyfreeLazyServerDel bool `cfg:"lazyfree-lazy-server-del"`
+
+	// DataDictSize is the initial number of shards of keyspace dict of each db, it grows and shrinks with keys
+	DataDictSize int `cfg:"data-dict-size"`
+	// TtlDictSize is the initial number of shards of ttl dict of each db
+	TtlDictSize int `cfg:"ttl-dict-size"`
 }
 
 // Properties holds global config properties
@@ -36,15 +92,39 @@ var Properties *ServerProperties
 
 func init() {
 	// default config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+	Properties = defaultProperties()
+	Properties.Bind = "127.0.0.1"
+	Properties.Port = 6379
+	Properties.AppendOnly = false
+}
+
+// defaultProperties returns properties which have a non-zero default value even if missing in config file
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
+		NodeID:                 -1,
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		SetMaxListpackEntries:  128,
+		SetMaxListpackValue:    64,
+		ZSetMaxListpackEntries: 128,
+		ZSetMaxListpackValue:   64,
+		TopicRetentionSize:     10000,
+		MaxMemoryPolicy:        "noeviction",
+		MaxMemorySamples:       5,
+		LfuLogFactor:           10,
+		ActiveExpireEffort:     1,
+		LfuDecayTime:           1,
+		AppendFilename:         "appendonly.aof",
+		AppendDirname:          "appendonlydir",
+		AofUseRdbPreamble:      true,
+		DataDictSize:           1 << 4,
+		TtlDictSize:            1 << 4,
 	}
 }
 
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	config := defaultProperties()
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -101,6 +181,66 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// memoryUnits are units of memory size in config, case-insensitive like redis.conf
+var memoryUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	// longer suffix first
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses memory size such as "100", "1k", "1kb", "2gb" into bytes
+// 1k => 1000 bytes, 1kb => 1024 bytes
+func ParseMemory(raw string) (uint64, error) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	if value == "" {
+		return 0, nil
+	}
+	unit := uint64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			unit = u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid memory size: " + raw)
+	}
+	return n * unit, nil
+}
+
+// SaveParam is a rule of `save`, rdb is saved if there are at least Changes changes in Seconds seconds
+type SaveParam struct {
+	Seconds int
+	Changes int
+}
+
+// ParseSaveParams parses rules of `save` such as "900 1 300 10", empty string or "" means no rule
+func ParseSaveParams(raw string) ([]SaveParam, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 1 && fields[0] == `""` {
+		return nil, nil
+	}
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save params: " + raw)
+	}
+	params := make([]SaveParam, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, errors.New("invalid save params: " + raw)
+		}
+		params = append(params, SaveParam{Seconds: seconds, Changes: changes})
+	}
+	return params, nil
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -1,9 +1,12 @@
 package aof
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,8 +18,15 @@ import (
 	"slava/internal/utils"
 	"slava/pkg/connection"
 	"slava/pkg/logger"
+
+	"github.com/hdt3213/rdb/core"
 )
 
+// rdbMagic is the beginning of rdb file, aof file starting with it has a rdb preamble
+var rdbMagic = []byte("REDIS")
+
+const rdbChecksumLen = 8
+
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
@@ -37,6 +47,8 @@ type payload struct {
 	cmdLine CmdLine
 	dbIndex int
 	wg      *sync.WaitGroup
+	// callback is called by aof goroutine with aof paused instead of writing cmdLine, see Persister.barrier
+	callback func()
 }
 
 // Listener will be called-back after receiving a aof payload
@@ -48,14 +60,20 @@ type Listener interface {
 
 // Persister receive msgs from channel and write to AOF file
 type Persister struct {
-	ctx         context.Context
-	cancel      context.CancelFunc
-	db          database.DBEngine
-	tmpDBMaker  func() database.DBEngine
-	aofChan     chan *payload
-	aofFile     *os.File
+	ctx     context.Context
+	cancel  context.CancelFunc
+	db      database.DBEngine
+	aofChan chan *payload
+	// aofFile is the incr file which commands are appended to
+	aofFile *os.File
+	// aofDir holds files of aof and manifest, see manifest.go
+	aofDir string
+	// aofFilename is the prefix of names of aof files
 	aofFilename string
+	manifest    *manifest
 	aofFsync    string
+	// rewriting is 1 during aof rewriting
+	rewriting int32
 	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shut down
 	aofFinished chan struct{}
 	// pause aof for start/finish aof rewrite progress
@@ -66,22 +84,43 @@ type Persister struct {
 	buffer []CmdLine
 }
 
-// NewPersister creates a new aof.Persister
-func NewPersister(db database.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() database.DBEngine) (*Persister, error) {
+// NewPersister creates a new aof.Persister with files in dirname,
+// an aof file named filename in working directory is moved into dirname as base file
+func NewPersister(db database.DBEngine, dirname string, filename string, load bool, fsync string) (*Persister, error) {
 	persister := &Persister{}
-	persister.aofFilename = filename
+	persister.aofDir = dirname
+	persister.aofFilename = filepath.Base(filename)
 	persister.aofFsync = strings.ToLower(fsync)
 	persister.db = db
-	persister.tmpDBMaker = tmpDBMaker
 	persister.currentDB = 0
+	err := os.MkdirAll(dirname, 0755)
+	if err != nil {
+		return nil, err
+	}
+	persister.manifest, err = persister.prepareManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+	// temp files are left by interrupted rewriting
+	if tempFiles, err := filepath.Glob(filepath.Join(dirname, tempPrefix+"*")); err == nil {
+		for _, tempFile := range tempFiles {
+			_ = os.Remove(tempFile)
+		}
+	}
 	if load {
-		persister.LoadAof(0)
+		persister.LoadAof()
+	}
+	if incr := persister.manifest.lastIncr(); incr != nil {
+		persister.aofFile, err = os.OpenFile(filepath.Join(dirname, incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	} else {
+		_, err = persister.openNewIncrFile()
 	}
-	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, err
 	}
-	persister.aofFile = aofFile
+	if err := persister.deleteHistory(); err != nil {
+		logger.Warn("delete history aof files failed: " + err.Error())
+	}
 	persister.aofChan = make(chan *payload, aofQueueSize)
 	persister.aofFinished = make(chan struct{})
 	persister.listeners = make(map[Listener]struct{})
@@ -127,11 +166,38 @@ func (persister *Persister) SaveCmdLine(dbIndex int, cmdLine CmdLine) {
 // listenCmd listen aof channel and write into file
 func (persister *Persister) listenCmd() {
 	for p := range persister.aofChan {
+		if p.callback != nil {
+			persister.pausingAof.Lock()
+			p.callback()
+			persister.pausingAof.Unlock()
+			p.wg.Done()
+			continue
+		}
 		persister.writeAof(p)
 	}
 	persister.aofFinished <- struct{}{}
 }
 
+// barrier calls fn with aof paused after all commands saved before are written into aof file.
+// it only enqueues fn, the returned wait blocks until fn returns.
+// commands saved after barrier returns are written after fn, so callers could unlock keys before waiting
+func (persister *Persister) barrier(fn func()) (wait func()) {
+	if persister.aofFsync == FsyncAlways {
+		// commands are written synchronously in SaveCmdLine
+		persister.pausingAof.Lock()
+		defer persister.pausingAof.Unlock()
+		fn()
+		return func() {}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	persister.aofChan <- &payload{
+		callback: fn,
+		wg:       wg,
+	}
+	return wg.Wait
+}
+
 func (persister *Persister) writeAof(p *payload) {
 	persister.buffer = persister.buffer[:0] // reuse underlying array
 	persister.pausingAof.Lock()             // prevent other goroutines from pausing aof
@@ -164,8 +230,8 @@ func (persister *Persister) writeAof(p *payload) {
 	}
 }
 
-// LoadAof read aof file, can only be used before Persister.listenCmd started
-func (persister *Persister) LoadAof(maxBytes int) {
+// LoadAof reads base file and incr files listed in manifest, can only be used before Persister.listenCmd started
+func (persister *Persister) LoadAof() {
 	// persister.db.Exec may call persister.AddAof
 	// delete aofChan to prevent loaded commands back into aofChan
 	aofChan := persister.aofChan
@@ -174,24 +240,30 @@ func (persister *Persister) LoadAof(maxBytes int) {
 		persister.aofChan = aofChan
 	}(aofChan)
 
-	file, err := os.Open(persister.aofFilename)
+	for _, info := range persister.manifest.files() {
+		persister.loadFile(filepath.Join(persister.aofDir, info.name))
+	}
+}
+
+// loadFile replays commands in an aof file, every file starts from db 0
+func (persister *Persister) loadFile(filename string) {
+	persister.currentDB = 0
+	file, err := os.Open(filename)
 	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
-		}
 		logger.Warn(err)
 		return
 	}
 	defer file.Close()
 
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
+	reader := bufio.NewReader(file)
+	if err := persister.loadRdbPreamble(reader); err != nil {
+		logger.Error("load rdb preamble failed: " + err.Error())
+		return
 	}
 	ch := parser.ParseStream(reader)
 	fakeConn := connection.NewFakeConn() // only used for save dbIndex
+	// internal commands such as _graph.restore are accepted from aof like from master
+	fakeConn.SetMaster()
 	for p := range ch {
 		if p.Err != nil {
 			if p.Err == io.EOF {
@@ -223,6 +295,28 @@ func (persister *Persister) LoadAof(maxBytes int) {
 	}
 }
 
+// loadRdbPreamble decodes rdb at the beginning of file if it starts with rdb magic,
+// reader is left at the aof commands following the rdb preamble
+func (persister *Persister) loadRdbPreamble(reader *bufio.Reader) error {
+	magic, err := reader.Peek(len(rdbMagic))
+	if err != nil || !bytes.Equal(magic, rdbMagic) {
+		// not rdb, or too short to be rdb
+		return nil
+	}
+	// decoder reuses reader as its buffer, so that no aof command is consumed by it
+	if err := persister.db.LoadRDB(core.NewDecoder(reader)); err != nil {
+		return err
+	}
+	// skip crc64 checksum and the LF following it
+	if _, err := reader.Discard(rdbChecksumLen); err != nil && err != io.EOF {
+		return err
+	}
+	if b, err := reader.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = reader.Discard(1)
+	}
+	return nil
+}
+
 // Close gracefully stops aof persistence procedure
 func (persister *Persister) Close() {
 	if persister.aofFile != nil {
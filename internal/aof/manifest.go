@@ -0,0 +1,332 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"slava/pkg/logger"
+)
+
+// 与 redis 7 一样, aof 由 appenddirname 目录下的多个文件组成:
+// 一个 base 文件 (aof 或 rdb 格式, 重写时由快照生成), 若干 incr 文件 (追加的命令) 和一个 manifest 文件.
+// manifest 记录了加载时需要的文件及其顺序, 每次修改都写入临时文件后通过 rename 原子替换,
+// 所以任何时刻崩溃, manifest 都指向一组完整的文件. 重写完成后旧的 base 和 incr 文件变为 history 并被删除
+const (
+	manifestSuffix = ".manifest"
+	baseSuffix     = ".base"
+	incrSuffix     = ".incr"
+	aofFormatExt   = ".aof"
+	rdbFormatExt   = ".rdb"
+	tempPrefix     = "temp-"
+
+	fileTypeBase    = "b"
+	fileTypeIncr    = "i"
+	fileTypeHistory = "h"
+)
+
+// aofInfo is a file listed in manifest
+type aofInfo struct {
+	name     string
+	seq      int64
+	fileType string
+}
+
+func (info *aofInfo) String() string {
+	return "file " + info.name + " seq " + strconv.FormatInt(info.seq, 10) + " type " + info.fileType + "\n"
+}
+
+// manifest lists files of aof, it is immutable after being persisted, modifications are made on a copy
+type manifest struct {
+	base    *aofInfo
+	incrs   []*aofInfo
+	history []*aofInfo
+	// seq of the latest base file and incr file, increases monotonically
+	currBaseSeq int64
+	currIncrSeq int64
+}
+
+func (m *manifest) clone() *manifest {
+	cloned := &manifest{
+		base:        m.base,
+		currBaseSeq: m.currBaseSeq,
+		currIncrSeq: m.currIncrSeq,
+	}
+	cloned.incrs = append(cloned.incrs, m.incrs...)
+	cloned.history = append(cloned.history, m.history...)
+	return cloned
+}
+
+// files returns files to load in order: base first, then incr files
+func (m *manifest) files() []*aofInfo {
+	var files []*aofInfo
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// lastIncr returns the incr file to append to, or nil if there is no incr file
+func (m *manifest) lastIncr() *aofInfo {
+	if len(m.incrs) == 0 {
+		return nil
+	}
+	return m.incrs[len(m.incrs)-1]
+}
+
+// addIncr adds a new incr file, commands are appended to the new file afterwards
+func (m *manifest) addIncr(filename string) *aofInfo {
+	m.currIncrSeq++
+	info := &aofInfo{
+		name:     filename + "." + strconv.FormatInt(m.currIncrSeq, 10) + incrSuffix + aofFormatExt,
+		seq:      m.currIncrSeq,
+		fileType: fileTypeIncr,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// setBase replaces base with a new base file, the old base and incr files before firstIncr become history,
+// all incr files become history if firstIncr is nil
+func (m *manifest) setBase(filename string, ext string, firstIncr *aofInfo) *aofInfo {
+	m.currBaseSeq++
+	if m.base != nil {
+		m.history = append(m.history, &aofInfo{name: m.base.name, seq: m.base.seq, fileType: fileTypeHistory})
+	}
+	m.base = &aofInfo{
+		name:     filename + "." + strconv.FormatInt(m.currBaseSeq, 10) + baseSuffix + ext,
+		seq:      m.currBaseSeq,
+		fileType: fileTypeBase,
+	}
+	var incrs []*aofInfo
+	for _, incr := range m.incrs {
+		if firstIncr == nil || incr.seq < firstIncr.seq {
+			m.history = append(m.history, &aofInfo{name: incr.name, seq: incr.seq, fileType: fileTypeHistory})
+		} else {
+			incrs = append(incrs, incr)
+		}
+	}
+	m.incrs = incrs
+	return m.base
+}
+
+func (m *manifest) String() string {
+	var builder strings.Builder
+	if m.base != nil {
+		builder.WriteString(m.base.String())
+	}
+	for _, info := range m.history {
+		builder.WriteString(info.String())
+	}
+	for _, info := range m.incrs {
+		builder.WriteString(info.String())
+	}
+	return builder.String()
+}
+
+func manifestName(filename string) string {
+	return filename + manifestSuffix
+}
+
+// loadManifest reads manifest in dir, returns nil if manifest does not exist
+func loadManifest(dir string, filename string) (*manifest, error) {
+	file, err := os.Open(filepath.Join(dir, manifestName(filename)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		info, err := parseAofInfo(line)
+		if err != nil {
+			return nil, err
+		}
+		switch info.fileType {
+		case fileTypeBase:
+			if m.base != nil {
+				return nil, errors.New("invalid manifest: more than one base file")
+			}
+			m.base = info
+			m.currBaseSeq = info.seq
+		case fileTypeIncr:
+			if last := m.lastIncr(); last != nil && last.seq >= info.seq {
+				return nil, errors.New("invalid manifest: incr files are not in order")
+			}
+			m.incrs = append(m.incrs, info)
+			m.currIncrSeq = info.seq
+		case fileTypeHistory:
+			m.history = append(m.history, info)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseAofInfo parses a line like "file appendonly.aof.1.base.aof seq 1 type b"
+func parseAofInfo(line string) (*aofInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid manifest line: %s", line)
+	}
+	info := &aofInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			info.name = fields[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid manifest line: %s", line)
+			}
+			info.seq = seq
+		case "type":
+			info.fileType = fields[i+1]
+		}
+	}
+	if info.name == "" || (info.fileType != fileTypeBase && info.fileType != fileTypeIncr && info.fileType != fileTypeHistory) {
+		return nil, fmt.Errorf("invalid manifest line: %s", line)
+	}
+	// files must be in aof dir
+	if filepath.Base(info.name) != info.name {
+		return nil, fmt.Errorf("invalid file name in manifest: %s", info.name)
+	}
+	return info, nil
+}
+
+// persistManifest writes manifest into a temp file and renames it to manifest atomically
+func persistManifest(dir string, filename string, m *manifest) error {
+	file, err := ioutil.TempFile(dir, tempPrefix+manifestName(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(m.String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, manifestName(filename)))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renaming in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// some file systems do not support syncing directory
+	_ = d.Sync()
+	return nil
+}
+
+// prepareManifest loads manifest, or creates it for a new aof dir. legacy is the single aof file used before
+// multi-part aof, it is moved into aof dir as base file
+func (persister *Persister) prepareManifest(legacy string) (*manifest, error) {
+	m, err := loadManifest(persister.aofDir, persister.aofFilename)
+	if err != nil {
+		return nil, err
+	}
+	legacyExists := false
+	if info, err := os.Stat(legacy); err == nil && !info.IsDir() {
+		legacyExists = true
+	}
+	if m == nil {
+		m = &manifest{}
+		if !legacyExists {
+			return m, nil
+		}
+		// persist manifest before moving the legacy file, so that an interrupted upgrade can be resumed
+		m.base = &aofInfo{
+			name:     persister.aofFilename,
+			seq:      1,
+			fileType: fileTypeBase,
+		}
+		m.currBaseSeq = 1
+		if err := persistManifest(persister.aofDir, persister.aofFilename, m); err != nil {
+			return nil, err
+		}
+	}
+	if m.base != nil && m.base.name == persister.aofFilename && legacyExists {
+		basePath := filepath.Join(persister.aofDir, m.base.name)
+		if _, err := os.Stat(basePath); os.IsNotExist(err) {
+			if err := os.Rename(legacy, basePath); err != nil {
+				return nil, err
+			}
+			if err := syncDir(persister.aofDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// openNewIncrFile switches aof to a new incr file, caller must pause aof or call it before aof starts.
+// manifest listing the new file is persisted before writing into it
+func (persister *Persister) openNewIncrFile() (*aofInfo, error) {
+	m := persister.manifest.clone()
+	incr := m.addIncr(persister.aofFilename)
+	file, err := os.OpenFile(filepath.Join(persister.aofDir, incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := persistManifest(persister.aofDir, persister.aofFilename, m); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	persister.manifest = m
+	if persister.aofFile != nil {
+		if err := persister.aofFile.Sync(); err != nil {
+			logger.Warn("fsync failed: " + err.Error())
+		}
+		_ = persister.aofFile.Close()
+	}
+	persister.aofFile = file
+	// every file is loaded from db 0
+	persister.currentDB = 0
+	return incr, nil
+}
+
+// deleteHistory removes history files and then removes them from manifest, caller must pause aof
+func (persister *Persister) deleteHistory() error {
+	if len(persister.manifest.history) == 0 {
+		return nil
+	}
+	for _, info := range persister.manifest.history {
+		err := os.Remove(filepath.Join(persister.aofDir, info.name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	m := persister.manifest.clone()
+	m.history = nil
+	if err := persistManifest(persister.aofDir, persister.aofFilename, m); err != nil {
+		return err
+	}
+	persister.manifest = m
+	return nil
+}
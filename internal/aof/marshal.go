@@ -7,8 +7,12 @@ import (
 	"slava/internal/interface/database"
 	"slava/internal/protocol"
 	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
 	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
 	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
 )
 
 // EntityToCmd serialize data entity to slava command
@@ -20,15 +24,22 @@ func EntityToCmd(key string, entity *database.DataEntity) *protocol.MultiBulkRep
 	switch val := entity.Data.(type) {
 	case []byte:
 		cmd = stringToCmd(key, val)
+	case int64:
+		cmd = stringToCmd(key, []byte(strconv.FormatInt(val, 10)))
 	case quicklist.List:
 		cmd = listToCmd(key, val)
-	//	Todo set
-	//case *set.Set:
-	//	cmd = setToCmd(key, val)
+	case *list.List:
+		cmd = linkedListToCmd(key, val)
+	case *set.Set:
+		cmd = setToCmd(key, val)
 	case dict.Dict:
 		cmd = hashToCmd(key, val)
 	case *SortedSet.SortedSet:
 		cmd = zSetToCmd(key, val)
+	case *graph.Graph:
+		cmd = graphToCmd(key, val)
+	case *suggest.Trie:
+		cmd = suggestToCmd(key, val)
 	}
 	return cmd
 }
@@ -57,21 +68,30 @@ func listToCmd(key string, list quicklist.List) *protocol.MultiBulkReply {
 	return protocol.MakeMultiBulkReply(args)
 }
 
+func linkedListToCmd(key string, l *list.List) *protocol.MultiBulkReply {
+	args := make([][]byte, 2, 2+l.Len())
+	args[0] = rPushAllCmd
+	args[1] = []byte(key)
+	for _, node := range l.Range(0, l.Len()-1) {
+		args = append(args, []byte(node.GetValue()))
+	}
+	return protocol.MakeMultiBulkReply(args)
+}
+
 var sAddCmd = []byte("SADD")
 
-//	Todo set
-//func setToCmd(key string, set *set.Set) *protocol.MultiBulkReply {
-//	args := make([][]byte, 2+set.Len())
-//	args[0] = sAddCmd
-//	args[1] = []byte(key)
-//	i := 0
-//	set.ForEach(func(val string) bool {
-//		args[2+i] = []byte(val)
-//		i++
-//		return true
-//	})
-//	return protocol.MakeMultiBulkReply(args)
-//}
+func setToCmd(key string, set *set.Set) *protocol.MultiBulkReply {
+	args := make([][]byte, 2+set.Len())
+	args[0] = sAddCmd
+	args[1] = []byte(key)
+	i := 0
+	set.ForEach(func(val string) bool {
+		args[2+i] = []byte(val)
+		i++
+		return true
+	})
+	return protocol.MakeMultiBulkReply(args)
+}
 
 var hMSetCmd = []byte("HMSET")
 
@@ -107,6 +127,33 @@ func zSetToCmd(key string, zset *SortedSet.SortedSet) *protocol.MultiBulkReply {
 	return protocol.MakeMultiBulkReply(args)
 }
 
+// GraphRestore is the internal command replacing key with serialized graph,
+// it is only accepted from aof and master since payload is not validated as thoroughly as other commands
+const GraphRestore = "_graph.restore"
+
+var graphRestoreCmd = []byte(GraphRestore)
+
+func graphToCmd(key string, g *graph.Graph) *protocol.MultiBulkReply {
+	args := make([][]byte, 3)
+	args[0] = graphRestoreCmd
+	args[1] = []byte(key)
+	args[2] = g.Marshal()
+	return protocol.MakeMultiBulkReply(args)
+}
+
+// SugRestore is the internal command replacing key with serialized suggestion dictionary, it is accepted from aof and master only
+const SugRestore = "_sug.restore"
+
+var sugRestoreCmd = []byte(SugRestore)
+
+func suggestToCmd(key string, trie *suggest.Trie) *protocol.MultiBulkReply {
+	args := make([][]byte, 3)
+	args[0] = sugRestoreCmd
+	args[1] = []byte(key)
+	args[2] = trie.Marshal()
+	return protocol.MakeMultiBulkReply(args)
+}
+
 var pExpireAtBytes = []byte("PEXPIREAT")
 
 // MakeExpireCmd generates command line to set expiration for the given key
@@ -117,3 +164,18 @@ func MakeExpireCmd(key string, expireAt time.Time) *protocol.MultiBulkReply {
 	args[2] = []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10))
 	return protocol.MakeMultiBulkReply(args)
 }
+
+// TopicAppend is the internal command appending entries to the retained log of a durable topic,
+// retained logs are out of databases so that they are written into aof and replicated by it
+const TopicAppend = "_topic.append"
+
+// TopicToCmd serialize retained log of durable topic to _topic.append command
+func TopicToCmd(topic string, entries []string) *protocol.MultiBulkReply {
+	args := make([][]byte, 2, 2+len(entries))
+	args[0] = []byte(TopicAppend)
+	args[1] = []byte(topic)
+	for _, entry := range entries {
+		args = append(args, []byte(entry))
+	}
+	return protocol.MakeMultiBulkReply(args)
+}
@@ -1,160 +1,182 @@
 package aof
 
 import (
-	"io"
+	"bufio"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"slava/config"
 	"slava/internal/interface/database"
 	"slava/internal/protocol"
+	"slava/internal/rdb"
 	"slava/internal/utils"
-	"slava/pkg/logger"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-func (persister *Persister) newRewriteHandler() *Persister {
-	h := &Persister{}
-	h.aofFilename = persister.aofFilename
-	h.db = persister.tmpDBMaker()
-	return h
-}
+// ErrRewriteInProgress is returned if a rewrite is started while another one is running
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
 
 // RewriteCtx holds context of an AOF rewriting procedure
 type RewriteCtx struct {
-	tmpFile  *os.File
-	fileSize int64
-	dbIdx    int // selected db index when startRewrite
+	// tmpFile is the new base file being written
+	tmpFile *os.File
+	// snapshot is the point-in-time view of db when incr is opened
+	snapshot database.Snapshot
+	// incr is the incr file opened at the moment of snapshot, it and incr files after it hold commands after snapshot
+	incr *aofInfo
+	// ext is format of the new base file, rdbFormatExt if it is written as rdb preamble
+	ext string
 }
 
 // Rewrite carries out AOF rewrite
 func (persister *Persister) Rewrite() error {
+	if !atomic.CompareAndSwapInt32(&persister.rewriting, 0, 1) {
+		return ErrRewriteInProgress
+	}
+	defer atomic.StoreInt32(&persister.rewriting, 0)
 	ctx, err := persister.StartRewrite()
 	if err != nil {
 		return err
 	}
 	err = persister.DoRewrite(ctx)
 	if err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
 		return err
 	}
+	return persister.FinishRewrite(ctx)
+}
 
-	persister.FinishRewrite(ctx)
-	return nil
+// RewriteInProgress returns whether aof rewriting is running
+func (persister *Persister) RewriteInProgress() bool {
+	return atomic.LoadInt32(&persister.rewriting) == 1
 }
 
 // DoRewrite actually rewrite aof file
 // makes DoRewrite public for testing only, please use Rewrite instead
 func (persister *Persister) DoRewrite(ctx *RewriteCtx) error {
-	tmpFile := ctx.tmpFile
+	defer ctx.snapshot.Release()
+	writer := bufio.NewWriter(ctx.tmpFile)
 
-	// load aof tmpFile
-	tmpAof := persister.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
+	if ctx.ext == rdbFormatExt {
+		// base file in rdb format is decoded directly on loading instead of replaying commands
+		if err := rdb.WritePreamble(writer, ctx.snapshot); err != nil {
+			return err
+		}
+	} else if err := writeAofCommands(writer, ctx.snapshot); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return ctx.tmpFile.Sync()
+}
 
-	// rewrite aof tmpFile
+// writeAofCommands writes commands that rebuild all dbs in snapshot
+func writeAofCommands(writer *bufio.Writer, snapshot database.Snapshot) error {
 	for i := 0; i < config.Properties.Databases; i++ {
 		// select db
 		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
-		_, err := tmpFile.Write(data)
+		_, err := writer.Write(data)
 		if err != nil {
 			return err
 		}
 		// dump db
-		tmpAof.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		snapshot.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
 			cmd := EntityToCmd(key, entity)
 			if cmd != nil {
-				_, _ = tmpFile.Write(cmd.ToBytes())
+				_, _ = writer.Write(cmd.ToBytes())
 			}
 			if expiration != nil {
 				cmd := MakeExpireCmd(key, *expiration)
 				if cmd != nil {
-					_, _ = tmpFile.Write(cmd.ToBytes())
+					_, _ = writer.Write(cmd.ToBytes())
 				}
 			}
 			return true
 		})
 	}
-	return nil
+	// retained logs of durable topics
+	var err error
+	snapshot.ForEachTopic(func(topic string, entries []string) bool {
+		_, err = writer.Write(TopicToCmd(topic, entries).ToBytes())
+		return err == nil
+	})
+	return err
 }
 
-// StartRewrite prepares rewrite procedure
+// StartRewrite takes a snapshot of db and switches aof to a new incr file at the same moment,
+// so that the new base file and incr files from the new one make up the whole data
 func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
-	persister.pausingAof.Lock() // pausing aof
-	defer persister.pausingAof.Unlock()
-
-	err := persister.aofFile.Sync()
+	ext := aofFormatExt
+	if config.Properties.AofUseRdbPreamble {
+		ext = rdbFormatExt
+	}
+	file, err := ioutil.TempFile(persister.aofDir, tempPrefix+"rewrite-*"+ext)
 	if err != nil {
-		logger.Warn("fsync failed")
 		return nil, err
 	}
+	ctx := &RewriteCtx{
+		tmpFile: file,
+		ext:     ext,
+	}
+	ctx.snapshot, err = persister.takeSnapshot(func() error {
+		var err error
+		ctx.incr, err = persister.openNewIncrFile()
+		return err
+	})
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return ctx, nil
+}
 
-	// get current aof file size
-	fileInfo, _ := os.Stat(persister.aofFilename)
-	filesize := fileInfo.Size()
-
-	// create tmp file
-	file, err := ioutil.TempFile("", "*.aof")
+// takeSnapshot takes a snapshot of db, atSnapshot is called with aof paused
+// after all commands before the snapshot are written into aof file
+func (persister *Persister) takeSnapshot(atSnapshot func() error) (database.Snapshot, error) {
+	var err error
+	var wait func()
+	snapshot := persister.db.Snapshot(func() {
+		// all keys are locked in hook, draining the aof queue doesn't have to block writers
+		wait = persister.barrier(func() {
+			err = atSnapshot()
+		})
+	})
+	wait()
 	if err != nil {
-		logger.Warn("tmp file create failed")
+		snapshot.Release()
 		return nil, err
 	}
-	return &RewriteCtx{
-		tmpFile:  file,
-		fileSize: filesize,
-		dbIdx:    persister.currentDB,
-	}, nil
+	return snapshot, nil
 }
 
-// FinishRewrite finish rewrite procedure
-func (persister *Persister) FinishRewrite(ctx *RewriteCtx) {
+// FinishRewrite makes the new base file take effect by replacing manifest, then removes the old files
+func (persister *Persister) FinishRewrite(ctx *RewriteCtx) error {
 	persister.pausingAof.Lock() // pausing aof
 	defer persister.pausingAof.Unlock()
 
-	tmpFile := ctx.tmpFile
-	// write commands executed during rewriting to tmp file
-	src, err := os.Open(persister.aofFilename)
-	if err != nil {
-		logger.Error("open aofFilename failed: " + err.Error())
-		return
+	m := persister.manifest.clone()
+	base := m.setBase(persister.aofFilename, ctx.ext, ctx.incr)
+	basePath := filepath.Join(persister.aofDir, base.name)
+	err := ctx.tmpFile.Close()
+	if err == nil {
+		err = os.Rename(ctx.tmpFile.Name(), basePath)
 	}
-	defer func() {
-		_ = src.Close()
-	}()
-	_, err = src.Seek(ctx.fileSize, 0)
 	if err != nil {
-		logger.Error("seek failed: " + err.Error())
-		return
-	}
-
-	// sync tmpFile's db index with online aofFile
-	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(ctx.dbIdx))).ToBytes()
-	_, err = tmpFile.Write(data)
-	if err != nil {
-		logger.Error("tmp file rewrite failed: " + err.Error())
-		return
-	}
-	// copy data
-	_, err = io.Copy(tmpFile, src)
-	if err != nil {
-		logger.Error("copy aof filed failed: " + err.Error())
-		return
-	}
-
-	// replace current aof file by tmp file
-	_ = persister.aofFile.Close()
-	_ = os.Rename(tmpFile.Name(), persister.aofFilename)
-
-	// reopen aof file for further write
-	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		panic(err)
+		_ = os.Remove(ctx.tmpFile.Name())
+		return err
 	}
-	persister.aofFile = aofFile
-
-	// write select command again to ensure aof file has the same db index with  persister.currentDB
-	data = protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
-	_, err = persister.aofFile.Write(data)
+	// old files are still in effect until the new manifest is persisted
+	err = persistManifest(persister.aofDir, persister.aofFilename, m)
 	if err != nil {
-		panic(err)
+		_ = os.Remove(basePath)
+		return err
 	}
+	persister.manifest = m
+	return persister.deleteHistory()
 }
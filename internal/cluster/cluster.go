@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"runtime/debug"
 	"slava/internal/aof"
@@ -43,8 +44,10 @@ type Cluster struct {
 	transactions *dict.ConcurrentDict // id -> Transaction
 
 	idGenerator *idgenerator.IDGenerator
+	// ids of relayed PUBLISH messages recently delivered
+	publishDedup *deduplicator
 	// use a variable to allow injecting stub for testing
-	relayImpl func(cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply
+	relayImpl func(ctx context.Context, cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply
 }
 
 // if only one node involved in a transaction, just execute the command don't apply tcc procedure
@@ -52,17 +55,19 @@ var allowFastTransaction = true
 
 // MakeCluster creates and starts a node of cluster
 func MakeCluster() *Cluster {
+	server := database.NewStandaloneServer()
 	cluster := &Cluster{
 		self: config.Properties.Self,
 
-		db: database.NewStandaloneServer(),
+		db: server,
 		// TODO MakeConcurrent的默认值应该在config里面规定
 		transactions:    dict.MakeConcurrent(replicas),
 		peerPicker:      consistenthash.New(replicas, nil),
 		nodeConnections: make(map[string]*pool.Pool),
 
-		idGenerator: idgenerator.MakeGenerator(config.Properties.Self),
-		relayImpl:   defaultRelayImpl,
+		idGenerator:  database.MakeIDGenerator(),
+		publishDedup: makeDeduplicator(publishDedupWindow),
+		relayImpl:    defaultRelayImpl,
 	}
 	contains := make(map[string]struct{})
 	nodes := make([]string, 0, len(config.Properties.Peers)+1)
@@ -103,6 +108,13 @@ func MakeCluster() *Cluster {
 		cluster.nodeConnections[peer] = pool.New(factory, finalizer, connectionPoolConfig)
 	}
 	cluster.nodes = nodes
+	// retained log of durable topic is kept by the node which the topic hashes to
+	server.SetTopicOwner(func(topic string) string {
+		if node := cluster.peerPicker.PickNode(topic); node != cluster.self {
+			return node
+		}
+		return ""
+	})
 	return cluster
 }
 
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"slava/internal/aof"
 	"strconv"
@@ -11,12 +12,12 @@ import (
 	"slava/internal/utils"
 )
 
-func (cluster *Cluster) getPeerClient(peer string) (*client.Client, error) {
+func (cluster *Cluster) getPeerClient(ctx context.Context, peer string) (*client.Client, error) {
 	pool, ok := cluster.nodeConnections[peer]
 	if !ok {
 		return nil, errors.New("connection pool not found")
 	}
-	raw, err := pool.Get()
+	raw, err := pool.GetContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -36,20 +37,21 @@ func (cluster *Cluster) returnPeerClient(peer string, peerClient *client.Client)
 	return nil
 }
 
-var defaultRelayImpl = func(cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
+// defaultRelayImpl gives up waiting for peer when ctx is done
+var defaultRelayImpl = func(ctx context.Context, cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
 	if node == cluster.self {
 		// to self db
 		return cluster.db.Exec(c, cmdLine)
 	}
-	peerClient, err := cluster.getPeerClient(node)
+	peerClient, err := cluster.getPeerClient(ctx, node)
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
 	defer func() {
 		_ = cluster.returnPeerClient(node, peerClient)
 	}()
-	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
-	return peerClient.Send(cmdLine)
+	peerClient.SendContext(ctx, utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
+	return peerClient.SendContext(ctx, cmdLine)
 }
 
 // relay function relays command to peer
@@ -57,7 +59,28 @@ var defaultRelayImpl = func(cluster *Cluster, node string, c slava.Connection, c
 // cannot call Prepare, Commit, execRollback of self node
 func (cluster *Cluster) relay(peer string, c slava.Connection, args [][]byte) slava.Reply {
 	// use a variable to allow injecting stub for testing
-	return cluster.relayImpl(cluster, peer, c, args)
+	return cluster.relayImpl(context.Background(), cluster, peer, c, args)
+}
+
+// relayLocal relays command to node which executes it on itself only,
+// so commands implemented by broadcasting such as FLUSHDB won't be broadcast again by peers
+func (cluster *Cluster) relayLocal(node string, c slava.Connection, args [][]byte) slava.Reply {
+	if node == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	cmdLine := make([][]byte, 0, len(args)+1)
+	cmdLine = append(cmdLine, relayLocalCmd)
+	cmdLine = append(cmdLine, args...)
+	return cluster.relay(node, c, cmdLine)
+}
+
+// broadcastLocal executes command on every node without further broadcasting
+func (cluster *Cluster) broadcastLocal(c slava.Connection, args [][]byte) map[string]slava.Reply {
+	result := make(map[string]slava.Reply)
+	for _, node := range cluster.nodes {
+		result[node] = cluster.relayLocal(node, c, args)
+	}
+	return result
 }
 
 // broadcast function broadcasts command to all node in cluster
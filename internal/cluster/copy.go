@@ -46,7 +46,10 @@ func Copy(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 		destNode: {destKey},
 	}
 
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	// prepare Copy from
 	srcPrepareResp := cluster.relayPrepare(srcNode, c, makeArgs("Prepare", txIDStr, "CopyFrom", srcKey))
@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"strconv"
+	"strings"
 
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
@@ -10,8 +11,17 @@ import (
 // Del atomically removes given writeKeys from cluster, writeKeys can be distributed on any node
 // if the given writeKeys are distributed on different node, Del will use try-commit-catch to remove them
 func Del(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	return del(cluster, c, "DEL", args)
+}
+
+// Unlink atomically removes given keys like Del, values are freed in background by nodes
+func Unlink(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	return del(cluster, c, "UNLINK", args)
+}
+
+func del(cluster *Cluster, c slava.Connection, cmdName string, args [][]byte) slava.Reply {
 	if len(args) < 2 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'del' command")
+		return protocol.MakeArgNumErrReply(strings.ToLower(cmdName))
 	}
 	keys := make([]string, len(args)-1)
 	for i := 1; i < len(args); i++ {
@@ -20,16 +30,19 @@ func Del(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 	groupMap := cluster.groupBy(keys)
 	if len(groupMap) == 1 && allowFastTransaction { // do fast
 		for peer, group := range groupMap { // only one peerKeys
-			return cluster.relay(peer, c, makeArgs("DEL", group...))
+			return cluster.relay(peer, c, makeArgs(cmdName, group...))
 		}
 	}
 	// prepare
 	var errReply slava.Reply
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	rollback := false
 	for peer, peerKeys := range groupMap {
-		peerArgs := []string{txIDStr, "DEL"}
+		peerArgs := []string{txIDStr, cmdName}
 		peerArgs = append(peerArgs, peerKeys...)
 		var resp slava.Reply
 		if peer == cluster.self {
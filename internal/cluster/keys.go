@@ -1,13 +1,30 @@
 package cluster
 
 import (
+	"math/rand"
+
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
 )
 
 // FlushDB removes all data in current database
 func FlushDB(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
-	replies := cluster.broadcast(c, args)
+	return broadcastLocalOk(cluster, c, args)
+}
+
+// FlushAll removes all data in cluster
+func FlushAll(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	return broadcastLocalOk(cluster, c, args)
+}
+
+// SwapDB swaps databases on every node in cluster
+func SwapDB(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	return broadcastLocalOk(cluster, c, args)
+}
+
+// broadcastLocalOk executes command on every node and returns OK if all of them succeed
+func broadcastLocalOk(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	replies := cluster.broadcastLocal(c, args)
 	var errReply protocol.ErrorReply
 	for _, v := range replies {
 		if protocol.IsErrorReply(v) {
@@ -21,7 +38,60 @@ func FlushDB(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 	return protocol.MakeErrReply("error occurs: " + errReply.Error())
 }
 
-// FlushAll removes all data in cluster
-func FlushAll(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
-	return FlushDB(cluster, c, args)
+// DBSize returns the sum of keys count on every node
+func DBSize(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	var size int64
+	for _, reply := range cluster.broadcastLocal(c, args) {
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		if intReply, ok := reply.(*protocol.IntReply); ok {
+			size += intReply.Code
+		}
+	}
+	return protocol.MakeIntReply(size)
+}
+
+// RandomKey returns a random key from a random node, it asks other nodes if the chosen node is empty
+func RandomKey(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	for _, i := range rand.Perm(len(cluster.nodes)) {
+		reply := cluster.relayLocal(cluster.nodes[i], c, args)
+		if bulkReply, ok := reply.(*protocol.BulkReply); ok && bulkReply.Arg != nil {
+			return reply
+		}
+	}
+	return &protocol.NullBulkReply{}
+}
+
+// Touch touches keys on their nodes and returns the number of existed keys
+func Touch(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) < 2 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'touch' command")
+	}
+	keys := make([]string, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		keys[i-1] = string(args[i])
+	}
+	var touched int64
+	for peer, group := range cluster.groupBy(keys) {
+		reply := cluster.relay(peer, c, makeArgs("TOUCH", group...))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		if intReply, ok := reply.(*protocol.IntReply); ok {
+			touched += intReply.Code
+		}
+	}
+	return protocol.MakeIntReply(touched)
+}
+
+// Object relays object subcommand to the node responsible for its key,
+// it is also used by memory and debug whose key is the third argument
+// object <subcommand> key
+func Object(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) < 3 {
+		return cluster.db.Exec(c, args)
+	}
+	peer := cluster.peerPicker.PickNode(string(args[2]))
+	return cluster.relay(peer, c, args)
 }
@@ -67,7 +67,10 @@ func MSet(cluster *Cluster, c slava.Connection, cmdLine aof.CmdLine) slava.Reply
 
 	//prepare
 	var errReply slava.Reply
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	rollback := false
 	for peer, group := range groupMap {
@@ -127,7 +130,10 @@ func MSetNX(cluster *Cluster, c slava.Connection, cmdLine aof.CmdLine) slava.Rep
 	// 1. Normal tcc preparation (undo log and lock related keys)
 	// 2. Peer checks whether any key already exists, If so it will return keyExistsErr. Then coordinator will request rollback over all participated nodes
 	var errReply slava.Reply
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	rollback := false
 	for node, group := range groupMap {
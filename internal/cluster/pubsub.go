@@ -1,8 +1,16 @@
 package cluster
 
 import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
+	"slava/internal/utils"
 	"slava/pkg/logger"
 )
 
@@ -16,14 +24,97 @@ var (
 	publishCmd      = []byte(publish)
 )
 
-// Publish broadcasts msg to all peers in cluster when receive publish command from client
+// publishRelayTimeout is the longest time to wait for a peer during PUBLISH fan-out,
+// a slow or broken peer only loses the message instead of blocking the publisher
+var publishRelayTimeout = time.Second
+
+// publishDedupWindow is how long a relayed message id is remembered
+const publishDedupWindow = time.Minute
+
+// deduplicator remembers recently seen message ids, so a relayed message
+// delivered more than once (e.g. retried by the relay client) is published only once
+type deduplicator struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+func makeDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastPurge: time.Now(),
+	}
+}
+
+// firstSeen records id and returns whether it has not been seen within the window
+func (d *deduplicator) firstSeen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Sub(d.lastPurge) > d.window {
+		for key, at := range d.seen {
+			if now.Sub(at) > d.window {
+				delete(d.seen, key)
+			}
+		}
+		d.lastPurge = now
+	}
+	if at, ok := d.seen[id]; ok && now.Sub(at) <= d.window {
+		return false
+	}
+	d.seen[id] = now
+	return true
+}
+
+// relayWithTimeout relays command to peer and gives up waiting after timeout,
+// the peer client is returned to pool at once and the late reply is dropped by it
+func (cluster *Cluster) relayWithTimeout(peer string, c slava.Connection, args [][]byte, timeout time.Duration) slava.Reply {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	reply := cluster.relayImpl(ctx, cluster, peer, c, args)
+	if ctx.Err() != nil && protocol.IsErrorReply(reply) {
+		return protocol.MakeErrReply("ERR relay to " + peer + " timeout")
+	}
+	return reply
+}
+
+// Publish fans out msg to all nodes in parallel through the _publish relay command
+// _publish carries a cluster-unique message id, peers only deliver it to local subscribers and never relay it again
 func Publish(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply(publish)
+	}
+	id, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	msgID := cluster.self + "#" + strconv.FormatInt(id, 10)
+	relayArgs := [][]byte{publishRelayCmd, []byte(msgID), args[1], args[2]}
+
+	type result struct {
+		node  string
+		reply slava.Reply
+	}
+	results := make(chan result, len(cluster.nodes))
+	for _, node := range cluster.nodes {
+		go func(node string) {
+			var reply slava.Reply
+			if node == cluster.self {
+				reply = onRelayedPublish(cluster, c, relayArgs)
+			} else {
+				reply = cluster.relayWithTimeout(node, c, relayArgs, publishRelayTimeout)
+			}
+			results <- result{node: node, reply: reply}
+		}(node)
+	}
 	var count int64 = 0
-	results := cluster.broadcast(c, args)
-	for _, val := range results {
-		if errReply, ok := val.(protocol.ErrorReply); ok {
-			logger.Error("publish occurs error: " + errReply.Error())
-		} else if intReply, ok := val.(*protocol.IntReply); ok {
+	for range cluster.nodes {
+		res := <-results
+		if errReply, ok := res.reply.(protocol.ErrorReply); ok {
+			logger.Error("publish to " + res.node + " occurs error: " + errReply.Error())
+		} else if intReply, ok := res.reply.(*protocol.IntReply); ok {
 			count += intReply.Code
 		}
 	}
@@ -31,9 +122,16 @@ func Publish(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 }
 
 // onRelayedPublish receives publish command from peer, just publish to local subscribing clients, do not relay to peers
+// _publish msgID channel message
 func onRelayedPublish(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
-	args[0] = publishCmd
-	return cluster.db.Exec(c, args) // let local db.hub handle publish
+	if len(args) != 4 {
+		return protocol.MakeArgNumErrReply(relayPublish)
+	}
+	if !cluster.publishDedup.firstSeen(string(args[1])) {
+		return protocol.MakeIntReply(0)
+	}
+	// let local db.hub handle publish
+	return cluster.db.Exec(c, [][]byte{publishCmd, args[2], args[3]})
 }
 
 // Subscribe puts the given connection into the given channel
@@ -45,3 +143,98 @@ func Subscribe(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply
 func UnSubscribe(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 	return cluster.db.Exec(c, args) // let local db.hub handle subscribe
 }
+
+// SPublish sends msg to the node which owns the shard channel, the channel is hashed like a key
+func SPublish(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply("spublish")
+	}
+	node := cluster.peerPicker.PickNode(string(args[1]))
+	return cluster.relayLocal(node, c, args)
+}
+
+// SSubscribe subscribes shard channels owned by current node
+// subscribers must connect to the owner node since SPUBLISH is not broadcast
+func SSubscribe(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("ssubscribe")
+	}
+	node := cluster.peerPicker.PickNode(string(args[1]))
+	for _, channel := range args[2:] {
+		if cluster.peerPicker.PickNode(string(channel)) != node {
+			return protocol.MakeErrReply("CROSSSLOT Channels in request don't hash to the same node")
+		}
+	}
+	if node != cluster.self {
+		return protocol.MakeErrReply("MOVED shard channel " + string(args[1]) + " is served by " + node)
+	}
+	return cluster.db.Exec(c, args)
+}
+
+// PubSub aggregates PUBSUB introspection replies of all nodes in cluster
+func PubSub(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) < 2 {
+		return cluster.db.Exec(c, args)
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "channels", "shardchannels", "numsub", "shardnumsub", "numpat":
+	default:
+		return cluster.db.Exec(c, args)
+	}
+	replies := cluster.broadcastLocal(c, args)
+	for _, reply := range replies {
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+	}
+	switch subCmd {
+	case "numpat":
+		// a pattern subscribed on several nodes is counted several times
+		var count int64
+		for _, reply := range replies {
+			if intReply, ok := reply.(*protocol.IntReply); ok {
+				count += intReply.Code
+			}
+		}
+		return protocol.MakeIntReply(count)
+	case "numsub", "shardnumsub":
+		counts := make(map[string]int64)
+		for _, reply := range replies {
+			multiRaw, ok := reply.(*protocol.MultiRawReply)
+			if !ok {
+				continue
+			}
+			for i := 0; i+1 < len(multiRaw.Replies); i += 2 {
+				channel, ok1 := multiRaw.Replies[i].(*protocol.BulkReply)
+				count, ok2 := multiRaw.Replies[i+1].(*protocol.IntReply)
+				if ok1 && ok2 {
+					counts[string(channel.Arg)] += count.Code
+				}
+			}
+		}
+		result := make([]slava.Reply, 0, 2*(len(args)-2))
+		for _, channel := range args[2:] {
+			result = append(result, protocol.MakeBulkReply(channel), protocol.MakeIntReply(counts[string(channel)]))
+		}
+		return protocol.MakeMultiRawReply(result)
+	}
+	// channels and shardchannels
+	channelSet := make(map[string]struct{})
+	for _, reply := range replies {
+		if multiBulk, ok := reply.(*protocol.MultiBulkReply); ok {
+			for _, channel := range multiBulk.Args {
+				channelSet[string(channel)] = struct{}{}
+			}
+		}
+	}
+	if len(channelSet) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	channels := make([]string, 0, len(channelSet))
+	for channel := range channelSet {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return protocol.MakeMultiBulkReply(utils.ToCmdLine(channels...))
+}
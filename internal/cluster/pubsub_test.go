@@ -0,0 +1,259 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"slava/config"
+	"slava/internal/aof"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+// makeTestClusters makes nodes of a cluster in memory, commands relayed to a peer are executed by it directly
+func makeTestClusters(t *testing.T, nodes ...string) map[string]*Cluster {
+	self, peers := config.Properties.Self, config.Properties.Peers
+	defer func() {
+		config.Properties.Self, config.Properties.Peers = self, peers
+	}()
+	clusters := make(map[string]*Cluster)
+	for _, node := range nodes {
+		config.Properties.Self = node
+		config.Properties.Peers = nil
+		for _, peer := range nodes {
+			if peer != node {
+				config.Properties.Peers = append(config.Properties.Peers, peer)
+			}
+		}
+		clusters[node] = MakeCluster()
+	}
+	relay := func(ctx context.Context, cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
+		return clusters[node].Exec(c, cmdLine)
+	}
+	for _, cluster := range clusters {
+		cluster.relayImpl = relay
+	}
+	t.Cleanup(func() {
+		for _, cluster := range clusters {
+			cluster.Close()
+		}
+	})
+	return clusters
+}
+
+func TestPublishFanOut(t *testing.T) {
+	clusters := makeTestClusters(t, "a", "b", "c")
+	var subscribers []*connection.FakeConn
+	for _, node := range []string{"a", "b", "c"} {
+		sub := connection.NewFakeConn()
+		clusters[node].Exec(sub, utils.ToCmdLine("subscribe", "ch"))
+		sub.Clean()
+		subscribers = append(subscribers, sub)
+	}
+	reply := clusters["a"].Exec(connection.NewFakeConn(), utils.ToCmdLine("publish", "ch", "msg"))
+	if intReply, ok := reply.(*protocol.IntReply); !ok || intReply.Code != 3 {
+		t.Errorf("expected 3 receivers, actual %s", reply.ToBytes())
+	}
+	expected := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("message", "ch", "msg")).ToBytes())
+	for i, sub := range subscribers {
+		if string(sub.Bytes()) != expected {
+			t.Errorf("expected subscriber %d receives message once, actual %q", i, sub.Bytes())
+		}
+	}
+}
+
+func TestPublishDedup(t *testing.T) {
+	clusters := makeTestClusters(t, "a", "b")
+	sub := connection.NewFakeConn()
+	clusters["b"].Exec(sub, utils.ToCmdLine("subscribe", "ch"))
+	sub.Clean()
+	// a relayed message delivered twice is published only once
+	relayed := utils.ToCmdLine(relayPublish, "a#1", "ch", "msg")
+	if reply := clusters["b"].Exec(nil, relayed); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected 1 receiver, actual %s", reply.ToBytes())
+	}
+	if reply := clusters["b"].Exec(nil, relayed); string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected duplicated message dropped, actual %s", reply.ToBytes())
+	}
+	expected := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("message", "ch", "msg")).ToBytes())
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected message received once, actual %q", sub.Bytes())
+	}
+
+	// ids are forgotten after the window
+	d := makeDeduplicator(10 * time.Millisecond)
+	if !d.firstSeen("x") || d.firstSeen("x") {
+		t.Errorf("expected x seen once")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !d.firstSeen("x") {
+		t.Errorf("expected x forgotten after window")
+	}
+}
+
+func TestPublishTimeout(t *testing.T) {
+	clusters := makeTestClusters(t, "a", "b", "c")
+	timeout := publishRelayTimeout
+	publishRelayTimeout = 50 * time.Millisecond
+	defer func() {
+		publishRelayTimeout = timeout
+	}()
+	// c never answers until the test ends
+	blocked := make(chan struct{})
+	defer close(blocked)
+	gaveUp := make(chan struct{})
+	relay := clusters["a"].relayImpl
+	clusters["a"].relayImpl = func(ctx context.Context, cluster *Cluster, node string, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
+		if node == "c" {
+			select {
+			case <-blocked:
+			case <-ctx.Done():
+				close(gaveUp)
+				return protocol.MakeErrReply("ERR " + ctx.Err().Error())
+			}
+		}
+		return relay(ctx, cluster, node, c, cmdLine)
+	}
+	for _, node := range []string{"a", "b"} {
+		clusters[node].Exec(connection.NewFakeConn(), utils.ToCmdLine("subscribe", "ch"))
+	}
+	start := time.Now()
+	reply := clusters["a"].Exec(connection.NewFakeConn(), utils.ToCmdLine("publish", "ch", "msg"))
+	if intReply, ok := reply.(*protocol.IntReply); !ok || intReply.Code != 2 {
+		t.Errorf("expected 2 receivers, actual %s", reply.ToBytes())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected publish not blocked by slow peer, actual %s", elapsed)
+	}
+	// relaying to c is cancelled instead of being left waiting in background
+	select {
+	case <-gaveUp:
+	default:
+		t.Errorf("expected relay to c cancelled after timeout")
+	}
+}
+
+// channelsOfNodes finds a shard channel owned by each node
+func channelsOfNodes(cluster *Cluster) map[string]string {
+	channels := make(map[string]string)
+	for i := 0; len(channels) < len(cluster.nodes); i++ {
+		channel := "ch" + strconv.Itoa(i)
+		node := cluster.peerPicker.PickNode(channel)
+		if _, ok := channels[node]; !ok {
+			channels[node] = channel
+		}
+	}
+	return channels
+}
+
+func TestShardPubSub(t *testing.T) {
+	clusters := makeTestClusters(t, "a", "b", "c")
+	channels := channelsOfNodes(clusters["a"])
+	owner := clusters["b"].peerPicker.PickNode(channels["b"])
+	if owner != "b" {
+		t.Fatalf("expected all nodes agree on owner of shard channel, actual %s", owner)
+	}
+
+	sub := connection.NewFakeConn()
+	reply := clusters["a"].Exec(sub, utils.ToCmdLine("ssubscribe", channels["b"]))
+	if !strings.HasPrefix(string(reply.ToBytes()), "-MOVED") {
+		t.Errorf("expected MOVED, actual %s", reply.ToBytes())
+	}
+	reply = clusters["b"].Exec(sub, utils.ToCmdLine("ssubscribe", channels["b"], channels["c"]))
+	if !strings.HasPrefix(string(reply.ToBytes()), "-CROSSSLOT") {
+		t.Errorf("expected CROSSSLOT, actual %s", reply.ToBytes())
+	}
+	clusters["b"].Exec(sub, utils.ToCmdLine("ssubscribe", channels["b"]))
+	sub.Clean()
+
+	// shard message is sent to the owner only
+	reply = clusters["c"].Exec(connection.NewFakeConn(), utils.ToCmdLine("spublish", channels["b"], "msg"))
+	if intReply, ok := reply.(*protocol.IntReply); !ok || intReply.Code != 1 {
+		t.Errorf("expected 1 receiver, actual %s", reply.ToBytes())
+	}
+	expected := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("smessage", channels["b"], "msg")).ToBytes())
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+}
+
+func TestPubSubAggregation(t *testing.T) {
+	clusters := makeTestClusters(t, "a", "b", "c")
+	for _, node := range []string{"a", "b"} {
+		sub := connection.NewFakeConn()
+		clusters[node].Exec(sub, utils.ToCmdLine("subscribe", "ch1"))
+		clusters[node].Exec(sub, utils.ToCmdLine("psubscribe", "p*"))
+	}
+	clusters["c"].Exec(connection.NewFakeConn(), utils.ToCmdLine("subscribe", "ch2"))
+	channels := channelsOfNodes(clusters["a"])
+	clusters["c"].Exec(connection.NewFakeConn(), utils.ToCmdLine("ssubscribe", channels["c"]))
+
+	conn := connection.NewFakeConn()
+	reply := clusters["a"].Exec(conn, utils.ToCmdLine("pubsub", "channels"))
+	if expected := protocol.MakeMultiBulkReply(utils.ToCmdLine("ch1", "ch2")); string(reply.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected union of channels %s, actual %s", expected.ToBytes(), reply.ToBytes())
+	}
+	reply = clusters["a"].Exec(conn, utils.ToCmdLine("pubsub", "numsub", "ch1", "ch2", "ch3"))
+	expected := protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte("ch1")), protocol.MakeIntReply(2),
+		protocol.MakeBulkReply([]byte("ch2")), protocol.MakeIntReply(1),
+		protocol.MakeBulkReply([]byte("ch3")), protocol.MakeIntReply(0),
+	})
+	if string(reply.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected merged NUMSUB %q, actual %q", expected.ToBytes(), reply.ToBytes())
+	}
+	// each node is asked only once through _local, peers do not broadcast again
+	if reply = clusters["b"].Exec(conn, utils.ToCmdLine("pubsub", "numpat")); string(reply.ToBytes()) != ":2\r\n" {
+		t.Errorf("expected 2 patterns, actual %s", reply.ToBytes())
+	}
+	if reply = clusters["b"].Exec(conn, utils.ToCmdLine(relayLocal, "pubsub", "numpat")); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected 1 pattern on b, actual %s", reply.ToBytes())
+	}
+	reply = clusters["b"].Exec(conn, utils.ToCmdLine("pubsub", "shardchannels"))
+	if expected := protocol.MakeMultiBulkReply(utils.ToCmdLine(channels["c"])); string(reply.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected shard channels %s, actual %s", expected.ToBytes(), reply.ToBytes())
+	}
+}
+
+func TestDurablePublish(t *testing.T) {
+	topics := config.Properties.DurableTopics
+	config.Properties.DurableTopics = []string{"events.*"}
+	defer func() {
+		config.Properties.DurableTopics = topics
+	}()
+	clusters := makeTestClusters(t, "a", "b", "c")
+	topic := "events.order"
+	owner := clusters["a"].peerPicker.PickNode(topic)
+	var publisher string
+	for node := range clusters {
+		if node != owner {
+			publisher = node
+		}
+	}
+	sub := connection.NewFakeConn()
+	clusters[owner].Exec(sub, utils.ToCmdLine("subscribe", topic))
+	reply := clusters[publisher].Exec(connection.NewFakeConn(), utils.ToCmdLine("publish", topic, "msg"))
+	if intReply, ok := reply.(*protocol.IntReply); !ok || intReply.Code != 1 {
+		t.Errorf("expected 1 receiver, actual %s", reply.ToBytes())
+	}
+
+	// only the owner retains the message
+	for node, cluster := range clusters {
+		resumed := connection.NewFakeConn()
+		reply := cluster.Exec(resumed, utils.ToCmdLine("subscribe", topic, "FROM", "0"))
+		if node != owner {
+			if !protocol.IsErrorReply(reply) || !strings.HasPrefix(string(reply.ToBytes()), "-MOVED") {
+				t.Errorf("expected MOVED from %s, actual %s", node, reply.ToBytes())
+			}
+			continue
+		}
+		if !strings.Contains(string(resumed.Bytes()), "msg") {
+			t.Errorf("expected msg replayed by owner, actual %q", resumed.Bytes())
+		}
+	}
+}
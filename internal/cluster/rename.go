@@ -25,7 +25,10 @@ func Rename(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 		srcNode:  {srcKey},
 		destNode: {destKey},
 	}
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	// prepare rename from
 	srcPrepareResp := cluster.relayPrepare(srcNode, c, makeArgs("Prepare", txIDStr, "RenameFrom", srcKey))
@@ -109,7 +112,10 @@ func RenameNx(cluster *Cluster, c slava.Connection, args [][]byte) slava.Reply {
 		srcNode:  {srcKey},
 		destNode: {destKey},
 	}
-	txID := cluster.idGenerator.NextID()
+	txID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
 	txIDStr := strconv.FormatInt(txID, 10)
 	// prepare rename from
 	srcPrepareResp := cluster.relayPrepare(srcNode, c, makeArgs("Prepare", txIDStr, "RenameFrom", srcKey))
@@ -5,11 +5,18 @@ import "slava/internal/interface/slava"
 func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
 	routerMap["ping"] = ping
+	routerMap["id.next"] = execLocal
+	routerMap["id.decode"] = execLocal
+	routerMap[relayLocal] = execRelayedLocal
+	routerMap["command"] = execLocal
+	routerMap["info"] = execLocal
+	routerMap["lastsave"] = execLocal
 
 	routerMap["prepare"] = execPrepare
 	routerMap["commit"] = execCommit
 	routerMap["rollback"] = execRollback
 	routerMap["del"] = Del
+	routerMap["unlink"] = Unlink
 
 	routerMap["expire"] = defaultFunc
 	routerMap["expireat"] = defaultFunc
@@ -18,12 +25,39 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["ttl"] = defaultFunc
 	routerMap["pttl"] = defaultFunc
 	routerMap["persist"] = defaultFunc
+	routerMap["expiretime"] = defaultFunc
+	routerMap["pexpiretime"] = defaultFunc
+	// key stays on the same node when moved to another database
+	routerMap["move"] = defaultFunc
+	routerMap["touch"] = Touch
+	routerMap["randomkey"] = RandomKey
+	routerMap["dbsize"] = DBSize
+	// like redis cluster, SCAN iterates keys of the node connected
+	routerMap["scan"] = execLocal
 	routerMap["exists"] = defaultFunc
 	routerMap["type"] = defaultFunc
+	routerMap["object"] = Object
+	routerMap["memory"] = Object
+	routerMap["debug"] = Object
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = RenameNx
 	routerMap["copy"] = Copy
 
+	routerMap["graph.addedge"] = defaultFunc
+	routerMap["graph.deledge"] = defaultFunc
+	routerMap["graph.setnode"] = defaultFunc
+	routerMap["graph.getnode"] = defaultFunc
+	routerMap["graph.getedge"] = defaultFunc
+	routerMap["graph.neighbors"] = defaultFunc
+	routerMap["graph.bfs"] = defaultFunc
+	routerMap["graph.shortestpath"] = defaultFunc
+	routerMap["graph.common"] = defaultFunc
+
+	routerMap["sug.add"] = defaultFunc
+	routerMap["sug.get"] = defaultFunc
+	routerMap["sug.del"] = defaultFunc
+	routerMap["sug.len"] = defaultFunc
+
 	routerMap["set"] = defaultFunc
 	routerMap["setnx"] = defaultFunc
 	routerMap["setex"] = defaultFunc
@@ -110,9 +144,16 @@ func makeRouter() map[string]CmdFunc {
 	routerMap[relayPublish] = onRelayedPublish
 	routerMap["subscribe"] = Subscribe
 	routerMap["unsubscribe"] = UnSubscribe
+	routerMap["psubscribe"] = Subscribe
+	routerMap["punsubscribe"] = UnSubscribe
+	routerMap["spublish"] = SPublish
+	routerMap["ssubscribe"] = SSubscribe
+	routerMap["sunsubscribe"] = UnSubscribe
+	routerMap["pubsub"] = PubSub
 
 	routerMap["flushdb"] = FlushDB
 	routerMap["flushall"] = FlushAll
+	routerMap["swapdb"] = SwapDB
 	routerMap[relayMulti] = execRelayedMulti
 	routerMap["getver"] = defaultFunc
 	routerMap["watch"] = execWatch
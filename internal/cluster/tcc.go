@@ -120,6 +120,8 @@ func (tx *Transaction) rollbackWithLock() error {
 	for _, cmdLine := range tx.undoLog {
 		tx.cluster.db.ExecWithLock(tx.conn, cmdLine)
 	}
+	// subscribers should not see changes of a rolled back transaction
+	tx.cluster.db.DropKeyEvents(tx.dbIndex, tx.writeKeys, tx.readKeys)
 	tx.unLockKeys()
 	tx.status = rolledBackStatus
 	return nil
@@ -13,6 +13,24 @@ func ping(cluster *Cluster, c slava.Connection, cmdLine aof.CmdLine) slava.Reply
 	return cluster.db.Exec(c, cmdLine)
 }
 
+// execLocal executes command on current node, such as id.next which node id already keeps unique across cluster
+func execLocal(cluster *Cluster, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
+	return cluster.db.Exec(c, cmdLine)
+}
+
+const relayLocal = "_local"
+
+var relayLocalCmd = []byte(relayLocal)
+
+// execRelayedLocal executes command relayed by relayLocal on current node
+// _local cmd args...
+func execRelayedLocal(cluster *Cluster, c slava.Connection, cmdLine aof.CmdLine) slava.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply(relayLocal)
+	}
+	return cluster.db.Exec(c, cmdLine[1:])
+}
+
 /*----- utils -------*/
 
 func makeArgs(cmd string, args ...string) [][]byte {
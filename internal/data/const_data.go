@@ -6,17 +6,17 @@ package data
 
 const Prime32 = uint32(16777619)
 
-// 在router包下：用来指示exec函数是写还是只读
+// 在router包下：用来指示exec函数是写还是只读, 取值是位掩码, 与 database 包中的 flagWrite 等一致
 const (
-	FlagWrite = iota
+	FlagWrite = 1 << iota
 	FlagReadOnly
+	// FlagDenyOOM 表示命令可能占用更多内存, 超过 maxmemory 且无法淘汰时拒绝执行
+	FlagDenyOOM
 )
 
-// database包下面的常数：用于初始化DB
+// database包下面的常数：用于初始化DB, dict的初始大小见配置 data-dict-size 和 ttl-dict-size
 const (
-	DataDictSize = 1 << 16 // 表示的是分数据库的大小
-	TtlDictSize  = 1 << 10 // 过期时间的大小
-	LockerSize   = 1024
+	LockerSize = 1024
 )
 
 // string包下面的常数
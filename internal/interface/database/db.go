@@ -3,6 +3,8 @@ package database
 import (
 	"slava/internal/interface/slava"
 	"time"
+
+	"github.com/hdt3213/rdb/core"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
@@ -24,12 +26,31 @@ type DBEngine interface {
 	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
 	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
 	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
+	// DropKeyEvents drops keyspace events not yet published of locked keys, it is used by rolling back
+	DropKeyEvents(dbIndex int, writeKeys []string, readKeys []string)
+	GetDBSize(dbIndex int) (int, int)
+	// Snapshot takes a point-in-time view of all databases without blocking writing during iterating
+	// hook is called at the moment of snapshot while writing is paused, it may be nil
+	Snapshot(hook func()) Snapshot
+	// LoadRDB puts data decoded from rdb into databases
+	LoadRDB(dec *core.Decoder) error
+}
+
+// Snapshot is a read only point-in-time view of databases, it must be released after use
+type Snapshot interface {
+	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
+	// GetDBSize returns number of keys and number of keys having ttl at the moment of snapshot
 	GetDBSize(dbIndex int) (int, int)
+	// ForEachTopic traverses retained logs of durable topics, entries are encoded as "<offset>:<message>"
+	ForEachTopic(cb func(topic string, entries []string) bool)
+	Release()
 }
 
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct {
 	Data interface{}
 	Lru  int32  // time unix
-	Lfu  uint32 // count
+	Lfu  uint32 // last decrement time in minutes(16 bits) and logarithmic access counter(8 bits)
+	// Shared is the number of snapshots referring to Data, Data must be copied before modified in place
+	Shared int32
 }
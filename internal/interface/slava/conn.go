@@ -8,11 +8,17 @@ type Connection interface {
 	SetPassword(string)
 	GetPassword() string
 
-	// client should keep its subscribing channels
+	// client should keep its subscribing channels, patterns and shard channels
 	Subscribe(channel string)
 	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SSubscribe(channel string)
+	SUnSubscribe(channel string)
 	SubsCount() int
 	GetChannels() []string
+	GetPatterns() []string
+	GetShardChannels() []string
 
 	InMultiState() bool
 	SetMultiState(bool)
@@ -0,0 +1,205 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"time"
+)
+
+// rdb has no type for values of slava modules, they are saved in aux fields with following keys.
+// redis and rdb tools skip aux fields they don't know, so the rest of the file is still readable
+const (
+	AuxGraph   = "slava-graph"
+	AuxSuggest = "slava-suggest"
+	// retained log of durable topic belongs to no db, its db index is always 0
+	AuxTopic = "slava-topic"
+)
+
+var errCorrupted = errors.New("corrupted module value")
+
+// ModuleValue is a key of module saved in aux field
+type ModuleValue struct {
+	DBIndex    int
+	Key        string
+	Expiration *time.Time
+	Payload    []byte
+}
+
+// Marshal serializes value as: db index, expire at in ms (0 means no ttl), key, payload
+func (v *ModuleValue) Marshal() string {
+	buf := &bytes.Buffer{}
+	var lenBuf [binary.MaxVarintLen64]byte
+	writeUvarint := func(x uint64) {
+		n := binary.PutUvarint(lenBuf[:], x)
+		buf.Write(lenBuf[:n])
+	}
+	writeUvarint(uint64(v.DBIndex))
+	var expireAt uint64
+	if v.Expiration != nil {
+		expireAt = uint64(v.Expiration.UnixNano() / 1e6)
+	}
+	writeUvarint(expireAt)
+	writeUvarint(uint64(len(v.Key)))
+	buf.WriteString(v.Key)
+	buf.Write(v.Payload)
+	return buf.String()
+}
+
+// UnmarshalModuleValue parses the value of aux field written by ModuleValue.Marshal
+func UnmarshalModuleValue(data string) (*ModuleValue, error) {
+	reader := bytes.NewReader([]byte(data))
+	dbIndex, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, errCorrupted
+	}
+	expireAt, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, errCorrupted
+	}
+	keyLen, err := binary.ReadUvarint(reader)
+	if err != nil || keyLen > uint64(reader.Len()) {
+		return nil, errCorrupted
+	}
+	key := make([]byte, keyLen)
+	_, _ = reader.Read(key)
+	payload := make([]byte, reader.Len())
+	_, _ = reader.Read(payload)
+	v := &ModuleValue{
+		DBIndex: int(dbIndex),
+		Key:     string(key),
+		Payload: payload,
+	}
+	if expireAt > 0 {
+		expiration := time.Unix(0, int64(expireAt)*int64(time.Millisecond))
+		v.Expiration = &expiration
+	}
+	return v, nil
+}
+
+// MarshalTopicLog serializes entries of retained log as payload of ModuleValue
+func MarshalTopicLog(entries []string) []byte {
+	buf := &bytes.Buffer{}
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(entries)))
+	buf.Write(lenBuf[:n])
+	for _, entry := range entries {
+		n = binary.PutUvarint(lenBuf[:], uint64(len(entry)))
+		buf.Write(lenBuf[:n])
+		buf.WriteString(entry)
+	}
+	return buf.Bytes()
+}
+
+// UnmarshalTopicLog parses payload produced by MarshalTopicLog
+func UnmarshalTopicLog(payload []byte) ([]string, error) {
+	reader := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(reader.Len()) {
+		return nil, errCorrupted
+	}
+	entries := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil || size > uint64(reader.Len()) {
+			return nil, errCorrupted
+		}
+		entry := make([]byte, size)
+		_, _ = reader.Read(entry)
+		entries = append(entries, string(entry))
+	}
+	return entries, nil
+}
+
+const (
+	opCodeResizeDB = 0xFB
+	opCodeSelectDB = 0xFE
+	opCodeAux      = 0xFA
+	opCodeEOF      = 0xFF
+)
+
+// AuxWriter sits between encoder and file, it writes aux fields at any position of rdb body.
+// encoder accepts aux fields only before the first db, and its checksum misses bytes not written by itself,
+// so AuxWriter checksums all bytes and writes the end of file instead of encoder
+type AuxWriter struct {
+	w   io.Writer
+	crc hash.Hash64
+}
+
+// NewAuxWriter wraps w
+func NewAuxWriter(w io.Writer) *AuxWriter {
+	return &AuxWriter{
+		w:   w,
+		crc: crc64.New(crc64.MakeTable(crc64.ISO)),
+	}
+}
+
+func (aw *AuxWriter) Write(p []byte) (int, error) {
+	n, err := aw.w.Write(p)
+	_, _ = aw.crc.Write(p[:n])
+	return n, err
+}
+
+// WriteAux writes an aux field, value is written as plain string
+func (aw *AuxWriter) WriteAux(key, value string) error {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(opCodeAux)
+	writeRDBString(buf, key)
+	writeRDBString(buf, value)
+	_, err := aw.Write(buf.Bytes())
+	return err
+}
+
+// WriteDBHeader writes db index and resize db like encoder.WriteDBHeader without checking state of encoder
+func (aw *AuxWriter) WriteDBHeader(dbIndex uint, keyCount, ttlCount uint64) error {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(opCodeSelectDB)
+	writeRDBLength(buf, uint64(dbIndex))
+	buf.WriteByte(opCodeResizeDB)
+	writeRDBLength(buf, keyCount)
+	writeRDBLength(buf, ttlCount)
+	_, err := aw.Write(buf.Bytes())
+	return err
+}
+
+// WriteEnd writes EOF and checksum
+func (aw *AuxWriter) WriteEnd() error {
+	_, err := aw.Write([]byte{opCodeEOF})
+	if err != nil {
+		return err
+	}
+	// checksum and the trailing LF are not checksummed
+	_, err = aw.w.Write(append(aw.crc.Sum(nil), '\n'))
+	return err
+}
+
+// writeRDBString writes string with length encoding of redis rdb
+func writeRDBString(buf *bytes.Buffer, s string) {
+	writeRDBLength(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// writeRDBLength writes length encoding of redis rdb
+func writeRDBLength(buf *bytes.Buffer, size uint64) {
+	switch {
+	case size < 1<<6:
+		buf.WriteByte(byte(size))
+	case size < 1<<14:
+		buf.WriteByte(byte(size>>8) | 0x40)
+		buf.WriteByte(byte(size))
+	case size <= math.MaxUint32:
+		buf.WriteByte(0x80)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(size))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(0x81)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], size)
+		buf.Write(b[:])
+	}
+}
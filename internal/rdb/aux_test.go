@@ -0,0 +1,80 @@
+package rdb
+
+import (
+	"bytes"
+	"hash/crc64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/parser"
+)
+
+func TestAuxWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	auxWriter := NewAuxWriter(buf)
+	enc := encoder.NewEncoder(auxWriter).EnableCompress()
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteDBHeader(0, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteStringObject("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	expiration := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	value := &ModuleValue{
+		DBIndex:    3,
+		Key:        "m",
+		Expiration: &expiration,
+		Payload:    []byte(strings.Repeat("x", 20000)), // long length encoding
+	}
+	if err := auxWriter.WriteAux(AuxGraph, value.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteStringObject("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auxWriter.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	body := data[:len(data)-9]
+	sum := crc64.New(crc64.MakeTable(crc64.ISO))
+	sum.Write(body)
+	if !bytes.Equal(sum.Sum(nil), data[len(data)-9:len(data)-1]) {
+		t.Error("checksum mismatch")
+	}
+
+	var keys []string
+	var loaded *ModuleValue
+	err := parser.NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().Parse(func(o parser.RedisObject) bool {
+		switch o.GetType() {
+		case parser.AuxType:
+			aux := o.(*parser.AuxObject)
+			if aux.Key == AuxGraph {
+				v, err := UnmarshalModuleValue(aux.Value)
+				if err != nil {
+					t.Error(err)
+				}
+				loaded = v
+			}
+		case parser.StringType:
+			keys = append(keys, o.GetKey())
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("expected keys a,b, actual %v", keys)
+	}
+	if loaded == nil || loaded.DBIndex != 3 || loaded.Key != "m" || !bytes.Equal(loaded.Payload, value.Payload) ||
+		loaded.Expiration == nil || !loaded.Expiration.Equal(expiration) {
+		t.Errorf("module value mismatch: %+v", loaded)
+	}
+}
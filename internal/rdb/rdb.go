@@ -0,0 +1,182 @@
+package rdb
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"slava/config"
+	"slava/internal/interface/database"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
+	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
+)
+
+// Write encodes all dbs in snapshot into w in rdb format
+func Write(w io.Writer, snapshot database.Snapshot) error {
+	return write(w, snapshot, false)
+}
+
+// WritePreamble encodes all dbs in snapshot into w as rdb preamble of aof, aof commands may follow it
+func WritePreamble(w io.Writer, snapshot database.Snapshot) error {
+	return write(w, snapshot, true)
+}
+
+func write(w io.Writer, snapshot database.Snapshot, aofPreamble bool) error {
+	auxWriter := NewAuxWriter(w)
+	// small hash and zset are written as ziplist just like their listpack encoding in memory
+	enc := encoder.NewEncoder(auxWriter).EnableCompress().
+		SetHashZipListOpt(config.Properties.HashMaxListpackValue, config.Properties.HashMaxListpackEntries).
+		SetZSetZipListOpt(config.Properties.ZSetMaxListpackValue, config.Properties.ZSetMaxListpackEntries)
+	err := enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+	preamble := "0"
+	if aofPreamble {
+		preamble = "1"
+	}
+	auxMap := map[string]string{
+		"redis-ver":    "6.0.0",
+		"redis-bits":   "64",
+		"aof-preamble": preamble,
+		"ctime":        strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	for k, v := range auxMap {
+		err := enc.WriteAux(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	encHeaderWritten := false
+	for i := 0; i < config.Properties.Databases; i++ {
+		keyCount, ttlCount := snapshot.GetDBSize(i)
+		if keyCount == 0 {
+			continue
+		}
+		// db header is written before the first key, a db holding modules only has no section
+		headerWritten := false
+		// dump db
+		var err2 error
+		snapshot.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if auxKey, payload := marshalModule(entity); auxKey != "" {
+				value := &ModuleValue{
+					DBIndex:    i,
+					Key:        key,
+					Expiration: expiration,
+					Payload:    payload,
+				}
+				err = auxWriter.WriteAux(auxKey, value.Marshal())
+				if err != nil {
+					err2 = err
+					return false
+				}
+				return true
+			}
+			if !headerWritten {
+				if encHeaderWritten {
+					// sets written by AuxWriter are unknown to encoder, it refuses a db header following an empty db
+					err = auxWriter.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
+				} else {
+					// encoder accepts objects only after it has written a db header
+					err = enc.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
+					encHeaderWritten = true
+				}
+				if err != nil {
+					err2 = err
+					return false
+				}
+				headerWritten = true
+			}
+			var opts []interface{}
+			if expiration != nil {
+				opts = append(opts, core.WithTTL(uint64(expiration.UnixNano()/1e6)))
+			}
+			switch obj := entity.Data.(type) {
+			case []byte:
+				err = enc.WriteStringObject(key, obj, opts...)
+			case int64:
+				// encoder writes string which could be parsed as integer in rdb integer encoding
+				err = enc.WriteStringObject(key, []byte(strconv.FormatInt(obj, 10)), opts...)
+			case quicklist.List:
+				vals := make([][]byte, 0, obj.Len())
+				obj.ForEach(func(i int, v interface{}) bool {
+					bytes, _ := v.([]byte)
+					vals = append(vals, bytes)
+					return true
+				})
+				err = enc.WriteListObject(key, vals, opts...)
+			case *list.List:
+				vals := make([][]byte, 0, obj.Len())
+				for _, node := range obj.Range(0, obj.Len()-1) {
+					vals = append(vals, []byte(node.GetValue()))
+				}
+				err = enc.WriteListObject(key, vals, opts...)
+			case *set.Set:
+				err = writeSetObject(auxWriter, key, obj, expiration)
+			case dict.Dict:
+				hash := make(map[string][]byte)
+				obj.ForEach(func(key string, val interface{}) bool {
+					bytes, _ := val.([]byte)
+					hash[key] = bytes
+					return true
+				})
+				err = enc.WriteHashMapObject(key, hash, opts...)
+			case *SortedSet.SortedSet:
+				var entries []*model.ZSetEntry
+				obj.ForEach(int64(0), obj.Len(), true, func(element *SortedSet.Element) bool {
+					entries = append(entries, &model.ZSetEntry{
+						Member: element.Member,
+						Score:  element.Score,
+					})
+					return true
+				})
+				err = enc.WriteZSetObject(key, entries, opts...)
+			}
+			if err != nil {
+				err2 = err
+				return false
+			}
+			return true
+		})
+		if err2 != nil {
+			return err2
+		}
+	}
+	// retained logs of durable topics
+	snapshot.ForEachTopic(func(topic string, entries []string) bool {
+		value := &ModuleValue{
+			Key:     topic,
+			Payload: MarshalTopicLog(entries),
+		}
+		err = auxWriter.WriteAux(AuxTopic, value.Marshal())
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	err = auxWriter.WriteEnd()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// marshalModule returns aux key and payload of value which rdb has no type for, aux key is empty for other values
+func marshalModule(entity *database.DataEntity) (string, []byte) {
+	switch obj := entity.Data.(type) {
+	case *graph.Graph:
+		return AuxGraph, obj.Marshal()
+	case *suggest.Trie:
+		return AuxSuggest, obj.Marshal()
+	}
+	return "", nil
+}
@@ -0,0 +1,139 @@
+package rdb
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"slava/internal/interface/database"
+	"slava/pkg/logger"
+)
+
+// ErrSaveInProgress is returned if a save is started while another one is running
+var ErrSaveInProgress = errors.New("Background save already in progress")
+
+// Saver saves snapshots of db into rdb file, it works without aof. only one save runs at a time
+type Saver struct {
+	db database.DBEngine
+	// saving is 1 while a save is running
+	saving int32
+	// lastSave is unix time of the last successful save
+	lastSave int64
+	// lastTry is unix time of the last save, successful or not
+	lastTry int64
+	// lastFailed is 1 if the last save failed
+	lastFailed int32
+}
+
+// MakeSaver creates a Saver of db, the last save time is initialized to now like redis does on startup
+func MakeSaver(db database.DBEngine) *Saver {
+	now := time.Now().Unix()
+	return &Saver{
+		db:       db,
+		lastSave: now,
+		lastTry:  now,
+	}
+}
+
+// Save takes a snapshot of db and writes it into filename, hook is called at the moment of snapshot
+func (saver *Saver) Save(filename string, hook func()) error {
+	snapshot, err := saver.begin(hook)
+	if err != nil {
+		return err
+	}
+	return saver.write(filename, snapshot)
+}
+
+// BGSave takes a snapshot of db before it returns and writes the snapshot into filename in background,
+// hook is called at the moment of snapshot, done is called with result of writing
+func (saver *Saver) BGSave(filename string, hook func(), done func(err error)) error {
+	snapshot, err := saver.begin(hook)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := saver.write(filename, snapshot)
+		if done != nil {
+			done(err)
+		}
+	}()
+	return nil
+}
+
+// InProgress returns whether a save is running
+func (saver *Saver) InProgress() bool {
+	return atomic.LoadInt32(&saver.saving) == 1
+}
+
+// LastSave returns unix time of the last successful save
+func (saver *Saver) LastSave() int64 {
+	return atomic.LoadInt64(&saver.lastSave)
+}
+
+// LastTry returns unix time of the last save, successful or not
+func (saver *Saver) LastTry() int64 {
+	return atomic.LoadInt64(&saver.lastTry)
+}
+
+// LastFailed returns whether the last save failed
+func (saver *Saver) LastFailed() bool {
+	return atomic.LoadInt32(&saver.lastFailed) == 1
+}
+
+func (saver *Saver) begin(hook func()) (database.Snapshot, error) {
+	if !atomic.CompareAndSwapInt32(&saver.saving, 0, 1) {
+		return nil, ErrSaveInProgress
+	}
+	return saver.db.Snapshot(hook), nil
+}
+
+// write saves snapshot by WriteFile and records the result,
+// filename is always a complete rdb file even if saving failed
+func (saver *Saver) write(filename string, snapshot database.Snapshot) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error(p)
+			err = errors.New("save rdb failed")
+		}
+		snapshot.Release()
+		now := time.Now().Unix()
+		atomic.StoreInt64(&saver.lastTry, now)
+		if err == nil {
+			atomic.StoreInt64(&saver.lastSave, now)
+			atomic.StoreInt32(&saver.lastFailed, 0)
+		} else {
+			atomic.StoreInt32(&saver.lastFailed, 1)
+		}
+		atomic.StoreInt32(&saver.saving, 0)
+	}()
+	return WriteFile(filename, snapshot)
+}
+
+// WriteFile writes snapshot into a temp file in the same directory and renames it to filename,
+// the temp file is removed if failed
+func WriteFile(filename string, snapshot database.Snapshot) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = Write(writer, snapshot)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
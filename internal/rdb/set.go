@@ -0,0 +1,75 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"slava/pkg/datastruct/set"
+)
+
+// rdb object types and opcodes used by writeSetObject
+const (
+	opCodeExpireTimeMs = 0xFC
+	typeSet            = 2
+	typeSetIntSet      = 11
+)
+
+// writeSetObject writes set as encoding in memory instead of encoder.WriteSetObject,
+// which writes any set of integers as intset no matter how large it is.
+// rdb before version 11 has no listpack set, so listpack and hashtable are both written as plain set,
+// redis and slava convert small plain set into compact encoding while loading
+func writeSetObject(aw *AuxWriter, key string, s *set.Set, expiration *time.Time) error {
+	buf := &bytes.Buffer{}
+	if expiration != nil {
+		buf.WriteByte(opCodeExpireTimeMs)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(expiration.UnixNano()/1e6))
+		buf.Write(b[:])
+	}
+	if ints, ok := s.Ints(); ok {
+		buf.WriteByte(typeSetIntSet)
+		writeRDBString(buf, key)
+		writeRDBString(buf, string(marshalIntset(ints)))
+	} else {
+		buf.WriteByte(typeSet)
+		writeRDBString(buf, key)
+		writeRDBLength(buf, uint64(s.Len()))
+		s.ForEach(func(member string) bool {
+			writeRDBString(buf, member)
+			return true
+		})
+	}
+	_, err := aw.Write(buf.Bytes())
+	return err
+}
+
+// marshalIntset encodes sorted integers as redis intset: encoding, length, then little endian integers
+func marshalIntset(ints []int64) []byte {
+	intSize := 2
+	for _, v := range ints {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			intSize = 8
+			break
+		}
+		if v < math.MinInt16 || v > math.MaxInt16 {
+			intSize = 4
+		}
+	}
+	b := make([]byte, 8+intSize*len(ints))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(intSize))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(ints)))
+	for i, v := range ints {
+		pos := 8 + i*intSize
+		switch intSize {
+		case 2:
+			binary.LittleEndian.PutUint16(b[pos:], uint16(v))
+		case 4:
+			binary.LittleEndian.PutUint32(b[pos:], uint32(v))
+		default:
+			binary.LittleEndian.PutUint64(b[pos:], uint64(v))
+		}
+	}
+	return b
+}
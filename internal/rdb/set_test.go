@@ -0,0 +1,96 @@
+package rdb
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"github.com/hdt3213/rdb/parser"
+	"slava/pkg/datastruct/set"
+)
+
+func TestWriteSetObject(t *testing.T) {
+	limits := set.Limits{MaxIntsetEntries: 4, MaxListpackEntries: 4, MaxListpackValue: 64}
+	intset := set.MakeCompact(limits, "3", "-70000", "1")
+	listpack := set.MakeCompact(limits, "a", "1")
+	// integers past intset limit are no longer written as intset
+	large := set.MakeCompact(limits)
+	for i := 0; i < 10; i++ {
+		large.Add(strconv.Itoa(i))
+	}
+	expiration := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+
+	buf := &bytes.Buffer{}
+	auxWriter := NewAuxWriter(buf)
+	enc := encoder.NewEncoder(auxWriter)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteDBHeader(0, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSetObject(auxWriter, "intset", intset, &expiration); err != nil {
+		t.Fatal(err)
+	}
+	// db holding sets only followed by another db
+	if err := auxWriter.WriteDBHeader(1, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSetObject(auxWriter, "listpack", listpack, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := auxWriter.WriteDBHeader(2, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSetObject(auxWriter, "large", large, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteStringObject("str", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auxWriter.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	type loadedSet struct {
+		db       int
+		encoding string
+		members  []string
+		ttl      bool
+	}
+	loaded := make(map[string]loadedSet)
+	err := parser.NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(o parser.RedisObject) bool {
+		if obj, ok := o.(*model.SetObject); ok {
+			members := make([]string, len(obj.Members))
+			for i, m := range obj.Members {
+				members[i] = string(m)
+			}
+			sort.Strings(members)
+			loaded[obj.Key] = loadedSet{
+				db:       obj.GetDBIndex(),
+				encoding: obj.GetEncoding(),
+				members:  members,
+				ttl:      obj.GetExpiration() != nil && obj.GetExpiration().Equal(expiration),
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := loaded["intset"]; s.db != 0 || s.encoding != model.IntSetEncoding ||
+		strings.Join(s.members, ",") != "-70000,1,3" || !s.ttl {
+		t.Errorf("unexpected intset: %+v", s)
+	}
+	if s := loaded["listpack"]; s.db != 1 || s.encoding != model.SetEncoding || len(s.members) != 2 || s.ttl {
+		t.Errorf("unexpected listpack: %+v", s)
+	}
+	if s := loaded["large"]; s.db != 2 || s.encoding != model.SetEncoding || len(s.members) != 10 {
+		t.Errorf("unexpected large set: %+v", s)
+	}
+}
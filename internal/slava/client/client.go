@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"net"
 	"runtime/debug"
@@ -13,7 +14,6 @@ import (
 	"slava/internal/protocol"
 	"slava/internal/slava/parser"
 	"slava/pkg/logger"
-	"slava/pkg/sync/wait"
 )
 
 const (
@@ -40,7 +40,7 @@ type request struct {
 	args      [][]byte
 	reply     slava.Reply
 	heartbeat bool
-	waiting   *wait.Wait
+	done      chan struct{} // closed when the reply arrives or the request fails
 	err       error
 }
 
@@ -113,7 +113,7 @@ func (client *Client) reconnect() {
 	close(client.waitingReqs)
 	for req := range client.waitingReqs {
 		req.err = errors.New("connection closed")
-		req.waiting.Done()
+		close(req.done)
 	}
 	client.waitingReqs = make(chan *request, chanSize)
 	// restart handle read
@@ -134,21 +134,35 @@ func (client *Client) handleWrite() {
 
 // Send sends a request to slava server
 func (client *Client) Send(args [][]byte) slava.Reply {
+	return client.SendContext(context.Background(), args)
+}
+
+// SendContext sends a request to slava server and gives up waiting when ctx is done.
+// the request given up is still answered by server, its reply is just dropped
+func (client *Client) SendContext(ctx context.Context, args [][]byte) slava.Reply {
 	if atomic.LoadInt32(&client.status) != running {
 		return protocol.MakeErrReply("client closed")
 	}
 	request := &request{
 		args:      args,
 		heartbeat: false,
-		waiting:   &wait.Wait{},
+		done:      make(chan struct{}),
 	}
-	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
-	timeout := request.waiting.WaitWithTimeout(maxWait)
-	if timeout {
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case client.pendingReqs <- request:
+	case <-ctx.Done():
+		return protocol.MakeErrReply("ERR " + ctx.Err().Error())
+	}
+	select {
+	case <-request.done:
+	case <-timer.C:
 		return protocol.MakeErrReply("server time out")
+	case <-ctx.Done():
+		return protocol.MakeErrReply("ERR " + ctx.Err().Error())
 	}
 	if request.err != nil {
 		return protocol.MakeErrReply("request failed")
@@ -160,13 +174,17 @@ func (client *Client) doHeartbeat() {
 	request := &request{
 		args:      [][]byte{[]byte("PING")},
 		heartbeat: true,
-		waiting:   &wait.Wait{},
+		done:      make(chan struct{}),
 	}
-	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
 	client.pendingReqs <- request
-	request.waiting.WaitWithTimeout(maxWait)
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-request.done:
+	case <-timer.C:
+	}
 }
 
 func (client *Client) doRequest(req *request) {
@@ -188,7 +206,7 @@ func (client *Client) doRequest(req *request) {
 		client.waitingReqs <- req
 	} else {
 		req.err = err
-		req.waiting.Done()
+		close(req.done)
 	}
 }
 
@@ -204,13 +222,11 @@ func (client *Client) finishRequest(reply slava.Reply) {
 		return
 	}
 	request.reply = reply
-	if request.waiting != nil {
-		request.waiting.Done()
-	}
+	close(request.done)
 }
 
 func (client *Client) handleRead() {
-	ch := parser.ParseStream(client.conn)
+	ch := parser.ParseReplyStream(client.conn)
 	for payload := range ch {
 		if payload.Err != nil {
 			status := atomic.LoadInt32(&client.status)
@@ -20,10 +20,25 @@ type Payload struct {
 	Err  error
 }
 
-// ParseStream reads data from io.Reader and send payloads through channel
+const (
+	// maxPrealloc limits capacity allocated by array header, elements beyond it are appended as they arrive
+	maxPrealloc = 1024
+	// maxReplyDepth limits nesting of arrays in replies
+	maxReplyDepth = 32
+)
+
+// ParseStream reads requests from io.Reader and send payloads through channel, arrays must consist of bulk strings
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go parse0(reader, ch, false)
+	return ch
+}
+
+// ParseReplyStream reads replies from io.Reader and send payloads through channel,
+// arrays may contain integers, status, errors and nested arrays
+func ParseReplyStream(reader io.Reader) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parse0(reader, ch, true)
 	return ch
 }
 
@@ -31,7 +46,7 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 func ParseBytes(data []byte) ([]slava.Reply, error) {
 	ch := make(chan *Payload)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	go parse0(reader, ch, false)
 	var results []slava.Reply
 	for payload := range ch {
 		if payload == nil {
@@ -52,7 +67,7 @@ func ParseBytes(data []byte) ([]slava.Reply, error) {
 func ParseOne(data []byte) (slava.Reply, error) {
 	ch := make(chan *Payload)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	go parse0(reader, ch, false)
 	payload := <-ch // parse0 will close the channel
 	if payload == nil {
 		return nil, errors.New("no protocol")
@@ -60,7 +75,7 @@ func ParseOne(data []byte) (slava.Reply, error) {
 	return payload.Data, payload.Err
 }
 
-func parse0(rawReader io.Reader, ch chan<- *Payload) {
+func parse0(rawReader io.Reader, ch chan<- *Payload, isReply bool) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(err, string(debug.Stack()))
@@ -116,7 +131,11 @@ func parse0(rawReader io.Reader, ch chan<- *Payload) {
 				return
 			}
 		case '*':
-			err = parseArray(line, reader, ch)
+			if isReply {
+				err = parseReplyArray(line, reader, ch)
+			} else {
+				err = parseArray(line, reader, ch)
+			}
 			if err != nil {
 				ch <- &Payload{Err: err}
 				close(ch)
@@ -186,7 +205,7 @@ func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 		}
 		return nil
 	}
-	lines := make([][]byte, 0, nStrs)
+	lines := make([][]byte, 0, preallocSize(nStrs))
 	for i := int64(0); i < nStrs; i++ {
 		var line []byte
 		line, err = reader.ReadBytes('\n')
@@ -219,6 +238,119 @@ func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 	return nil
 }
 
+func parseReplyArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+	reply, err := readArray(header, reader, 1)
+	if err != nil {
+		if protoErr, ok := err.(*protocolErr); ok {
+			protocolError(ch, protoErr.msg)
+			return nil
+		}
+		return err
+	}
+	ch <- &Payload{
+		Data: reply,
+	}
+	return nil
+}
+
+// protocolErr means illegal data in stream, parser could continue reading following lines
+type protocolErr struct {
+	msg string
+}
+
+func (e *protocolErr) Error() string {
+	return e.msg
+}
+
+// readArray reads elements of array whose header has been read, elements may be bulk strings,
+// integers, status, errors or nested arrays.
+// It returns MultiBulkReply if all elements are bulk strings, otherwise MultiRawReply
+func readArray(header []byte, reader *bufio.Reader, depth int) (slava.Reply, error) {
+	if depth > maxReplyDepth {
+		return nil, &protocolErr{"too deeply nested array"}
+	}
+	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || nStrs < 0 {
+		return nil, &protocolErr{"illegal array header " + string(header[1:])}
+	} else if nStrs == 0 {
+		return protocol.MakeEmptyMultiBulkReply(), nil
+	}
+	lines := make([][]byte, 0, preallocSize(nStrs))
+	// replies is not nil once an element other than bulk string is found
+	var replies []slava.Reply
+	for i := int64(0); i < nStrs; i++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		length := len(line)
+		if length < 3 || line[length-2] != '\r' {
+			return nil, &protocolErr{"illegal array element " + string(line)}
+		}
+		line = line[:length-2]
+		var element slava.Reply
+		switch line[0] {
+		case '$':
+			strLen, err := strconv.ParseInt(string(line[1:]), 10, 64)
+			if err != nil || strLen < -1 {
+				return nil, &protocolErr{"illegal bulk string length " + string(line)}
+			}
+			if strLen == -1 {
+				if replies == nil {
+					lines = append(lines, []byte{})
+					continue
+				}
+				element = protocol.MakeNullBulkReply()
+				break
+			}
+			body := make([]byte, strLen+2)
+			if _, err = io.ReadFull(reader, body); err != nil {
+				return nil, err
+			}
+			if replies == nil {
+				lines = append(lines, body[:len(body)-2])
+				continue
+			}
+			element = protocol.MakeBulkReply(body[:len(body)-2])
+		case ':':
+			value, err := strconv.ParseInt(string(line[1:]), 10, 64)
+			if err != nil {
+				return nil, &protocolErr{"illegal number " + string(line[1:])}
+			}
+			element = protocol.MakeIntReply(value)
+		case '+':
+			element = protocol.MakeStatusReply(string(line[1:]))
+		case '-':
+			element = protocol.MakeErrReply(string(line[1:]))
+		case '*':
+			element, err = readArray(line, reader, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &protocolErr{"illegal array element " + string(line)}
+		}
+		if replies == nil {
+			replies = make([]slava.Reply, 0, preallocSize(nStrs))
+			for _, l := range lines {
+				replies = append(replies, protocol.MakeBulkReply(l))
+			}
+		}
+		replies = append(replies, element)
+	}
+	if replies != nil {
+		return protocol.MakeMultiRawReply(replies), nil
+	}
+	return protocol.MakeMultiBulkReply(lines), nil
+}
+
+func preallocSize(n int64) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
 func protocolError(ch chan<- *Payload, msg string) {
 	err := errors.New("protocol error: " + msg)
 	ch <- &Payload{Err: err}
@@ -55,6 +55,39 @@ func TestParseStream(t *testing.T) {
 	}
 }
 
+func TestParseReplyStream(t *testing.T) {
+	nested := protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte("channel")),
+		protocol.MakeIntReply(2),
+		protocol.MakeMultiRawReply([]slava.Reply{
+			protocol.MakeStatusReply("write"),
+			protocol.MakeNullBulkReply(),
+		}),
+		protocol.MakeEmptyMultiBulkReply(),
+	})
+	ch := ParseReplyStream(bytes.NewReader(nested.ToBytes()))
+	payload := <-ch
+	if payload.Err != nil {
+		t.Fatal(payload.Err)
+	}
+	if !utils.BytesEquals(nested.ToBytes(), payload.Data.ToBytes()) {
+		t.Error("parse failed: " + string(nested.ToBytes()))
+	}
+
+	// requests are arrays of bulk strings only
+	ch = ParseStream(bytes.NewReader(nested.ToBytes()))
+	if payload := <-ch; payload.Err == nil {
+		t.Error("expected protocol error for nested request")
+	}
+
+	deep := bytes.Repeat([]byte("*1\r\n"), maxReplyDepth+1)
+	deep = append(deep, []byte(":1\r\n")...)
+	ch = ParseReplyStream(bytes.NewReader(deep))
+	if payload := <-ch; payload.Err == nil {
+		t.Error("expected protocol error for too deeply nested reply")
+	}
+}
+
 func TestParseOne(t *testing.T) {
 	replies := []slava.Reply{
 		protocol.MakeIntReply(1),
@@ -30,6 +30,10 @@ type Connection struct {
 
 	// subscribing channels
 	subs map[string]bool
+	// subscribing patterns
+	psubs map[string]bool
+	// subscribing shard channels
+	ssubs map[string]bool
 
 	// password may be changed by CONFIG command during runtime, so store the password
 	password string
@@ -59,6 +63,8 @@ func (c *Connection) Close() error {
 	c.sendingData.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
 	c.subs = nil
+	c.psubs = nil
+	c.ssubs = nil
 	c.password = ""
 	c.queue = nil
 	c.watching = nil
@@ -123,9 +129,53 @@ func (c *Connection) UnSubscribe(channel string) {
 	delete(c.subs, channel)
 }
 
-// SubsCount returns the number of subscribing channels
+// PSubscribe add current connection into subscribers of the given pattern
+func (c *Connection) PSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.psubs == nil {
+		c.psubs = make(map[string]bool)
+	}
+	c.psubs[pattern] = true
+}
+
+// PUnSubscribe removes current connection from subscribers of the given pattern
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.psubs) == 0 {
+		return
+	}
+	delete(c.psubs, pattern)
+}
+
+// SSubscribe add current connection into subscribers of the given shard channel
+func (c *Connection) SSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ssubs == nil {
+		c.ssubs = make(map[string]bool)
+	}
+	c.ssubs[channel] = true
+}
+
+// SUnSubscribe removes current connection from subscribers of the given shard channel
+func (c *Connection) SUnSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.ssubs) == 0 {
+		return
+	}
+	delete(c.ssubs, channel)
+}
+
+// SubsCount returns the number of subscribing channels and patterns
 func (c *Connection) SubsCount() int {
-	return len(c.subs)
+	return len(c.subs) + len(c.psubs)
 }
 
 // GetChannels returns all subscribing channels
@@ -142,6 +192,30 @@ func (c *Connection) GetChannels() []string {
 	return channels
 }
 
+// GetPatterns returns all subscribing patterns
+func (c *Connection) GetPatterns() []string {
+	if c.psubs == nil {
+		return make([]string, 0)
+	}
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// GetShardChannels returns all subscribing shard channels
+func (c *Connection) GetShardChannels() []string {
+	if c.ssubs == nil {
+		return make([]string, 0)
+	}
+	channels := make([]string, 0, len(c.ssubs))
+	for channel := range c.ssubs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
 // SetPassword stores password for authentication
 func (c *Connection) SetPassword(password string) {
 	c.password = password
@@ -162,6 +236,7 @@ func (c *Connection) SetMultiState(state bool) {
 	if !state { // reset data when cancel multi
 		c.watching = nil
 		c.queue = nil
+		c.txErrors = nil
 		c.flags &= ^flagMulti // clean multi flag
 		return
 	}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"sort"
+	"strings"
+
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/wildcard"
+)
+
+// sysCmdTable 保存由 Server 直接处理的命令的元数据, 它们不经过 DB.Exec, 只用于 COMMAND 查询
+var sysCmdTable = make(map[string]*command)
+
+func registerSysCommand(name string, arity int, flags int) *command {
+	name = strings.ToLower(name)
+	cmd := &command{
+		name:       name,
+		arity:      arity,
+		flags:      flags,
+		categories: flagCategories(flags),
+	}
+	sysCmdTable[name] = cmd
+	return cmd
+}
+
+func init() {
+	registerSysCommand("Ping", -1, flagFast).category("@connection")
+	registerSysCommand("Auth", 2, flagFast).category("@connection")
+	registerSysCommand("Command", -1, flagFast).category("@connection")
+	registerSysCommand("Select", 2, flagFast).category("@connection")
+	registerSysCommand("SlaveOf", 3, flagAdmin)
+	registerSysCommand("ReplConf", -1, flagAdmin)
+	registerSysCommand("PSync", -3, flagAdmin)
+	registerSysCommand("Subscribe", -2, flagPubSub)
+	registerSysCommand("Unsubscribe", -1, flagPubSub)
+	registerSysCommand("PSubscribe", -2, flagPubSub)
+	registerSysCommand("PUnsubscribe", -1, flagPubSub)
+	registerSysCommand("Publish", 3, flagPubSub)
+	registerSysCommand("SSubscribe", -2, flagPubSub).keys(1, -1, 1)
+	registerSysCommand("SUnsubscribe", -1, flagPubSub).keys(1, -1, 1)
+	registerSysCommand("SPublish", 3, flagPubSub).keys(1, 1, 1)
+	registerSysCommand("PubSub", -2, flagPubSub)
+	registerSysCommand("BGRewriteAOF", 1, flagAdmin)
+	registerSysCommand("RewriteAOF", 1, flagAdmin)
+	registerSysCommand("Save", 1, flagAdmin)
+	registerSysCommand("BGSave", -1, flagAdmin)
+	registerSysCommand("LastSave", 1, flagFast)
+	registerSysCommand("FlushAll", -1, flagWrite).category("@keyspace", "@dangerous")
+	registerSysCommand("FlushDB", -1, flagWrite).category("@keyspace", "@dangerous")
+	registerSysCommand("SwapDB", 3, flagWrite).category("@keyspace", "@dangerous")
+	registerSysCommand("Copy", -3, flagWrite|flagDenyOOM).keys(1, 2, 1).category("@keyspace")
+	registerSysCommand("Move", 3, flagWrite).keys(1, 1, 1).category("@keyspace")
+	registerSysCommand("Memory", -2, flagReadOnly).keys(2, 2, 1).category("@keyspace")
+	registerSysCommand("Info", -1, flagReadOnly).category("@dangerous")
+	registerSysCommand("ID.Next", -1, flagWrite)
+	registerSysCommand("ID.Decode", 2, flagReadOnly)
+	registerSysCommand("Multi", 1, flagFast).category("@transaction")
+	registerSysCommand("Exec", 1, flagFast).category("@transaction")
+	registerSysCommand("Discard", 1, flagFast).category("@transaction")
+	registerSysCommand("Watch", -2, flagFast).keys(1, -1, 1).category("@transaction")
+}
+
+func lookupCommand(name string) *command {
+	name = strings.ToLower(name)
+	if cmd, ok := cmdTable[name]; ok {
+		return cmd
+	}
+	return sysCmdTable[name]
+}
+
+// allCommands returns all commands sorted by name
+func allCommands() []*command {
+	result := make([]*command, 0, len(cmdTable)+len(sysCmdTable))
+	for _, cmd := range cmdTable {
+		result = append(result, cmd)
+	}
+	for _, cmd := range sysCmdTable {
+		result = append(result, cmd)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func (cmd *command) flagNames() []string {
+	var names []string
+	for _, f := range flagTable {
+		if cmd.flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// group returns command group used by COMMAND DOCS
+func (cmd *command) group() string {
+	for _, category := range cmd.categories {
+		switch category {
+		case "@keyspace":
+			return "generic"
+		case "@sortedset":
+			return "sorted-set"
+		case "@transaction":
+			return "transactions"
+		case "@connection", "@string", "@list", "@pubsub", "@graph", "@suggest":
+			return category[1:]
+		}
+	}
+	return "server"
+}
+
+func makeStatusList(items []string) slava.Reply {
+	replies := make([]slava.Reply, len(items))
+	for i, item := range items {
+		replies[i] = protocol.MakeStatusReply(item)
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// keySpecReply 按照 redis 7 的 key specs 格式描述 key 的位置
+func (cmd *command) keySpecReply() slava.Reply {
+	if cmd.firstKey == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	lastKey := cmd.lastKey
+	if lastKey >= 0 {
+		// relative to first key
+		lastKey -= cmd.firstKey
+	}
+	access := "RO"
+	if cmd.flags&flagWrite != 0 {
+		access = "RW"
+	}
+	spec := []slava.Reply{
+		protocol.MakeBulkReply([]byte("flags")),
+		makeStatusList([]string{access}),
+		protocol.MakeBulkReply([]byte("begin_search")),
+		protocol.MakeMultiRawReply([]slava.Reply{
+			protocol.MakeBulkReply([]byte("type")),
+			protocol.MakeBulkReply([]byte("index")),
+			protocol.MakeBulkReply([]byte("spec")),
+			protocol.MakeMultiRawReply([]slava.Reply{
+				protocol.MakeBulkReply([]byte("index")),
+				protocol.MakeIntReply(int64(cmd.firstKey)),
+			}),
+		}),
+		protocol.MakeBulkReply([]byte("find_keys")),
+		protocol.MakeMultiRawReply([]slava.Reply{
+			protocol.MakeBulkReply([]byte("type")),
+			protocol.MakeBulkReply([]byte("range")),
+			protocol.MakeBulkReply([]byte("spec")),
+			protocol.MakeMultiRawReply([]slava.Reply{
+				protocol.MakeBulkReply([]byte("lastkey")),
+				protocol.MakeIntReply(int64(lastKey)),
+				protocol.MakeBulkReply([]byte("keystep")),
+				protocol.MakeIntReply(int64(cmd.keyStep)),
+				protocol.MakeBulkReply([]byte("limit")),
+				protocol.MakeIntReply(0),
+			}),
+		}),
+	}
+	return protocol.MakeMultiRawReply([]slava.Reply{protocol.MakeMultiRawReply(spec)})
+}
+
+// infoReply returns reply of COMMAND INFO in redis 7 format:
+// name, arity, flags, first key, last key, step, acl categories, tips, key specs, subcommands
+func (cmd *command) infoReply() slava.Reply {
+	return protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte(cmd.name)),
+		protocol.MakeIntReply(int64(cmd.arity)),
+		makeStatusList(cmd.flagNames()),
+		protocol.MakeIntReply(int64(cmd.firstKey)),
+		protocol.MakeIntReply(int64(cmd.lastKey)),
+		protocol.MakeIntReply(int64(cmd.keyStep)),
+		makeStatusList(cmd.categories),
+		protocol.MakeEmptyMultiBulkReply(),
+		cmd.keySpecReply(),
+		protocol.MakeEmptyMultiBulkReply(),
+	})
+}
+
+// docsReply returns reply of COMMAND DOCS.
+// slava keeps no documents of commands, so summary, since, complexity and arguments of redis are absent,
+// only group is given for clients such as redis-cli which group commands in help
+func (cmd *command) docsReply() slava.Reply {
+	return protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte("group")),
+		protocol.MakeBulkReply([]byte(cmd.group())),
+	})
+}
+
+// getKeys extracts keys from command line (including command name)
+func (cmd *command) getKeys(cmdLine [][]byte) []string {
+	if cmd.prepare != nil {
+		write, read := cmd.prepare(cmdLine[1:])
+		return append(write, read...)
+	}
+	if cmd.firstKey == 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(cmdLine)
+	}
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(cmdLine); i += cmd.keyStep {
+		keys = append(keys, string(cmdLine[i]))
+	}
+	return keys
+}
+
+// execCommand returns details about commands
+// command [COUNT|LIST|INFO|DOCS|GETKEYS|HELP]
+func execCommand(args [][]byte) slava.Reply {
+	if len(args) == 0 {
+		commands := allCommands()
+		replies := make([]slava.Reply, len(commands))
+		for i, cmd := range commands {
+			replies[i] = cmd.infoReply()
+		}
+		return protocol.MakeMultiRawReply(replies)
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "help":
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("(no subcommand)"),
+			[]byte("    Return details about all commands."),
+			[]byte("COUNT"),
+			[]byte("    Return the total number of commands in this server."),
+			[]byte("LIST [FILTERBY (ACLCAT <category>|PATTERN <pattern>)]"),
+			[]byte("    Return a list of all commands in this server."),
+			[]byte("INFO [<command-name> ...]"),
+			[]byte("    Return details about the given commands, or all commands if none given."),
+			[]byte("DOCS [<command-name> ...]"),
+			[]byte("    Return documentation about the given commands, or all commands if none given."),
+			[]byte("GETKEYS <full-command>"),
+			[]byte("    Return the keys from a full command."),
+		})
+	case "count":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("command|count")
+		}
+		return protocol.MakeIntReply(int64(len(cmdTable) + len(sysCmdTable)))
+	case "list":
+		return execCommandList(args[1:])
+	case "info":
+		if len(args) == 1 {
+			return execCommand(nil)
+		}
+		replies := make([]slava.Reply, 0, len(args)-1)
+		for _, name := range args[1:] {
+			cmd := lookupCommand(string(name))
+			if cmd == nil {
+				replies = append(replies, protocol.MakeNullBulkReply())
+				continue
+			}
+			replies = append(replies, cmd.infoReply())
+		}
+		return protocol.MakeMultiRawReply(replies)
+	case "docs":
+		var commands []*command
+		if len(args) == 1 {
+			commands = allCommands()
+		} else {
+			for _, name := range args[1:] {
+				// unknown commands are omitted
+				if cmd := lookupCommand(string(name)); cmd != nil {
+					commands = append(commands, cmd)
+				}
+			}
+		}
+		replies := make([]slava.Reply, 0, 2*len(commands))
+		for _, cmd := range commands {
+			replies = append(replies, protocol.MakeBulkReply([]byte(cmd.name)), cmd.docsReply())
+		}
+		return protocol.MakeMultiRawReply(replies)
+	case "getkeys":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("command|getkeys")
+		}
+		cmdLine := args[1:]
+		cmd := lookupCommand(string(cmdLine[0]))
+		if cmd == nil {
+			return protocol.MakeErrReply("ERR Invalid command specified")
+		}
+		if !validateArity(cmd.arity, cmdLine) {
+			return protocol.MakeErrReply("ERR Invalid number of arguments specified for command")
+		}
+		keys := cmd.getKeys(cmdLine)
+		if len(keys) == 0 {
+			return protocol.MakeErrReply("ERR The command has no key arguments")
+		}
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return protocol.MakeMultiBulkReply(result)
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try COMMAND HELP.")
+}
+
+// execCommandList implements command list [FILTERBY ACLCAT category|PATTERN pattern]
+func execCommandList(args [][]byte) slava.Reply {
+	filter := func(cmd *command) bool { return true }
+	if len(args) > 0 {
+		if len(args) != 3 || strings.ToLower(string(args[0])) != "filterby" {
+			return &protocol.SyntaxErrReply{}
+		}
+		value := string(args[2])
+		switch strings.ToLower(string(args[1])) {
+		case "aclcat":
+			category := "@" + strings.TrimPrefix(strings.ToLower(value), "@")
+			filter = func(cmd *command) bool {
+				for _, c := range cmd.categories {
+					if c == category {
+						return true
+					}
+				}
+				return false
+			}
+		case "pattern":
+			pattern, err := wildcard.CompilePattern(strings.ToLower(value))
+			if err != nil {
+				return protocol.MakeErrReply("ERR illegal wildcard")
+			}
+			filter = func(cmd *command) bool {
+				return pattern.IsMatch(cmd.name)
+			}
+		case "module":
+			// modules are not supported
+			return protocol.MakeEmptyMultiBulkReply()
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	var result [][]byte
+	for _, cmd := range allCommands() {
+		if filter(cmd) {
+			result = append(result, []byte(cmd.name))
+		}
+	}
+	if len(result) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
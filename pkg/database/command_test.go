@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+
+	"slava/internal/protocol"
+	"slava/internal/utils"
+)
+
+func TestCommandInfo(t *testing.T) {
+	reply := execCommand(utils.ToCmdLine("count"))
+	count := reply.(*protocol.IntReply).Code
+	if count != int64(len(cmdTable)+len(sysCmdTable)) {
+		t.Errorf("wrong command count %d", count)
+	}
+
+	reply = execCommand(utils.ToCmdLine("info", "mset", "no-such-command"))
+	infos := reply.(*protocol.MultiRawReply).Replies
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 replies, actual %d", len(infos))
+	}
+	if _, ok := infos[1].(*protocol.NullBulkReply); !ok {
+		t.Errorf("unknown command should be nil")
+	}
+	mset := infos[0].(*protocol.MultiRawReply).Replies
+	if len(mset) != 10 {
+		t.Fatalf("expected 10 fields, actual %d", len(mset))
+	}
+	assertReply(t, mset[0], protocol.MakeBulkReply([]byte("mset")))
+	assertReply(t, mset[1], protocol.MakeIntReply(-3))
+	assertReply(t, mset[2], makeStatusList([]string{"write", "denyoom"}))
+	for i, expected := range []int64{1, -1, 2} {
+		assertReply(t, mset[3+i], protocol.MakeIntReply(expected))
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	if del := lookupCommand("del"); del.flags&flagDenyOOM != 0 || len(del.flagNames()) != 1 {
+		t.Errorf("DEL should be write only, actual %v", del.flagNames())
+	}
+	// zero value has no flag
+	cmd := &command{}
+	if len(cmd.flagNames()) != 0 || cmd.flags&flagWrite != 0 {
+		t.Errorf("command without flags should not be write, actual %v", cmd.flagNames())
+	}
+	if categories := flagCategories(flagWrite | flagDenyOOM); len(categories) != 1 || categories[0] != "@write" {
+		t.Errorf("unexpected categories %v", categories)
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	cases := []struct {
+		cmdLine  []string
+		expected []string
+	}{
+		{[]string{"set", "k", "v", "EX", "10"}, []string{"k"}},
+		{[]string{"mset", "k1", "v1", "k2", "v2"}, []string{"k1", "k2"}},
+		{[]string{"del", "k1", "k2", "k3"}, []string{"k1", "k2", "k3"}},
+		{[]string{"rename", "src", "dest"}, []string{"src", "dest"}},
+		{[]string{"object", "encoding", "k"}, []string{"k"}},
+		{[]string{"copy", "src", "dest", "db", "1"}, []string{"src", "dest"}},
+		{[]string{"watch", "k1", "k2"}, []string{"k1", "k2"}},
+	}
+	for _, c := range cases {
+		reply := execCommand(utils.ToCmdLine2("getkeys", c.cmdLine...))
+		assertReply(t, reply, protocol.MakeMultiBulkReply(utils.ToCmdLine(c.expected...)))
+	}
+	if reply := execCommand(utils.ToCmdLine("getkeys", "dbsize")); !protocol.IsErrorReply(reply) {
+		t.Errorf("dbsize has no key arguments")
+	}
+	if reply := execCommand(utils.ToCmdLine("getkeys", "get")); !protocol.IsErrorReply(reply) {
+		t.Errorf("wrong number of arguments should be rejected")
+	}
+}
+
+func TestCommandList(t *testing.T) {
+	reply := execCommand(utils.ToCmdLine("list", "filterby", "pattern", "graph.*"))
+	names := reply.(*protocol.MultiBulkReply).Args
+	for _, name := range names {
+		if cmdTable[string(name)].group() != "graph" {
+			t.Errorf("unexpected command %s", name)
+		}
+	}
+	reply = execCommand(utils.ToCmdLine("list", "filterby", "aclcat", "transaction"))
+	assertReply(t, reply, protocol.MakeMultiBulkReply(utils.ToCmdLine("discard", "exec", "multi", "watch")))
+}
@@ -1,20 +1,23 @@
 package database
 
 import (
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"slava/config"
 	. "slava/internal/data"
 	"slava/internal/interface/database"
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
 	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
 	"slava/pkg/datastruct/list"
 	"slava/pkg/datastruct/lock"
+	"slava/pkg/datastruct/set"
 	SortedSet "slava/pkg/datastruct/sortedset"
-	"slava/pkg/logger"
-	"slava/pkg/timewheel"
+	"slava/pkg/datastruct/suggest"
 )
 
 // 分数据库的功能，slava含有16个分数据库
@@ -27,7 +30,7 @@ import (
 
 // TODO DB字段设置
 type DB struct {
-	index      int       // 表示第几个分数据库
+	index      int32     // 表示第几个分数据库, swapdb 时会被修改, 需通过 getIndex/setIndex 原子读写
 	data       dict.Dict // 分数据库的数据存放
 	ttlMap     dict.Dict // key所对应的过期时间
 	versionMap dict.Dict // key所对应的版本（uint32）
@@ -35,6 +38,27 @@ type DB struct {
 	// 仅对复杂的命令使用该互斥锁，如，rpush，incr,msetnx...
 	locker *lock.Locks
 	AddAof func(CmdLine)
+	// notifier publishes keyspace events, nil means disabled
+	notifier func(dbIndex int, class int, event string, key string)
+	// notifyEnabled tells whether events of the class would be published, nil means always
+	notifyEnabled func(class int) bool
+	// pendingEvents are keyspace events waiting for their keys to be unlocked
+	pendingEvents keyEventQueue
+	// stats of server the db belongs to, nil if the db is used alone
+	stats *serverStats
+	// lazyfree frees big values in background, nil means values are always freed at once
+	lazyfree *lazyFreer
+	// snapshots are active snapshots of db ([]*dbSnapshot), keys are preserved for them before modified
+	snapshots atomic.Value
+}
+
+// getIndex returns index of db, it may be changed by swapdb at any time
+func (db *DB) getIndex() int {
+	return int(atomic.LoadInt32(&db.index))
+}
+
+func (db *DB) setIndex(index int) {
+	atomic.StoreInt32(&db.index, int32(index))
 }
 
 // slava命令的执行函数
@@ -59,9 +83,9 @@ func MakeDB() *DB {
 
 func makeDB() *DB {
 	db := &DB{
-		data:       dict.MakeConcurrent(DataDictSize),
-		ttlMap:     dict.MakeConcurrent(TtlDictSize),
-		versionMap: dict.MakeConcurrent(DataDictSize),
+		data:       dict.MakeConcurrent(config.Properties.DataDictSize),
+		ttlMap:     dict.MakeConcurrent(config.Properties.TtlDictSize),
+		versionMap: dict.MakeConcurrent(config.Properties.DataDictSize),
 		locker:     lock.Make(LockerSize),
 		AddAof:     func(line CmdLine) {},
 	}
@@ -69,31 +93,9 @@ func makeDB() *DB {
 }
 
 // 一个分数据库中执行命令
-// transaction包下有控制事务命令和其他不能在事务中执行的命令
+// 事务控制命令(multi, exec, discard, watch)由Server处理, 因为事务可以跨越多个分数据库
 
 func (db *DB) Exec(c slava.Connection, cmdLine CmdLine) slava.Reply {
-	cmdName := strings.ToLower(string(cmdLine[0]))
-	if cmdName == "multi" { // 命令为开始事务的命令
-		if len(cmdLine) != 1 {
-			return protocol.MakeArgNumErrReply(cmdName)
-		}
-		return StartMulti(c)
-	} else if cmdName == "discard" { // 撤销事务
-		if len(cmdLine) != 1 {
-			return protocol.MakeArgNumErrReply(cmdName)
-		}
-		return DiscardMulti(c)
-	} else if cmdName == "exec" { // 提交事务
-		if len(cmdLine) != 1 {
-			return protocol.MakeErrReply(cmdName)
-		}
-		return execMulti(db, c)
-	} else if cmdName == "watch" {
-		if !validateArity(-2, cmdLine) {
-			return protocol.MakeArgNumErrReply(cmdName)
-		}
-		return Watch(db, c, cmdLine[1:])
-	}
 	// 如果现在是开启事务的状态，则将该命令入队
 	if c != nil && c.InMultiState() {
 		return EnqueueCmd(c, cmdLine)
@@ -115,8 +117,8 @@ func (db *DB) execNormalCommand(cmdLine [][]byte) slava.Reply {
 	prepare := cmd.prepare
 	write, read := prepare(cmdLine[1:])
 	db.addVersion(write...)
-	db.locker.RWLocks(write, read)
-	defer db.locker.RWUnLocks(write, read)
+	db.RWLocks(write, read)
+	defer db.RWUnLocks(write, read)
 	fun := cmd.executor
 	return fun(db, cmdLine[1:])
 }
@@ -152,66 +154,51 @@ func (db *DB) execWithLock(cmdLine [][]byte) slava.Reply {
 	return fun(db, cmdLine[1:])
 }
 
+// versionClock 为所有分数据库分配版本码, 版本码在分数据库之间不会重复,
+// 所以 SWAPDB 或 FLUSHDB 替换分数据库之后, 监控的已写入过的key都会被视为发生了改变
+var versionClock uint32
+
 // 对于写入的操作，则要更改相应键值的version信息
 func (db *DB) addVersion(keys ...string) {
 	for _, key := range keys {
-		versionCode := db.GetVersion(key)
-		db.versionMap.Put(key, versionCode+1)
+		db.versionMap.Put(key, atomic.AddUint32(&versionClock, 1))
 	}
 }
 
-// TTL的函数
-func genExpireTask(key string) string {
-	return "expire:" + key
-}
-
-// Expire 设置key的过期时间
+// Expire 设置key的过期时间, 过期的key由主动过期周期或访问时删除, 见 expire.go
 func (db *DB) Expire(key string, expireTime time.Time) {
 	db.ttlMap.Put(key, expireTime)
-	taskKey := genExpireTask(key)
-	timewheel.At(expireTime, taskKey, func() {
-		keys := []string{key}
-		db.locker.RWLocks(keys, nil)
-		defer db.locker.RWUnLocks(keys, nil)
-		// check-lock-check, ttl may be updated during waiting lock
-		logger.Info("expire" + key)
-		rawExpireTime, ok := db.ttlMap.Get(key)
-		if !ok {
-			return
-		}
-		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			db.Remove(key)
-		}
-	})
 }
 
 // 取消一个key的ttlCmd
 func (db *DB) Persist(key string) {
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
 }
 
-// 判断一个键值是否过期
+// 判断一个键值是否过期, 过期则删除
 func (db *DB) IsExpired(key string) bool {
 	//db.locker.Lock(key)
 	//defer db.locker.UnLock(key)
-	rawExpireTime, exists := db.ttlMap.Get(key)
+	return db.expireIfNeeded(key)
+}
+
+// 实现分数据库对数据的操作
+func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+	raw, exists := db.data.Get(key)
 	if !exists {
-		return false
+		return nil, false
 	}
-	expireTime, _ := rawExpireTime.(time.Time)
-	expired := time.Now().After(expireTime)
-	if expired {
-		db.Remove(key)
+	if db.IsExpired(key) {
+		return nil, false
 	}
-	return expired
+	entity, _ := raw.(*database.DataEntity)
+	atomic.StoreInt32(&entity.Lru, lruClock())
+	updateLFU(entity)
+	return entity, true
 }
 
-// 实现分数据库对数据的操作
-func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+// peekEntity returns entity without touching its Lru and Lfu, it is used by introspection commands
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
 	raw, exists := db.data.Get(key)
 	if !exists {
 		return nil, false
@@ -220,29 +207,44 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 		return nil, false
 	}
 	entity, _ := raw.(*database.DataEntity)
-	atomic.StoreInt32(&entity.Lru, int32(time.Now().Unix()))
-	atomic.AddUint32(&entity.Lfu, 1)
 	return entity, true
 }
 
-// 在分数据库中加入一个key value
+// inheritAccess carries Lru and Lfu of the entity being overwritten over to the new one,
+// so that a hot key overwritten by SET or other commands is not taken as a new key by eviction
+func (db *DB) inheritAccess(key string, entity *database.DataEntity) {
+	raw, exists := db.data.Get(key)
+	if !exists {
+		return
+	}
+	old, _ := raw.(*database.DataEntity)
+	if old == nil || old == entity {
+		return
+	}
+	atomic.StoreInt32(&entity.Lru, atomic.LoadInt32(&old.Lru))
+	atomic.StoreUint32(&entity.Lfu, atomic.LoadUint32(&old.Lfu))
+}
+
+// 在分数据库中加入一个key value, 覆盖写入也算一次访问
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	atomic.StoreInt32(&entity.Lru, int32(time.Now().Unix()))
-	atomic.AddUint32(&entity.Lfu, 1)
+	db.inheritAccess(key, entity)
+	atomic.StoreInt32(&entity.Lru, lruClock())
+	updateLFU(entity)
 	return db.data.Put(key, entity)
 }
 
 // 如果存在则修改，返回1，如果不存在返回0
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	atomic.StoreInt32(&entity.Lru, int32(time.Now().Unix()))
-	atomic.AddUint32(&entity.Lfu, 1)
+	atomic.StoreInt32(&entity.Lru, lruClock())
+	updateLFU(entity)
 	return db.data.PutIfAbsent(key, entity)
 }
 
 // 如果不存在的时候加入，返回1，否则返回0
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	atomic.StoreInt32(&entity.Lru, int32(time.Now().Unix()))
-	atomic.AddUint32(&entity.Lfu, 1)
+	db.inheritAccess(key, entity)
+	atomic.StoreInt32(&entity.Lru, lruClock())
+	updateLFU(entity)
 	return db.data.PutIfExists(key, entity)
 }
 
@@ -250,8 +252,6 @@ func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
 func (db *DB) Remove(key string) {
 	db.data.Remove(key)
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
 }
 
 // 从数据库中移除多个键值
@@ -288,19 +288,21 @@ func (db *DB) ForEach(cb func(key string, data *database.DataEntity, expiration
 }
 
 func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
-	entity, exists := db.GetEntity(key)
+	entity, exists := db.getUnshared(key)
 	if !exists {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.([]byte)
-	if !ok {
-		return nil, &protocol.WrongTypeErrReply{}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return val, nil
+	case int64:
+		return []byte(strconv.FormatInt(val, 10)), nil
 	}
-	return bytes, nil
+	return nil, &protocol.WrongTypeErrReply{}
 }
 
 func (db *DB) getAsList(key string) (*list.List, protocol.ErrorReply) {
-	entity, exists := db.GetEntity(key)
+	entity, exists := db.getUnshared(key)
 	if !exists {
 		return nil, nil
 	}
@@ -327,8 +329,64 @@ func (db *DB) getOrInitList(key string) (*list.List, bool, protocol.ErrorReply)
 	return getList, isNew, nil
 }
 
+func (db *DB) getAsSet(key string) (*set.Set, protocol.ErrorReply) {
+	entity, exists := db.getUnshared(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*set.Set)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+func (db *DB) getOrInitSet(key string) (s *set.Set, inited bool, errReply protocol.ErrorReply) {
+	s, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if s == nil {
+		s = makeSet()
+		db.PutEntity(key, &database.DataEntity{
+			Data: s,
+		})
+		inited = true
+	}
+	return s, inited, nil
+}
+
+func (db *DB) getAsDict(key string) (dict.Dict, protocol.ErrorReply) {
+	entity, exists := db.getUnshared(key)
+	if !exists {
+		return nil, nil
+	}
+	hash, ok := entity.Data.(dict.Dict)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return hash, nil
+}
+
+func (db *DB) getOrInitDict(key string) (hash dict.Dict, inited bool, errReply protocol.ErrorReply) {
+	hash, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if hash == nil {
+		hash = makeHash()
+		db.PutEntity(key, &database.DataEntity{
+			Data: hash,
+		})
+		inited = true
+	}
+	return hash, inited, nil
+}
+
 func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, protocol.ErrorReply) {
-	entity, exists := db.GetEntity(key)
+	entity, exists := db.getUnshared(key)
 	if !exists {
 		return nil, nil
 	}
@@ -346,7 +404,7 @@ func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, in
 	}
 	inited = false
 	if sortedSet == nil {
-		sortedSet = SortedSet.Make()
+		sortedSet = makeSortedSet()
 		db.PutEntity(key, &database.DataEntity{
 			Data: sortedSet,
 		})
@@ -355,13 +413,70 @@ func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, in
 	return sortedSet, inited, nil
 }
 
+func (db *DB) getAsGraph(key string) (*graph.Graph, protocol.ErrorReply) {
+	entity, exists := db.getUnshared(key)
+	if !exists {
+		return nil, nil
+	}
+	g, ok := entity.Data.(*graph.Graph)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return g, nil
+}
+
+func (db *DB) getOrInitGraph(key string) (g *graph.Graph, inited bool, errReply protocol.ErrorReply) {
+	g, errReply = db.getAsGraph(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if g == nil {
+		g = graph.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: g,
+		})
+		inited = true
+	}
+	return g, inited, nil
+}
+
+func (db *DB) getAsSuggest(key string) (*suggest.Trie, protocol.ErrorReply) {
+	entity, exists := db.getUnshared(key)
+	if !exists {
+		return nil, nil
+	}
+	trie, ok := entity.Data.(*suggest.Trie)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return trie, nil
+}
+
+func (db *DB) getOrInitSuggest(key string) (trie *suggest.Trie, inited bool, errReply protocol.ErrorReply) {
+	trie, errReply = db.getAsSuggest(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if trie == nil {
+		trie = suggest.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: trie,
+		})
+		inited = true
+	}
+	return trie, inited, nil
+}
+
 /* ---- Lock Function ----- */
-// RWLocks lock keys for writing and reading
+// RWLocks lock keys for writing and reading, keys to write are preserved for active snapshots before modified
 func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
 	db.locker.RWLocks(writeKeys, readKeys)
+	db.preserveForSnapshots(writeKeys)
 }
 
-// RWUnLocks unlock keys for writing and reading
+// RWUnLocks unlock keys for writing and reading, then publishes keyspace events raised on them
 func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
-	db.locker.RWUnLocks(writeKeys, readKeys)
+	publishEvents(db.rwUnLocksTakeEvents(writeKeys, readKeys))
 }
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"slava/internal/interface/database"
+)
+
+// evictionPoolSize is the number of candidates kept in eviction pool, same as redis
+const evictionPoolSize = 16
+
+// lruClock returns current time in seconds stored in DataEntity.Lru, it can be replaced in tests
+var lruClock = func() int32 {
+	return int32(time.Now().Unix())
+}
+
+type evictionCandidate struct {
+	// idle is score of candidate, the greater the better to evict
+	idle uint64
+	key  string
+	db   *DB
+	// entity sampled, the candidate is stale if the key has been deleted or overwritten since then
+	entity *database.DataEntity
+}
+
+// evictionPool keeps the best candidates sampled across rounds and dbs like evictionPoolPopulate in redis,
+// so a round evicts the best key ever sampled instead of the best among a few samples
+// it is protected by Server.evictMu
+type evictionPool struct {
+	// candidates sorted by idle ascending, the best candidate is the last one
+	candidates []*evictionCandidate
+}
+
+func makeEvictionPool() *evictionPool {
+	return &evictionPool{
+		candidates: make([]*evictionCandidate, 0, evictionPoolSize),
+	}
+}
+
+// idleScore returns how suitable the key is for eviction under policy
+func idleScore(db *DB, key string, entity *database.DataEntity, policy uint8) uint64 {
+	switch policy {
+	case maxMemoryLruAllKeys, maxMemoryLruTtl:
+		idle := int64(lruClock()) - int64(atomic.LoadInt32(&entity.Lru))
+		if idle < 0 {
+			return 0
+		}
+		return uint64(idle)
+	case maxMemoryLfuAllKeys, maxMemoryLfuTtl:
+		return lfuCounterMax - uint64(lfuFreq(entity))
+	case maxMemoryTtl:
+		raw, ok := db.ttlMap.Get(key)
+		if !ok {
+			return 0
+		}
+		// the sooner to expire, the greater the score
+		return uint64(math.MaxInt64 - raw.(time.Time).UnixNano())
+	}
+	return 0
+}
+
+// populate samples keys of db and inserts them into pool if they are better than the worst candidate
+func (pool *evictionPool) populate(db *DB, policy uint8, samples int) {
+	keys := db.data
+	if policy == maxMemoryLruTtl || policy == maxMemoryLfuTtl || policy == maxMemoryTtl {
+		keys = db.ttlMap
+	}
+	for _, key := range keys.RandomDistinctKeys(samples) {
+		raw, ok := db.data.Get(key)
+		if !ok {
+			continue
+		}
+		entity := raw.(*database.DataEntity)
+		pool.insert(&evictionCandidate{idle: idleScore(db, key, entity, policy), key: key, db: db, entity: entity})
+	}
+}
+
+func (pool *evictionPool) insert(candidate *evictionCandidate) {
+	// a key sampled again replaces its stale score
+	for i, c := range pool.candidates {
+		if c.key == candidate.key && c.db == candidate.db {
+			pool.candidates = append(pool.candidates[:i], pool.candidates[i+1:]...)
+			break
+		}
+	}
+	if len(pool.candidates) == evictionPoolSize {
+		if candidate.idle <= pool.candidates[0].idle {
+			return
+		}
+		// drop the worst candidate
+		copy(pool.candidates, pool.candidates[1:])
+		pool.candidates = pool.candidates[:evictionPoolSize-1]
+	}
+	i := len(pool.candidates)
+	for i > 0 && pool.candidates[i-1].idle > candidate.idle {
+		i--
+	}
+	pool.candidates = append(pool.candidates, nil)
+	copy(pool.candidates[i+1:], pool.candidates[i:])
+	pool.candidates[i] = candidate
+}
+
+// popBest removes and returns the best candidate
+func (pool *evictionPool) popBest() (*evictionCandidate, bool) {
+	n := len(pool.candidates)
+	if n == 0 {
+		return nil, false
+	}
+	best := pool.candidates[n-1]
+	pool.candidates = pool.candidates[:n-1]
+	return best, true
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"slava/config"
+	"slava/internal/utils"
+)
+
+// 过期的key有两种删除方式:
+//
+//	被动过期: 访问key时在 IsExpired 中删除
+//	主动过期: 后台周期性地从各db的ttlMap中采样并删除已过期的key, 若采样中过期key的比例较高, 说明还有很多
+//	过期key, 则继续采样直到用完本周期的时间片, 与 redis 的 activeExpireCycle 相同
+const (
+	// activeExpireCycleHz is how many times the cycle runs per second
+	activeExpireCycleHz = 10
+	// activeExpireCycleKeysPerLoop is keys sampled from a db per loop
+	activeExpireCycleKeysPerLoop = 20
+	// activeExpireCycleAcceptableStale is the percentage of expired keys in samples to stop sampling a db
+	activeExpireCycleAcceptableStale = 10
+	// activeExpireCycleTimePerc is the percentage of cpu time the cycle may use
+	activeExpireCycleTimePerc = 25
+)
+
+// activeExpireParams are adjusted by active-expire-effort from 1 to 10,
+// greater effort samples more keys and tolerates less expired keys with more cpu time
+type activeExpireParams struct {
+	keysPerLoop     int
+	acceptableStale int
+	timeLimit       time.Duration
+}
+
+func makeActiveExpireParams(effort int) *activeExpireParams {
+	if effort < 1 {
+		effort = 1
+	} else if effort > 10 {
+		effort = 10
+	}
+	effort-- // from 0 to 9
+	timePerc := activeExpireCycleTimePerc + 2*effort
+	return &activeExpireParams{
+		keysPerLoop:     activeExpireCycleKeysPerLoop + activeExpireCycleKeysPerLoop/4*effort,
+		acceptableStale: activeExpireCycleAcceptableStale - effort,
+		timeLimit:       time.Second / activeExpireCycleHz * time.Duration(timePerc) / 100,
+	}
+}
+
+// expireIfNeeded removes key if it is expired, the deletion is propagated to aof and replicas as DEL
+// the caller should hold lock of the key
+func (db *DB) expireIfNeeded(key string) bool {
+	rawExpireTime, exists := db.ttlMap.Get(key)
+	if !exists {
+		return false
+	}
+	expireTime, _ := rawExpireTime.(time.Time)
+	if !time.Now().After(expireTime) {
+		return false
+	}
+	db.removeKey(key, config.Properties.LazyfreeLazyExpire)
+	db.addVersion(key)
+	db.AddAof(utils.ToCmdLine("del", key))
+	db.notify(notifyExpired, "expired", key)
+	if db.stats != nil {
+		atomic.AddInt64(&db.stats.expiredKeys, 1)
+	}
+	return true
+}
+
+// activeExpire samples keys having ttl and removes expired ones
+func (db *DB) activeExpire(samples int) (sampled int, expired int) {
+	for _, key := range db.ttlMap.RandomDistinctKeys(samples) {
+		sampled++
+		keys := []string{key}
+		db.RWLocks(keys, nil)
+		if db.expireIfNeeded(key) {
+			expired++
+		}
+		db.RWUnLocks(keys, nil)
+	}
+	return
+}
+
+func (server *Server) startActiveExpireCycle() {
+	go func() {
+		ticker := time.NewTicker(time.Second / activeExpireCycleHz)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				server.activeExpireCycle()
+			case <-server.closed:
+				return
+			}
+		}
+	}()
+}
+
+// activeExpireCycle removes expired keys in all dbs until few expired keys are found or time is up,
+// the next cycle continues from the db where this one stopped
+func (server *Server) activeExpireCycle() {
+	start := time.Now()
+	params := server.expireParams
+	var sampled, expired int
+	timeLimitReached := false
+	for i := 0; i < len(server.dbSet) && !timeLimitReached; i++ {
+		db := server.mustSelectDB(server.nextExpireDB)
+		server.nextExpireDB = (server.nextExpireDB + 1) % len(server.dbSet)
+		for db.ttlMap.Len() > 0 {
+			dbSampled, dbExpired := db.activeExpire(params.keysPerLoop)
+			sampled += dbSampled
+			expired += dbExpired
+			if time.Since(start) > params.timeLimit {
+				timeLimitReached = true
+				atomic.AddInt64(&server.stats.expiredTimeCapReached, 1)
+				break
+			}
+			if dbSampled == 0 || dbExpired*100 <= dbSampled*params.acceptableStale {
+				break
+			}
+		}
+	}
+
+	// moving average of percentage of expired keys in samples, which estimates expired keys still in memory
+	current := float64(0)
+	if sampled > 0 {
+		current = float64(expired) / float64(sampled)
+	}
+	stale := math.Float64frombits(atomic.LoadUint64(&server.stats.expiredStalePerc))
+	stale = current*0.05 + stale*0.95
+	atomic.StoreUint64(&server.stats.expiredStalePerc, math.Float64bits(stale))
+}
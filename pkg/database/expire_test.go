@@ -0,0 +1,51 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func TestActiveExpireCycle(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	for i := 0; i < 100; i++ {
+		server.Exec(conn, utils.ToCmdLine("set", "volatile"+strconv.Itoa(i), "v", "PX", "1"))
+	}
+	for i := 0; i < 10; i++ {
+		server.Exec(conn, utils.ToCmdLine("set", "ttl"+strconv.Itoa(i), "v", "EX", "1000"))
+		server.Exec(conn, utils.ToCmdLine("set", "persistent"+strconv.Itoa(i), "v"))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// expired keys are removed without being accessed
+	server.activeExpireCycle()
+	db := server.mustSelectDB(0)
+	if db.data.Len() != 20 || db.ttlMap.Len() != 10 {
+		t.Errorf("expected 20 keys and 10 ttl left, actual %d keys and %d ttl", db.data.Len(), db.ttlMap.Len())
+	}
+	info := string(server.execInfo(utils.ToCmdLine("stats")).(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "expired_keys:100\r\n") {
+		t.Errorf("expected 100 expired keys, actual %q", info)
+	}
+	if strings.Contains(info, "expired_stale_perc:0.00\r\n") {
+		t.Errorf("expected stale percentage above 0, actual %q", info)
+	}
+}
+
+func TestActiveExpireParams(t *testing.T) {
+	params := makeActiveExpireParams(1)
+	if params.keysPerLoop != 20 || params.acceptableStale != 10 || params.timeLimit != 25*time.Millisecond {
+		t.Errorf("unexpected params of effort 1: %+v", params)
+	}
+	params = makeActiveExpireParams(100)
+	if params.keysPerLoop != 65 || params.acceptableStale != 1 || params.timeLimit != 43*time.Millisecond {
+		t.Errorf("unexpected params of effort 10: %+v", params)
+	}
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"slava/internal/aof"
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/datastruct/graph"
+)
+
+const (
+	// graphDefaultLimit is max number of nodes returned by traversal if LIMIT is not given
+	graphDefaultLimit = 1000
+	// graphMaxLimit protects server from huge traversal
+	graphMaxLimit = 100000
+	// graphDefaultDepth is max hops of BFS and SHORTESTPATH if DEPTH is not given
+	graphDefaultDepth = 3
+)
+
+type graphTraverseOpts struct {
+	dir   graph.Direction
+	limit int
+	depth int
+}
+
+// parseGraphOpts parses [DIRECTION OUT|IN|BOTH] [LIMIT n] [DEPTH n]
+func parseGraphOpts(args [][]byte, allowDepth bool) (*graphTraverseOpts, protocol.ErrorReply) {
+	opts := &graphTraverseOpts{
+		dir:   graph.Out,
+		limit: graphDefaultLimit,
+		depth: graphDefaultDepth,
+	}
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if i+1 >= len(args) {
+			return nil, &protocol.SyntaxErrReply{}
+		}
+		value := string(args[i+1])
+		i++
+		switch arg {
+		case "direction":
+			switch strings.ToLower(value) {
+			case "out":
+				opts.dir = graph.Out
+			case "in":
+				opts.dir = graph.In
+			case "both":
+				opts.dir = graph.Both
+			default:
+				return nil, &protocol.SyntaxErrReply{}
+			}
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > graphMaxLimit {
+				return nil, protocol.MakeErrReply("ERR limit must between 1 and " + strconv.Itoa(graphMaxLimit))
+			}
+			opts.limit = limit
+		case "depth":
+			if !allowDepth {
+				return nil, &protocol.SyntaxErrReply{}
+			}
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth <= 0 {
+				return nil, protocol.MakeErrReply("ERR depth must be a positive integer")
+			}
+			opts.depth = depth
+		default:
+			return nil, &protocol.SyntaxErrReply{}
+		}
+	}
+	return opts, nil
+}
+
+func parseProps(args [][]byte) (map[string]string, protocol.ErrorReply) {
+	if len(args)%2 != 0 {
+		return nil, &protocol.SyntaxErrReply{}
+	}
+	props := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		props[string(args[i])] = string(args[i+1])
+	}
+	return props, nil
+}
+
+func propsToReply(props map[string]string) slava.Reply {
+	fields := make([]string, 0, len(props))
+	for k := range props {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	result := make([][]byte, 0, len(props)*2)
+	for _, k := range fields {
+		result = append(result, []byte(k), []byte(props[k]))
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+func stringsToReply(values []string) slava.Reply {
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		result[i] = []byte(v)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execGraphAddEdge adds an edge with properties, returns 1 if the edge is new
+// graph.addedge key from to [prop value ...]
+func execGraphAddEdge(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	props, errReply := parseProps(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	g, _, errReply := db.getOrInitGraph(key)
+	if errReply != nil {
+		return errReply
+	}
+	added := g.AddEdge(string(args[1]), string(args[2]), props)
+	db.AddAof(utils.ToCmdLine3("graph.addedge", args...))
+	db.notify(notifyModule, "graph.addedge", key)
+	if added {
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeIntReply(0)
+}
+
+// execGraphDelEdge removes an edge, the key would be removed if graph becomes empty
+// graph.deledge key from to
+func execGraphDelEdge(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	g, errReply := db.getAsGraph(key)
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return protocol.MakeIntReply(0)
+	}
+	if !g.RemoveEdge(string(args[1]), string(args[2])) {
+		return protocol.MakeIntReply(0)
+	}
+	db.notify(notifyModule, "graph.deledge", key)
+	if g.NodeCount() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	db.AddAof(utils.ToCmdLine3("graph.deledge", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// execGraphSetNode sets properties of node
+// graph.setnode key node prop value [prop value ...]
+func execGraphSetNode(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	props, errReply := parseProps(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	g, _, errReply := db.getOrInitGraph(key)
+	if errReply != nil {
+		return errReply
+	}
+	g.SetNode(string(args[1]), props)
+	db.AddAof(utils.ToCmdLine3("graph.setnode", args...))
+	db.notify(notifyModule, "graph.setnode", key)
+	return protocol.MakeOkReply()
+}
+
+// execGraphGetNode returns properties of node
+// graph.getnode key node
+func execGraphGetNode(db *DB, args [][]byte) slava.Reply {
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.NullBulkReply{}
+	}
+	props, exists := g.GetNode(string(args[1]))
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	return propsToReply(props)
+}
+
+// execGraphGetEdge returns properties of edge
+// graph.getedge key from to
+func execGraphGetEdge(db *DB, args [][]byte) slava.Reply {
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.NullBulkReply{}
+	}
+	props, exists := g.GetEdge(string(args[1]), string(args[2]))
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	return propsToReply(props)
+}
+
+// execGraphNeighbors returns adjacent nodes
+// graph.neighbors key node [DIRECTION OUT|IN|BOTH] [LIMIT n]
+func execGraphNeighbors(db *DB, args [][]byte) slava.Reply {
+	opts, errReply := parseGraphOpts(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	return stringsToReply(g.Neighbors(string(args[1]), opts.dir, opts.limit))
+}
+
+// execGraphBFS returns nodes within k hops and their depth
+// graph.bfs key start [DEPTH k] [DIRECTION OUT|IN|BOTH] [LIMIT n]
+func execGraphBFS(db *DB, args [][]byte) slava.Reply {
+	opts, errReply := parseGraphOpts(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	hops := g.BFS(string(args[1]), opts.depth, opts.dir, opts.limit)
+	result := make([]slava.Reply, 0, len(hops)*2)
+	for _, hop := range hops {
+		result = append(result, protocol.MakeBulkReply([]byte(hop.Node)), protocol.MakeIntReply(int64(hop.Depth)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execGraphShortestPath returns nodes on the shortest path, or nil if unreachable within given depth
+// graph.shortestpath key from to [DEPTH k] [DIRECTION OUT|IN|BOTH]
+func execGraphShortestPath(db *DB, args [][]byte) slava.Reply {
+	opts, errReply := parseGraphOpts(args[3:], true)
+	if errReply != nil {
+		return errReply
+	}
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.NullBulkReply{}
+	}
+	path := g.ShortestPath(string(args[1]), string(args[2]), opts.depth, opts.dir)
+	if path == nil {
+		return &protocol.NullBulkReply{}
+	}
+	return stringsToReply(path)
+}
+
+// execGraphCommon returns common neighbors of two nodes
+// graph.common key a b [DIRECTION OUT|IN|BOTH] [LIMIT n]
+func execGraphCommon(db *DB, args [][]byte) slava.Reply {
+	opts, errReply := parseGraphOpts(args[3:], false)
+	if errReply != nil {
+		return errReply
+	}
+	g, errReply := db.getAsGraph(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if g == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	return stringsToReply(g.CommonNeighbors(string(args[1]), string(args[2]), opts.dir, opts.limit))
+}
+
+// execGraphRestore replaces key with serialized graph, it is used by aof rewrite and undo logs
+// _graph.restore key payload
+func execGraphRestore(db *DB, args [][]byte) slava.Reply {
+	g, err := graph.Unmarshal(args[1])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	db.PutEntity(string(args[0]), &database.DataEntity{
+		Data: g,
+	})
+	db.AddAof(utils.ToCmdLine3(aof.GraphRestore, args...))
+	db.notify(notifyModule, "graph.restore", string(args[0]))
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("Graph.AddEdge", execGraphAddEdge, WriteFirstKey, RollbackFirstKey, -4, flagWrite|flagDenyOOM).category("@graph")
+	RegisterCommand("Graph.DelEdge", execGraphDelEdge, WriteFirstKey, RollbackFirstKey, 4, flagWrite).category("@graph")
+	RegisterCommand("Graph.SetNode", execGraphSetNode, WriteFirstKey, RollbackFirstKey, -5, flagWrite|flagDenyOOM).category("@graph")
+	RegisterCommand("Graph.GetNode", execGraphGetNode, ReadFirstKey, nil, 3, flagReadOnly).category("@graph")
+	RegisterCommand("Graph.GetEdge", execGraphGetEdge, ReadFirstKey, nil, 4, flagReadOnly).category("@graph")
+	RegisterCommand("Graph.Neighbors", execGraphNeighbors, ReadFirstKey, nil, -3, flagReadOnly).category("@graph")
+	RegisterCommand("Graph.BFS", execGraphBFS, ReadFirstKey, nil, -3, flagReadOnly).category("@graph")
+	RegisterCommand("Graph.ShortestPath", execGraphShortestPath, ReadFirstKey, nil, -4, flagReadOnly).category("@graph")
+	RegisterCommand("Graph.Common", execGraphCommon, ReadFirstKey, nil, -4, flagReadOnly).category("@graph")
+	RegisterCommand(aof.GraphRestore, execGraphRestore, WriteFirstKey, RollbackFirstKey, 3, flagWrite|flagDenyOOM).category("@graph")
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+)
+
+// hashes are loaded from rdb only, HMSET is kept so that aof rewrite and undo logs of hashes can be replayed
+
+// execHMSet sets fields of hash
+// hmset key field value [field value ...]
+func execHMSet(db *DB, args [][]byte) slava.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("hmset")
+	}
+	key := string(args[0])
+	hash, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	for i := 1; i < len(args); i += 2 {
+		hash.Put(string(args[i]), args[i+1])
+	}
+	db.AddAof(utils.ToCmdLine3("hmset", args...))
+	db.notify(notifyHash, "hset", key)
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("HMSet", execHMSet, WriteFirstKey, RollbackFirstKey, -4, flagWrite|flagDenyOOM).category("@hash")
+}
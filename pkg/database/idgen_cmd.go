@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"slava/config"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/idgenerator"
+	"slava/pkg/logger"
+)
+
+// maxIDBatch limits how many ids a single ID.NEXT could generate
+const maxIDBatch = 10000
+
+// MakeIDGenerator creates a snowflake generator according to node-id and id-max-clock-backward in config
+func MakeIDGenerator() *idgenerator.IDGenerator {
+	var gen *idgenerator.IDGenerator
+	if config.Properties.NodeID >= 0 {
+		g, err := idgenerator.MakeGeneratorWithNodeID(int64(config.Properties.NodeID))
+		if err != nil {
+			logger.Warn("invalid node-id, fallback to hash of self: " + err.Error())
+		} else {
+			gen = g
+		}
+	}
+	if gen == nil {
+		self := config.Properties.Self
+		if self == "" {
+			self = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+		}
+		gen = idgenerator.MakeGenerator(self)
+	}
+	gen.SetMaxBackward(time.Duration(config.Properties.IDMaxClockBackward) * time.Millisecond)
+	return gen
+}
+
+// execIDNext generates k-sortable unique ids
+// id.next [count]
+func execIDNext(server *Server, args [][]byte) slava.Reply {
+	if len(args) > 1 {
+		return protocol.MakeArgNumErrReply("id.next")
+	}
+	if len(args) == 0 {
+		id, err := server.idGenerator.NextID()
+		if err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		return protocol.MakeIntReply(id)
+	}
+	count, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if count <= 0 || count > maxIDBatch {
+		return protocol.MakeErrReply("ERR count must between 1 and " + strconv.Itoa(maxIDBatch))
+	}
+	result := make([]slava.Reply, count)
+	for i := 0; i < count; i++ {
+		id, err := server.idGenerator.NextID()
+		if err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		result[i] = protocol.MakeIntReply(id)
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execIDDecode splits id into timestamp in milliseconds, node id and sequence
+// id.decode id
+func execIDDecode(args [][]byte) slava.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("id.decode")
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(args[0])), 10, 64)
+	if err != nil || id < 0 {
+		return protocol.MakeErrReply("ERR invalid id")
+	}
+	ts, node, seq := idgenerator.Decode(id)
+	return protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeIntReply(ts.UnixNano() / int64(time.Millisecond)),
+		protocol.MakeIntReply(node),
+		protocol.MakeIntReply(seq),
+	})
+}
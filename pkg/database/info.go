@@ -0,0 +1,115 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+)
+
+// serverStats holds counters reported by INFO stats, they are updated atomically
+type serverStats struct {
+	// evictedKeys is number of keys evicted due to maxmemory
+	evictedKeys int64
+	// expiredKeys is number of expired keys removed by active or lazy expiration
+	expiredKeys int64
+	// expiredStalePerc is bits of float64, the moving average ratio of expired keys in samples of active expire cycle
+	expiredStalePerc uint64
+	// expiredTimeCapReached is times active expire cycle stopped for running out of time
+	expiredTimeCapReached int64
+}
+
+// infoSection writes fields of a section of INFO into builder
+type infoSection struct {
+	name  string
+	write func(server *Server, builder *strings.Builder)
+}
+
+var infoSections = []infoSection{
+	{name: "memory", write: (*Server).infoMemory},
+	{name: "persistence", write: (*Server).infoPersistence},
+	{name: "stats", write: (*Server).infoStats},
+	{name: "keyspace", write: (*Server).infoKeyspace},
+}
+
+func writeInfoField(builder *strings.Builder, field string, value string) {
+	builder.WriteString(field)
+	builder.WriteByte(':')
+	builder.WriteString(value)
+	builder.WriteString("\r\n")
+}
+
+// bytesToHuman formats bytes like 1.50M as redis does
+func bytesToHuman(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[i]
+}
+
+func (server *Server) infoMemory(builder *strings.Builder) {
+	used := server.memTracker.used()
+	writeInfoField(builder, "used_memory", strconv.FormatUint(used, 10))
+	writeInfoField(builder, "used_memory_human", bytesToHuman(used))
+	writeInfoField(builder, "maxmemory", strconv.FormatUint(server.maxMemory, 10))
+	writeInfoField(builder, "maxmemory_human", bytesToHuman(server.maxMemory))
+	writeInfoField(builder, "maxmemory_policy", maxMemoryPolicyNames[server.maxMemoryPolicy])
+	writeInfoField(builder, "lazyfree_pending_objects", strconv.FormatInt(server.lazyfree.pendingObjects(), 10))
+}
+
+func (server *Server) infoStats(builder *strings.Builder) {
+	writeInfoField(builder, "expired_keys", strconv.FormatInt(atomic.LoadInt64(&server.stats.expiredKeys), 10))
+	stale := math.Float64frombits(atomic.LoadUint64(&server.stats.expiredStalePerc))
+	writeInfoField(builder, "expired_stale_perc", strconv.FormatFloat(stale*100, 'f', 2, 64))
+	writeInfoField(builder, "expired_time_cap_reached_count",
+		strconv.FormatInt(atomic.LoadInt64(&server.stats.expiredTimeCapReached), 10))
+	writeInfoField(builder, "evicted_keys", strconv.FormatInt(atomic.LoadInt64(&server.stats.evictedKeys), 10))
+	writeInfoField(builder, "lazyfreed_objects", strconv.FormatInt(server.lazyfree.freedObjects(), 10))
+}
+
+func (server *Server) infoKeyspace(builder *strings.Builder) {
+	for i := range server.dbSet {
+		keys, expires := server.GetDBSize(i)
+		if keys == 0 {
+			continue
+		}
+		writeInfoField(builder, "db"+strconv.Itoa(i),
+			"keys="+strconv.Itoa(keys)+",expires="+strconv.Itoa(expires))
+	}
+}
+
+// execInfo returns information and statistics of server
+// info [section ...]
+func (server *Server) execInfo(args [][]byte) slava.Reply {
+	all := len(args) == 0
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		section := strings.ToLower(string(arg))
+		if section == "all" || section == "default" || section == "everything" {
+			all = true
+		}
+		wanted[section] = true
+	}
+	builder := &strings.Builder{}
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(server, builder)
+	}
+	return protocol.MakeBulkReply([]byte(builder.String()))
+}
@@ -5,13 +5,18 @@ import (
 	"strings"
 	"time"
 
+	"slava/config"
 	"slava/internal/aof"
+	"slava/internal/interface/database"
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
 	"slava/internal/utils"
 	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
 	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/set"
 	"slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
 	"slava/pkg/wildcard"
 )
 
@@ -22,13 +27,37 @@ func execDel(db *DB, args [][]byte) slava.Reply {
 		keys[i] = string(v)
 	}
 
-	deleted := db.Removes(keys...)
+	deleted := 0
+	for _, key := range keys {
+		if _, exists := db.GetEntity(key); exists {
+			db.Remove(key)
+			db.notify(notifyGeneric, "del", key)
+			deleted++
+		}
+	}
 	if deleted > 0 {
 		db.AddAof(utils.ToCmdLine3("del", args...))
 	}
 	return protocol.MakeIntReply(int64(deleted))
 }
 
+// execUnlink removes keys like DEL, but big values are freed in background
+func execUnlink(db *DB, args [][]byte) slava.Reply {
+	deleted := 0
+	for _, arg := range args {
+		key := string(arg)
+		if _, exists := db.GetEntity(key); exists {
+			db.unlinkKey(key)
+			db.notify(notifyGeneric, "del", key)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.AddAof(utils.ToCmdLine3("unlink", args...))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
 func undoDel(db *DB, args [][]byte) []CmdLine {
 	keys := make([]string, len(args))
 	for i, v := range args {
@@ -65,26 +94,38 @@ func execType(db *DB, args [][]byte) slava.Reply {
 	if !exists {
 		return protocol.MakeStatusReply("none")
 	}
-	switch entity.Data.(type) {
-	case []byte:
-		return protocol.MakeStatusReply("string")
-	case list.List:
-		return protocol.MakeStatusReply("list")
+	if name := typeName(entity.Data); name != "" {
+		return protocol.MakeStatusReply(name)
+	}
+	return &protocol.UnknownErrReply{}
+}
+
+// typeName returns type of value reported by TYPE, returns "" for unknown types
+func typeName(data interface{}) string {
+	switch data.(type) {
+	case []byte, int64:
+		return "string"
+	case *list.List:
+		return "list"
 	case dict.Dict:
-		return protocol.MakeStatusReply("hash")
-	//	TODO set
-	//case *set.Set:
-	//	return protocol.MakeStatusReply("set")
+		return "hash"
+	case *set.Set:
+		return "set"
 	case *sortedset.SortedSet:
-		return protocol.MakeStatusReply("zset")
+		return "zset"
+	case *graph.Graph:
+		return "graph"
+	case *suggest.Trie:
+		return "suggest"
 	}
-	return &protocol.UnknownErrReply{}
+	return ""
 }
 
+// prepareRename locks both keys for writing, src is removed by rename
 func prepareRename(args [][]byte) ([]string, []string) {
 	src := string(args[0])
 	dest := string(args[1])
-	return []string{dest}, []string{src}
+	return []string{src, dest}, nil
 }
 
 // execRename a key
@@ -99,7 +140,12 @@ func execRename(db *DB, args [][]byte) slava.Reply {
 	if !ok {
 		return protocol.MakeErrReply("no such key")
 	}
+	if src == dest {
+		return &protocol.OkReply{}
+	}
 	rawTTL, hasTTL := db.ttlMap.Get(src)
+	// value and ttl of dest are overwritten
+	db.removeKey(dest, config.Properties.LazyfreeLazyServerDel)
 	db.PutEntity(dest, entity)
 	db.Remove(src)
 	if hasTTL {
@@ -109,6 +155,8 @@ func execRename(db *DB, args [][]byte) slava.Reply {
 		db.Expire(dest, expireTime)
 	}
 	db.AddAof(utils.ToCmdLine3("rename", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return &protocol.OkReply{}
 }
 
@@ -142,90 +190,133 @@ func execRenameNx(db *DB, args [][]byte) slava.Reply {
 		db.Expire(dest, expireTime)
 	}
 	db.AddAof(utils.ToCmdLine3("renamenx", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return protocol.MakeIntReply(1)
 }
 
-// execExpire sets a key's time to live in seconds
-func execExpire(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
+// expireFlags are conditions of EXPIRE family commands
+type expireFlags struct {
+	nx, xx, gt, lt bool
+}
 
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+func parseExpireFlags(args [][]byte) (*expireFlags, protocol.ErrorReply) {
+	flags := &expireFlags{}
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		default:
+			return nil, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
 	}
-	ttl := time.Duration(ttlArg) * time.Second
+	if flags.nx && (flags.xx || flags.gt || flags.lt) {
+		return nil, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags.gt && flags.lt {
+		return nil, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
 
+// expireIfMatch sets expiration of key if it matches the flags, a key without ttl is regarded as having infinite ttl
+func expireIfMatch(db *DB, key string, expireAt time.Time, flagArgs [][]byte) slava.Reply {
+	flags, errReply := parseExpireFlags(flagArgs)
+	if errReply != nil {
+		return errReply
+	}
 	_, exists := db.GetEntity(key)
 	if !exists {
 		return protocol.MakeIntReply(0)
 	}
+	raw, hasTTL := db.ttlMap.Get(key)
+	if flags.nx && hasTTL || flags.xx && !hasTTL {
+		return protocol.MakeIntReply(0)
+	}
+	if flags.gt && (!hasTTL || !expireAt.After(raw.(time.Time))) {
+		return protocol.MakeIntReply(0)
+	}
+	if flags.lt && hasTTL && !expireAt.Before(raw.(time.Time)) {
+		return protocol.MakeIntReply(0)
+	}
 
-	expireAt := time.Now().Add(ttl)
 	db.Expire(key, expireAt)
 	db.AddAof(aof.MakeExpireCmd(key, expireAt).Args)
+	db.notify(notifyGeneric, "expire", key)
 	return protocol.MakeIntReply(1)
 }
 
+// execExpire sets a key's time to live in seconds
+// expire key seconds [NX|XX|GT|LT]
+func execExpire(db *DB, args [][]byte) slava.Reply {
+	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	ttl := time.Duration(ttlArg) * time.Second
+	return expireIfMatch(db, string(args[0]), time.Now().Add(ttl), args[2:])
+}
+
 // execExpireAt sets a key's expiration in unix timestamp
+// expireat key unix-time-seconds [NX|XX|GT|LT]
 func execExpireAt(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
-
 	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	expireAt := time.Unix(raw, 0)
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
-	}
-
-	db.Expire(key, expireAt)
-	db.AddAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return expireIfMatch(db, string(args[0]), time.Unix(raw, 0), args[2:])
 }
 
 // execPExpire sets a key's time to live in milliseconds
+// pexpire key milliseconds [NX|XX|GT|LT]
 func execPExpire(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
-
 	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
 	ttl := time.Duration(ttlArg) * time.Millisecond
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
-	}
-
-	expireAt := time.Now().Add(ttl)
-	db.Expire(key, expireAt)
-	db.AddAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return expireIfMatch(db, string(args[0]), time.Now().Add(ttl), args[2:])
 }
 
 // execPExpireAt sets a key's expiration in unix timestamp specified in milliseconds
+// pexpireat key unix-time-milliseconds [NX|XX|GT|LT]
 func execPExpireAt(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
-
 	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
 	expireAt := time.Unix(0, raw*int64(time.Millisecond))
+	return expireIfMatch(db, string(args[0]), expireAt, args[2:])
+}
+
+// execExpireTime returns the absolute unix timestamp in seconds at which the key will expire
+func execExpireTime(db *DB, args [][]byte) slava.Reply {
+	return expireTimeOf(db, string(args[0]), time.Second)
+}
 
+// execPExpireTime returns the absolute unix timestamp in milliseconds at which the key will expire
+func execPExpireTime(db *DB, args [][]byte) slava.Reply {
+	return expireTimeOf(db, string(args[0]), time.Millisecond)
+}
+
+// expireTimeOf returns -2 if key not exists, -1 if key has no ttl
+func expireTimeOf(db *DB, key string, unit time.Duration) slava.Reply {
 	_, exists := db.GetEntity(key)
 	if !exists {
-		return protocol.MakeIntReply(0)
+		return protocol.MakeIntReply(-2)
 	}
-
-	db.Expire(key, expireAt)
-
-	db.AddAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	raw, exists := db.ttlMap.Get(key)
+	if !exists {
+		return protocol.MakeIntReply(-1)
+	}
+	expireTime, _ := raw.(time.Time)
+	return protocol.MakeIntReply(expireTime.UnixNano() / int64(unit))
 }
 
 // execTTL returns a key's time to live in seconds
@@ -277,6 +368,7 @@ func execPersist(db *DB, args [][]byte) slava.Reply {
 
 	db.Persist(key)
 	db.AddAof(utils.ToCmdLine3("persist", args...))
+	db.notify(notifyGeneric, "persist", key)
 	return protocol.MakeIntReply(1)
 }
 
@@ -296,6 +388,91 @@ func execKeys(db *DB, args [][]byte) slava.Reply {
 	return protocol.MakeMultiBulkReply(result)
 }
 
+// execScan incrementally iterates keys in db, keys existing during the whole iteration are returned at least once
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) slava.Reply {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid cursor")
+	}
+	count := 10
+	var pattern *wildcard.Pattern
+	var typ string
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern, err = wildcard.CompilePattern(value)
+			if err != nil {
+				return protocol.MakeErrReply("ERR illegal wildcard")
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return protocol.MakeSyntaxErrReply()
+			}
+		case "TYPE":
+			typ = strings.ToLower(value)
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	now := time.Now()
+	keys := make([][]byte, 0)
+	cursor = db.data.Scan(cursor, count, func(key string, val interface{}) bool {
+		if pattern != nil && !pattern.IsMatch(key) {
+			return true
+		}
+		if typ != "" {
+			entity, _ := val.(*database.DataEntity)
+			if typeName(entity.Data) != typ {
+				return true
+			}
+		}
+		// expired keys cannot be removed while iterating, they are just skipped
+		if raw, ok := db.ttlMap.Get(key); ok && now.After(raw.(time.Time)) {
+			return true
+		}
+		keys = append(keys, []byte(key))
+		return true
+	})
+	return protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		protocol.MakeMultiBulkReply(keys),
+	})
+}
+
+// execTouch updates last access time of keys and returns the number of existed keys
+func execTouch(db *DB, args [][]byte) slava.Reply {
+	return execExists(db, args)
+}
+
+// randomKeyAttempts limits tries of RANDOMKEY to skip expired keys
+const randomKeyAttempts = 100
+
+// execRandomKey returns a random key in db
+func execRandomKey(db *DB, args [][]byte) slava.Reply {
+	for i := 0; i < randomKeyAttempts; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			break
+		}
+		// the key is not locked, so expired keys are skipped like SCAN instead of being removed
+		if raw, ok := db.ttlMap.Get(keys[0]); !ok || !time.Now().After(raw.(time.Time)) {
+			return protocol.MakeBulkReply([]byte(keys[0]))
+		}
+	}
+	return &protocol.NullBulkReply{}
+}
+
+// execDBSize returns the number of keys in db
+func execDBSize(db *DB, args [][]byte) slava.Reply {
+	return protocol.MakeIntReply(int64(db.data.Len()))
+}
+
 func toTTLCmd(db *DB, key string) *protocol.MultiBulkReply {
 	raw, exists := db.ttlMap.Get(key)
 	if !exists {
@@ -316,39 +493,48 @@ func undoExpire(db *DB, args [][]byte) []CmdLine {
 
 // execCopy usage: COPY source destination [DB destination-db] [REPLACE]
 // This command copies the value stored at the source key to the destination key.
-func execCopy(mdb *Server, conn slava.Connection, args [][]byte) slava.Reply {
-	dbIndex := conn.GetDBIndex()
-	db := mdb.mustSelectDB(dbIndex) // Current DB
-	replaceFlag := false
+// srcIndex is the index of selected db
+func execCopy(mdb *Server, srcIndex int, args [][]byte) slava.Reply {
+	destIndex, _, errReply := parseCopyOptions(mdb, srcIndex, args)
+	if errReply != nil {
+		return errReply
+	}
+	mdb.swapMu.RLock()
+	defer mdb.swapMu.RUnlock()
+	srcKeys, destKeys := []string{string(args[0])}, []string{string(args[1])}
+	if srcIndex == destIndex {
+		db := mdb.mustSelectDB(srcIndex)
+		db.RWLocks(destKeys, srcKeys)
+		defer db.RWUnLocks(destKeys, srcKeys)
+		return copyWithLock(mdb, srcIndex, args)
+	}
+	// always lock the db with lower index first, just like MOVE
+	srcDB, destDB := mdb.mustSelectDB(srcIndex), mdb.mustSelectDB(destIndex)
+	if srcIndex < destIndex {
+		srcDB.RWLocks(nil, srcKeys)
+		defer srcDB.RWUnLocks(nil, srcKeys)
+		destDB.RWLocks(destKeys, nil)
+		defer destDB.RWUnLocks(destKeys, nil)
+	} else {
+		destDB.RWLocks(destKeys, nil)
+		defer destDB.RWUnLocks(destKeys, nil)
+		srcDB.RWLocks(nil, srcKeys)
+		defer srcDB.RWUnLocks(nil, srcKeys)
+	}
+	return copyWithLock(mdb, srcIndex, args)
+}
+
+// copyWithLock executes COPY, caller must hold the read lock of source key and the write lock of destination key
+func copyWithLock(mdb *Server, srcIndex int, args [][]byte) slava.Reply {
+	db := mdb.mustSelectDB(srcIndex) // Current DB
 	srcKey := string(args[0])
 	destKey := string(args[1])
-
-	// Parse options
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "db" {
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				idx, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if idx >= len(mdb.dbSet) || idx < 0 {
-					return protocol.MakeErrReply("ERR DB index is out of range")
-				}
-				dbIndex = idx
-				i++
-			} else if arg == "replace" {
-				replaceFlag = true
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
-		}
+	dbIndex, replaceFlag, errReply := parseCopyOptions(mdb, srcIndex, args)
+	if errReply != nil {
+		return errReply
 	}
 
-	if srcKey == destKey && dbIndex == conn.GetDBIndex() {
+	if srcKey == destKey && dbIndex == srcIndex {
 		return protocol.MakeErrReply("ERR source and destination objects are the same")
 	}
 
@@ -364,30 +550,134 @@ func execCopy(mdb *Server, conn slava.Connection, args [][]byte) slava.Reply {
 		if replaceFlag == false {
 			return protocol.MakeIntReply(0)
 		}
+		destDB.removeKey(destKey, config.Properties.LazyfreeLazyServerDel)
 	}
 
-	destDB.PutEntity(destKey, src)
+	// source and destination must not share the value, otherwise modifying one changes the other
+	destDB.PutEntity(destKey, &database.DataEntity{Data: cloneData(src.Data)})
 	raw, exists := db.ttlMap.Get(srcKey)
 	if exists {
 		expire := raw.(time.Time)
 		destDB.Expire(destKey, expire)
 	}
-	mdb.AddAof(conn.GetDBIndex(), utils.ToCmdLine3("copy", args...))
+	mdb.AddAof(srcIndex, utils.ToCmdLine3("copy", args...))
+	destDB.notify(notifyGeneric, "copy_to", destKey)
+	return protocol.MakeIntReply(1)
+}
+
+// parseCopyOptions returns index of destination db and whether REPLACE is given
+func parseCopyOptions(mdb *Server, srcIndex int, args [][]byte) (int, bool, slava.Reply) {
+	dbIndex := srcIndex
+	replaceFlag := false
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "db" {
+			if i+1 >= len(args) {
+				return 0, false, &protocol.SyntaxErrReply{}
+			}
+			idx, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return 0, false, &protocol.SyntaxErrReply{}
+			}
+			if idx >= len(mdb.dbSet) || idx < 0 {
+				return 0, false, protocol.MakeErrReply("ERR DB index is out of range")
+			}
+			dbIndex = idx
+			i++
+		} else if arg == "replace" {
+			replaceFlag = true
+		} else {
+			return 0, false, &protocol.SyntaxErrReply{}
+		}
+	}
+	return dbIndex, replaceFlag, nil
+}
+
+// execMove usage: MOVE key db
+// moves key from the selected database to the given database, it does nothing if key already exists in destination
+func execMove(mdb *Server, conn slava.Connection, args [][]byte) slava.Reply {
+	srcIndex := conn.GetDBIndex()
+	destIndex, errReply := parseMoveDest(mdb, srcIndex, args)
+	if errReply != nil {
+		return errReply
+	}
+	mdb.swapMu.RLock()
+	defer mdb.swapMu.RUnlock()
+	// always lock the db with lower index first to avoid dead lock between MOVEs in opposite directions
+	keys := []string{string(args[0])}
+	first, second := mdb.mustSelectDB(srcIndex), mdb.mustSelectDB(destIndex)
+	if srcIndex > destIndex {
+		first, second = second, first
+	}
+	first.RWLocks(keys, nil)
+	second.RWLocks(keys, nil)
+	defer func() {
+		// move_from and move_to are published in order after both dbs are unlocked
+		events := second.rwUnLocksTakeEvents(keys, nil)
+		publishEvents(append(events, first.rwUnLocksTakeEvents(keys, nil)...))
+	}()
+	return moveWithLock(mdb, srcIndex, destIndex, args)
+}
+
+// parseMoveDest returns index of destination db of MOVE
+func parseMoveDest(mdb *Server, srcIndex int, args [][]byte) (int, slava.Reply) {
+	destIndex, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if destIndex >= len(mdb.dbSet) || destIndex < 0 {
+		return 0, protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	if srcIndex == destIndex {
+		return 0, protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	return destIndex, nil
+}
+
+// moveWithLock executes MOVE, invoker should lock the key in both databases
+func moveWithLock(mdb *Server, srcIndex int, destIndex int, args [][]byte) slava.Reply {
+	key := string(args[0])
+	srcDB, destDB := mdb.mustSelectDB(srcIndex), mdb.mustSelectDB(destIndex)
+	entity, exists := srcDB.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists = destDB.GetEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+	raw, hasTTL := srcDB.ttlMap.Get(key)
+	srcDB.addVersion(key)
+	destDB.addVersion(key)
+	srcDB.Remove(key)
+	destDB.PutEntity(key, entity)
+	if hasTTL {
+		destDB.Expire(key, raw.(time.Time))
+	}
+	mdb.AddAof(srcIndex, utils.ToCmdLine3("move", args...))
+	srcDB.notify(notifyGeneric, "move_from", key)
+	destDB.notify(notifyGeneric, "move_to", key)
 	return protocol.MakeIntReply(1)
 }
 
 func init() {
-	RegisterCommand("Del", execDel, writeAllKeys, undoDel, -2, flagWrite)
-	RegisterCommand("Expire", execExpire, writeFirstKey, undoExpire, 3, flagWrite)
-	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, 3, flagWrite)
-	RegisterCommand("PExpire", execPExpire, writeFirstKey, undoExpire, 3, flagWrite)
-	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, undoExpire, 3, flagWrite)
-	RegisterCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly)
-	RegisterCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly)
-	RegisterCommand("Persist", execPersist, writeFirstKey, undoExpire, 2, flagWrite)
-	RegisterCommand("Exists", execExists, readAllKeys, nil, -2, flagReadOnly)
-	RegisterCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly)
-	RegisterCommand("Rename", execRename, prepareRename, undoRename, 3, flagReadOnly)
-	RegisterCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagReadOnly)
-	RegisterCommand("Keys", execKeys, noPrepare, nil, 2, flagReadOnly)
+	RegisterCommand("Del", execDel, writeAllKeys, undoDel, -2, flagWrite).keys(1, -1, 1).category("@keyspace")
+	RegisterCommand("Unlink", execUnlink, writeAllKeys, undoDel, -2, flagWrite).keys(1, -1, 1).category("@keyspace")
+	RegisterCommand("Expire", execExpire, writeFirstKey, undoExpire, -3, flagWrite).category("@keyspace")
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, -3, flagWrite).category("@keyspace")
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, undoExpire, -3, flagWrite).category("@keyspace")
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, undoExpire, -3, flagWrite).category("@keyspace")
+	RegisterCommand("ExpireTime", execExpireTime, readFirstKey, nil, 2, flagReadOnly).category("@keyspace")
+	RegisterCommand("PExpireTime", execPExpireTime, readFirstKey, nil, 2, flagReadOnly).category("@keyspace")
+	RegisterCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly).category("@keyspace")
+	RegisterCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly).category("@keyspace")
+	RegisterCommand("Persist", execPersist, writeFirstKey, undoExpire, 2, flagWrite).category("@keyspace")
+	RegisterCommand("Exists", execExists, readAllKeys, nil, -2, flagReadOnly).keys(1, -1, 1).category("@keyspace")
+	RegisterCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly).category("@keyspace")
+	RegisterCommand("Rename", execRename, prepareRename, undoRename, 3, flagWrite).keys(1, 2, 1).category("@keyspace")
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagWrite).keys(1, 2, 1).category("@keyspace")
+	RegisterCommand("Keys", execKeys, noPrepare, nil, 2, flagReadOnly).keys(0, 0, 0).category("@keyspace", "@dangerous")
+	RegisterCommand("Scan", execScan, noPrepare, nil, -2, flagReadOnly).keys(0, 0, 0).category("@keyspace")
+	RegisterCommand("Touch", execTouch, readAllKeys, nil, -2, flagReadOnly).keys(1, -1, 1).category("@keyspace")
+	RegisterCommand("RandomKey", execRandomKey, noPrepare, nil, 1, flagReadOnly).keys(0, 0, 0).category("@keyspace")
+	RegisterCommand("DBSize", execDBSize, noPrepare, nil, 1, flagReadOnly).keys(0, 0, 0).category("@keyspace")
 }
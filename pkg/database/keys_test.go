@@ -0,0 +1,144 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func TestExpireFlags(t *testing.T) {
+	testDB := MakeDB()
+	execSet(testDB, utils.ToCmdLine("K1", "V1"))
+
+	cases := []struct {
+		args     []string
+		expected int64
+	}{
+		{[]string{"K1", "100", "XX"}, 0}, // no ttl yet
+		{[]string{"K1", "100", "GT"}, 0}, // no ttl means infinite
+		{[]string{"K1", "100", "LT"}, 1},
+		{[]string{"K1", "200", "NX"}, 0},
+		{[]string{"K1", "200", "LT"}, 0},
+		{[]string{"K1", "200", "GT"}, 1},
+		{[]string{"K1", "50", "XX"}, 1},
+		{[]string{"K2", "50"}, 0},
+	}
+	for _, c := range cases {
+		actual := execExpire(testDB, utils.ToCmdLine(c.args...))
+		if !utils.BytesEquals(actual.ToBytes(), protocol.MakeIntReply(c.expected).ToBytes()) {
+			t.Errorf("expire %v: expected %d, actual %s", c.args, c.expected, actual.ToBytes())
+		}
+	}
+	if reply := execExpire(testDB, utils.ToCmdLine("K1", "50", "NX", "GT")); !protocol.IsErrorReply(reply) {
+		t.Errorf("NX and GT should not be compatible")
+	}
+	ttl := execTTL(testDB, utils.ToCmdLine("K1")).(*protocol.IntReply).Code
+	if ttl > 50 || ttl < 48 {
+		t.Errorf("expected ttl about 50, actual %d", ttl)
+	}
+	expireTime := execExpireTime(testDB, utils.ToCmdLine("K1")).(*protocol.IntReply).Code
+	pExpireTime := execPExpireTime(testDB, utils.ToCmdLine("K1")).(*protocol.IntReply).Code
+	if pExpireTime/1000 != expireTime {
+		t.Errorf("EXPIRETIME %d and PEXPIRETIME %d are inconsistent", expireTime, pExpireTime)
+	}
+}
+
+func TestMoveAndSwapDB(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("expire", "K1", "100"))
+
+	reply := server.Exec(conn, utils.ToCmdLine("move", "K1", "1"))
+	if !utils.BytesEquals(reply.ToBytes(), protocol.MakeIntReply(1).ToBytes()) {
+		t.Errorf("move failed: %s", reply.ToBytes())
+	}
+	if reply = server.Exec(conn, utils.ToCmdLine("dbsize")); !utils.BytesEquals(reply.ToBytes(), protocol.MakeIntReply(0).ToBytes()) {
+		t.Errorf("key should be removed from source db")
+	}
+
+	reply = server.Exec(conn, utils.ToCmdLine("swapdb", "0", "1"))
+	if _, ok := reply.(*protocol.OkReply); !ok {
+		t.Errorf("swapdb failed: %s", reply.ToBytes())
+	}
+	reply = server.Exec(conn, utils.ToCmdLine("get", "K1"))
+	if !utils.BytesEquals(reply.ToBytes(), protocol.MakeBulkReply([]byte("V1")).ToBytes()) {
+		t.Errorf("expected V1 after swapdb, actual %s", reply.ToBytes())
+	}
+	ttl := server.Exec(conn, utils.ToCmdLine("ttl", "K1")).(*protocol.IntReply).Code
+	if ttl <= 0 {
+		t.Errorf("ttl should be kept by move, actual %d", ttl)
+	}
+	reply = server.Exec(conn, utils.ToCmdLine("randomkey"))
+	if !utils.BytesEquals(reply.ToBytes(), protocol.MakeBulkReply([]byte("K1")).ToBytes()) {
+		t.Errorf("expected K1 from randomkey, actual %s", reply.ToBytes())
+	}
+}
+
+func TestScan(t *testing.T) {
+	testDB := MakeDB()
+	for i := 0; i < 100; i++ {
+		execSet(testDB, utils.ToCmdLine("str"+strconv.Itoa(i), "v"))
+	}
+	execZAdd(testDB, utils.ToCmdLine("zset", "1", "a"))
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := execScan(testDB, utils.ToCmdLine(cursor, "MATCH", "str*", "COUNT", "20"))
+		replies := reply.(*protocol.MultiRawReply).Replies
+		cursor = string(replies[0].(*protocol.BulkReply).Arg)
+		for _, key := range replies[1].(*protocol.MultiBulkReply).Args {
+			seen[string(key)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 100 || seen["zset"] {
+		t.Errorf("expected 100 keys matching str*, actual %d", len(seen))
+	}
+
+	reply := execScan(testDB, utils.ToCmdLine("0", "TYPE", "zset", "COUNT", "1000"))
+	keys := reply.(*protocol.MultiRawReply).Replies[1].(*protocol.MultiBulkReply).Args
+	if len(keys) != 1 || string(keys[0]) != "zset" {
+		t.Errorf("expected only zset, actual %d keys", len(keys))
+	}
+	if reply := execScan(testDB, utils.ToCmdLine("0", "COUNT")); !protocol.IsErrorReply(reply) {
+		t.Errorf("expected syntax error")
+	}
+}
+
+// run with -race: swapdb changes index of db while commands on it read the index
+func TestSwapDBConcurrently(t *testing.T) {
+	server := NewStandaloneServer()
+	server.notifyFlags, _ = parseNotifyFlags("KEA")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn := connection.NewFakeConn()
+		for i := 0; i < 1000; i++ {
+			server.Exec(conn, utils.ToCmdLine("swapdb", "0", "1"))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		// commands keep working on the db they selected while it is being swapped
+		db := server.mustSelectDB(0)
+		conn := connection.NewFakeConn()
+		for i := 0; i < 1000; i++ {
+			db.Exec(conn, utils.ToCmdLine("set", "K"+strconv.Itoa(i), "V"))
+		}
+	}()
+	wg.Wait()
+	for i, db := range server.dbSet {
+		if index := db.Load().(*DB).getIndex(); index != i {
+			t.Errorf("expected index %d of db, actual %d", i, index)
+		}
+	}
+}
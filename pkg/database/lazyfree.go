@@ -0,0 +1,121 @@
+package database
+
+import (
+	"sync/atomic"
+
+	"slava/internal/interface/database"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
+	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
+)
+
+// lazyfree 与 redis 一样: 删除大的 value 时只将其从 dict 中摘除, 释放工作交给后台 goroutine
+// go 中 value 最终由 GC 回收, worker 持有 value 的最后一个引用, 在命令执行路径之外丢弃它,
+// 以后需要在释放时做的工作(如统计)也应放在 worker 中, 不会占用 key 的锁
+const (
+	// lazyfreeThreshold values with more elements than threshold are freed in background
+	lazyfreeThreshold = 64
+	// lazyfreeQueueSize values are freed at once if the queue is full
+	lazyfreeQueueSize = 1024
+)
+
+type lazyFreer struct {
+	jobs chan interface{}
+	// pending is number of objects queued but not freed yet
+	pending int64
+	// freed is number of objects freed in background
+	freed int64
+}
+
+func makeLazyFreer() *lazyFreer {
+	return &lazyFreer{
+		jobs: make(chan interface{}, lazyfreeQueueSize),
+	}
+}
+
+// start runs the worker until closed is closed, objects left in queue are collected by GC
+func (f *lazyFreer) start(closed <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case <-closed:
+				return
+			case <-f.jobs:
+				// the last reference is dropped here
+				atomic.AddInt64(&f.pending, -1)
+				atomic.AddInt64(&f.freed, 1)
+			}
+		}
+	}()
+}
+
+// free hands obj over to the worker if effort of freeing it exceeds lazyfreeThreshold, otherwise obj is freed at once
+func (f *lazyFreer) free(obj interface{}, effort int) {
+	if f == nil || effort <= lazyfreeThreshold {
+		return
+	}
+	atomic.AddInt64(&f.pending, 1)
+	select {
+	case f.jobs <- obj:
+	default:
+		atomic.AddInt64(&f.pending, -1)
+	}
+}
+
+func (f *lazyFreer) pendingObjects() int64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&f.pending)
+}
+
+func (f *lazyFreer) freedObjects() int64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&f.freed)
+}
+
+// lazyfreeEffort returns number of elements to free in value, strings are counted as 1
+func lazyfreeEffort(data interface{}) int {
+	switch val := data.(type) {
+	case quicklist.List:
+		return val.Len()
+	case *list.List:
+		return val.Len()
+	case *set.Set:
+		return val.Len()
+	case *SortedSet.SortedSet:
+		return int(val.Len())
+	case dict.Dict:
+		return val.Len()
+	case *graph.Graph:
+		return val.NodeCount() + val.EdgeCount()
+	case *suggest.Trie:
+		return val.Len()
+	}
+	return 1
+}
+
+// unlinkKey removes key from db at once, and frees its value in background if the value is big
+func (db *DB) unlinkKey(key string) {
+	raw, exists := db.data.Get(key)
+	db.Remove(key)
+	if exists {
+		entity, _ := raw.(*database.DataEntity)
+		db.lazyfree.free(entity.Data, lazyfreeEffort(entity.Data))
+	}
+}
+
+// removeKey removes key and frees its value in background if lazy is true
+func (db *DB) removeKey(key string, lazy bool) {
+	if lazy {
+		db.unlinkKey(key)
+	} else {
+		db.Remove(key)
+	}
+}
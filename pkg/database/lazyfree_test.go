@@ -0,0 +1,75 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"slava/internal/interface/database"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func waitLazyfree(t *testing.T, server *Server, freed int64) {
+	deadline := time.Now().Add(time.Second)
+	for server.lazyfree.freedObjects() < freed || server.lazyfree.pendingObjects() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d objects freed in background, actual %d, pending %d",
+				freed, server.lazyfree.freedObjects(), server.lazyfree.pendingObjects())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLazyfree(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	cmdLine := []string{"zadd", "big"}
+	for i := 0; i < lazyfreeThreshold*2; i++ {
+		cmdLine = append(cmdLine, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	server.Exec(conn, utils.ToCmdLine(cmdLine...))
+	server.Exec(conn, utils.ToCmdLine("set", "small", "v"))
+
+	reply := server.Exec(conn, utils.ToCmdLine("unlink", "big", "small", "none"))
+	if code := reply.(*protocol.IntReply).Code; code != 2 {
+		t.Errorf("expected 2 keys unlinked, actual %d", code)
+	}
+	if size := server.mustSelectDB(0).data.Len(); size != 0 {
+		t.Errorf("expected unlinked keys removed at once, actual %d keys", size)
+	}
+	// only the big zset is freed in background
+	waitLazyfree(t, server, 1)
+	if freed := server.lazyfree.freedObjects(); freed != 1 {
+		t.Errorf("expected 1 object freed in background, actual %d", freed)
+	}
+
+	for i := 0; i < lazyfreeThreshold*2; i++ {
+		server.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), "v"))
+	}
+	// old db handed over to lazyfree is still visible to snapshot taken before flushing
+	snapshot := server.Snapshot(nil)
+	defer snapshot.Release()
+	reply = server.Exec(conn, utils.ToCmdLine("flushdb", "async"))
+	if !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	if size := server.mustSelectDB(0).data.Len(); size != 0 {
+		t.Errorf("expected empty db after FLUSHDB ASYNC, actual %d keys", size)
+	}
+	waitLazyfree(t, server, 2)
+	count := 0
+	snapshot.ForEach(0, func(key string, data *database.DataEntity, expiration *time.Time) bool {
+		count++
+		return true
+	})
+	if count != lazyfreeThreshold*2 {
+		t.Errorf("expected %d keys in snapshot, actual %d", lazyfreeThreshold*2, count)
+	}
+
+	reply = server.Exec(conn, utils.ToCmdLine("flushall", "lazy"))
+	if !protocol.IsErrorReply(reply) {
+		t.Errorf("expected syntax error")
+	}
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"slava/config"
+	"slava/internal/interface/database"
+)
+
+// DataEntity.Lfu 与 redis 一样由两部分组成:
+//
+//	高 16 位: 上次递减计数的时间, 单位为分钟, 会回绕
+//	低 8 位:  对数计数器, 访问越多递增的概率越低, 每过 lfu-decay-time 分钟减一
+const (
+	lfuInitVal    = 5
+	lfuCounterMax = 255
+)
+
+var (
+	// lfuClock and lfuRand can be replaced to simulate access patterns in tests
+	lfuClock = time.Now
+	lfuRand  = rand.Float64
+)
+
+// lfuTimeInMinutes returns current time in minutes, keeping only the least significant 16 bits
+func lfuTimeInMinutes() uint32 {
+	return uint32(lfuClock().Unix()/60) & 0xFFFF
+}
+
+// lfuTimeElapsed returns minutes since ldt, considering wrapping of 16 bits
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 0xFFFF - ldt + now
+}
+
+// lfuLogIncr increments counter logarithmically, the greater the counter is, the less likely it increments
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == lfuCounterMax {
+		return counter
+	}
+	baseVal := float64(0)
+	if counter > lfuInitVal {
+		baseVal = float64(counter - lfuInitVal)
+	}
+	factor := float64(config.Properties.LfuLogFactor)
+	p := 1.0 / (baseVal*factor + 1)
+	if lfuRand() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn returns counter decremented by elapsed decay periods, without updating lfu
+func lfuDecrAndReturn(lfu uint32) uint32 {
+	ldt := lfu >> 8
+	counter := lfu & 0xFF
+	var periods uint32
+	if decayTime := config.Properties.LfuDecayTime; decayTime > 0 {
+		periods = lfuTimeElapsed(ldt) / uint32(decayTime)
+	}
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfuFreq returns access frequency of entity, used by OBJECT FREQ and lfu eviction
+func lfuFreq(entity *database.DataEntity) uint32 {
+	return lfuDecrAndReturn(atomic.LoadUint32(&entity.Lfu))
+}
+
+// updateLFU decays and then increments counter of entity on access, a new entity starts from lfuInitVal
+func updateLFU(entity *database.DataEntity) {
+	lfu := atomic.LoadUint32(&entity.Lfu)
+	var counter uint32
+	if lfu == 0 {
+		counter = lfuInitVal
+	} else {
+		counter = lfuLogIncr(lfuDecrAndReturn(lfu))
+	}
+	// concurrent accesses may overwrite each other, it is acceptable for an approximate counter
+	atomic.StoreUint32(&entity.Lfu, lfuTimeInMinutes()<<8|counter)
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"slava/internal/interface/database"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+// useVirtualClock makes lfu read time from the returned pointer and use a seeded random source
+func useVirtualClock(t *testing.T) *time.Time {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	lfuClock = func() time.Time {
+		return now
+	}
+	lfuRand = rand.New(rand.NewSource(1)).Float64
+	t.Cleanup(func() {
+		lfuClock = time.Now
+		lfuRand = rand.Float64
+	})
+	return &now
+}
+
+func TestLfuLogCounter(t *testing.T) {
+	useVirtualClock(t)
+	entity := &database.DataEntity{}
+	updateLFU(entity)
+	if freq := lfuFreq(entity); freq != lfuInitVal {
+		t.Errorf("new key should start from %d, actual %d", lfuInitVal, freq)
+	}
+	for i := 0; i < 100; i++ {
+		updateLFU(entity)
+	}
+	// with lfu-log-factor 10, about 100 hits make counter 10
+	if freq := lfuFreq(entity); freq < 8 || freq > 14 {
+		t.Errorf("expected counter near 10 after 100 hits, actual %d", freq)
+	}
+	for i := 0; i < 1000000; i++ {
+		updateLFU(entity)
+	}
+	if freq := lfuFreq(entity); freq != lfuCounterMax {
+		t.Errorf("expected counter saturated after 1M hits, actual %d", freq)
+	}
+}
+
+func TestLfuDecay(t *testing.T) {
+	now := useVirtualClock(t)
+	server := NewStandaloneServer()
+	db := server.mustSelectDB(0)
+	conn := connection.NewFakeConn()
+
+	// yesterday's hot key
+	server.Exec(conn, utils.ToCmdLine("set", "old", "1"))
+	for i := 0; i < 1000; i++ {
+		server.Exec(conn, utils.ToCmdLine("get", "old"))
+	}
+	entity, _ := db.peekEntity("old")
+	hot := lfuFreq(entity)
+
+	*now = now.Add(10 * time.Minute)
+	if freq := lfuFreq(entity); freq != hot-10 {
+		t.Errorf("expected counter decayed to %d after 10 minutes, actual %d", hot-10, freq)
+	}
+
+	// a few hours later, the old key is colder than a key accessed recently
+	*now = now.Add(3 * time.Hour)
+	server.Exec(conn, utils.ToCmdLine("set", "new", "1"))
+	for i := 0; i < 10; i++ {
+		server.Exec(conn, utils.ToCmdLine("get", "new"))
+	}
+	pool := makeEvictionPool()
+	pool.populate(db, maxMemoryLfuAllKeys, 5)
+	if best, _ := pool.popBest(); best.key != "old" {
+		t.Errorf("expected decayed key to be evicted, actual %s", best.key)
+	}
+	reply := server.Exec(conn, utils.ToCmdLine("object", "freq", "old"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected freq 0, actual %q", reply.ToBytes())
+	}
+}
+
+func TestLfuKeptOnOverwrite(t *testing.T) {
+	useVirtualClock(t)
+	server := NewStandaloneServer()
+	db := server.mustSelectDB(0)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "hot", "1"))
+	for i := 0; i < 1000; i++ {
+		server.Exec(conn, utils.ToCmdLine("get", "hot"))
+	}
+	entity, _ := db.peekEntity("hot")
+	hot := lfuFreq(entity)
+
+	// overwriting counts as an access instead of starting over
+	server.Exec(conn, utils.ToCmdLine("set", "hot", "2"))
+	server.Exec(conn, utils.ToCmdLine("set", "hot", "3", "XX"))
+	entity, _ = db.peekEntity("hot")
+	if freq := lfuFreq(entity); freq < hot {
+		t.Errorf("expected counter kept as %d after overwrite, actual %d", hot, freq)
+	}
+}
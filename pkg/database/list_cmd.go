@@ -39,18 +39,27 @@ func execListRPush(db *DB, args [][]byte) slava.Reply {
 	}
 
 	db.AddAof(utils.ToCmdLine3("rpush", args...))
+	db.notify(notifyList, "rpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
-// 通过ListRpop操作进行撤销
+// 通过RPop操作进行撤销
 func undoRPush(db *DB, args [][]byte) []CmdLine {
+	return undoPush(db, args, "RPOP")
+}
+
+// undoPush pops pushed values, or removes the key if it is created by push
+func undoPush(db *DB, args [][]byte, popCmd string) []CmdLine {
 	// args[0] key
 	// args[1:] 参数
 	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return []CmdLine{utils.ToCmdLine("DEL", key)}
+	}
 	count := len(args) - 1
 	cmdLines := make([]CmdLine, 0, count)
 	for i := 0; i < count; i++ {
-		cmdLines = append(cmdLines, utils.ToCmdLine("ListRpop", key))
+		cmdLines = append(cmdLines, utils.ToCmdLine(popCmd, key))
 	}
 	return cmdLines
 }
@@ -70,19 +79,13 @@ func execListLPush(db *DB, args [][]byte) slava.Reply {
 	}
 
 	db.AddAof(utils.ToCmdLine3("lpush", args...))
+	db.notify(notifyList, "lpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
+// 通过LPop操作进行撤销
 func undoLPush(db *DB, args [][]byte) []CmdLine {
-	// args[0] key
-	// args[1:] 参数
-	key := string(args[0])
-	count := len(args) - 1
-	cmdLines := make([]CmdLine, 0, count)
-	for i := 0; i < count; i++ {
-		cmdLines = append(cmdLines, utils.ToCmdLine("ListLpop", key))
-	}
-	return cmdLines
+	return undoPush(db, args, "LPOP")
 }
 
 func execListRpop(db *DB, args [][]byte) slava.Reply {
@@ -97,6 +100,12 @@ func execListRpop(db *DB, args [][]byte) slava.Reply {
 	}
 
 	node := list.RPop()
+	db.notify(notifyList, "rpop", key)
+	// 空的list需要从数据库中删除
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
 
 	db.AddAof(utils.ToCmdLine3("rpop", args...))
 	return protocol.MakeBulkReply([]byte(node.GetValue()))
@@ -115,6 +124,12 @@ func execListLpop(db *DB, args [][]byte) slava.Reply {
 	}
 
 	node := list.LPop()
+	db.notify(notifyList, "lpop", key)
+	// 空的list需要从数据库中删除
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
 
 	db.AddAof(utils.ToCmdLine3("lpop", args...))
 	return protocol.MakeBulkReply([]byte(node.GetValue()))
@@ -189,11 +204,11 @@ func execListRange(db *DB, args [][]byte) slava.Reply {
 // 读操作：无undo函数
 // 写操作：有undo函数
 func init() {
-	RegisterCommand("ListLen", execListLen, ReadFirstKey, nil, 1, flagReadOnly)
-	RegisterCommand("ListRPush", execListRPush, WriteFirstKey, undoRPush, 1, flagWrite)
-	RegisterCommand("ListLPush", execListLPush, WriteFirstKey, undoLPush, 1, flagWrite)
-	RegisterCommand("ListRpop", execListRpop, ReadFirstKey, nil, 1, flagReadOnly)
-	RegisterCommand("ListLpop", execListLpop, ReadFirstKey, nil, 1, flagReadOnly)
-	RegisterCommand("ListGetByIndex", execListGetByIndex, ReadFirstKey, nil, 1, flagReadOnly)
-	RegisterCommand("ListRange", execListRange, ReadFirstKey, nil, 1, flagReadOnly)
+	RegisterCommand("LLen", execListLen, ReadFirstKey, nil, 2, flagReadOnly).category("@list")
+	RegisterCommand("RPush", execListRPush, WriteFirstKey, undoRPush, -3, flagWrite|flagDenyOOM).category("@list")
+	RegisterCommand("LPush", execListLPush, WriteFirstKey, undoLPush, -3, flagWrite|flagDenyOOM).category("@list")
+	RegisterCommand("RPop", execListRpop, WriteFirstKey, RollbackFirstKey, 2, flagWrite).category("@list")
+	RegisterCommand("LPop", execListLpop, WriteFirstKey, RollbackFirstKey, 2, flagWrite).category("@list")
+	RegisterCommand("LIndex", execListGetByIndex, ReadFirstKey, nil, 3, flagReadOnly).category("@list")
+	RegisterCommand("LRange", execListRange, ReadFirstKey, nil, 4, flagReadOnly).category("@list")
 }
@@ -1,208 +1,260 @@
 package database
 
 import (
-	"math/rand"
-	"runtime"
-	"slava/internal/interface/database"
-	"slava/pkg/datastruct/dict"
+	"errors"
+	"runtime/metrics"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"slava/config"
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/datastruct/dict"
 )
 
 const (
-	maxMemoryLruAllKeys = iota
+	maxMemoryNoEviction = iota
+	maxMemoryLruAllKeys
 	maxMemoryLfuAllKeys
+	maxMemoryRandomAllKeys
 	maxMemoryLruTtl
 	maxMemoryLfuTtl
+	maxMemoryRandomTtl
+	maxMemoryTtl
 )
 
-// freeMemoryIfNeeded 进行内存清理如果需要的话
-func (server *Server) freeMemoryIfNeeded(keyNumsOneRound int, dbNumsOneRound int) {
-	var memUsed uint64
-	var memStats runtime.MemStats
-
-	for {
-		runtime.ReadMemStats(&memStats)
-		//memUsed = memStats.HeapAlloc + memStats.HeapIdle // question
-		memUsed = memStats.HeapAlloc
+var maxMemoryPolicyNames = []string{
+	maxMemoryNoEviction:    "noeviction",
+	maxMemoryLruAllKeys:    "allkeys-lru",
+	maxMemoryLfuAllKeys:    "allkeys-lfu",
+	maxMemoryRandomAllKeys: "allkeys-random",
+	maxMemoryLruTtl:        "volatile-lru",
+	maxMemoryLfuTtl:        "volatile-lfu",
+	maxMemoryRandomTtl:     "volatile-random",
+	maxMemoryTtl:           "volatile-ttl",
+}
 
-		// 不需要清除，直接return
-		if memUsed <= server.maxMemory {
-			return
-		}
+// memStatsInterval 限制读取堆内存统计的频率, 读取 runtime/metrics 不会 stop the world, 但仍需汇总各 P 的统计
+const memStatsInterval = 10 * time.Millisecond
 
-		if dbNumsOneRound > len(server.dbSet) {
-			dbNumsOneRound = len(server.dbSet)
-		}
+// metrics read by memoryTracker, they are the same as HeapAlloc and NumGC of runtime.MemStats
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+)
 
-		// 挑选需要释放key的db，挑选策略可以更改，目前采用简单的随机方式
-		dbIds := randomSelectDB(len(server.dbSet), dbNumsOneRound)
-		var wg sync.WaitGroup
-
-		for i := 0; i < dbNumsOneRound; i++ {
-			slavaDb, _ := server.selectDB(dbIds[i])
-
-			// 暂时不支持random 只支持lru和lfu
-			if server.maxMemoryPolicy == maxMemoryLruAllKeys || server.maxMemoryPolicy == maxMemoryLruTtl {
-				wg.Add(1)
-				if server.maxMemoryPolicy == maxMemoryLruTtl {
-					// ttl
-					go func() {
-						defer wg.Done()
-						approximateLruTtl(slavaDb, keyNumsOneRound)
-					}()
-				} else {
-					go func() {
-						defer wg.Done()
-						approximateLruAll(slavaDb, keyNumsOneRound)
-					}()
-				}
-			}
+var errOOM = protocol.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
 
-			if server.maxMemoryPolicy == maxMemoryLfuAllKeys || server.maxMemoryPolicy == maxMemoryLfuTtl {
-				wg.Add(1)
-				if server.maxMemoryPolicy == maxMemoryLfuTtl {
-					// ttl
-					go func() {
-						defer wg.Done()
-						approximateLfuTtl(slavaDb, keyNumsOneRound)
-					}()
-				} else {
-					func() {
-						defer wg.Done()
-						approximateLfuAll(slavaDb, keyNumsOneRound)
-					}()
-				}
-			}
+func parseMaxMemoryPolicy(name string) (uint8, error) {
+	for policy, policyName := range maxMemoryPolicyNames {
+		if policyName == name {
+			return uint8(policy), nil
 		}
-
-		// 等待该轮多个db的清除操作完成后再循环
-		wg.Wait()
 	}
+	return 0, errors.New("unknown maxmemory-policy " + name)
 }
 
-func randomSelectDB(dbNums int, selectNums int) []int {
-	rand.Seed(time.Now().UnixNano()) // 设置随机数种子，使用当前时间戳
-
-	nums := make(map[int]struct{})
+// memoryTracker estimates memory in use
+// heap in use is read at most once per interval, memory freed by eviction is subtracted until next GC
+// actually releases it, otherwise eviction would go on until GC
+type memoryTracker struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	heapAlloc uint64
+	numGC     uint32
+	freed     uint64
+	readAt    time.Time
+	// readMemStats returns heap in use and number of completed GC, it can be replaced in tests
+	readMemStats func() (uint64, uint32)
+}
 
-	for i := 0; i < selectNums; i++ {
-		num := rand.Intn(dbNums)
-		if _, ok := nums[num]; !ok {
-			nums[num] = struct{}{}
-		}
+func makeMemoryTracker() *memoryTracker {
+	return &memoryTracker{
+		interval:     memStatsInterval,
+		readMemStats: readHeapStats,
 	}
+}
 
-	result := make([]int, selectNums)
-	i := 0
-	for key := range nums {
-		result[i] = key
-		i++
+// readHeapStats returns heap in use and number of completed GC without stopping the world like runtime.ReadMemStats
+func readHeapStats() (uint64, uint32) {
+	samples := []metrics.Sample{
+		{Name: metricHeapObjects},
+		{Name: metricGCCycles},
 	}
-
-	return result
+	metrics.Read(samples)
+	var heapAlloc uint64
+	var numGC uint32
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		heapAlloc = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		numGC = uint32(samples[1].Value.Uint64())
+	}
+	return heapAlloc, numGC
 }
 
-func approximateLruAll(slavaDb *DB, nums int) {
-	data := slavaDb.data.(*dict.ConcurrentDict)
-	keyLruMap := data.RandomDistinctKeysWithTime(nums)
-
-	if len(keyLruMap) < 1 {
-		return
-	}
-	var earliestTimestamp int64
-	var earliestKey string
-	i := 0
-	for key, ts := range keyLruMap {
-		ts64 := int64(ts)
-		t := time.Unix(ts64, 0)
-		if i == 0 || t.Before(time.Unix(earliestTimestamp, 0)) {
-			earliestTimestamp = ts64
-			earliestKey = key
+func (t *memoryTracker) used() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := time.Now(); now.Sub(t.readAt) >= t.interval {
+		heapAlloc, numGC := t.readMemStats()
+		if numGC != t.numGC {
+			t.numGC = numGC
+			t.freed = 0
 		}
-		i++
+		t.heapAlloc = heapAlloc
+		t.readAt = now
+	}
+	if t.freed >= t.heapAlloc {
+		return 0
 	}
+	return t.heapAlloc - t.freed
+}
 
-	slavaDb.Remove(earliestKey)
-	return
+func (t *memoryTracker) addFreed(freed uint64) {
+	t.mu.Lock()
+	t.freed += freed
+	t.mu.Unlock()
 }
 
-func approximateLruTtl(slavaDb *DB, nums int) {
-	data := slavaDb.data.(*dict.ConcurrentDict)
-	ttlMap := slavaDb.ttlMap
+// denyOOM frees memory before write commands, returns OOM error if used memory is still over maxmemory.
+// replicas never evict keys by themselves, they delete keys following DEL from master
+func (server *Server) denyOOM(c slava.Connection, cmdName string) protocol.ErrorReply {
+	cmd := lookupCommand(cmdName)
+	if cmd == nil || cmd.flags&flagWrite == 0 {
+		return nil
+	}
+	// write commands without denyoom such as DEL may release memory, they are allowed even if nothing can be evicted
+	errReply := server.freeMemoryForWrite()
+	if errReply == nil || cmd.flags&flagDenyOOM == 0 {
+		return nil
+	}
+	if c != nil && c.InMultiState() {
+		c.AddTxError(errReply)
+	}
+	return errReply
+}
 
-	candidateKeys := ttlMap.RandomDistinctKeys(nums)
-	if len(candidateKeys) < 1 {
-		return
+// freeMemoryForWrite frees memory before adding data, returns OOM error if used memory is still over maxmemory
+func (server *Server) freeMemoryForWrite() protocol.ErrorReply {
+	if server.maxMemory == 0 || atomic.LoadInt32(&server.role) == slaveRole {
+		return nil
 	}
+	return server.freeMemoryIfNeeded()
+}
 
-	// len(keyLruMap) 可能小于nums
-	var earliestTimestamp int64
-	var earliestKey string
+// freeMemoryIfNeeded 进行内存清理如果需要的话, 无法释放足够内存时返回 OOM 错误
+func (server *Server) freeMemoryIfNeeded() protocol.ErrorReply {
+	if server.memTracker.used() <= server.maxMemory {
+		return nil
+	}
+	// only one goroutine evicts at a time, others wait and check again
+	server.evictMu.Lock()
+	defer server.evictMu.Unlock()
+	used := server.memTracker.used()
+	if used <= server.maxMemory {
+		return nil
+	}
+	if server.maxMemoryPolicy == maxMemoryNoEviction {
+		return errOOM
+	}
+	toFree := used - server.maxMemory
+	var freed uint64
+	for freed < toFree {
+		n := server.evictOneRound()
+		if n == 0 {
+			break
+		}
+		freed += n
+	}
+	server.memTracker.addFreed(freed)
+	if freed < toFree {
+		return errOOM
+	}
+	return nil
+}
 
-	for i, key := range candidateKeys {
-		val, ok := data.Get(key)
-		if ok {
-			ts := int64(val.(*database.DataEntity).Lru)
-			t := time.Unix(ts, 0)
-			if i == 0 || t.Before(time.Unix(earliestTimestamp, 0)) {
-				earliestTimestamp = ts
-				earliestKey = key
+// evictOneRound evicts a key chosen by policy, returns estimated memory freed or 0 if nothing can be evicted
+func (server *Server) evictOneRound() uint64 {
+	volatile := server.maxMemoryPolicy >= maxMemoryLruTtl
+	if server.maxMemoryPolicy == maxMemoryRandomAllKeys || server.maxMemoryPolicy == maxMemoryRandomTtl {
+		// visit dbs in turn so that keys are evicted from all dbs evenly
+		for i := 0; i < len(server.dbSet); i++ {
+			server.nextEvictDB = (server.nextEvictDB + 1) % len(server.dbSet)
+			db := server.mustSelectDB(server.nextEvictDB)
+			keys := db.data
+			if volatile {
+				keys = db.ttlMap
+			}
+			if freed := server.evict(db, randomKey(keys), nil); freed > 0 {
+				return freed
 			}
 		}
+		return 0
 	}
 
-	slavaDb.Remove(earliestKey)
-	return
+	for i := range server.dbSet {
+		db := server.mustSelectDB(i)
+		if (volatile && db.ttlMap.Len() > 0) || (!volatile && db.data.Len() > 0) {
+			server.evictionPool.populate(db, server.maxMemoryPolicy, server.maxMemorySamples)
+		}
+	}
+	for {
+		candidate, ok := server.evictionPool.popBest()
+		if !ok {
+			return 0
+		}
+		if freed := server.evictCandidate(candidate, volatile); freed > 0 {
+			return freed
+		}
+	}
 }
 
-func approximateLfuAll(slavaDb *DB, nums int) {
-	data := slavaDb.data.(*dict.ConcurrentDict)
-	keyLfuMap := data.RandomDistinctKeysWithCount(nums)
-
-	if len(keyLfuMap) < 1 {
-		return
+// evictCandidate evicts candidate from eviction pool unless it is stale, returns estimated memory freed.
+// since the candidate was sampled, its db may have been flushed, and its key may have been deleted,
+// overwritten or persisted, then its score says nothing about the key any more
+func (server *Server) evictCandidate(candidate *evictionCandidate, volatile bool) uint64 {
+	db := candidate.db
+	if server.mustSelectDB(db.getIndex()) != db {
+		return 0
 	}
-	var minimumCount uint32
-	var minimumKey string
-
-	i := 0
-	for key, count := range keyLfuMap {
-		if i == 0 || count > minimumCount {
-			minimumCount = count
-			minimumKey = key
+	if volatile {
+		if _, hasTTL := db.ttlMap.Get(candidate.key); !hasTTL {
+			return 0
 		}
-		i++
 	}
-
-	slavaDb.Remove(minimumKey)
-	return
+	return server.evict(db, candidate.key, candidate.entity)
 }
 
-func approximateLfuTtl(slavaDb *DB, nums int) {
-	data := slavaDb.data.(*dict.ConcurrentDict)
-	ttlMap := slavaDb.ttlMap
-
-	candidateKeys := ttlMap.RandomDistinctKeys(nums)
-	if len(candidateKeys) < 1 {
-		return
+func randomKey(d dict.Dict) string {
+	keys := d.RandomDistinctKeys(1)
+	if len(keys) == 0 {
+		return ""
 	}
+	return keys[0]
+}
 
-	// len(keyLruMap) 可能小于nums
-	var minimumCount uint32
-	var minimumKey string
-
-	for i, key := range candidateKeys {
-		val, ok := data.Get(key)
-		if ok {
-			count := val.(*database.DataEntity).Lfu
-			if i == 0 || count > minimumCount {
-				minimumCount = count
-				minimumKey = key
-			}
-		}
+// evict removes the key chosen by maxmemory policy, returns estimated memory freed
+// if expected is not nil, the key is evicted only if it still holds the expected entity.
+// the deletion is propagated to aof and replicas as DEL
+func (server *Server) evict(slavaDb *DB, key string, expected *database.DataEntity) uint64 {
+	if key == "" {
+		return 0
 	}
-
-	slavaDb.Remove(minimumKey)
-	return
+	slavaDb.RWLocks([]string{key}, nil)
+	defer slavaDb.RWUnLocks([]string{key}, nil)
+	entity, exists := slavaDb.peekEntity(key)
+	if !exists || (expected != nil && entity != expected) {
+		return 0
+	}
+	size := sizeOfKey(key) + sizeOfData(entity.Data, defaultMemorySamples)
+	slavaDb.removeKey(key, config.Properties.LazyfreeLazyEviction)
+	slavaDb.addVersion(key)
+	slavaDb.AddAof(utils.ToCmdLine("del", key))
+	slavaDb.notify(notifyEvicted, "evicted", key)
+	atomic.AddInt64(&server.stats.evictedKeys, 1)
+	return uint64(size)
 }
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"slava/config"
+	"slava/internal/interface/database"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]uint64{
+		"":      0,
+		"100":   100,
+		"1k":    1000,
+		"1kb":   1024,
+		"2GB":   2 << 30,
+		"10 mb": 10 << 20,
+	}
+	for value, expected := range cases {
+		actual, err := config.ParseMemory(value)
+		if err != nil || actual != expected {
+			t.Errorf("parse %s: expected %d, actual %d, err %v", value, expected, actual, err)
+		}
+	}
+	if _, err := config.ParseMemory("1xb"); err == nil {
+		t.Errorf("invalid size should be rejected")
+	}
+}
+
+func TestMaxMemoryEviction(t *testing.T) {
+	server := NewStandaloneServer()
+	db := server.mustSelectDB(0)
+	// every key costs 1000 bytes, every read sees a new GC
+	var numGC uint32
+	server.memTracker.interval = 0
+	server.memTracker.readMemStats = func() (uint64, uint32) {
+		numGC++
+		return uint64(db.data.Len()) * 1000, numGC
+	}
+	server.maxMemory = 10 * 1000
+	server.maxMemoryPolicy = maxMemoryRandomAllKeys
+	conn := connection.NewFakeConn()
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 20; i++ {
+		reply := server.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), value))
+		if protocol.IsErrorReply(reply) {
+			t.Fatalf("set should succeed after eviction, actual %s", reply.ToBytes())
+		}
+	}
+	if db.data.Len() != 11 {
+		t.Errorf("expected 11 keys, actual %d", db.data.Len())
+	}
+	info := string(server.execInfo(utils.ToCmdLine("stats")).(*protocol.BulkReply).Arg)
+	if !strings.Contains(info, "evicted_keys:9\r\n") {
+		t.Errorf("expected 9 evicted keys, actual %q", info)
+	}
+
+	// nothing can be evicted by volatile policies without keys having ttl
+	server.maxMemoryPolicy = maxMemoryLruTtl
+	reply := server.Exec(conn, utils.ToCmdLine("set", "k", value))
+	if string(reply.ToBytes()) != string(errOOM.ToBytes()) {
+		t.Errorf("expected OOM, actual %s", reply.ToBytes())
+	}
+	server.maxMemoryPolicy = maxMemoryNoEviction
+	reply = server.Exec(conn, utils.ToCmdLine("set", "k", value))
+	if string(reply.ToBytes()) != string(errOOM.ToBytes()) {
+		t.Errorf("expected OOM, actual %s", reply.ToBytes())
+	}
+	// so are commands handled by server itself
+	reply = server.Exec(conn, utils.ToCmdLine("copy", "k18", "k18-copy"))
+	if string(reply.ToBytes()) != string(errOOM.ToBytes()) {
+		t.Errorf("expected OOM, actual %s", reply.ToBytes())
+	}
+	// read commands and commands freeing memory are allowed
+	if reply = server.Exec(conn, utils.ToCmdLine("move", "k18", "1")); protocol.IsErrorReply(reply) {
+		t.Errorf("move should be allowed, actual %s", reply.ToBytes())
+	}
+	if reply = server.Exec(conn, utils.ToCmdLine("get", "k19")); protocol.IsErrorReply(reply) {
+		t.Errorf("get should be allowed, actual %s", reply.ToBytes())
+	}
+	if reply = server.Exec(conn, utils.ToCmdLine("del", "k19")); protocol.IsErrorReply(reply) {
+		t.Errorf("del should be allowed, actual %s", reply.ToBytes())
+	}
+	server.maxMemory = 0
+}
+
+func TestEvictStaleCandidate(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "k", "1"))
+	pool := makeEvictionPool()
+	pool.populate(server.mustSelectDB(0), maxMemoryLruAllKeys, 5)
+	candidate, _ := pool.popBest()
+
+	// the key is deleted and set again after sampled
+	server.Exec(conn, utils.ToCmdLine("del", "k"))
+	server.Exec(conn, utils.ToCmdLine("set", "k", "2"))
+	if freed := server.evictCandidate(candidate, false); freed != 0 {
+		t.Errorf("stale candidate should not be evicted")
+	}
+	// the db is flushed after sampled
+	pool.populate(server.mustSelectDB(0), maxMemoryLruAllKeys, 5)
+	candidate, _ = pool.popBest()
+	server.Exec(conn, utils.ToCmdLine("flushdb"))
+	server.Exec(conn, utils.ToCmdLine("set", "k", "3"))
+	if freed := server.evictCandidate(candidate, false); freed != 0 {
+		t.Errorf("candidate of flushed db should not be evicted")
+	}
+	if n := server.Exec(conn, utils.ToCmdLine("exists", "k")).(*protocol.IntReply).Code; n != 1 {
+		t.Errorf("expected k kept")
+	}
+	pool.populate(server.mustSelectDB(0), maxMemoryLruAllKeys, 5)
+	candidate, _ = pool.popBest()
+	if freed := server.evictCandidate(candidate, false); freed == 0 {
+		t.Errorf("expected fresh candidate evicted")
+	}
+}
+
+func TestReadHeapStats(t *testing.T) {
+	heapAlloc, _ := readHeapStats()
+	if heapAlloc == 0 {
+		t.Errorf("expected heap in use")
+	}
+}
+
+func TestEvictionPool(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "hot", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "cold", "1"))
+	for i := 0; i < 10; i++ {
+		server.Exec(conn, utils.ToCmdLine("get", "hot"))
+	}
+	pool := makeEvictionPool()
+	pool.populate(server.mustSelectDB(0), maxMemoryLfuAllKeys, 5)
+	if best, _ := pool.popBest(); best.key != "cold" {
+		t.Errorf("expected cold key to be evicted, actual %s", best.key)
+	}
+
+	// pool keeps the best candidates ever sampled
+	pool = makeEvictionPool()
+	for i := 0; i < 100; i++ {
+		pool.insert(&evictionCandidate{idle: uint64(i % 50), key: strconv.Itoa(i % 50)})
+	}
+	if len(pool.candidates) != evictionPoolSize {
+		t.Fatalf("expected %d candidates, actual %d", evictionPoolSize, len(pool.candidates))
+	}
+	for i := 49; i > 49-evictionPoolSize; i-- {
+		if best, _ := pool.popBest(); best.idle != uint64(i) {
+			t.Errorf("expected idle %d, actual %d", i, best.idle)
+		}
+	}
+	if _, ok := pool.popBest(); ok {
+		t.Errorf("pool should be empty")
+	}
+}
+
+// sampleEvict evicts the least recently used key among samples of every db,
+// which is how keys were evicted before eviction pool, it takes as many samples as the pool does
+func sampleEvict(server *Server, samples int) {
+	var victimDB *DB
+	var victim string
+	var oldest int32
+	for i := range server.dbSet {
+		db := server.mustSelectDB(i)
+		for _, key := range db.data.RandomDistinctKeys(samples) {
+			raw, ok := db.data.Get(key)
+			if !ok {
+				continue
+			}
+			if lru := raw.(*database.DataEntity).Lru; victim == "" || lru < oldest {
+				victimDB, victim, oldest = db, key, lru
+			}
+		}
+	}
+	if victimDB != nil {
+		server.evict(victimDB, victim, nil)
+	}
+}
+
+// benchmarkHitRatio replays a zipfian trace over a cache holding 10% of keys spread in 4 dbs, and reports hit ratio
+func benchmarkHitRatio(b *testing.B, evict func(server *Server)) {
+	const (
+		keyspace = 100000
+		capacity = keyspace / 10
+		dbCount  = 4
+	)
+	server := NewStandaloneServer()
+	server.maxMemoryPolicy = maxMemoryLruAllKeys
+	// one access per virtual second, so that lru clock can tell accesses apart
+	var clock int32
+	origin := lruClock
+	lruClock = func() int32 {
+		return clock
+	}
+	defer func() {
+		lruClock = origin
+	}()
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keyspace-1)
+	size := 0
+	hits := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clock = int32(i)
+		n := zipf.Uint64()
+		db := server.mustSelectDB(int(n % dbCount))
+		key := strconv.FormatUint(n, 10)
+		if _, ok := db.GetEntity(key); ok {
+			hits++
+			continue
+		}
+		db.PutEntity(key, &database.DataEntity{Data: []byte("v")})
+		size++
+		for size > capacity {
+			evict(server)
+			size = 0
+			for j := 0; j < dbCount; j++ {
+				keys, _ := server.GetDBSize(j)
+				size += keys
+			}
+		}
+	}
+	b.ReportMetric(float64(hits)/float64(b.N), "hits/op")
+}
+
+// BenchmarkEvictionHitRatio compares hit ratio of evicting with and without pool by the same samples per eviction,
+// run it with a fixed -benchtime such as 1000000x so that both replay the same trace
+func BenchmarkEvictionHitRatio(b *testing.B) {
+	b.Run("sampling", func(b *testing.B) {
+		benchmarkHitRatio(b, func(server *Server) {
+			sampleEvict(server, 5)
+		})
+	})
+	b.Run("pool", func(b *testing.B) {
+		benchmarkHitRatio(b, func(server *Server) {
+			server.maxMemorySamples = 5
+			server.evictOneRound()
+		})
+	})
+}
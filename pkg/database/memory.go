@@ -0,0 +1,300 @@
+package database
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
+	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
+)
+
+// 估算内存时使用的各种结构在64位平台上的大小, 结果只是近似值
+const (
+	sizeOfPointer   = 8
+	sizeOfString    = 16 // header of string
+	sizeOfSlice     = 24 // header of slice
+	sizeOfInterface = 16
+	sizeOfTime      = 24
+	sizeOfEntity    = sizeOfInterface + 8 // DataEntity
+	// sizeOfMapEntry is the average cost of an entry in go map including bucket overhead, excluding key and value
+	sizeOfMapEntry = 48
+	// sizeOfSkiplistNode is the average size of a skiplist node, a node has 4/3 levels on average
+	sizeOfSkiplistNode = sizeOfString + 8 + sizeOfPointer + sizeOfSlice + 4*(sizeOfPointer+16)/3
+	// quicklistPageSize is capacity of page in quicklist
+	quicklistPageSize = 1024
+
+	// defaultMemorySamples is the number of sampled elements of MEMORY USAGE if SAMPLES is not given
+	defaultMemorySamples = 5
+)
+
+// sampleLimit returns number of elements to sample, samples <= 0 means all
+func sampleLimit(size int, samples int) int {
+	if samples <= 0 || samples > size {
+		return size
+	}
+	return samples
+}
+
+// scale estimates total size from sampled size
+func scale(sampledSize int64, sampled int, size int) int64 {
+	if sampled == 0 {
+		return 0
+	}
+	return sampledSize * int64(size) / int64(sampled)
+}
+
+func sizeOfValue(val interface{}) int64 {
+	switch v := val.(type) {
+	case []byte:
+		return sizeOfSlice + int64(cap(v))
+	case string:
+		return sizeOfString + int64(len(v))
+	}
+	return sizeOfInterface
+}
+
+// sizeOfData estimates memory used by value, it samples at most `samples` elements of collections
+func sizeOfData(data interface{}, samples int) int64 {
+	switch val := data.(type) {
+	case []byte:
+		return sizeOfSlice + int64(cap(val))
+	case int64:
+		if val >= 0 && val < sharedIntegersLen {
+			return 0
+		}
+		return 8
+	case quicklist.List:
+		size := val.Len()
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		if limit > 0 {
+			for _, v := range val.Range(0, limit) {
+				sampled += sizeOfValue(v)
+			}
+		}
+		pages := (size + quicklistPageSize - 1) / quicklistPageSize
+		return int64(pages)*(6*sizeOfPointer+sizeOfSlice+quicklistPageSize*sizeOfInterface) + scale(sampled, limit, size)
+	case *list.List:
+		size := val.Len()
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		if limit > 0 {
+			for _, node := range val.Range(0, limit-1) {
+				sampled += 2*sizeOfPointer + sizeOfString + int64(len(node.GetValue()))
+			}
+		}
+		return 3*sizeOfPointer + scale(sampled, limit, size)
+	case *set.Set:
+		size := val.Len()
+		if val.Encoding() == "intset" {
+			return sizeOfSlice + int64(size)*8
+		}
+		perMember := int64(sizeOfString)
+		if val.Encoding() == "hashtable" {
+			perMember += sizeOfMapEntry
+		}
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		i := 0
+		val.ForEach(func(member string) bool {
+			if i >= limit {
+				return false
+			}
+			sampled += perMember + int64(len(member))
+			i++
+			return true
+		})
+		return sizeOfSlice + scale(sampled, limit, size)
+	case *SortedSet.SortedSet:
+		size := int(val.Len())
+		perMember := int64(sizeOfPointer + sizeOfString + 8)
+		if val.Encoding() == "skiplist" {
+			perMember = sizeOfSkiplistNode + sizeOfMapEntry + sizeOfPointer
+		}
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		if limit > 0 {
+			val.ForEach(0, int64(limit), false, func(element *SortedSet.Element) bool {
+				sampled += perMember + int64(len(element.Member))
+				return true
+			})
+		}
+		return sizeOfSlice + scale(sampled, limit, size)
+	case dict.Dict:
+		size := val.Len()
+		perEntry := int64(sizeOfString + sizeOfInterface)
+		if lp, ok := val.(*dict.ListpackDict); !ok || lp.Encoding() == "hashtable" {
+			perEntry += sizeOfMapEntry
+		}
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		i := 0
+		val.ForEach(func(key string, v interface{}) bool {
+			if i >= limit {
+				return false
+			}
+			sampled += perEntry + int64(len(key)) + sizeOfValue(v)
+			i++
+			return true
+		})
+		return sizeOfSlice + scale(sampled, limit, size)
+	case *graph.Graph:
+		// every node has 3 maps, every edge is stored in both ends
+		return int64(val.NodeCount())*(sizeOfString+sizeOfMapEntry+3*sizeOfMapEntry) +
+			int64(val.EdgeCount())*(2*sizeOfMapEntry+2*sizeOfString)
+	case *suggest.Trie:
+		size := val.Len()
+		limit := sampleLimit(size, samples)
+		var sampled int64
+		i := 0
+		val.ForEach(func(entry *suggest.Entry) bool {
+			if i >= limit {
+				return false
+			}
+			// every rune takes a trie node in the worst case
+			sampled += int64(len(entry.Value))*(sizeOfMapEntry+sizeOfPointer*2) +
+				sizeOfString + 8 + sizeOfSlice + int64(len(entry.Payload))
+			i++
+			return true
+		})
+		return scale(sampled, limit, size)
+	}
+	return 0
+}
+
+// sizeOfKey estimates memory used by key and its entity in db
+func sizeOfKey(key string) int64 {
+	return sizeOfMapEntry + sizeOfString + int64(len(key)) + sizeOfPointer + sizeOfEntity
+}
+
+// memoryUsage returns estimated memory used by key, returns false if key not exists
+func (db *DB) memoryUsage(key string, samples int) (int64, bool) {
+	db.RWLocks(nil, []string{key})
+	defer db.RWUnLocks(nil, []string{key})
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return 0, false
+	}
+	return sizeOfKey(key) + sizeOfData(entity.Data, samples), true
+}
+
+// overhead estimates memory used by dict of keys and ttl, excluding values
+func (db *DB) overhead() (main int64, expires int64) {
+	main = int64(db.data.Len()) * (sizeOfMapEntry + sizeOfString + sizeOfPointer + sizeOfEntity)
+	main += int64(db.versionMap.Len()) * (sizeOfMapEntry + sizeOfString + sizeOfInterface)
+	expires = int64(db.ttlMap.Len()) * (sizeOfMapEntry + sizeOfString + sizeOfInterface + sizeOfTime)
+	return
+}
+
+// execMemory reports memory usage
+// memory usage key [SAMPLES count]
+// memory stats
+func execMemory(server *Server, c slava.Connection, args [][]byte) slava.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("memory")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "usage":
+		if len(args) != 2 && len(args) != 4 {
+			return protocol.MakeArgNumErrReply("memory|usage")
+		}
+		samples := defaultMemorySamples
+		if len(args) == 4 {
+			if strings.ToLower(string(args[2])) != "samples" {
+				return &protocol.SyntaxErrReply{}
+			}
+			n, err := strconv.Atoi(string(args[3]))
+			if err != nil || n < 0 {
+				return protocol.MakeErrReply("ERR value is out of range, must be positive")
+			}
+			samples = n
+		}
+		db, errReply := server.selectDB(c.GetDBIndex())
+		if errReply != nil {
+			return errReply
+		}
+		usage, exists := db.memoryUsage(string(args[1]), samples)
+		if !exists {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeIntReply(usage)
+	case "stats":
+		return server.memoryStats()
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try MEMORY HELP.")
+}
+
+func (server *Server) memoryStats() slava.Reply {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	allocated := int64(memStats.HeapAlloc)
+
+	var overheadTotal, keyCount int64
+	var dbReplies []slava.Reply
+	for i := range server.dbSet {
+		db, _ := server.selectDB(i)
+		keys := int64(db.data.Len())
+		if keys == 0 {
+			continue
+		}
+		keyCount += keys
+		main, expires := db.overhead()
+		overheadTotal += main + expires
+		dbReplies = append(dbReplies,
+			protocol.MakeBulkReply([]byte("db."+strconv.Itoa(i))),
+			protocol.MakeMultiRawReply([]slava.Reply{
+				protocol.MakeBulkReply([]byte("overhead.hashtable.main")),
+				protocol.MakeIntReply(main),
+				protocol.MakeBulkReply([]byte("overhead.hashtable.expires")),
+				protocol.MakeIntReply(expires),
+			}))
+	}
+	dataset := allocated - overheadTotal
+	if dataset < 0 {
+		dataset = 0
+	}
+	var bytesPerKey, percentage int64
+	if keyCount > 0 {
+		bytesPerKey = allocated / keyCount
+	}
+	if allocated > 0 {
+		percentage = dataset * 100 / allocated
+	}
+
+	result := []slava.Reply{
+		protocol.MakeBulkReply([]byte("total.allocated")),
+		protocol.MakeIntReply(allocated),
+		protocol.MakeBulkReply([]byte("heap.sys")),
+		protocol.MakeIntReply(int64(memStats.HeapSys)),
+		protocol.MakeBulkReply([]byte("overhead.total")),
+		protocol.MakeIntReply(overheadTotal),
+	}
+	result = append(result, dbReplies...)
+	result = append(result,
+		protocol.MakeBulkReply([]byte("keys.count")),
+		protocol.MakeIntReply(keyCount),
+		protocol.MakeBulkReply([]byte("keys.bytes-per-key")),
+		protocol.MakeIntReply(bytesPerKey),
+		protocol.MakeBulkReply([]byte("dataset.bytes")),
+		protocol.MakeIntReply(dataset),
+		protocol.MakeBulkReply([]byte("dataset.percentage")),
+		protocol.MakeIntReply(percentage),
+	)
+	return protocol.MakeMultiRawReply(result)
+}
+
+// isShared returns whether the value is shared by many keys
+func isShared(entity *database.DataEntity) bool {
+	val, ok := entity.Data.(int64)
+	return ok && val >= 0 && val < sharedIntegersLen
+}
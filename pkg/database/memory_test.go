@@ -0,0 +1,18 @@
+package database
+
+import (
+	"testing"
+
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+)
+
+func TestSizeOfEmptyList(t *testing.T) {
+	// empty lists must not be sampled
+	if size := sizeOfData(quicklist.NewQuickList(), 5); size != 0 {
+		t.Errorf("expected no memory of empty quicklist, actual %d", size)
+	}
+	if size := sizeOfData(list.NewList(), 5); size <= 0 {
+		t.Errorf("expected memory of list header, actual %d", size)
+	}
+}
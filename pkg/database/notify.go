@@ -0,0 +1,174 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"slava/pkg/pubsub"
+)
+
+// classes of keyspace events, see notify-keyspace-events in redis.conf
+const (
+	notifyKeyspace = 1 << iota // K, publish to __keyspace@<db>__:<key>
+	notifyKeyevent             // E, publish to __keyevent@<db>__:<event>
+	notifyGeneric              // g, generic commands such as DEL, EXPIRE, RENAME
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x, key expired
+	notifyEvicted              // e, key evicted by maxmemory
+	notifyModule               // d, commands of graph and suggest
+	notifyAll      = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyModule // A
+)
+
+// parseNotifyFlags parses notify-keyspace-events such as "KEA" into classes of events
+func parseNotifyFlags(classes string) (int, error) {
+	flags := 0
+	for _, c := range classes {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'd':
+			flags |= notifyModule
+		case 'A':
+			flags |= notifyAll
+		default:
+			return 0, fmt.Errorf("unknown class of keyspace events '%c'", c)
+		}
+	}
+	return flags, nil
+}
+
+// keyEvent is a keyspace event waiting for the lock of its key to be released
+type keyEvent struct {
+	seq      uint64 // order of events raised by all dbs
+	notifier func(dbIndex int, class int, event string, key string)
+	dbIndex  int // index of db when the event is raised, swapdb may change it before publishing
+	class    int
+	event    string
+	key      string
+}
+
+var keyEventSeq uint64
+
+// keyEventQueue holds events of keys locked by running commands.
+// subscribers must not see a change before it is visible to other clients, nor a change rolled back by EXEC,
+// so events are queued by key and published after the command unlocks the key
+type keyEventQueue struct {
+	mu     sync.Mutex
+	size   int32 // number of queued events, unlocking keys skips the queue if it is 0
+	events map[string][]*keyEvent
+}
+
+// notify queues keyspace event of the given key, the caller should hold lock of the key.
+// the event is published after the key is unlocked by DB.RWUnLocks
+func (db *DB) notify(class int, event string, key string) {
+	if db.notifier == nil || (db.notifyEnabled != nil && !db.notifyEnabled(class)) {
+		return
+	}
+	ev := &keyEvent{
+		seq:      atomic.AddUint64(&keyEventSeq, 1),
+		notifier: db.notifier,
+		dbIndex:  db.getIndex(),
+		class:    class,
+		event:    event,
+		key:      key,
+	}
+	q := &db.pendingEvents
+	q.mu.Lock()
+	if q.events == nil {
+		q.events = make(map[string][]*keyEvent)
+	}
+	q.events[key] = append(q.events[key], ev)
+	q.mu.Unlock()
+	atomic.AddInt32(&q.size, 1)
+}
+
+// takeEvents removes and returns queued events of the given keys, the caller should hold lock of the keys
+func (db *DB) takeEvents(writeKeys []string, readKeys []string) []*keyEvent {
+	q := &db.pendingEvents
+	if atomic.LoadInt32(&q.size) == 0 {
+		return nil
+	}
+	var events []*keyEvent
+	q.mu.Lock()
+	for _, keys := range [][]string{writeKeys, readKeys} {
+		for _, key := range keys {
+			if list, ok := q.events[key]; ok {
+				events = append(events, list...)
+				delete(q.events, key)
+			}
+		}
+	}
+	q.mu.Unlock()
+	atomic.AddInt32(&q.size, -int32(len(events)))
+	return events
+}
+
+// rwUnLocksTakeEvents unlocks keys and returns their queued events,
+// so that events of several dbs unlocked together can be published in order by publishEvents
+func (db *DB) rwUnLocksTakeEvents(writeKeys []string, readKeys []string) []*keyEvent {
+	events := db.takeEvents(writeKeys, readKeys)
+	db.locker.RWUnLocks(writeKeys, readKeys)
+	return events
+}
+
+// publishEvents publishes events in the order they were raised, the caller should not hold any lock
+func publishEvents(events []*keyEvent) {
+	if len(events) > 1 {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].seq < events[j].seq
+		})
+	}
+	for _, ev := range events {
+		ev.notifier(ev.dbIndex, ev.class, ev.event, ev.key)
+	}
+}
+
+// keyspaceEventEnabled returns false if events of the class are disabled or no one subscribes anything
+func (server *Server) keyspaceEventEnabled(class int) bool {
+	flags := server.notifyFlags
+	return flags&class != 0 && flags&(notifyKeyspace|notifyKeyevent) != 0 && !server.hub.IsEmpty()
+}
+
+// notifyKeyspaceEvent publishes event to keyspace channel and keyevent channel
+// it returns immediately if the class is not enabled or no one subscribes anything
+func (server *Server) notifyKeyspaceEvent(dbIndex int, class int, event string, key string) {
+	if !server.keyspaceEventEnabled(class) {
+		return
+	}
+	flags := server.notifyFlags
+	prefix := "@" + strconv.Itoa(dbIndex) + "__:"
+	if flags&notifyKeyspace != 0 {
+		channel := "__keyspace" + prefix + key
+		pubsub.Publish(server.hub, [][]byte{[]byte(channel), []byte(event)})
+	}
+	if flags&notifyKeyevent != 0 {
+		channel := "__keyevent" + prefix + event
+		pubsub.Publish(server.hub, [][]byte{[]byte(channel), []byte(key)})
+	}
+}
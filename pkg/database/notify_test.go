@@ -0,0 +1,114 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func makeMessage(channel string, msg string) []byte {
+	return protocol.MakeMultiBulkReply(utils.ToCmdLine("message", channel, msg)).ToBytes()
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+	server := NewStandaloneServer()
+	flags, err := parseNotifyFlags("KEA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.notifyFlags = flags
+	conn := connection.NewFakeConn()
+	sub := connection.NewFakeConn()
+	server.Exec(sub, utils.ToCmdLine("subscribe", "__keyspace@0__:K1", "__keyevent@0__:del", "__keyevent@0__:expired"))
+	sub.Clean()
+
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("del", "K1", "K2"))
+	server.Exec(conn, utils.ToCmdLine("set", "K2", "V2", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	server.Exec(conn, utils.ToCmdLine("get", "K2"))
+
+	var expected []byte
+	expected = append(expected, makeMessage("__keyspace@0__:K1", "set")...)
+	expected = append(expected, makeMessage("__keyspace@0__:K1", "del")...)
+	expected = append(expected, makeMessage("__keyevent@0__:del", "K1")...)
+	expected = append(expected, makeMessage("__keyevent@0__:expired", "K2")...)
+	if string(sub.Bytes()) != string(expected) {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+
+	// disabled classes should not be published
+	server.notifyFlags, _ = parseNotifyFlags("Kl")
+	sub.Clean()
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("del", "K1"))
+	if len(sub.Bytes()) != 0 {
+		t.Errorf("expected no notification, actual %q", sub.Bytes())
+	}
+	server.notifyFlags = 0
+
+	if _, err := parseNotifyFlags("KEy"); err == nil {
+		t.Errorf("unknown class should be rejected")
+	}
+}
+
+func TestKeyspaceNotificationAfterUnlock(t *testing.T) {
+	server := NewStandaloneServer()
+	server.notifyFlags, _ = parseNotifyFlags("KEA")
+	db := server.mustSelectDB(0)
+	db.notifier = func(dbIndex int, class int, event string, key string) {
+		// event is published after the key is unlocked, so it can be locked by others
+		done := make(chan struct{})
+		go func() {
+			db.locker.RWLocks([]string{key}, nil)
+			db.locker.RWUnLocks([]string{key}, nil)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("event %s of %s is published while the key is locked", event, key)
+		}
+		server.notifyKeyspaceEvent(dbIndex, class, event, key)
+	}
+	conn := connection.NewFakeConn()
+	sub := connection.NewFakeConn()
+	server.Exec(sub, utils.ToCmdLine("subscribe", "__keyspace@0__:K1", "__keyspace@1__:K1"))
+	sub.Clean()
+
+	// events of a rolled back transaction are dropped
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("del", "K1"))
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V2"))
+	server.Exec(conn, utils.ToCmdLine("incr", "K1"))
+	reply := server.Exec(conn, utils.ToCmdLine("exec"))
+	if !protocol.IsErrorReply(reply) {
+		t.Fatalf("expected exec abort, actual %s", reply.ToBytes())
+	}
+	expected := makeMessage("__keyspace@0__:K1", "set")
+	if string(sub.Bytes()) != string(expected) {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+
+	// events of a committed transaction are published in order
+	sub.Clean()
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V2"))
+	server.Exec(conn, utils.ToCmdLine("move", "K1", "1"))
+	server.Exec(conn, utils.ToCmdLine("exec"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("move", "K1", "0"))
+	var expectedBytes []byte
+	expectedBytes = append(expectedBytes, makeMessage("__keyspace@0__:K1", "set")...)
+	expectedBytes = append(expectedBytes, makeMessage("__keyspace@0__:K1", "move_from")...)
+	expectedBytes = append(expectedBytes, makeMessage("__keyspace@1__:K1", "move_to")...)
+	expectedBytes = append(expectedBytes, makeMessage("__keyspace@1__:K1", "move_from")...)
+	expectedBytes = append(expectedBytes, makeMessage("__keyspace@0__:K1", "move_to")...)
+	if string(sub.Bytes()) != string(expectedBytes) {
+		t.Errorf("expected %q, actual %q", expectedBytes, sub.Bytes())
+	}
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"slava/config"
+	"slava/internal/aof"
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
+	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
+)
+
+// makeHash creates a hash in listpack encoding according to hash-max-listpack-* config
+func makeHash() dict.Dict {
+	return dict.MakeListpack(config.Properties.HashMaxListpackEntries, config.Properties.HashMaxListpackValue)
+}
+
+// makeSet creates a set in intset encoding according to set-max-* config
+func makeSet(members ...string) *set.Set {
+	return set.MakeCompact(set.Limits{
+		MaxIntsetEntries:   config.Properties.SetMaxIntsetEntries,
+		MaxListpackEntries: config.Properties.SetMaxListpackEntries,
+		MaxListpackValue:   config.Properties.SetMaxListpackValue,
+	}, members...)
+}
+
+// makeSortedSet creates a sorted set in listpack encoding according to zset-max-listpack-* config
+func makeSortedSet() *SortedSet.SortedSet {
+	return SortedSet.MakeCompact(config.Properties.ZSetMaxListpackEntries, config.Properties.ZSetMaxListpackValue)
+}
+
+// encodingOf returns internal encoding of value as OBJECT ENCODING does
+func encodingOf(data interface{}) string {
+	switch val := data.(type) {
+	case []byte, int64:
+		return stringEncoding(val)
+	case quicklist.List:
+		return "quicklist"
+	case *list.List:
+		return "linkedlist"
+	case *set.Set:
+		return val.Encoding()
+	case *SortedSet.SortedSet:
+		return val.Encoding()
+	case *dict.ListpackDict:
+		return val.Encoding()
+	case dict.Dict:
+		return "hashtable"
+	case *graph.Graph:
+		return "graph"
+	case *suggest.Trie:
+		return "trie"
+	}
+	return "unknown"
+}
+
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// execObject inspects internal of value
+// object encoding|idletime|freq|refcount key
+func execObject(db *DB, args [][]byte) slava.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd == "help" {
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("ENCODING <key>"),
+			[]byte("    Return the kind of internal representation used in order to store the value associated with a <key>."),
+			[]byte("FREQ <key>"),
+			[]byte("    Return the access frequency index of the <key>."),
+			[]byte("IDLETIME <key>"),
+			[]byte("    Return the idle time of the <key>, that is the approximated number of seconds elapsed since the last access to the key."),
+			[]byte("REFCOUNT <key>"),
+			[]byte("    Return the number of references of the value associated with the specified <key>."),
+		})
+	}
+	if subCmd != "encoding" && subCmd != "idletime" && subCmd != "freq" && subCmd != "refcount" {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("object|" + subCmd)
+	}
+	entity, exists := db.peekEntity(string(args[1]))
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	switch subCmd {
+	case "encoding":
+		return protocol.MakeBulkReply([]byte(encodingOf(entity.Data)))
+	case "idletime":
+		return protocol.MakeIntReply(idleTime(entity))
+	case "freq":
+		return protocol.MakeIntReply(int64(lfuFreq(entity)))
+	default: // refcount
+		if isShared(entity) {
+			return protocol.MakeIntReply(math.MaxInt32)
+		}
+		return protocol.MakeIntReply(1)
+	}
+}
+
+// idleTime returns seconds since last access of entity
+func idleTime(entity *database.DataEntity) int64 {
+	idle := int64(lruClock()) - int64(atomic.LoadInt32(&entity.Lru))
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+// execDebug implements debug object key
+func execDebug(db *DB, args [][]byte) slava.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd != "object" {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try DEBUG HELP.")
+	}
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("debug|" + subCmd)
+	}
+	key := string(args[1])
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	refCount := 1
+	if isShared(entity) {
+		refCount = math.MaxInt32
+	}
+	// serializedlength is the size of the command rebuilding the key in aof
+	var serializedLength int
+	if cmd := aof.EntityToCmd(key, entity); cmd != nil {
+		serializedLength = len(cmd.ToBytes())
+	}
+	return protocol.MakeStatusReply(fmt.Sprintf("Value at:%p refcount:%d encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d",
+		entity, refCount, encodingOf(entity.Data), serializedLength, atomic.LoadInt32(&entity.Lru), idleTime(entity)))
+}
+
+func init() {
+	RegisterCommand("Object", execObject, prepareObject, nil, -2, flagReadOnly).keys(2, 2, 1).category("@keyspace")
+	RegisterCommand("Debug", execDebug, prepareObject, nil, -2, flagReadOnly).keys(2, 2, 1).category("@keyspace", "@admin", "@dangerous")
+}
@@ -3,20 +3,27 @@ package database
 import (
 	"fmt"
 	"os"
-	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/list"
 
 	"github.com/hdt3213/rdb/core"
 	rdb "github.com/hdt3213/rdb/parser"
 	"slava/config"
 	"slava/internal/aof"
 	"slava/internal/interface/database"
-	"slava/pkg/datastruct/dict"
-	SortedSet "slava/pkg/datastruct/sortedset"
+	slavaRdb "slava/internal/rdb"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/suggest"
+	"slava/pkg/logger"
+	"strings"
 	"sync/atomic"
 )
 
+// loadRdbFile loads the rdb file written by SAVE, BGSAVE and save rules, it is fine if nothing has been saved
 func (server *Server) loadRdbFile() error {
-	rdbFile, err := os.Open(config.Properties.RDBFilename)
+	rdbFile, err := os.Open(rdbFilename())
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("open rdb file failed " + err.Error())
 	}
@@ -31,29 +38,50 @@ func (server *Server) loadRdbFile() error {
 	return nil
 }
 
+// LoadRDB puts data decoded from rdb into databases, such as rdb preamble of aof
+func (server *Server) LoadRDB(dec *core.Decoder) error {
+	return server.loadRDB(dec)
+}
+
 func (server *Server) loadRDB(dec *core.Decoder) error {
-	return dec.Parse(func(o rdb.RedisObject) bool {
+	return dec.WithSpecialOpCode().Parse(func(o rdb.RedisObject) bool {
+		switch o.GetType() {
+		case rdb.AuxType:
+			server.loadAux(o.(*rdb.AuxObject))
+			return true
+		case rdb.DBSizeType:
+			return true
+		}
 		db := server.mustSelectDB(o.GetDBIndex())
 		var entity *database.DataEntity
 		switch o.GetType() {
 		case rdb.StringType:
 			str := o.(*rdb.StringObject)
 			entity = &database.DataEntity{
-				Data: str.Value,
+				Data: makeStringData(str.Value),
 			}
 		case rdb.ListType:
 			listObj := o.(*rdb.ListObject)
-			list := quicklist.NewQuickList()
+			// list commands work on linked list
+			l := list.NewList()
 			for _, v := range listObj.Values {
-				list.Add(v)
+				l.RPush(string(v))
 			}
 			entity = &database.DataEntity{
-				Data: list,
+				Data: l,
+			}
+		case rdb.SetType:
+			setObj := o.(*rdb.SetObject)
+			s := makeSet()
+			for _, member := range setObj.Members {
+				s.Add(string(member))
+			}
+			entity = &database.DataEntity{
+				Data: s,
 			}
 		case rdb.HashType:
 			hashObj := o.(*rdb.HashObject)
-			// Todo make concurrent的默认值
-			hash := dict.MakeConcurrent(4)
+			hash := makeHash()
 			for k, v := range hashObj.Hash {
 				hash.Put(k, v)
 			}
@@ -62,7 +90,7 @@ func (server *Server) loadRDB(dec *core.Decoder) error {
 			}
 		case rdb.ZSetType:
 			zsetObj := o.(*rdb.ZSetObject)
-			zSet := SortedSet.Make()
+			zSet := makeSortedSet()
 			for _, e := range zsetObj.Entries {
 				zSet.Add(e.Member, e.Score)
 			}
@@ -81,28 +109,101 @@ func (server *Server) loadRDB(dec *core.Decoder) error {
 	})
 }
 
-func NewPersister(db database.DBEngine, filename string, load bool, fsync string) (*aof.Persister, error) {
-	return aof.NewPersister(db, filename, load, fsync, func() database.DBEngine {
-		return MakeAuxiliaryServer()
-	})
+// loadAux loads values saved in aux fields by slava modules, other aux fields are skipped
+func (server *Server) loadAux(aux *rdb.AuxObject) {
+	switch aux.Key {
+	case slavaRdb.AuxGraph, slavaRdb.AuxSuggest, slavaRdb.AuxTopic:
+	default:
+		return
+	}
+	value, err := slavaRdb.UnmarshalModuleValue(aux.Value)
+	if err != nil {
+		logger.Warn("load " + aux.Key + " failed: " + err.Error())
+		return
+	}
+	if aux.Key == slavaRdb.AuxTopic {
+		server.loadTopic(value)
+		return
+	}
+	db, errReply := server.selectDB(value.DBIndex)
+	if errReply != nil {
+		logger.Warn("load " + aux.Key + " failed: " + errReply.Error())
+		return
+	}
+	entity := &database.DataEntity{}
+	switch aux.Key {
+	case slavaRdb.AuxGraph:
+		g, err := graph.Unmarshal(value.Payload)
+		if err != nil {
+			logger.Warn("load graph failed: " + err.Error())
+			return
+		}
+		entity.Data = g
+	case slavaRdb.AuxSuggest:
+		trie, err := suggest.Unmarshal(value.Payload)
+		if err != nil {
+			logger.Warn("load suggestion dictionary failed: " + err.Error())
+			return
+		}
+		entity.Data = trie
+	}
+	db.PutEntity(value.Key, entity)
+	if value.Expiration != nil {
+		db.Expire(value.Key, *value.Expiration)
+	}
+	db.AddAof(aof.EntityToCmd(value.Key, entity).Args)
+}
+
+// loadTopic puts retained log of durable topic into topicLogs
+func (server *Server) loadTopic(value *slavaRdb.ModuleValue) {
+	entries, err := slavaRdb.UnmarshalTopicLog(value.Payload)
+	if err != nil {
+		logger.Warn("load topic " + value.Key + " failed: " + err.Error())
+		return
+	}
+	server.appendTopicEntries(value.Key, entries)
+	server.AddAof(0, aof.TopicToCmd(value.Key, entries).Args)
+}
+
+func NewPersister(db database.DBEngine, dirname string, filename string, load bool, fsync string) (*aof.Persister, error) {
+	return aof.NewPersister(db, dirname, filename, load, fsync)
 }
 
+// AddAof propagates a change of data, it is counted as dirty for save rules and written into aof if appendonly.
+// every key written by cmdLine is a change, e.g. MSET of 100 keys is 100 changes
 func (server *Server) AddAof(dbIndex int, cmdLine aof.CmdLine) {
-	if server.persister != nil {
+	atomic.AddInt64(&server.dirty, changesOf(cmdLine))
+	if server.persister != nil && config.Properties.AppendOnly { // config may be changed during runtime
 		server.persister.SaveCmdLine(dbIndex, cmdLine)
 	}
 }
 
+// changesOf returns number of keys written by cmdLine, command without keys like SWAPDB is one change
+func changesOf(cmdLine aof.CmdLine) int64 {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return 1
+	}
+	write, _ := cmd.prepare(cmdLine[1:])
+	if len(write) == 0 {
+		return 1
+	}
+	return int64(len(write))
+}
+
+// bindAddAof makes changes of db propagated by server, see Server.AddAof
+func (server *Server) bindAddAof(singleDB *DB) {
+	singleDB.AddAof = func(line aof.CmdLine) {
+		// index of db is read at runtime since SWAPDB changes it
+		server.AddAof(singleDB.getIndex(), line)
+	}
+}
+
 func (server *Server) bindPersister(aofHandler *aof.Persister) {
 	server.persister = aofHandler
 	// bind SaveCmdLine
 	for _, db := range server.dbSet {
-		singleDB := db.Load().(*DB)
-		singleDB.AddAof = func(line aof.CmdLine) {
-			if config.Properties.AppendOnly { // config may be changed during runtime
-				server.persister.SaveCmdLine(singleDB.index, line)
-			}
-		}
+		server.bindAddAof(db.Load().(*DB))
 	}
 }
 
@@ -116,5 +217,8 @@ func MakeAuxiliaryServer() *Server {
 		holder.Store(makeDB())
 		mdb.dbSet[i] = holder
 	}
+	// retained logs are loaded as they are
+	mdb.topics = &topicConfig{}
+	mdb.topicLogs.Store(makeDB())
 	return mdb
 }
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"slava/config"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func setupAofConfig(t *testing.T, dir string, filename string) {
+	backup := *config.Properties
+	t.Cleanup(func() {
+		*config.Properties = backup
+	})
+	config.Properties.AppendOnly = true
+	config.Properties.AppendDirname = filepath.Join(dir, "appendonlydir")
+	config.Properties.AppendFilename = filename
+	config.Properties.AppendFsync = "always"
+}
+
+func readManifest(t *testing.T) string {
+	data, err := ioutil.ReadFile(filepath.Join(config.Properties.AppendDirname, "appendonly.aof.manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMultiPartAof(t *testing.T) {
+	setupAofConfig(t, t.TempDir(), "appendonly.aof")
+	config.Properties.AofUseRdbPreamble = false
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "b", "2"))
+	if manifest := readManifest(t); manifest != "file appendonly.aof.1.incr.aof seq 1 type i\n" {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+	if reply := server.Exec(conn, utils.ToCmdLine("rewriteaof")); !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	server.Exec(conn, utils.ToCmdLine("set", "c", "3"))
+	expected := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+	if manifest := readManifest(t); manifest != expected {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+	if _, err := os.Stat(filepath.Join(config.Properties.AppendDirname, "appendonly.aof.1.incr.aof")); !os.IsNotExist(err) {
+		t.Errorf("expected old incr file removed")
+	}
+	server.Close()
+
+	loaded := NewStandaloneServer()
+	defer loaded.Close()
+	loadedConn := connection.NewFakeConn()
+	loadedConn.SelectDB(1)
+	for key, value := range map[string]string{"b": "2", "c": "3"} {
+		reply := loaded.Exec(loadedConn, utils.ToCmdLine("get", key))
+		if string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(value)).ToBytes()) {
+			t.Errorf("expected %s of %s, actual %s", value, key, reply.ToBytes())
+		}
+	}
+}
+
+func TestFlushDBAof(t *testing.T) {
+	setupAofConfig(t, t.TempDir(), "appendonly.aof")
+	config.Properties.AofUseRdbPreamble = false
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "b", "2"))
+	server.Exec(conn, utils.ToCmdLine("flushdb", "async"))
+	server.Close()
+
+	loaded := NewStandaloneServer()
+	defer loaded.Close()
+	if size, _ := loaded.GetDBSize(1); size != 0 {
+		t.Errorf("expected db 1 flushed after loading, actual %d keys", size)
+	}
+	if size, _ := loaded.GetDBSize(0); size != 1 {
+		t.Errorf("expected db 0 kept, actual %d keys", size)
+	}
+}
+
+func TestUpgradeLegacyAof(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "appendonly.aof")
+	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("set", "a", "1")).ToBytes()
+	if err := ioutil.WriteFile(legacy, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	setupAofConfig(t, dir, legacy)
+	server := NewStandaloneServer()
+	defer server.Close()
+	reply := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("get", "a"))
+	if string(reply.ToBytes()) != "$1\r\n1\r\n" {
+		t.Errorf("expected data of legacy aof loaded, actual %s", reply.ToBytes())
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("expected legacy aof moved into aof dir")
+	}
+	if manifest := readManifest(t); !strings.HasPrefix(manifest, "file appendonly.aof seq 1 type b\n") {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+}
+
+func TestAofRdbPreamble(t *testing.T) {
+	setupAofConfig(t, t.TempDir(), "appendonly.aof")
+	config.Properties.AofUseRdbPreamble = true
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("rpush", "list", "x", "y"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "b", "2", "ex", "1000"))
+	if reply := server.Exec(conn, utils.ToCmdLine("rewriteaof")); !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	server.Exec(conn, utils.ToCmdLine("set", "c", "3"))
+	expected := "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+	if manifest := readManifest(t); manifest != expected {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+	base, err := ioutil.ReadFile(filepath.Join(config.Properties.AppendDirname, "appendonly.aof.1.base.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(base), "REDIS") {
+		t.Errorf("expected base file in rdb format")
+	}
+	server.Close()
+
+	loaded := NewStandaloneServer()
+	loadedConn := connection.NewFakeConn()
+	if size := loaded.Exec(loadedConn, utils.ToCmdLine("llen", "list")).(*protocol.IntReply).Code; size != 2 {
+		t.Errorf("expected 2 elements in list, actual %d", size)
+	}
+	loadedConn.SelectDB(1)
+	for key, value := range map[string]string{"b": "2", "c": "3"} {
+		reply := loaded.Exec(loadedConn, utils.ToCmdLine("get", key))
+		if string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(value)).ToBytes()) {
+			t.Errorf("expected %s of %s, actual %s", value, key, reply.ToBytes())
+		}
+	}
+	if ttl := loaded.Exec(loadedConn, utils.ToCmdLine("ttl", "b")).(*protocol.IntReply).Code; ttl <= 0 || ttl > 1000 {
+		t.Errorf("expected ttl of b loaded, actual %d", ttl)
+	}
+	loaded.Close()
+
+	// commands may follow rdb preamble in the same file, like aof rewritten by redis before 7.0
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "appendonly.aof")
+	data := append(base, protocol.MakeMultiBulkReply(utils.ToCmdLine("set", "d", "4")).ToBytes()...)
+	if err := ioutil.WriteFile(legacy, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	setupAofConfig(t, dir, legacy)
+	server = NewStandaloneServer()
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	for key, value := range map[string]string{"a": "1", "d": "4"} {
+		reply := server.Exec(conn, utils.ToCmdLine("get", key))
+		if string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(value)).ToBytes()) {
+			t.Errorf("expected %s of %s, actual %s", value, key, reply.ToBytes())
+		}
+	}
+}
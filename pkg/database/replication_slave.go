@@ -5,9 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"os"
 	"slava/internal/aof"
 	"strconv"
 	"strings"
@@ -17,11 +15,13 @@ import (
 
 	rdb "github.com/hdt3213/rdb/parser"
 	"slava/config"
+	"slava/internal/interface/database"
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
 	"slava/internal/slava/parser"
 	"slava/internal/utils"
 	"slava/pkg/connection"
+	"slava/pkg/datastruct/list"
 	"slava/pkg/logger"
 )
 
@@ -306,25 +306,6 @@ func (server *Server) psyncHandshake() (bool, error) {
 	return isFullReSync, nil
 }
 
-func makeRdbLoader(upgradeAof bool) (*Server, string, error) {
-	rdbLoader := MakeAuxiliaryServer()
-	if !upgradeAof {
-		return rdbLoader, "", nil
-	}
-	// make aof handler to generate new aof file during loading rdb
-	newAofFile, err := ioutil.TempFile("", "*.aof")
-	if err != nil {
-		return nil, "", fmt.Errorf("create temp rdb failed: %v", err)
-	}
-	newAofFilename := newAofFile.Name()
-	aofHandler, err := NewPersister(rdbLoader, newAofFilename, false, aof.FsyncNo)
-	if err != nil {
-		return nil, "", err
-	}
-	rdbLoader.bindPersister(aofHandler)
-	return rdbLoader, newAofFilename, nil
-}
-
 // loadMasterRDB downloads rdb after handshake has been done
 func (server *Server) loadMasterRDB(configVersion int32) error {
 	rdbPayload := <-server.slaveStatus.masterChan
@@ -339,11 +320,8 @@ func (server *Server) loadMasterRDB(configVersion int32) error {
 	logger.Info(fmt.Sprintf("receive %d bytes of rdb from master", len(rdbReply.Arg)))
 	rdbDec := rdb.NewDecoder(bytes.NewReader(rdbReply.Arg))
 
-	rdbLoader, newAofFilename, err := makeRdbLoader(config.Properties.AppendOnly)
-	if err != nil {
-		return err
-	}
-	err = rdbLoader.loadRDB(rdbDec)
+	rdbLoader := MakeAuxiliaryServer()
+	err := rdbLoader.loadRDB(rdbDec)
 	if err != nil {
 		return errors.New("dump rdb failed: " + err.Error())
 	}
@@ -354,28 +332,35 @@ func (server *Server) loadMasterRDB(configVersion int32) error {
 		// slaveStatus conf changed during connecting and waiting mutex
 		return configChangedErr
 	}
-	for i, h := range rdbLoader.dbSet {
-		newDB := h.Load().(*DB)
-		server.loadDB(i, newDB)
-	}
+	server.loadAuxiliary(rdbLoader)
 
-	if config.Properties.AppendOnly {
-		// use new aof file
-		server.persister.Close()
-		err = os.Rename(newAofFilename, config.Properties.AppendFilename)
-		if err != nil {
-			return err
-		}
-		persister, err := NewPersister(server, config.Properties.AppendFilename, false, config.Properties.AppendFsync)
+	if server.persister != nil {
+		// aof files hold data before full sync, rewrite them with data from master
+		err = server.persister.Rewrite()
 		if err != nil {
 			return err
 		}
-		server.bindPersister(persister)
 	}
 
 	return nil
 }
 
+// loadAuxiliary replaces all data including retained logs of durable topics with data loaded by aux
+func (server *Server) loadAuxiliary(aux *Server) {
+	for i, h := range aux.dbSet {
+		newDB := h.Load().(*DB)
+		server.loadDB(i, newDB)
+	}
+	server.topicLogs.Store(aux.topicDB())
+	// auxiliary server has no id generator
+	server.topicDB().ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if log, ok := entity.Data.(*list.List); ok && log.Len() > 0 {
+			server.seedOffset(log.GetByIndex(log.Len() - 1).GetValue())
+		}
+		return true
+	})
+}
+
 func (server *Server) receiveAOF(ctx context.Context, configVersion int32) error {
 	conn := connection.NewConn(server.slaveStatus.masterConn)
 	conn.SetMaster()
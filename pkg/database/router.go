@@ -2,37 +2,103 @@ package database
 
 import (
 	"strings"
-
-	. "slava/internal/data"
 )
 
 var cmdTable = make(map[string]*command)
 
 type command struct {
+	name     string
 	executor ExecFunc // 执行的函数
 	prepare  PreFunc
 	undo     UndoFunc
 	arity    int // 表示输入的参数个数
-	flags    int // 指示该只读还是写函数
+	flags    int // 标记的位掩码, 指示该只读还是写函数等, 零值表示没有任何标记
+
+	// key spec, 描述参数中key的位置, 供 COMMAND INFO 和 COMMAND GETKEYS 使用
+	// firstKey 为 0 表示没有 key, lastKey 为负数表示从末尾倒数
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// categories 是 ACL 分类, 比如 @string, @write
+	categories []string
 }
 
+// flags of command, they are bits and could be combined, e.g. flagWrite|flagDenyOOM
 const (
-	flagWrite = iota
+	flagWrite = 1 << iota
 	flagReadOnly
+	// flagDenyOOM 表示命令可能占用更多内存, 超过 maxmemory 且无法淘汰时拒绝执行
+	flagDenyOOM
+	// 以下标记只用于由 Server 直接处理的命令
+	flagAdmin
+	flagPubSub
+	flagFast
 )
 
+// flagTable lists flags in the order of COMMAND INFO with their names and ACL categories
+var flagTable = []struct {
+	flag       int
+	name       string
+	categories []string
+}{
+	{flagWrite, "write", []string{"@write"}},
+	{flagReadOnly, "readonly", []string{"@read"}},
+	{flagDenyOOM, "denyoom", nil},
+	{flagAdmin, "admin", []string{"@admin", "@dangerous"}},
+	{flagPubSub, "pubsub", []string{"@pubsub"}},
+	{flagFast, "fast", []string{"@fast"}},
+}
+
 // 注册函数
 // 将命令注册到对应的cmdTable中
+// 默认第一个参数是 key, 可以通过 keys 修改
 
-func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int, flags int) {
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int, flags int) *command {
 	name = strings.ToLower(name)
-	cmdTable[name] = &command{
-		executor: executor,
-		prepare:  prepare,
-		undo:     rollback,
-		arity:    arity,
-		flags:    flags,
+	cmd := &command{
+		name:       name,
+		executor:   executor,
+		prepare:    prepare,
+		undo:       rollback,
+		arity:      arity,
+		flags:      flags,
+		firstKey:   1,
+		lastKey:    1,
+		keyStep:    1,
+		categories: flagCategories(flags),
 	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+// keys sets key spec of command
+func (cmd *command) keys(firstKey int, lastKey int, keyStep int) *command {
+	cmd.firstKey = firstKey
+	cmd.lastKey = lastKey
+	cmd.keyStep = keyStep
+	return cmd
+}
+
+// category appends ACL categories of command
+func (cmd *command) category(categories ...string) *command {
+	cmd.categories = append(cmd.categories, categories...)
+	return cmd
+}
+
+func flagCategories(flags int) []string {
+	var categories []string
+	for _, f := range flagTable {
+		if flags&f.flag != 0 {
+			categories = append(categories, f.categories...)
+		}
+	}
+	return categories
+}
+
+// isInternalCommand returns whether the command is generated by slava itself such as _graph.restore,
+// clients could not issue them
+func isInternalCommand(name string) bool {
+	return strings.HasPrefix(name, "_")
 }
 
 func isReadOnlyCommand(name string) bool {
@@ -41,5 +107,5 @@ func isReadOnlyCommand(name string) bool {
 	if cmd == nil {
 		return false
 	}
-	return cmd.flags == FlagReadOnly
+	return cmd.flags&flagReadOnly != 0
 }
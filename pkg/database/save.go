@@ -0,0 +1,125 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"slava/config"
+	"slava/internal/rdb"
+	"slava/pkg/logger"
+)
+
+// rdb 持久化独立于 aof: SAVE, BGSAVE 和 save 规则都通过 rdb.Saver 保存快照
+// dirty 记录上次保存以来的修改次数, 传播到 aof 的命令写入的每个 key 计为一次修改, 无论是否开启 aof,
+// FLUSHDB 和 FLUSHALL 删除的每个 key 也计为一次修改
+const (
+	// saveCronHz is how many times save rules are checked per second
+	saveCronHz = 10
+	// saveRetryDelay is seconds to wait before automatic saving retries after a failure
+	saveRetryDelay = 5
+)
+
+func rdbFilename() string {
+	if config.Properties.RDBFilename == "" {
+		return "dump.rdb"
+	}
+	return config.Properties.RDBFilename
+}
+
+// saveRDB saves rdb in foreground or background, changes before the snapshot are not dirty any more once saved
+func (server *Server) saveRDB(background bool) error {
+	var dirty int64
+	hook := func() {
+		dirty = atomic.LoadInt64(&server.dirty)
+	}
+	if !background {
+		err := server.rdbSaver.Save(rdbFilename(), hook)
+		if err == nil {
+			atomic.AddInt64(&server.dirty, -dirty)
+		}
+		return err
+	}
+	return server.rdbSaver.BGSave(rdbFilename(), hook, func(err error) {
+		if err != nil {
+			logger.Error("background saving failed: " + err.Error())
+			return
+		}
+		atomic.AddInt64(&server.dirty, -dirty)
+		logger.Info("background saving terminated with success")
+	})
+}
+
+func (server *Server) startSaveCron() {
+	if len(server.saveParams) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second / saveCronHz)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				server.saveIfNeeded(time.Now())
+			case <-server.closed:
+				return
+			}
+		}
+	}()
+}
+
+// saveIfNeeded starts background saving if any save rule is satisfied
+func (server *Server) saveIfNeeded(now time.Time) {
+	saver := server.rdbSaver
+	if saver.InProgress() {
+		return
+	}
+	// do not retry too often after a failure
+	if saver.LastFailed() && now.Unix()-saver.LastTry() <= saveRetryDelay {
+		return
+	}
+	dirty := atomic.LoadInt64(&server.dirty)
+	elapsed := now.Unix() - saver.LastSave()
+	for _, param := range server.saveParams {
+		if dirty >= int64(param.Changes) && elapsed >= int64(param.Seconds) {
+			logger.Info(fmt.Sprintf("%d changes in %d seconds. Saving...", param.Changes, param.Seconds))
+			if err := server.saveRDB(true); err != nil && err != rdb.ErrSaveInProgress {
+				logger.Error("background saving failed: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// saveOnClose saves rdb before server closes if save rules are configured like redis does on shutdown
+func (server *Server) saveOnClose() {
+	if len(server.saveParams) == 0 || server.rdbSaver == nil {
+		return
+	}
+	if err := server.saveRDB(false); err != nil {
+		logger.Error("saving on close failed: " + err.Error())
+	}
+}
+
+func (server *Server) infoPersistence(builder *strings.Builder) {
+	saver := server.rdbSaver
+	writeInfoField(builder, "rdb_changes_since_last_save", strconv.FormatInt(atomic.LoadInt64(&server.dirty), 10))
+	writeInfoField(builder, "rdb_bgsave_in_progress", boolToInfo(saver.InProgress()))
+	writeInfoField(builder, "rdb_last_save_time", strconv.FormatInt(saver.LastSave(), 10))
+	status := "ok"
+	if saver.LastFailed() {
+		status = "err"
+	}
+	writeInfoField(builder, "rdb_last_bgsave_status", status)
+	writeInfoField(builder, "aof_enabled", boolToInfo(config.Properties.AppendOnly))
+	writeInfoField(builder, "aof_rewrite_in_progress", boolToInfo(server.persister != nil && server.persister.RewriteInProgress()))
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"slava/config"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/suggest"
+)
+
+func TestParseSaveParams(t *testing.T) {
+	params, err := config.ParseSaveParams("900 1 300 10")
+	if err != nil || len(params) != 2 || params[1] != (config.SaveParam{Seconds: 300, Changes: 10}) {
+		t.Errorf("unexpected save params %v, %v", params, err)
+	}
+	if params, err := config.ParseSaveParams(`""`); err != nil || len(params) != 0 {
+		t.Errorf("expected no save params")
+	}
+	for _, raw := range []string{"900", "900 x", "0 1"} {
+		if _, err := config.ParseSaveParams(raw); err == nil {
+			t.Errorf("expected error of %s", raw)
+		}
+	}
+}
+
+func waitSaveFinished(t *testing.T, server *Server) {
+	deadline := time.Now().Add(time.Second)
+	for server.rdbSaver.InProgress() {
+		if time.Now().After(deadline) {
+			t.Fatal("background saving timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSaveWithoutAof(t *testing.T) {
+	filename := config.Properties.RDBFilename
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	defer func() {
+		config.Properties.RDBFilename = filename
+	}()
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "b", "2"))
+	if dirty := atomic.LoadInt64(&server.dirty); dirty != 2 {
+		t.Errorf("expected 2 changes, actual %d", dirty)
+	}
+	reply := server.Exec(conn, utils.ToCmdLine("save"))
+	if !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	info := string(server.Exec(conn, utils.ToCmdLine("info", "persistence")).ToBytes())
+	if !strings.Contains(info, "rdb_changes_since_last_save:0") {
+		t.Errorf("expected no changes since last save, actual %s", info)
+	}
+	lastSave := server.Exec(conn, utils.ToCmdLine("lastsave")).(*protocol.IntReply).Code
+	if lastSave != server.rdbSaver.LastSave() || lastSave < time.Now().Unix()-1 {
+		t.Errorf("unexpected LASTSAVE %d", lastSave)
+	}
+
+	// BGSAVE is rejected while another saving is waiting for the snapshot
+	locked := []string{"a"}
+	server.mustSelectDB(0).RWLocks(locked, nil)
+	go server.Exec(connection.NewFakeConn(), utils.ToCmdLine("bgsave"))
+	for !server.rdbSaver.InProgress() {
+		time.Sleep(time.Millisecond)
+	}
+	reply = server.Exec(conn, utils.ToCmdLine("bgsave"))
+	if !protocol.IsErrorReply(reply) {
+		t.Errorf("expected error of concurrent BGSAVE, actual %s", reply.ToBytes())
+	}
+	server.mustSelectDB(0).RWUnLocks(locked, nil)
+	waitSaveFinished(t, server)
+
+	// rdb is loaded on startup since aof is disabled
+	loaded := NewStandaloneServer()
+	reply = loaded.Exec(conn, utils.ToCmdLine("get", "b"))
+	if string(reply.ToBytes()) != "$1\r\n2\r\n" {
+		t.Errorf("expected loaded value 2, actual %s", reply.ToBytes())
+	}
+}
+
+func TestDirtyPerKey(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	args := []string{"mset"}
+	for i := 0; i < 100; i++ {
+		args = append(args, "k"+strconv.Itoa(i), "v")
+	}
+	server.Exec(conn, utils.ToCmdLine(args...))
+	if dirty := atomic.LoadInt64(&server.dirty); dirty != 100 {
+		t.Errorf("expected 100 changes by mset, actual %d", dirty)
+	}
+	atomic.StoreInt64(&server.dirty, 0)
+	server.Exec(conn, utils.ToCmdLine("flushdb"))
+	// 100 removed keys and the flush itself
+	if dirty := atomic.LoadInt64(&server.dirty); dirty != 101 {
+		t.Errorf("expected 101 changes by flushdb, actual %d", dirty)
+	}
+}
+
+func TestSaveDefaultFilename(t *testing.T) {
+	filename := config.Properties.RDBFilename
+	config.Properties.RDBFilename = ""
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		config.Properties.RDBFilename = filename
+		_ = os.Chdir(wd)
+	}()
+	// nothing to load before the first saving
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	conn.SelectDB(1)
+	server.Exec(conn, utils.ToCmdLine("rpush", "list", "x", "y"))
+	if reply := server.Exec(conn, utils.ToCmdLine("save")); !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	if _, err := os.Stat("dump.rdb"); err != nil {
+		t.Fatalf("expected dump.rdb saved, actual %v", err)
+	}
+	server.Close()
+
+	// restarted server loads the saved file
+	loaded := NewStandaloneServer()
+	defer loaded.Close()
+	loadedConn := connection.NewFakeConn()
+	if reply := loaded.Exec(loadedConn, utils.ToCmdLine("get", "a")); string(reply.ToBytes()) != "$1\r\n1\r\n" {
+		t.Errorf("expected a loaded, actual %s", reply.ToBytes())
+	}
+	loadedConn.SelectDB(1)
+	if size := loaded.Exec(loadedConn, utils.ToCmdLine("llen", "list")).(*protocol.IntReply).Code; size != 2 {
+		t.Errorf("expected 2 elements in list, actual %d", size)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	filename := config.Properties.RDBFilename
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	defer func() {
+		config.Properties.RDBFilename = filename
+	}()
+	server := NewStandaloneServer()
+	server.saveParams = []config.SaveParam{{Seconds: 10, Changes: 2}}
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	now := time.Now()
+	server.saveIfNeeded(now.Add(time.Minute))
+	if server.rdbSaver.InProgress() || server.rdbSaver.LastSave() > now.Unix() {
+		t.Errorf("expected no saving with too few changes")
+	}
+	server.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	server.saveIfNeeded(now)
+	if server.rdbSaver.InProgress() || server.rdbSaver.LastSave() > now.Unix() {
+		t.Errorf("expected no saving within 10 seconds since last save")
+	}
+	server.saveIfNeeded(now.Add(time.Minute))
+	waitSaveFinished(t, server)
+	if dirty := atomic.LoadInt64(&server.dirty); dirty != 0 {
+		t.Errorf("expected no changes after saving, actual %d", dirty)
+	}
+	if server.rdbSaver.LastFailed() {
+		t.Errorf("expected saving succeeded")
+	}
+}
+
+func TestSaveModules(t *testing.T) {
+	filename := config.Properties.RDBFilename
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	defer func() {
+		config.Properties.RDBFilename = filename
+	}()
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	// a string which is a marshaled graph must be loaded as string
+	fake := string(graph.Make().Marshal())
+	server.Exec(conn, utils.ToCmdLine("set", "str", fake))
+	fakeTrie := string(suggest.Make().Marshal())
+	server.Exec(conn, utils.ToCmdLine("set", "str2", fakeTrie))
+	// db 1 holds graphs and suggestion dictionaries only
+	conn.SelectDB(1)
+	server.Exec(conn, utils.ToCmdLine("graph.addedge", "g", "a", "b"))
+	server.Exec(conn, utils.ToCmdLine("sug.add", "sug", "hello", "1"))
+	server.Exec(conn, utils.ToCmdLine("expire", "g", "1000"))
+	if reply := server.Exec(conn, utils.ToCmdLine("save")); !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+
+	loaded := NewStandaloneServer()
+	loadedConn := connection.NewFakeConn()
+	reply := loaded.Exec(loadedConn, utils.ToCmdLine("get", "str"))
+	if string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(fake)).ToBytes()) {
+		t.Errorf("expected string loaded, actual %s", reply.ToBytes())
+	}
+	reply = loaded.Exec(loadedConn, utils.ToCmdLine("get", "str2"))
+	if string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(fakeTrie)).ToBytes()) {
+		t.Errorf("expected string loaded, actual %s", reply.ToBytes())
+	}
+	loadedConn.SelectDB(1)
+	if reply := loaded.Exec(loadedConn, utils.ToCmdLine("type", "g")); string(reply.ToBytes()) != "+graph\r\n" {
+		t.Errorf("expected graph loaded, actual %s", reply.ToBytes())
+	}
+	if reply := loaded.Exec(loadedConn, utils.ToCmdLine("sug.len", "sug")); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected suggestion dictionary loaded, actual %s", reply.ToBytes())
+	}
+	if ttl := loaded.Exec(loadedConn, utils.ToCmdLine("ttl", "g")).(*protocol.IntReply).Code; ttl <= 0 {
+		t.Errorf("expected ttl of graph loaded, actual %d", ttl)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,7 +14,10 @@ import (
 	"slava/internal/interface/database"
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
+	"slava/internal/rdb"
 	"slava/internal/utils"
+	"slava/pkg/datastruct/lock"
+	"slava/pkg/idgenerator"
 	"slava/pkg/logger"
 	"slava/pkg/pubsub"
 )
@@ -24,6 +28,15 @@ type Server struct {
 
 	// handle publish/subscribe
 	hub *pubsub.Hub
+	// notifyFlags are classes of keyspace events to be published, parsed from notify-keyspace-events
+	notifyFlags int
+	// durable topics retain published messages for replaying
+	topics      *topicConfig
+	topicLocker *lock.Locks
+	// topicLogs holds retained logs of durable topics, it is out of dbSet so that user commands can't touch it
+	topicLogs atomic.Value // *DB
+	// topicOwner returns the node retaining log of durable topic in cluster, empty string means current node
+	topicOwner func(topic string) string
 	// handle aof persistence
 	persister *aof.Persister
 
@@ -33,8 +46,39 @@ type Server struct {
 	masterStatus *masterStatus
 
 	// for memory release
-	maxMemory       uint64
-	maxMemoryPolicy uint8
+	maxMemory        uint64
+	maxMemoryPolicy  uint8
+	maxMemorySamples int
+	memTracker       *memoryTracker
+	// evictMu protects evictionPool and nextEvictDB
+	evictMu      sync.Mutex
+	evictionPool *evictionPool
+	nextEvictDB  int
+
+	// statistics reported by INFO
+	stats serverStats
+
+	// active expire cycle, see expire.go
+	expireParams *activeExpireParams
+	nextExpireDB int
+	// lazyfree frees big values in background, see lazyfree.go
+	lazyfree *lazyFreer
+	// closed is closed when server closes to stop background jobs
+	closed chan struct{}
+
+	// generates ids for ID.NEXT
+	idGenerator *idgenerator.IDGenerator
+
+	// swapMu is locked by SWAPDB, commands across databases such as MOVE and EXEC hold its read lock
+	// so that databases won't be swapped during execution
+	swapMu sync.RWMutex
+	// snapshotMu protects registering and releasing snapshots, see snapshot.go
+	snapshotMu sync.Mutex
+	// dirty is number of changes since last save, see Server.AddAof
+	dirty int64
+	// rdbSaver saves rdb by SAVE, BGSAVE and save rules, see save.go
+	rdbSaver   *rdb.Saver
+	saveParams []config.SaveParam
 }
 
 // NewStandaloneServer creates a standalone slava server, with multi database and all other funtions
@@ -44,17 +88,54 @@ func NewStandaloneServer() *Server {
 		config.Properties.Databases = 16
 	}
 	server.dbSet = make([]*atomic.Value, config.Properties.Databases)
+	server.lazyfree = makeLazyFreer()
 	for i := range server.dbSet {
 		singleDB := MakeDB()
-		singleDB.index = i
+		singleDB.setIndex(i)
+		singleDB.notifier = server.notifyKeyspaceEvent
+		singleDB.notifyEnabled = server.keyspaceEventEnabled
+		singleDB.stats = &server.stats
+		singleDB.lazyfree = server.lazyfree
+		server.bindAddAof(singleDB)
 		holder := &atomic.Value{}
 		holder.Store(singleDB)
 		server.dbSet[i] = holder
 	}
 	server.hub = pubsub.MakeHub()
+	notifyFlags, err := parseNotifyFlags(config.Properties.NotifyKeyspaceEvents)
+	if err != nil {
+		logger.Warn("invalid notify-keyspace-events: " + err.Error())
+	}
+	server.notifyFlags = notifyFlags
+	server.idGenerator = MakeIDGenerator()
+	server.topics = parseTopicConfig(config.Properties.DurableTopics,
+		config.Properties.TopicRetentionSize, config.Properties.TopicRetentionTime)
+	server.topicLocker = lock.Make(16)
+	server.topicLogs.Store(makeDB())
+	server.closed = make(chan struct{})
+	server.expireParams = makeActiveExpireParams(config.Properties.ActiveExpireEffort)
+	server.memTracker = makeMemoryTracker()
+	server.evictionPool = makeEvictionPool()
+	server.maxMemorySamples = config.Properties.MaxMemorySamples
+	if maxMemory, err := config.ParseMemory(config.Properties.MaxMemory); err != nil {
+		logger.Warn("invalid maxmemory: " + err.Error())
+	} else {
+		server.maxMemory = maxMemory
+	}
+	if policy, err := parseMaxMemoryPolicy(config.Properties.MaxMemoryPolicy); err != nil {
+		logger.Warn(err.Error() + ", fallback to noeviction")
+	} else {
+		server.maxMemoryPolicy = policy
+	}
+	server.rdbSaver = rdb.MakeSaver(server)
+	if saveParams, err := config.ParseSaveParams(config.Properties.Save); err != nil {
+		logger.Warn(err.Error() + ", automatic saving disabled")
+	} else {
+		server.saveParams = saveParams
+	}
 	validAof := false
 	if config.Properties.AppendOnly {
-		aofHandler, err := NewPersister(server,
+		aofHandler, err := NewPersister(server, config.Properties.AppendDirname,
 			config.Properties.AppendFilename, true, config.Properties.AppendFsync)
 		if err != nil {
 			panic(err)
@@ -62,16 +143,21 @@ func NewStandaloneServer() *Server {
 		server.bindPersister(aofHandler)
 		validAof = true
 	}
-	if config.Properties.RDBFilename != "" && !validAof {
-		// load rdb
+	if !validAof {
+		// load rdb from the same file as saving, dump.rdb if dbfilename is not set
 		err := server.loadRdbFile()
 		if err != nil {
 			logger.Error(err)
 		}
 	}
+	// changes replayed from aof or rdb are not dirty
+	server.dirty = 0
 	server.slaveStatus = initReplSlaveStatus()
 	server.initMaster()
 	server.startReplCron()
+	server.startSaveCron()
+	server.startActiveExpireCycle()
+	server.lazyfree.start(server.closed)
 	server.role = masterRole // The initialization process does not require atomicity
 	return server
 }
@@ -98,6 +184,9 @@ func (server *Server) Exec(c slava.Connection, cmdLine [][]byte) (result slava.R
 	if !isAuthenticated(c) {
 		return protocol.MakeErrReply("NOAUTH Authentication required")
 	}
+	if cmdName == "command" {
+		return execCommand(cmdLine[1:])
+	}
 	if cmdName == "slaveof" {
 		if c != nil && c.InMultiState() {
 			return protocol.MakeErrReply("cannot use slave of database within multi")
@@ -116,40 +205,82 @@ func (server *Server) Exec(c slava.Connection, cmdLine [][]byte) (result slava.R
 			return protocol.MakeErrReply("READONLY You can't write against a read only slave.")
 		}
 	}
+	// internal commands come from aof and master only
+	if isInternalCommand(cmdName) && !c.IsMaster() {
+		return protocol.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	// write commands handled by server such as COPY and MOVE free memory as well as normal commands
+	if errReply := server.denyOOM(c, cmdName); errReply != nil {
+		return errReply
+	}
 
 	// special commands which cannot execute within transaction
 	if cmdName == "subscribe" {
 		if len(cmdLine) < 2 {
 			return protocol.MakeArgNumErrReply("subscribe")
 		}
+		// SUBSCRIBE a FROM b subscribes 3 channels unless a is a durable topic
+		if len(cmdLine) == 4 && strings.ToLower(string(cmdLine[2])) == "from" && server.topics.isDurable(string(cmdLine[1])) {
+			return server.subscribeFrom(c, cmdLine[1:])
+		}
 		return pubsub.Subscribe(server.hub, c, cmdLine[1:])
 	} else if cmdName == "publish" {
-		return pubsub.Publish(server.hub, cmdLine[1:])
+		return server.publish(cmdLine[1:])
+	} else if cmdName == aof.TopicAppend {
+		return server.execTopicAppend(cmdLine[1:])
 	} else if cmdName == "unsubscribe" {
 		return pubsub.UnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "psubscribe" {
+		if len(cmdLine) < 2 {
+			return protocol.MakeArgNumErrReply("psubscribe")
+		}
+		return pubsub.PSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "punsubscribe" {
+		return pubsub.PUnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "ssubscribe" {
+		if len(cmdLine) < 2 {
+			return protocol.MakeArgNumErrReply("ssubscribe")
+		}
+		return pubsub.SSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "sunsubscribe" {
+		return pubsub.SUnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "spublish" {
+		return pubsub.SPublish(server.hub, cmdLine[1:])
+	} else if cmdName == "pubsub" {
+		return pubsub.PubSub(server.hub, cmdLine[1:])
 	} else if cmdName == "bgrewriteaof" {
 		// aof.go imports router.go, router.go cannot import BGRewriteAOF from aof.go
 		return BGRewriteAOF(server, cmdLine[1:])
 	} else if cmdName == "rewriteaof" {
 		return RewriteAOF(server, cmdLine[1:])
 	} else if cmdName == "flushall" {
-		return server.flushAll()
+		async, errReply := parseFlushMode(cmdLine[1:])
+		if errReply != nil {
+			return errReply
+		}
+		if c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'FlushAll' cannot be used in MULTI")
+		}
+		return server.flushAll(async)
 	} else if cmdName == "flushdb" {
-		if !validateArity(1, cmdLine) {
+		if len(cmdLine) > 2 {
 			return protocol.MakeArgNumErrReply(cmdName)
 		}
+		async, errReply := parseFlushMode(cmdLine[1:])
+		if errReply != nil {
+			return errReply
+		}
 		if c.InMultiState() {
 			return protocol.MakeErrReply("ERR command 'FlushDB' cannot be used in MULTI")
 		}
-		return server.flushDB(c.GetDBIndex())
+		return server.flushDB(c.GetDBIndex(), async)
 	} else if cmdName == "save" {
 		return SaveRDB(server, cmdLine[1:])
 	} else if cmdName == "bgsave" {
 		return BGSaveRDB(server, cmdLine[1:])
+	} else if cmdName == "lastsave" {
+		return protocol.MakeIntReply(server.rdbSaver.LastSave())
 	} else if cmdName == "select" {
-		if c != nil && c.InMultiState() {
-			return protocol.MakeErrReply("cannot select database within multi")
-		}
 		if len(cmdLine) != 2 {
 			return protocol.MakeArgNumErrReply("select")
 		}
@@ -158,13 +289,67 @@ func (server *Server) Exec(c slava.Connection, cmdLine [][]byte) (result slava.R
 		if len(cmdLine) < 3 {
 			return protocol.MakeArgNumErrReply("copy")
 		}
-		return execCopy(server, c, cmdLine[1:])
+		if c.InMultiState() {
+			c.EnqueueCmd(cmdLine)
+			return protocol.MakeQueuedReply()
+		}
+		return execCopy(server, c.GetDBIndex(), cmdLine[1:])
+	} else if cmdName == "move" {
+		if len(cmdLine) != 3 {
+			return protocol.MakeArgNumErrReply("move")
+		}
+		if c.InMultiState() {
+			c.EnqueueCmd(cmdLine)
+			return protocol.MakeQueuedReply()
+		}
+		return execMove(server, c, cmdLine[1:])
+	} else if cmdName == "swapdb" {
+		if len(cmdLine) != 3 {
+			return protocol.MakeArgNumErrReply("swapdb")
+		}
+		if c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'SwapDB' cannot be used in MULTI")
+		}
+		return server.execSwapDB(c, cmdLine[1:])
 	} else if cmdName == "replconf" {
 		return server.execReplConf(c, cmdLine[1:])
 	} else if cmdName == "psync" {
 		return server.execPSync(c, cmdLine[1:])
+	} else if cmdName == "id.next" {
+		if c.InMultiState() {
+			return protocol.MakeErrReply("ERR command 'ID.Next' cannot be used in MULTI")
+		}
+		return execIDNext(server, cmdLine[1:])
+	} else if cmdName == "id.decode" {
+		return execIDDecode(cmdLine[1:])
+	} else if cmdName == "memory" {
+		return execMemory(server, c, cmdLine[1:])
+	} else if cmdName == "info" {
+		return server.execInfo(cmdLine[1:])
+	}
+
+	// transaction
+	if cmdName == "multi" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(c)
+	} else if cmdName == "discard" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(c)
+	} else if cmdName == "exec" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return server.execExec(c)
+	} else if cmdName == "watch" {
+		if !validateArity(-2, cmdLine) {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return server.execWatch(c, cmdLine[1:])
 	}
-	// todo: support multi database transaction
 
 	// normal commands
 	dbIndex := c.GetDBIndex()
@@ -188,6 +373,8 @@ func (server *Server) Close() {
 		server.persister.Close()
 	}
 	server.stopMaster()
+	server.saveOnClose()
+	close(server.closed)
 }
 
 func execSelect(c slava.Connection, mdb *Server, args [][]byte) slava.Reply {
@@ -198,37 +385,107 @@ func execSelect(c slava.Connection, mdb *Server, args [][]byte) slava.Reply {
 	if dbIndex >= len(mdb.dbSet) || dbIndex < 0 {
 		return protocol.MakeErrReply("ERR DB index is out of range")
 	}
+	// SELECT in transaction takes effect when EXEC
+	if c.InMultiState() {
+		c.EnqueueCmd(utils.ToCmdLine("select", strconv.Itoa(dbIndex)))
+		return protocol.MakeQueuedReply()
+	}
 	c.SelectDB(dbIndex)
 	return protocol.MakeOkReply()
 }
 
-func (server *Server) flushDB(dbIndex int) slava.Reply {
+// parseFlushMode parses [ASYNC|SYNC] option of FLUSHDB and FLUSHALL, returns true if ASYNC
+func parseFlushMode(args [][]byte) (bool, protocol.ErrorReply) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) == 1 {
+		switch strings.ToUpper(string(args[0])) {
+		case "ASYNC":
+			return true, nil
+		case "SYNC":
+			return false, nil
+		}
+	}
+	return false, protocol.MakeSyntaxErrReply()
+}
+
+// flushDB replaces db with an empty one, data of old db is freed in background if async is true
+func (server *Server) flushDB(dbIndex int, async bool) slava.Reply {
 	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
 		return protocol.MakeErrReply("ERR DB index is out of range")
 	}
-	newDB := MakeDB()
-	server.loadDB(dbIndex, newDB)
+	// every removed key is a change like redis
+	atomic.AddInt64(&server.dirty, int64(server.resetDB(dbIndex, async)))
+	server.AddAof(dbIndex, utils.ToCmdLine("flushdb"))
 	return &protocol.OkReply{}
 }
 
+// resetDB replaces db with an empty one and returns number of keys removed, the old db is freed in background if async is true
+func (server *Server) resetDB(dbIndex int, async bool) int {
+	oldDB := server.mustSelectDB(dbIndex)
+	server.loadDB(dbIndex, MakeDB())
+	removed := oldDB.data.Len()
+	if async {
+		server.lazyfree.free(oldDB, removed)
+	}
+	return removed
+}
+
 func (server *Server) loadDB(dbIndex int, newDB *DB) slava.Reply {
 	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
 		return protocol.MakeErrReply("ERR DB index is out of range")
 	}
 	oldDB := server.mustSelectDB(dbIndex)
-	newDB.index = dbIndex
-	newDB.AddAof = oldDB.AddAof // inherit oldDB
+	newDB.setIndex(dbIndex)
+	server.bindAddAof(newDB)
+	newDB.notifier = oldDB.notifier
+	newDB.notifyEnabled = oldDB.notifyEnabled
+	newDB.stats = oldDB.stats
+	newDB.lazyfree = oldDB.lazyfree
 	server.dbSet[dbIndex].Store(newDB)
 	return &protocol.OkReply{}
 }
 
-func (server *Server) flushAll() slava.Reply {
-	for i := range server.dbSet {
-		server.flushDB(i)
+// execSwapDB swaps two databases, clients connected to one database will see data of the other one immediately
+func (server *Server) execSwapDB(c slava.Connection, args [][]byte) slava.Reply {
+	index1, err1 := strconv.Atoi(string(args[0]))
+	index2, err2 := strconv.Atoi(string(args[1]))
+	if err1 != nil || err2 != nil {
+		return protocol.MakeErrReply("ERR invalid DB index")
 	}
-	if server.persister != nil {
-		server.persister.SaveCmdLine(0, utils.ToCmdLine("FlushAll"))
+	if index1 >= len(server.dbSet) || index1 < 0 || index2 >= len(server.dbSet) || index2 < 0 {
+		return protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	server.swapMu.Lock()
+	defer server.swapMu.Unlock()
+	if index1 != index2 {
+		db1 := server.mustSelectDB(index1)
+		db2 := server.mustSelectDB(index2)
+		// writers hold key locks until their aof is written, locking all keys of both dbs makes swapdb a barrier:
+		// writes before it are written into aof with old index and writes after it with new index.
+		// watched keys are regarded as changed since versions of keys are unique among dbs, see addVersion
+		db1.locker.LockAll()
+		defer db1.locker.UnLockAll()
+		db2.locker.LockAll()
+		defer db2.locker.UnLockAll()
+		// AddAof of db reads index at runtime, so commands will be written into aof with new index
+		db1.setIndex(index2)
+		db2.setIndex(index1)
+		server.dbSet[index1].Store(db2)
+		server.dbSet[index2].Store(db1)
+	}
+	server.AddAof(c.GetDBIndex(), utils.ToCmdLine3("swapdb", args...))
+	return &protocol.OkReply{}
+}
+
+func (server *Server) flushAll(async bool) slava.Reply {
+	removed := 0
+	for i := range server.dbSet {
+		removed += server.resetDB(i, async)
 	}
+	atomic.AddInt64(&server.dirty, int64(removed))
+	server.AddAof(0, utils.ToCmdLine("FlushAll"))
 	return &protocol.OkReply{}
 }
 
@@ -253,12 +510,13 @@ func (server *Server) ForEach(dbIndex int, cb func(key string, data *database.Da
 }
 
 // ExecMulti executes multi commands transaction Atomically and Isolated
+// keys in watching belong to the selected database of conn
 func (server *Server) ExecMulti(conn slava.Connection, watching map[string]uint32, cmdLines []aof.CmdLine) slava.Reply {
-	selectedDB, errReply := server.selectDB(conn.GetDBIndex())
-	if errReply != nil {
-		return errReply
+	dbWatching := make(map[string]uint32, len(watching))
+	for key, ver := range watching {
+		dbWatching[makeWatchKey(conn.GetDBIndex(), key)] = ver
 	}
-	return selectedDB.ExecMulti(conn, watching, cmdLines)
+	return server.execMulti(conn, dbWatching, cmdLines)
 }
 
 // RWLocks lock keys for writing and reading
@@ -271,6 +529,11 @@ func (server *Server) RWUnLocks(dbIndex int, writeKeys []string, readKeys []stri
 	server.mustSelectDB(dbIndex).RWUnLocks(writeKeys, readKeys)
 }
 
+// DropKeyEvents drops keyspace events raised on locked keys, the caller should hold lock of the keys
+func (server *Server) DropKeyEvents(dbIndex int, writeKeys []string, readKeys []string) {
+	server.mustSelectDB(dbIndex).takeEvents(writeKeys, readKeys)
+}
+
 // GetUndoLogs return rollback commands
 func (server *Server) GetUndoLogs(dbIndex int, cmdLine [][]byte) []aof.CmdLine {
 	return server.mustSelectDB(dbIndex).GetUndoLogs(cmdLine)
@@ -287,7 +550,14 @@ func (server *Server) ExecWithLock(conn slava.Connection, cmdLine [][]byte) slav
 
 // BGRewriteAOF asynchronously rewrites Append-Only-File
 func BGRewriteAOF(db *Server, args [][]byte) slava.Reply {
-	go db.persister.Rewrite()
+	if db.persister.RewriteInProgress() {
+		return protocol.MakeErrReply("ERR " + aof.ErrRewriteInProgress.Error())
+	}
+	go func() {
+		if err := db.persister.Rewrite(); err != nil {
+			logger.Error("background aof rewriting failed: " + err.Error())
+		}
+	}()
 	return protocol.MakeStatusReply("Background append only file rewriting started")
 }
 
@@ -302,40 +572,19 @@ func RewriteAOF(db *Server, args [][]byte) slava.Reply {
 
 // SaveRDB start RDB writing and blocked until it finished
 func SaveRDB(db *Server, args [][]byte) slava.Reply {
-	if db.persister == nil {
-		return protocol.MakeErrReply("please enable aof before using save")
-	}
-	rdbFilename := config.Properties.RDBFilename
-	if rdbFilename == "" {
-		rdbFilename = "dump.rdb"
-	}
-	err := db.persister.Rewrite2RDB(rdbFilename)
+	err := db.saveRDB(false)
 	if err != nil {
-		return protocol.MakeErrReply(err.Error())
+		return protocol.MakeErrReply("ERR " + err.Error())
 	}
 	return protocol.MakeOkReply()
 }
 
-// BGSaveRDB asynchronously save RDB
+// BGSaveRDB asynchronously save RDB, the snapshot is taken before it returns
 func BGSaveRDB(db *Server, args [][]byte) slava.Reply {
-	if db.persister == nil {
-		return protocol.MakeErrReply("please enable aof before using save")
+	err := db.saveRDB(true)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
 	}
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				logger.Error(err)
-			}
-		}()
-		rdbFilename := config.Properties.RDBFilename
-		if rdbFilename == "" {
-			rdbFilename = "dump.rdb"
-		}
-		err := db.persister.Rewrite2RDB(rdbFilename)
-		if err != nil {
-			logger.Error(err)
-		}
-	}()
 	return protocol.MakeStatusReply("Background saving started")
 }
 
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+)
+
+// sets are loaded from rdb only, SADD is kept so that aof rewrite and undo logs of sets can be replayed
+
+// execSAdd adds members into set
+// sadd key member [member ...]
+func execSAdd(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	s, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	count := 0
+	for _, member := range args[1:] {
+		count += s.Add(string(member))
+	}
+	db.AddAof(utils.ToCmdLine3("sadd", args...))
+	db.notify(notifySet, "sadd", key)
+	return protocol.MakeIntReply(int64(count))
+}
+
+func init() {
+	RegisterCommand("SAdd", execSAdd, WriteFirstKey, RollbackFirstKey, -3, flagWrite|flagDenyOOM).category("@set")
+}
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"slava/internal/interface/database"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/list"
+	"slava/pkg/datastruct/quicklist"
+	"slava/pkg/datastruct/set"
+	SortedSet "slava/pkg/datastruct/sortedset"
+	"slava/pkg/datastruct/suggest"
+)
+
+// 快照: BGSAVE, BGREWRITEAOF 和主从全量同步需要某一时刻的数据视图, 同时不能阻塞写命令
+// redis 通过 fork 依赖操作系统的写时复制, 这里在 key 的粒度上实现写时复制:
+// 快照存在期间, 写命令在获取 key 的写锁之后、修改 key 之前将 key 的原值引用保存到快照中,
+// 遍历快照时优先使用保存的原值, 其余的 key 在持有读锁时直接读取当前值.
+// 保存原值时不复制, 只增加 DataEntity.Shared 计数: 删除和覆盖 key 不需要复制,
+// 只有原地修改的命令通过类型 getter (getAsList 等) 取值时才复制一份替换 db 中的值, 见 DB.getUnshared.
+// 原值被遍历之后计数就会减少, 所以每个值最多复制一次, 已经被遍历过的 key 不再保存, 遍历越快需要复制的值越少.
+// 复制发生在持有 key 的锁时, 耗时与值的大小成正比, 这是以 key 为粒度写时复制的代价
+
+// preservedEntry is a key at the moment of snapshot, or a mark that the key has been visited
+type preservedEntry struct {
+	// entity is nil if the key did not exist at the moment of snapshot, or it has been emitted.
+	// Shared of entity is increased while it is referred by the entry
+	entity     *database.DataEntity
+	expiration *time.Time
+	// emitted is true if the key has been passed to the consumer of snapshot
+	emitted bool
+}
+
+// dbSnapshot is the snapshot of a single db
+type dbSnapshot struct {
+	db        *DB
+	mu        sync.Mutex
+	preserved map[string]*preservedEntry
+	keyCount  int
+	ttlCount  int
+	// released is true after the snapshot is released, keys are not preserved any more
+	released bool
+}
+
+// Snapshot is a point-in-time view of all dbs in server, see Server.Snapshot
+type Snapshot struct {
+	server *Server
+	dbs    []*dbSnapshot
+	// topics is the snapshot of retained logs of durable topics
+	topics   *dbSnapshot
+	released int32
+}
+
+// Snapshot takes a snapshot of all dbs, hook is called at the moment of snapshot.
+// it waits for executing commands to finish and blocks new commands only until hook returns,
+// so hook must be short: aof persister only enqueues a barrier in hook and waits for it after all keys are unlocked,
+// instead of waiting for up to aofQueueSize pending commands to be written while all keys are locked
+func (server *Server) Snapshot(hook func()) database.Snapshot {
+	// SWAPDB, MOVE and EXEC must not run across the moment of snapshot
+	server.swapMu.Lock()
+	defer server.swapMu.Unlock()
+	dbs := make([]*DB, len(server.dbSet), len(server.dbSet)+1)
+	for i := range server.dbSet {
+		dbs[i] = server.mustSelectDB(i)
+	}
+	// the last one is topicDB
+	dbs = append(dbs, server.topicDB())
+	for _, db := range dbs {
+		db.locker.LockAll()
+	}
+	defer func() {
+		for i := len(dbs) - 1; i >= 0; i-- {
+			dbs[i].locker.UnLockAll()
+		}
+	}()
+
+	snapshot := &Snapshot{
+		server: server,
+		dbs:    make([]*dbSnapshot, len(server.dbSet)),
+	}
+	server.snapshotMu.Lock()
+	for i, db := range dbs {
+		s := &dbSnapshot{
+			db:        db,
+			preserved: make(map[string]*preservedEntry),
+			keyCount:  db.data.Len(),
+			ttlCount:  db.ttlMap.Len(),
+		}
+		db.addSnapshot(s)
+		if i < len(snapshot.dbs) {
+			snapshot.dbs[i] = s
+		} else {
+			snapshot.topics = s
+		}
+	}
+	server.snapshotMu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	return snapshot
+}
+
+// GetDBSize returns number of keys and number of keys with ttl at the moment of snapshot
+func (snapshot *Snapshot) GetDBSize(dbIndex int) (int, int) {
+	s := snapshot.dbs[dbIndex]
+	return s.keyCount, s.ttlCount
+}
+
+// ForEach traverses keys of db at the moment of snapshot, consumer must not modify the entity
+func (snapshot *Snapshot) ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	snapshot.dbs[dbIndex].forEach(cb)
+}
+
+// ForEachTopic traverses retained logs of durable topics at the moment of snapshot
+func (snapshot *Snapshot) ForEachTopic(cb func(topic string, entries []string) bool) {
+	snapshot.topics.forEach(func(topic string, data *database.DataEntity, expiration *time.Time) bool {
+		log, ok := data.Data.(*list.List)
+		if !ok || log.Len() == 0 {
+			return true
+		}
+		// entries are copied while holding the lock of topic
+		entries := make([]string, 0, log.Len())
+		for _, node := range log.Range(0, log.Len()-1) {
+			entries = append(entries, node.GetValue())
+		}
+		return cb(topic, entries)
+	})
+}
+
+// forEach traverses keys of db at the moment of snapshot
+func (s *dbSnapshot) forEach(cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	// keys created after snapshot are skipped since they are preserved as absent before creation
+	for _, key := range s.db.data.Keys() {
+		if !s.visit(key, cb) {
+			return
+		}
+	}
+	// keys removed after snapshot, or keys modified before visited
+	for _, entry := range s.unemitted() {
+		if !s.emit(entry.key, entry.entity, entry.expiration, entry.preservedEntry, cb) {
+			return
+		}
+	}
+}
+
+// Release stops preserving keys for the snapshot, the snapshot can not be used any more
+func (snapshot *Snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&snapshot.released, 0, 1) {
+		return
+	}
+	snapshot.server.snapshotMu.Lock()
+	defer snapshot.server.snapshotMu.Unlock()
+	for _, s := range snapshot.dbs {
+		s.db.removeSnapshot(s)
+		s.release()
+	}
+	snapshot.topics.db.removeSnapshot(snapshot.topics)
+	snapshot.topics.release()
+}
+
+// release drops values which are not emitted
+func (s *dbSnapshot) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = true
+	for _, entry := range s.preserved {
+		entry.drop()
+	}
+}
+
+// drop stops referring the value, so that writers could modify it in place again. caller must hold mu of snapshot
+func (entry *preservedEntry) drop() {
+	if entry.entity != nil {
+		atomic.AddInt32(&entry.entity.Shared, -1)
+		entry.entity = nil
+	}
+}
+
+// emit passes preserved value of key to consumer and drops it after
+func (s *dbSnapshot) emit(key string, entity *database.DataEntity, expiration *time.Time, entry *preservedEntry,
+	cb func(key string, data *database.DataEntity, expiration *time.Time) bool) bool {
+	defer func() {
+		s.mu.Lock()
+		entry.drop()
+		s.mu.Unlock()
+	}()
+	return cb(key, entity, expiration)
+}
+
+// visit passes key to consumer unless it has been emitted, returns false if consumer stops the iteration
+func (s *dbSnapshot) visit(key string, cb func(key string, data *database.DataEntity, expiration *time.Time) bool) bool {
+	readKeys := []string{key}
+	s.db.locker.RWLocks(nil, readKeys)
+	defer s.db.locker.RWUnLocks(nil, readKeys)
+	s.mu.Lock()
+	entry, ok := s.preserved[key]
+	if ok {
+		if entry.emitted {
+			s.mu.Unlock()
+			return true
+		}
+		entry.emitted = true
+		entity, expiration := entry.entity, entry.expiration
+		s.mu.Unlock()
+		if entity == nil {
+			return true
+		}
+		return s.emit(key, entity, expiration, entry, cb)
+	}
+	// the key is not modified since snapshot, mark it so that writers won't preserve it any more
+	s.preserved[key] = &preservedEntry{emitted: true}
+	s.mu.Unlock()
+
+	entity, expiration, exists := s.db.peekWithTTL(key)
+	if !exists {
+		return true
+	}
+	return cb(key, entity, expiration)
+}
+
+type keyedEntry struct {
+	key        string
+	entity     *database.DataEntity
+	expiration *time.Time
+	*preservedEntry
+}
+
+// unemitted returns preserved keys which are not emitted yet and marks them emitted
+func (s *dbSnapshot) unemitted() []keyedEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []keyedEntry
+	for key, entry := range s.preserved {
+		if entry.emitted {
+			continue
+		}
+		entry.emitted = true
+		if entry.entity != nil {
+			entries = append(entries, keyedEntry{
+				key:            key,
+				entity:         entry.entity,
+				expiration:     entry.expiration,
+				preservedEntry: entry,
+			})
+		}
+	}
+	return entries
+}
+
+// preserve saves the value of key before it is modified, caller must hold the write lock of key
+func (s *dbSnapshot) preserve(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.preserved[key]; ok || s.released {
+		return
+	}
+	entry := &preservedEntry{}
+	if entity, expiration, exists := s.db.peekWithTTL(key); exists {
+		// refer to the value instead of copying it, it will be copied only if modified in place
+		atomic.AddInt32(&entity.Shared, 1)
+		entry.entity = entity
+		entry.expiration = expiration
+	}
+	s.preserved[key] = entry
+}
+
+// getUnshared is GetEntity for typed getters whose result may be modified in place.
+// if a snapshot refers to the entity, its value is copied and the copy takes its place in db, see dbSnapshot.preserve
+func (db *DB) getUnshared(key string) (*database.DataEntity, bool) {
+	entity, exists := db.GetEntity(key)
+	if !exists || atomic.LoadInt32(&entity.Shared) == 0 {
+		return entity, exists
+	}
+	// readers holding read lock may copy it at the same time, any of the copies is ok
+	cloned := &database.DataEntity{
+		Data: cloneData(entity.Data),
+		Lru:  atomic.LoadInt32(&entity.Lru),
+		Lfu:  atomic.LoadUint32(&entity.Lfu),
+	}
+	db.data.Put(key, cloned)
+	return cloned, true
+}
+
+// peekWithTTL returns entity and expiration of key without checking ttl and updating access time
+func (db *DB) peekWithTTL(key string) (*database.DataEntity, *time.Time, bool) {
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+	entity, _ := raw.(*database.DataEntity)
+	var expiration *time.Time
+	if rawTTL, ok := db.ttlMap.Get(key); ok {
+		expireTime, _ := rawTTL.(time.Time)
+		expiration = &expireTime
+	}
+	return entity, expiration, true
+}
+
+// addSnapshot registers s to db, caller must hold Server.snapshotMu
+func (db *DB) addSnapshot(s *dbSnapshot) {
+	snapshots, _ := db.snapshots.Load().([]*dbSnapshot)
+	updated := make([]*dbSnapshot, 0, len(snapshots)+1)
+	updated = append(updated, snapshots...)
+	db.snapshots.Store(append(updated, s))
+}
+
+// removeSnapshot unregisters s from db, caller must hold Server.snapshotMu
+func (db *DB) removeSnapshot(s *dbSnapshot) {
+	snapshots, _ := db.snapshots.Load().([]*dbSnapshot)
+	updated := make([]*dbSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if snapshot != s {
+			updated = append(updated, snapshot)
+		}
+	}
+	db.snapshots.Store(updated)
+}
+
+// preserveForSnapshots is called after write keys are locked, it preserves keys for active snapshots
+func (db *DB) preserveForSnapshots(writeKeys []string) {
+	snapshots, _ := db.snapshots.Load().([]*dbSnapshot)
+	for _, s := range snapshots {
+		for _, key := range writeKeys {
+			s.preserve(key)
+		}
+	}
+}
+
+// cloneData returns a deep copy of value, so that modifying one won't affect the other
+func cloneData(data interface{}) interface{} {
+	switch val := data.(type) {
+	case []byte:
+		// strings may be modified in place by APPEND and SETRANGE
+		bytes := make([]byte, len(val))
+		copy(bytes, val)
+		return bytes
+	case quicklist.List:
+		cloned := quicklist.NewQuickList()
+		val.ForEach(func(i int, v interface{}) bool {
+			cloned.Add(v)
+			return true
+		})
+		return cloned
+	case *list.List:
+		cloned := list.NewList()
+		if val.Len() > 0 {
+			for _, node := range val.Range(0, val.Len()-1) {
+				cloned.RPush(node.GetValue())
+			}
+		}
+		return cloned
+	case *set.Set:
+		cloned := makeSet()
+		val.ForEach(func(member string) bool {
+			cloned.Add(member)
+			return true
+		})
+		return cloned
+	case dict.Dict:
+		cloned := makeHash()
+		val.ForEach(func(field string, v interface{}) bool {
+			cloned.Put(field, v)
+			return true
+		})
+		return cloned
+	case *SortedSet.SortedSet:
+		cloned := makeSortedSet()
+		val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			cloned.Add(element.Member, element.Score)
+			return true
+		})
+		return cloned
+	case *graph.Graph:
+		cloned, err := graph.Unmarshal(val.Marshal())
+		if err != nil {
+			return val
+		}
+		return cloned
+	case *suggest.Trie:
+		cloned, err := suggest.Unmarshal(val.Marshal())
+		if err != nil {
+			return val
+		}
+		return cloned
+	}
+	// int64 and other immutable values
+	return data
+}
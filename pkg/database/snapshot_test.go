@@ -0,0 +1,149 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"slava/internal/interface/database"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+	"slava/pkg/datastruct/list"
+	SortedSet "slava/pkg/datastruct/sortedset"
+)
+
+func TestSnapshot(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	for i := 0; i < 1000; i++ {
+		server.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), "v"+strconv.Itoa(i)))
+	}
+	server.Exec(conn, utils.ToCmdLine("zadd", "z", "1", "a"))
+	server.Exec(conn, utils.ToCmdLine("expire", "k0", "1000"))
+
+	snapshot := server.Snapshot(nil)
+	if keyCount, ttlCount := snapshot.GetDBSize(0); keyCount != 1001 || ttlCount != 1 {
+		t.Errorf("expected 1001 keys and 1 ttl, actual %d keys and %d ttl", keyCount, ttlCount)
+	}
+
+	// writes go on while iterating the snapshot
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn := connection.NewFakeConn()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := "k" + strconv.Itoa(i%1000)
+			switch i % 4 {
+			case 0:
+				server.Exec(conn, utils.ToCmdLine("append", key, "x"))
+			case 1:
+				server.Exec(conn, utils.ToCmdLine("del", key))
+			case 2:
+				server.Exec(conn, utils.ToCmdLine("set", "new"+strconv.Itoa(i), "v"))
+			case 3:
+				server.Exec(conn, utils.ToCmdLine("zadd", "z", strconv.Itoa(i), "m"+strconv.Itoa(i)))
+			}
+			server.Exec(conn, utils.ToCmdLine("persist", "k0"))
+		}
+	}()
+
+	seen := make(map[string]bool)
+	snapshot.ForEach(0, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if seen[key] {
+			t.Errorf("key %s emitted twice", key)
+		}
+		seen[key] = true
+		switch {
+		case key == "z":
+			zset := entity.Data.(*SortedSet.SortedSet)
+			if zset.Len() != 1 {
+				t.Errorf("expected 1 member in z, actual %d", zset.Len())
+			}
+		case key == "k0" && expiration == nil:
+			t.Errorf("expected ttl of k0")
+		default:
+			i := key[1:]
+			if val, ok := entity.Data.([]byte); !ok || string(val) != "v"+i {
+				t.Errorf("expected value v%s of %s, actual %v", i, key, entity.Data)
+			}
+		}
+		// give writer a chance to modify keys not visited yet
+		if len(seen)%100 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return true
+	})
+	close(stop)
+	wg.Wait()
+	if len(seen) != 1001 {
+		t.Errorf("expected 1001 keys in snapshot, actual %d", len(seen))
+	}
+
+	snapshot.Release()
+	snapshots, _ := server.mustSelectDB(0).snapshots.Load().([]*dbSnapshot)
+	if len(snapshots) != 0 {
+		t.Errorf("expected snapshot released")
+	}
+}
+
+func TestCopyDeepClone(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "src", "a"))
+	server.Exec(conn, utils.ToCmdLine("copy", "src", "dest"))
+	server.Exec(conn, utils.ToCmdLine("append", "src", "b"))
+	server.Exec(conn, utils.ToCmdLine("setrange", "src", "0", "c"))
+	reply := server.Exec(conn, utils.ToCmdLine("get", "dest"))
+	if string(reply.ToBytes()) != "$1\r\na\r\n" {
+		t.Errorf("expected dest unchanged, actual %s", reply.ToBytes())
+	}
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("rpush", "l", "a", "b"))
+	server.Exec(conn, utils.ToCmdLine("set", "s", "v"))
+	db := server.mustSelectDB(0)
+	list0, _ := db.data.Get("l")
+	str0, _ := db.data.Get("s")
+
+	snapshot := server.Snapshot(nil)
+	// ttl doesn't modify value, it should not be copied
+	server.Exec(conn, utils.ToCmdLine("expire", "s", "100"))
+	if str, _ := db.data.Get("s"); str != str0 {
+		t.Error("expected s not copied")
+	}
+	// rpush modifies list in place, the snapshot keeps the original one
+	server.Exec(conn, utils.ToCmdLine("rpush", "l", "c"))
+	if list, _ := db.data.Get("l"); list == list0 {
+		t.Error("expected l copied before modified")
+	}
+	snapshot.ForEach(0, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		switch key {
+		case "l":
+			if entity != list0 || entity.Data.(*list.List).Len() != 2 {
+				t.Errorf("expected original l in snapshot")
+			}
+		case "s":
+			if entity != str0 || expiration != nil {
+				t.Errorf("expected s without ttl in snapshot")
+			}
+		}
+		return true
+	})
+	snapshot.Release()
+	for _, raw := range []interface{}{list0, str0} {
+		if shared := raw.(*database.DataEntity).Shared; shared != 0 {
+			t.Errorf("expected entity not shared after emitted, actual %d", shared)
+		}
+	}
+}
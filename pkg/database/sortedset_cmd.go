@@ -45,6 +45,7 @@ func execZAdd(db *DB, args [][]byte) slava.Reply {
 	}
 
 	db.AddAof(utils.ToCmdLine3("zadd", args...))
+	db.notify(notifyZSet, "zadd", key)
 
 	return protocol.MakeIntReply(int64(i))
 }
@@ -431,6 +432,7 @@ func execZRemRangeByScore(db *DB, args [][]byte) slava.Reply {
 	removed := sortedSet.RemoveByScore(min, max)
 	if removed > 0 {
 		db.AddAof(utils.ToCmdLine3("zremrangebyscore", args...))
+		db.notify(notifyZSet, "zremrangebyscore", key)
 	}
 	return protocol.MakeIntReply(removed)
 }
@@ -482,6 +484,7 @@ func execZRemRangeByRank(db *DB, args [][]byte) slava.Reply {
 	removed := sortedSet.RemoveByRank(start, stop)
 	if removed > 0 {
 		db.AddAof(utils.ToCmdLine3("zremrangebyrank", args...))
+		db.notify(notifyZSet, "zremrangebyrank", key)
 	}
 	return protocol.MakeIntReply(removed)
 }
@@ -508,6 +511,7 @@ func execZPopMin(db *DB, args [][]byte) slava.Reply {
 	removed := sortedSet.PopMin(count)
 	if len(removed) > 0 {
 		db.AddAof(utils.ToCmdLine3("zpopmin", args...))
+		db.notify(notifyZSet, "zpopmin", key)
 	}
 	result := make([][]byte, 0, len(removed)*2)
 	for _, element := range removed {
@@ -544,6 +548,7 @@ func execZRem(db *DB, args [][]byte) slava.Reply {
 	}
 	if deleted > 0 {
 		db.AddAof(utils.ToCmdLine3("zrem", args...))
+		db.notify(notifyZSet, "zrem", key)
 	}
 	return protocol.MakeIntReply(deleted)
 }
@@ -578,12 +583,14 @@ func execZIncrBy(db *DB, args [][]byte) slava.Reply {
 	if !exists {
 		sortedSet.Add(field, delta)
 		db.AddAof(utils.ToCmdLine3("zincrby", args...))
+		db.notify(notifyZSet, "zincr", key)
 		return protocol.MakeBulkReply(args[1])
 	}
 	score := element.Score + delta
 	sortedSet.Add(field, score)
 	bytes := []byte(strconv.FormatFloat(score, 'f', -1, 64))
 	db.AddAof(utils.ToCmdLine3("zincrby", args...))
+	db.notify(notifyZSet, "zincr", key)
 	return protocol.MakeBulkReply(bytes)
 }
 
@@ -594,19 +601,19 @@ func undoZIncr(db *DB, args [][]byte) []CmdLine {
 }
 
 func init() {
-	RegisterCommand("ZAdd", execZAdd, WriteFirstKey, undoZAdd, -4, flagWrite)
-	RegisterCommand("ZScore", execZScore, ReadFirstKey, nil, 3, flagReadOnly)
-	RegisterCommand("ZIncrBy", execZIncrBy, WriteFirstKey, undoZIncr, 4, flagWrite)
-	RegisterCommand("ZRank", execZRank, ReadFirstKey, nil, 3, flagReadOnly)
-	RegisterCommand("ZCount", execZCount, ReadFirstKey, nil, 4, flagReadOnly)
-	RegisterCommand("ZRevRank", execZRevRank, ReadFirstKey, nil, 3, flagReadOnly)
-	RegisterCommand("ZCard", execZCard, ReadFirstKey, nil, 2, flagReadOnly)
-	RegisterCommand("ZRange", execZRange, ReadFirstKey, nil, -4, flagReadOnly)
-	RegisterCommand("ZRangeByScore", execZRangeByScore, ReadFirstKey, nil, -4, flagReadOnly)
-	RegisterCommand("ZRevRange", execZRevRange, ReadFirstKey, nil, -4, flagReadOnly)
-	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, ReadFirstKey, nil, -4, flagReadOnly)
-	RegisterCommand("ZPopMin", execZPopMin, WriteFirstKey, RollbackFirstKey, -2, flagWrite)
-	RegisterCommand("ZRem", execZRem, WriteFirstKey, undoZRem, -3, flagWrite)
-	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, WriteFirstKey, RollbackFirstKey, 4, flagWrite)
-	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, WriteFirstKey, RollbackFirstKey, 4, flagWrite)
+	RegisterCommand("ZAdd", execZAdd, WriteFirstKey, undoZAdd, -4, flagWrite|flagDenyOOM).category("@sortedset")
+	RegisterCommand("ZScore", execZScore, ReadFirstKey, nil, 3, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZIncrBy", execZIncrBy, WriteFirstKey, undoZIncr, 4, flagWrite|flagDenyOOM).category("@sortedset")
+	RegisterCommand("ZRank", execZRank, ReadFirstKey, nil, 3, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZCount", execZCount, ReadFirstKey, nil, 4, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZRevRank", execZRevRank, ReadFirstKey, nil, 3, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZCard", execZCard, ReadFirstKey, nil, 2, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZRange", execZRange, ReadFirstKey, nil, -4, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZRangeByScore", execZRangeByScore, ReadFirstKey, nil, -4, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZRevRange", execZRevRange, ReadFirstKey, nil, -4, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, ReadFirstKey, nil, -4, flagReadOnly).category("@sortedset")
+	RegisterCommand("ZPopMin", execZPopMin, WriteFirstKey, RollbackFirstKey, -2, flagWrite).category("@sortedset")
+	RegisterCommand("ZRem", execZRem, WriteFirstKey, undoZRem, -3, flagWrite).category("@sortedset")
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, WriteFirstKey, RollbackFirstKey, 4, flagWrite).category("@sortedset")
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, WriteFirstKey, RollbackFirstKey, 4, flagWrite).category("@sortedset")
 }
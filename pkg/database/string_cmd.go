@@ -1,12 +1,14 @@
 package database
 
 import (
+	"math"
 	"math/bits"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"slava/internal/aof"
 	. "slava/internal/data"
 	"slava/internal/interface/database"
 	"slava/internal/interface/slava"
@@ -132,11 +134,12 @@ func execGetEX(db *DB, args [][]byte) slava.Reply {
 		if ttl != UnlimitedTTl { // 说明key参数后面跟了EX或PX
 			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond) // Add函数传入的值是纳秒
 			db.Expire(key, expireTime)
-			// 加入AOF操作
-			// TODO
+			db.AddAof(aof.MakeExpireCmd(key, expireTime).Args)
+			db.notify(notifyGeneric, "expire", key)
 		} else { // len(args) > 1 并且ttl == unlimitedTTl说明此时key后面的参数一定是PERSIST，但是在上面已经执行过PERSIST的操作了
 			// 加入AOF操作
 			db.AddAof(utils.ToCmdLine3("persist", args[0]))
+			db.notify(notifyGeneric, "persist", key)
 		}
 	}
 	return protocol.MakeBulkReply(bytes)
@@ -176,7 +179,7 @@ func execSet(db *DB, args [][]byte) slava.Reply {
 				//if ttl != UnlimitedTTl { // 表明再遍历中已经遇到过一个EX或者PX命令，现在又遇到一个，所以报错
 				//	return &protocol.SyntaxErrReply{}
 				//}
-				if i+1 >= len(arg) {
+				if i+1 >= len(args) {
 					return &protocol.SyntaxErrReply{}
 				}
 				tllArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
@@ -189,7 +192,7 @@ func execSet(db *DB, args [][]byte) slava.Reply {
 				//if ttl != UnlimitedTTl {
 				//	return &protocol.SyntaxErrReply{}
 				//}
-				if i+1 >= len(arg) {
+				if i+1 >= len(args) {
 					return &protocol.SyntaxErrReply{}
 				}
 				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
@@ -203,7 +206,7 @@ func execSet(db *DB, args [][]byte) slava.Reply {
 			}
 		}
 	}
-	entity := &database.DataEntity{Data: value}
+	entity := &database.DataEntity{Data: makeStringData(value)}
 	result := 0
 	switch policy {
 	case UpsertPolicy: // 默认值，说明后面跟的不是NX和XX
@@ -219,13 +222,16 @@ func execSet(db *DB, args [][]byte) slava.Reply {
 		if ttl != UnlimitedTTl {
 			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
 			db.Expire(key, expireTime)
-			// TODO
-			// AOF
+			db.AddAof(utils.ToCmdLine3("set", args[0], args[1]))
+			db.AddAof(aof.MakeExpireCmd(key, expireTime).Args)
+			db.notify(notifyString, "set", key)
+			db.notify(notifyGeneric, "expire", key)
 		} else { // NX|XX
 			// 在Persist中会判断key是不是在ttMap中
 			db.Persist(key) // 对于某个原本带有生存时间（TTL）的键来说， 当 SET 命令成功在这个键上执行时， 这个键原有的 TTL 将被清除。
 			// AOF操作
 			db.AddAof(utils.ToCmdLine3("set", args...))
+			db.notify(notifyString, "set", key)
 		}
 	}
 	if result > 0 {
@@ -238,9 +244,12 @@ func execSet(db *DB, args [][]byte) slava.Reply {
 func execSetNX(db *DB, args [][]byte) slava.Reply {
 	key := string(args[0])
 	value := args[1]
-	entity := &database.DataEntity{Data: value}
+	entity := &database.DataEntity{Data: makeStringData(value)}
 	result := db.PutIfAbsent(key, entity)
-	db.AddAof(utils.ToCmdLine3("setnx", args...))
+	if result > 0 {
+		db.AddAof(utils.ToCmdLine3("setnx", args...))
+		db.notify(notifyString, "set", key)
+	}
 	return protocol.MakeIntReply(int64(result))
 }
 
@@ -256,13 +265,13 @@ func execSetEX(db *DB, args [][]byte) slava.Reply {
 		return protocol.MakeErrReply("ERR invalid expire time in setex")
 	}
 	ttl := ttlArg * 1000
-	entity := &database.DataEntity{Data: value}
+	entity := &database.DataEntity{Data: makeStringData(value)}
 	db.PutEntity(key, entity)
 	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
 	db.Expire(key, expireTime)
 	db.AddAof(utils.ToCmdLine3("setex", args...))
-	// AOF
-	// TODO
+	db.notify(notifyString, "set", key)
+	db.notify(notifyGeneric, "expire", key)
 	return &protocol.OkReply{}
 }
 
@@ -277,13 +286,13 @@ func execPSetEX(db *DB, args [][]byte) slava.Reply {
 	if ttlArg <= 0 {
 		return protocol.MakeErrReply("ERR invalid expire time in psetex")
 	}
-	entity := &database.DataEntity{Data: value}
+	entity := &database.DataEntity{Data: makeStringData(value)}
 	db.PutEntity(key, entity)
 	expireTime := time.Now().Add(time.Duration(ttlArg) * time.Millisecond)
 	db.Expire(key, expireTime)
 	db.AddAof(utils.ToCmdLine3("setex", args...))
-	// AOF
-	// TODO
+	db.notify(notifyString, "set", key)
+	db.notify(notifyGeneric, "expire", key)
 	return &protocol.OkReply{}
 }
 
@@ -315,11 +324,14 @@ func execMSet(db *DB, args [][]byte) slava.Reply {
 	}
 	for i := 0; i < size; i++ {
 		entity := &database.DataEntity{
-			Data: values[i],
+			Data: makeStringData(values[i]),
 		}
 		db.PutEntity(keys[i], entity)
 	}
 	db.AddAof(utils.ToCmdLine3("mset", args...))
+	for _, key := range keys {
+		db.notify(notifyString, "set", key)
+	}
 	return &protocol.OkReply{}
 }
 
@@ -364,10 +376,13 @@ func execMSetNX(db *DB, args [][]byte) slava.Reply {
 		}
 	}
 	for i, key := range keys {
-		entity := &database.DataEntity{Data: values[i]}
+		entity := &database.DataEntity{Data: makeStringData(values[i])}
 		db.PutEntity(key, entity)
 	}
 	db.AddAof(utils.ToCmdLine3("msetnx", args...))
+	for _, key := range keys {
+		db.notify(notifyString, "set", key)
+	}
 	return protocol.MakeIntReply(1)
 }
 
@@ -380,10 +395,11 @@ func execGetSet(db *DB, args [][]byte) slava.Reply {
 	if err != nil {
 		return err
 	}
-	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.PutEntity(key, &database.DataEntity{Data: makeStringData(value)})
 	// 修改了key的值，这时候就需要重置key的ttl
 	db.Persist(key)
 	db.AddAof(utils.ToCmdLine3("getset", args...))
+	db.notify(notifyString, "set", key)
 	if entity == nil { // 说明其中没有key的值
 		return &protocol.NullBulkReply{}
 	}
@@ -403,57 +419,40 @@ func execGetDel(db *DB, args [][]byte) slava.Reply {
 	// 删除key值
 	db.Remove(keys)
 	db.AddAof(utils.ToCmdLine3("getdel", args...))
+	db.notify(notifyGeneric, "del", keys)
 	return protocol.MakeBulkReply(value)
 }
 
 // Incr key所对应的value加一，如果不是value不是整数则失败
 func execIncr(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
-	value, err := db.getAsString(key)
-	if err != nil { // key值存在但是value不是string类型
-		return err
-	}
-	if value == nil { // key值不存在，没有找到key的值，先加入key的值key的值最开始是0，然后incr
-		db.PutEntity(key, &database.DataEntity{Data: []byte("1")})
-		db.AddAof(utils.ToCmdLine3("incr", args...))
-		return protocol.MakeIntReply(1)
-	}
-	Num, err2 := strconv.ParseInt(string(value), 10, 64)
-	if err2 != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	newValue := strconv.FormatInt(Num+1, 10)
-	db.PutEntity(key, &database.DataEntity{Data: []byte(newValue)})
-	db.AddAof(utils.ToCmdLine3("incr", args...))
-	return protocol.MakeIntReply(Num + 1)
+	return incrBy(db, "incr", args, 1)
 }
 
 // IncrBy key所对应的value加上给定的值，如果不是整数则失败
 func execIncrBy(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
 	rawDelta := string(args[1])
 	delta, err := strconv.ParseInt(rawDelta, 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	// 取key中对应的value
-	value, errorReply := db.getAsString(key)
-	if errorReply != nil { // key值存在但是value不是string类型
-		return errorReply
-	}
-	if value == nil { // key值不存在，没有找到key的值，先加入key的值key的值最开始是0，然后incr
-		db.PutEntity(key, &database.DataEntity{Data: args[1]})
-		db.AddAof(utils.ToCmdLine3("incrby", args...))
-		return protocol.MakeIntReply(delta)
+	return incrBy(db, "incrby", args, delta)
+}
+
+// incrBy 直接在int64上进行计算，key不存在时看作0，结果以整数编码存储
+func incrBy(db *DB, cmdName string, args [][]byte, delta int64) slava.Reply {
+	key := string(args[0])
+	val, _, errReply := db.getAsInteger(key)
+	if errReply != nil { // key值存在但是value不是整数
+		return errReply
 	}
-	Num, err2 := strconv.ParseInt(string(value), 10, 64)
-	if err2 != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	result := val + delta
+	if (delta > 0 && result < val) || (delta < 0 && result > val) {
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
 	}
-	newValue := strconv.FormatInt(Num+delta, 10)
-	db.PutEntity(key, &database.DataEntity{Data: []byte(newValue)})
-	db.AddAof(utils.ToCmdLine3("incrby", args...))
-	return protocol.MakeIntReply(Num + delta)
+	db.PutEntity(key, &database.DataEntity{Data: makeIntData(result)})
+	db.AddAof(utils.ToCmdLine3(cmdName, args...))
+	db.notify(notifyString, "incrby", key)
+	return protocol.MakeIntReply(result)
 }
 
 // IncrByFloat key所对应的value增加一个给定的浮点值
@@ -470,7 +469,8 @@ func execIncrByFloat(db *DB, args [][]byte) slava.Reply {
 	}
 	if value == nil { // 说明key不存在，则要添加key
 		db.PutEntity(key, &database.DataEntity{Data: args[1]})
-		db.AddAof(utils.ToCmdLine3("incrfloat", args...))
+		db.AddAof(utils.ToCmdLine3("incrbyfloat", args...))
+		db.notify(notifyString, "incrbyfloat", key)
 		return protocol.MakeBulkReply(args[1])
 	}
 	val, err := decimal.NewFromString(string(value))
@@ -478,59 +478,24 @@ func execIncrByFloat(db *DB, args [][]byte) slava.Reply {
 		return protocol.MakeErrReply("ERR value is not a valid float")
 	}
 	db.PutEntity(key, &database.DataEntity{Data: []byte(delta.Add(val).String())})
-	db.AddAof(utils.ToCmdLine3("incrfloat", args...))
+	db.AddAof(utils.ToCmdLine3("incrbyfloat", args...))
+	db.notify(notifyString, "incrbyfloat", key)
 	return protocol.MakeBulkReply([]byte(delta.Add(val).String()))
 }
 
 // Decr key所对应的value，减1
 func execDecr(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
-	value, errorReply := db.getAsString(key)
-	if errorReply != nil { // key值存在但是value的值不是string类型
-		return errorReply
-	}
-	if value == nil { // 表明key不存在
-		db.PutEntity(key, &database.DataEntity{Data: []byte("-1")})
-		db.AddAof(utils.ToCmdLine3("decr", args...))
-		return protocol.MakeIntReply(-1)
-	}
-	val, err := strconv.ParseInt(string(value), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val-1, 10))})
-	db.AddAof(utils.ToCmdLine3("decr", args...))
-	return protocol.MakeIntReply(val - 1)
+	return incrBy(db, "decr", args, -1)
 }
 
 // DecrBy key所对应的value减少给定的值
 func execDecrBy(db *DB, args [][]byte) slava.Reply {
-	key := string(args[0])
 	rawDelta := string(args[1])
 	delta, err := strconv.ParseInt(rawDelta, 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	value, errReply := db.getAsString(key)
-	if errReply != nil { // 找到key但是value不是string
-		return errReply
-	}
-	//val, err := strconv.ParseInt(string(value), 10, 64)
-	//if err != nil {
-	//	return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	//}
-	if value == nil { // 没有找到key
-		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(-delta, 10))})
-		db.AddAof(utils.ToCmdLine3("decrBy", args...))
-		return protocol.MakeIntReply(-delta)
-	}
-	val, err := strconv.ParseInt(string(value), 10, 64)
-	if err != nil {
+	if err != nil || delta == math.MinInt64 {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val-delta, 10))})
-	db.AddAof(utils.ToCmdLine3("decrBy", args...))
-	return protocol.MakeIntReply(val - delta)
+	return incrBy(db, "decrby", args, -delta)
 }
 
 // StrLen 返回key对应的value的长度
@@ -565,6 +530,7 @@ func execAppend(db *DB, args [][]byte) slava.Reply {
 	bytes = append(bytes, appendValue...)
 	db.PutEntity(key, &database.DataEntity{Data: bytes})
 	db.AddAof(utils.ToCmdLine3("append", args...))
+	db.notify(notifyString, "append", key)
 	return protocol.MakeIntReply(int64(len(bytes)))
 }
 
@@ -600,6 +566,7 @@ func execSetRange(db *DB, args [][]byte) slava.Reply {
 	}
 	db.PutEntity(key, &database.DataEntity{Data: value})
 	db.AddAof(utils.ToCmdLine3("setRange", args...))
+	db.notify(notifyString, "setrange", key)
 	return protocol.MakeIntReply(int64(len(value)))
 }
 
@@ -656,6 +623,8 @@ func execSetBit(db *DB, args [][]byte) slava.Reply {
 	former := bm.GetBit(offset)
 	bm.SetBit(offset, v)
 	db.PutEntity(key, &database.DataEntity{Data: bm.ToBytes()})
+	db.AddAof(utils.ToCmdLine3("setbit", args...))
+	db.notify(notifyString, "setbit", key)
 	return protocol.MakeIntReply(int64(former))
 }
 
@@ -812,52 +781,52 @@ func execBitPos(db *DB, args [][]byte) slava.Reply {
 // init的方法，将上面的方法注册到cmdTable中
 func init() {
 	// set k v ex time|px time|xx|nx
-	RegisterCommand("Set", execSet, WriteFirstKey, RollbackFirstKey, ArityNegativeTree, FlagWrite)
+	RegisterCommand("Set", execSet, WriteFirstKey, RollbackFirstKey, ArityNegativeTree, FlagWrite|FlagDenyOOM).category("@string")
 	// setnx k v
-	RegisterCommand("SetNX", execSetNX, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("SetNX", execSetNX, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// setex k time v
-	RegisterCommand("SetEX", execSetEX, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite)
+	RegisterCommand("SetEX", execSetEX, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite|FlagDenyOOM).category("@string")
 	// psetex k time v
-	RegisterCommand("PSetEX", execPSetEX, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite)
+	RegisterCommand("PSetEX", execPSetEX, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite|FlagDenyOOM).category("@string")
 	// mset k1 v1 k2 v2 k3 v3 ...
-	RegisterCommand("MSet", execMSet, prepareMSet, undoMSet, ArityNegativeTree, FlagWrite)
+	RegisterCommand("MSet", execMSet, prepareMSet, undoMSet, ArityNegativeTree, FlagWrite|FlagDenyOOM).keys(1, -1, 2).category("@string")
 	// msetnx k1 v1 k2 v2 k3 v3 ...
-	RegisterCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, ArityNegativeTree, FlagWrite)
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, ArityNegativeTree, FlagWrite|FlagDenyOOM).keys(1, -1, 2).category("@string")
 	//MGet k1 k2 k3 ...
-	RegisterCommand("MGet", execMGet, ReadAllKeys, nil, ArityNegativeTwo, FlagReadOnly)
+	RegisterCommand("MGet", execMGet, ReadAllKeys, nil, ArityNegativeTwo, FlagReadOnly).keys(1, -1, 1).category("@string")
 	// get k
-	RegisterCommand("Get", execGet, ReadFirstKey, nil, ArityTwo, FlagReadOnly)
+	RegisterCommand("Get", execGet, ReadFirstKey, nil, ArityTwo, FlagReadOnly).category("@string")
 	// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
-	RegisterCommand("GetEX", execGetEX, WriteFirstKey, RollbackFirstKey, ArityNegativeTree, FlagWrite)
+	RegisterCommand("GetEX", execGetEX, WriteFirstKey, RollbackFirstKey, ArityNegativeTree, FlagWrite).category("@string")
 	// getset key value
-	RegisterCommand("GetSet", execGetSet, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("GetSet", execGetSet, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// getdel key
-	RegisterCommand("GetDel", execGetDel, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite)
+	RegisterCommand("GetDel", execGetDel, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite).category("@string")
 	// incr key
-	RegisterCommand("Incr", execIncr, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite)
+	RegisterCommand("Incr", execIncr, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite|FlagDenyOOM).category("@string")
 	// incrby key int
-	RegisterCommand("IncrBy", execIncrBy, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("IncrBy", execIncrBy, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// incrbyfloat ket float
-	RegisterCommand("IncrByFloat", execIncrByFloat, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("IncrByFloat", execIncrByFloat, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// decr key
-	RegisterCommand("Decr", execDecr, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite)
+	RegisterCommand("Decr", execDecr, WriteFirstKey, RollbackFirstKey, ArityTwo, FlagWrite|FlagDenyOOM).category("@string")
 	// decr key int
-	RegisterCommand("DecrBy", execDecrBy, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("DecrBy", execDecrBy, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// strlen key
-	RegisterCommand("StrLen", execStrLen, ReadFirstKey, nil, ArityTwo, FlagReadOnly)
+	RegisterCommand("StrLen", execStrLen, ReadFirstKey, nil, ArityTwo, FlagReadOnly).category("@string")
 	// apend key value
-	RegisterCommand("Append", execAppend, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite)
+	RegisterCommand("Append", execAppend, WriteFirstKey, RollbackFirstKey, ArityTree, FlagWrite|FlagDenyOOM).category("@string")
 	// setrange key offset value
-	RegisterCommand("SetRange", execSetRange, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite)
+	RegisterCommand("SetRange", execSetRange, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite|FlagDenyOOM).category("@string")
 	// getrange key begin end
-	RegisterCommand("GetRange", execGetRange, ReadFirstKey, nil, ArityFour, FlagReadOnly)
+	RegisterCommand("GetRange", execGetRange, ReadFirstKey, nil, ArityFour, FlagReadOnly).category("@string")
 	// setbit key offset v
-	RegisterCommand("SetBit", execSetBit, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite)
+	RegisterCommand("SetBit", execSetBit, WriteFirstKey, RollbackFirstKey, ArityFour, FlagWrite|FlagDenyOOM).category("@string")
 	// getbit key offset
-	RegisterCommand("GetBit", execGetBit, ReadFirstKey, nil, ArityTree, FlagReadOnly)
+	RegisterCommand("GetBit", execGetBit, ReadFirstKey, nil, ArityTree, FlagReadOnly).category("@string")
 	// bitcount key (begin end)
-	RegisterCommand("BitCount", execBitCount, ReadFirstKey, nil, ArityNegativeTwo, FlagReadOnly)
+	RegisterCommand("BitCount", execBitCount, ReadFirstKey, nil, ArityNegativeTwo, FlagReadOnly).category("@string")
 	// bitpos key v begin end
-	RegisterCommand("BitPos", execBitPos, ReadFirstKey, nil, ArityNegativeTree, FlagReadOnly)
+	RegisterCommand("BitPos", execBitPos, ReadFirstKey, nil, ArityNegativeTree, FlagReadOnly).category("@string")
 
 }
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"strconv"
+
+	"slava/internal/protocol"
+)
+
+// string 类型的value有两种存储方式:
+// 能够无损转换为int64的value直接以int64存储, 其余的以[]byte存储
+
+const (
+	// sharedIntegersLen 小于该值的非负整数使用共享的对象
+	sharedIntegersLen = 10000
+	// maxIntStringLen is length of the longest int64 string, -9223372036854775808
+	maxIntStringLen = 20
+	// embstrSizeLimit is the size limit of embstr encoding in redis, it is only used by OBJECT ENCODING here
+	embstrSizeLimit = 44
+)
+
+// sharedIntegers 保存预先装箱的小整数，存入DataEntity时不需要再分配内存
+var sharedIntegers [sharedIntegersLen]interface{}
+
+func init() {
+	for i := range sharedIntegers {
+		sharedIntegers[i] = int64(i)
+	}
+}
+
+// makeIntData boxes integer, small integers share the same object
+func makeIntData(val int64) interface{} {
+	if val >= 0 && val < sharedIntegersLen {
+		return sharedIntegers[val]
+	}
+	return val
+}
+
+// makeStringData returns int64 if value could be converted to integer without losing anything, otherwise returns value itself
+func makeStringData(value []byte) interface{} {
+	if len(value) == 0 || len(value) > maxIntStringLen {
+		return value
+	}
+	val, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || strconv.FormatInt(val, 10) != string(value) {
+		return value
+	}
+	return makeIntData(val)
+}
+
+// stringEncoding returns encoding of string value as OBJECT ENCODING does
+func stringEncoding(data interface{}) string {
+	switch val := data.(type) {
+	case int64:
+		return "int"
+	case []byte:
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+	}
+	return "raw"
+}
+
+// getAsInteger returns integer value of key, exists is false if key not exists
+func (db *DB) getAsInteger(key string) (val int64, exists bool, errReply protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return 0, false, nil
+	}
+	switch data := entity.Data.(type) {
+	case int64:
+		return data, true, nil
+	case []byte:
+		val, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return 0, true, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		return val, true, nil
+	}
+	return 0, true, &protocol.WrongTypeErrReply{}
+}
@@ -478,3 +478,34 @@ func TestExEcGetRange(t *testing.T) {
 func TestExecSetBit(t *testing.T) {
 
 }
+
+func TestIntegerEncoding(t *testing.T) {
+	testDB := MakeDB()
+	execSet(testDB, utils.ToCmdLine("K1", "100"))
+	execSet(testDB, utils.ToCmdLine("K2", "0100"))
+	execIncrBy(testDB, utils.ToCmdLine("K1", "9223372036854775700"))
+	execIncr(testDB, utils.ToCmdLine("K3"))
+
+	expected := map[string]string{"K1": "int", "K2": "embstr", "K3": "int"}
+	for key, encoding := range expected {
+		entity, _ := testDB.GetEntity(key)
+		if actual := encodingOf(entity.Data); actual != encoding {
+			t.Errorf("expected %s encoding of %s, actual %s", encoding, key, actual)
+		}
+	}
+	entity, _ := testDB.GetEntity("K3")
+	if !reflect.DeepEqual(entity.Data, sharedIntegers[1]) {
+		t.Errorf("small integer should use shared object")
+	}
+	if !utils.BytesEquals(execGet(testDB, utils.ToCmdLine("K1")).ToBytes(),
+		protocol.MakeBulkReply([]byte("9223372036854775800")).ToBytes()) {
+		t.Errorf("Get integer value incorrect")
+	}
+	if !utils.BytesEquals(execIncrBy(testDB, utils.ToCmdLine("K1", "100")).ToBytes(),
+		protocol.MakeErrReply("ERR increment or decrement would overflow").ToBytes()) {
+		t.Errorf("overflow is not detected")
+	}
+	if !utils.BytesEquals(execAppend(testDB, utils.ToCmdLine("K3", "0")).ToBytes(), protocol.MakeIntReply(2).ToBytes()) {
+		t.Errorf("Append to integer value incorrect")
+	}
+}
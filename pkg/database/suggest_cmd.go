@@ -0,0 +1,167 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"slava/internal/aof"
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/datastruct/suggest"
+)
+
+// sugDefaultMax is number of suggestions returned by SUG.GET if MAX is not given
+const sugDefaultMax = 5
+
+// execSugAdd adds suggestion into dictionary, returns size of dictionary
+// sug.add key string score [INCR] [PAYLOAD payload]
+func execSugAdd(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	value := string(args[1])
+	score, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(score) {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	incr := false
+	var payload []byte
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "incr" {
+			incr = true
+		} else if arg == "payload" && i+1 < len(args) {
+			payload = args[i+1]
+			i++
+		} else {
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+
+	trie, _, errReply := db.getOrInitSuggest(key)
+	if errReply != nil {
+		return errReply
+	}
+	if incr {
+		// inf plus -inf
+		if entry, ok := trie.Get(value); ok && math.IsNaN(entry.Score+score) {
+			return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	trie.Add(value, score, incr, payload)
+	db.AddAof(utils.ToCmdLine3("sug.add", args...))
+	db.notify(notifyModule, "sug.add", key)
+	return protocol.MakeIntReply(int64(trie.Len()))
+}
+
+// execSugGet returns suggestions start with prefix ordered by score
+// sug.get key prefix [FUZZY] [WITHSCORES] [WITHPAYLOADS] [MAX num]
+func execSugGet(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	prefix := string(args[1])
+	fuzzy := false
+	withScores := false
+	withPayloads := false
+	max := sugDefaultMax
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "fuzzy" {
+			fuzzy = true
+		} else if arg == "withscores" {
+			withScores = true
+		} else if arg == "withpayloads" {
+			withPayloads = true
+		} else if arg == "max" && i+1 < len(args) {
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || n <= 0 {
+				return protocol.MakeErrReply("ERR max must be a positive integer")
+			}
+			max = n
+			i++
+		} else {
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+
+	trie, errReply := db.getAsSuggest(key)
+	if errReply != nil {
+		return errReply
+	}
+	if trie == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	entries := trie.Suggest(prefix, fuzzy, max)
+	result := make([]slava.Reply, 0, len(entries)*3)
+	for _, entry := range entries {
+		result = append(result, protocol.MakeBulkReply([]byte(entry.Value)))
+		if withScores {
+			score := strconv.FormatFloat(entry.Score, 'f', -1, 64)
+			result = append(result, protocol.MakeBulkReply([]byte(score)))
+		}
+		if withPayloads {
+			if entry.Payload == nil {
+				result = append(result, &protocol.NullBulkReply{})
+			} else {
+				result = append(result, protocol.MakeBulkReply(entry.Payload))
+			}
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// execSugDel removes suggestion, returns 1 if it existed
+// sug.del key string
+func execSugDel(db *DB, args [][]byte) slava.Reply {
+	key := string(args[0])
+	trie, errReply := db.getAsSuggest(key)
+	if errReply != nil {
+		return errReply
+	}
+	if trie == nil || !trie.Remove(string(args[1])) {
+		return protocol.MakeIntReply(0)
+	}
+	db.notify(notifyModule, "sug.del", key)
+	if trie.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	db.AddAof(utils.ToCmdLine3("sug.del", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// execSugLen returns size of dictionary
+// sug.len key
+func execSugLen(db *DB, args [][]byte) slava.Reply {
+	trie, errReply := db.getAsSuggest(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if trie == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(trie.Len()))
+}
+
+// execSugRestore replaces key with serialized dictionary, it is used by aof rewrite and undo logs
+// _sug.restore key payload
+func execSugRestore(db *DB, args [][]byte) slava.Reply {
+	trie, err := suggest.Unmarshal(args[1])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	db.PutEntity(string(args[0]), &database.DataEntity{
+		Data: trie,
+	})
+	db.AddAof(utils.ToCmdLine3(aof.SugRestore, args...))
+	db.notify(notifyModule, "sug.restore", string(args[0]))
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("Sug.Add", execSugAdd, WriteFirstKey, RollbackFirstKey, -4, flagWrite|flagDenyOOM).category("@suggest")
+	RegisterCommand("Sug.Get", execSugGet, ReadFirstKey, nil, -3, flagReadOnly).category("@suggest")
+	RegisterCommand("Sug.Del", execSugDel, WriteFirstKey, RollbackFirstKey, 3, flagWrite).category("@suggest")
+	RegisterCommand("Sug.Len", execSugLen, ReadFirstKey, nil, 2, flagReadOnly).category("@suggest")
+	RegisterCommand(aof.SugRestore, execSugRestore, WriteFirstKey, RollbackFirstKey, 3, flagWrite|flagDenyOOM).category("@suggest")
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"slava/internal/aof"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/idgenerator"
+	"slava/pkg/logger"
+	"slava/pkg/pubsub"
+	"slava/pkg/wildcard"
+)
+
+// durable topic 的消息会追加到有界的日志中, 订阅者断开后可以用 SUBSCRIBE topic FROM offset 续订
+// 日志以 list 形式保存在不属于 dbSet 的 topicDB 中, 键为 topic 名, 所以 FLUSHALL, DEL, SWAPDB 和淘汰都不会影响它,
+// 追加的记录通过内部命令 _topic.append 写入 aof 并复制给 slave, rdb 中保存在 aux 字段中, 见 rdb.AuxTopic
+// 每条记录为 "<offset>:<message>", offset 是 snowflake id, 单调递增且包含了发布时间
+// 集群模式下消息仍会投递到所有节点, 但只有 topic 哈希到的节点保存日志, 续订需要连接该节点, 见 SetTopicOwner
+
+// topicConfig holds patterns and retention of durable topics
+type topicConfig struct {
+	patterns []*wildcard.Pattern
+	maxLen   int
+	maxAge   time.Duration
+}
+
+// parseTopicConfig compiles durable-topics patterns, invalid patterns are skipped
+func parseTopicConfig(patterns []string, maxLen int, maxAgeSeconds int) *topicConfig {
+	cfg := &topicConfig{
+		maxLen: maxLen,
+		maxAge: time.Duration(maxAgeSeconds) * time.Second,
+	}
+	for _, raw := range patterns {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		pattern, err := wildcard.CompilePattern(raw)
+		if err != nil {
+			logger.Warn("invalid durable-topics pattern " + raw + ": " + err.Error())
+			continue
+		}
+		cfg.patterns = append(cfg.patterns, pattern)
+	}
+	return cfg
+}
+
+func (cfg *topicConfig) isDurable(topic string) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, pattern := range cfg.patterns {
+		if pattern.IsMatch(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExpired returns whether the message at offset is out of time retention
+func (cfg *topicConfig) isExpired(offset int64, now time.Time) bool {
+	if cfg.maxAge <= 0 {
+		return false
+	}
+	publishedAt, _, _ := idgenerator.Decode(offset)
+	return now.Sub(publishedAt) > cfg.maxAge
+}
+
+type topicEntry struct {
+	offset  int64
+	message []byte
+}
+
+func encodeTopicEntry(offset int64, message []byte) string {
+	return strconv.FormatInt(offset, 10) + ":" + string(message)
+}
+
+func decodeTopicEntry(raw string) (*topicEntry, bool) {
+	i := strings.IndexByte(raw, ':')
+	if i < 0 {
+		return nil, false
+	}
+	offset, err := strconv.ParseInt(raw[:i], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &topicEntry{offset: offset, message: []byte(raw[i+1:])}, true
+}
+
+// topicDB returns the db holding retained logs of durable topics
+func (server *Server) topicDB() *DB {
+	return server.topicLogs.Load().(*DB)
+}
+
+// appendTopic appends message to the retained log of topic, returns offset of the message
+func (server *Server) appendTopic(topic string, message []byte) (int64, error) {
+	offset, err := server.idGenerator.NextID()
+	if err != nil {
+		return 0, err
+	}
+	entry := encodeTopicEntry(offset, message)
+	server.appendTopicEntries(topic, []string{entry})
+	server.AddAof(0, aof.TopicToCmd(topic, []string{entry}).Args)
+	return offset, nil
+}
+
+// appendTopicEntries appends encoded entries to the retained log of topic and trims it by retention,
+// trimming is not propagated since replaying the entries trims the log as well
+func (server *Server) appendTopicEntries(topic string, entries []string) {
+	db := server.topicDB()
+	keys := []string{topic}
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+
+	// topicDB holds lists only
+	log, _, _ := db.getOrInitList(topic)
+	for _, entry := range entries {
+		log.RPush(entry)
+	}
+	if len(entries) > 0 {
+		server.seedOffset(entries[len(entries)-1])
+	}
+	// retention, the oldest messages are at the head
+	now := time.Now()
+	for log.Len() > 0 {
+		if server.topics.maxLen <= 0 || log.Len() <= server.topics.maxLen {
+			head, ok := decodeTopicEntry(log.GetByIndex(0).GetValue())
+			if ok && !server.topics.isExpired(head.offset, now) {
+				break
+			}
+		}
+		log.LPop()
+	}
+	if log.Len() == 0 {
+		db.Remove(topic)
+	}
+}
+
+// execTopicAppend replays _topic.append from aof or master
+// _topic.append topic entry [entry ...]
+func (server *Server) execTopicAppend(args [][]byte) slava.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply(aof.TopicAppend)
+	}
+	topic := string(args[0])
+	entries := make([]string, 0, len(args)-1)
+	for _, entry := range args[1:] {
+		entries = append(entries, string(entry))
+	}
+	server.topicLocker.Lock(topic)
+	defer server.topicLocker.UnLock(topic)
+	server.appendTopicEntries(topic, entries)
+	server.AddAof(0, utils.ToCmdLine3(aof.TopicAppend, args...))
+	return protocol.MakeOkReply()
+}
+
+// seedOffset makes offsets of new messages greater than offset of entry, which may be loaded from aof, rdb or master,
+// so that retained logs stay in order even if the clock rolled back during restart
+func (server *Server) seedOffset(entry string) {
+	if e, ok := decodeTopicEntry(entry); ok && server.idGenerator != nil {
+		server.idGenerator.Seed(e.offset)
+	}
+}
+
+// readTopic returns retained messages of topic whose offset is not less than from
+func (server *Server) readTopic(topic string, from int64) []*topicEntry {
+	db := server.topicDB()
+	keys := []string{topic}
+	db.RWLocks(nil, keys)
+	defer db.RWUnLocks(nil, keys)
+
+	log, _ := db.getAsList(topic)
+	if log == nil {
+		return nil
+	}
+	now := time.Now()
+	var result []*topicEntry
+	for _, node := range log.Range(0, log.Len()-1) {
+		entry, ok := decodeTopicEntry(node.GetValue())
+		if !ok || entry.offset < from || server.topics.isExpired(entry.offset, now) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// SetTopicOwner makes server retain durable topics owned by current node only, it is used by cluster
+// which delivers a message to every node while the topic hashes to one of them.
+// owner returns the node owning the topic, or empty string if it is current node
+func (server *Server) SetTopicOwner(owner func(topic string) string) {
+	server.topicOwner = owner
+}
+
+// ownerOfTopic returns the node retaining log of topic, empty string means current node
+func (server *Server) ownerOfTopic(topic string) string {
+	if server.topicOwner == nil {
+		return ""
+	}
+	return server.topicOwner(topic)
+}
+
+// publish sends message to subscribers, messages of durable topics are retained by the owner node before sending
+func (server *Server) publish(args [][]byte) slava.Reply {
+	if len(args) != 2 || !server.topics.isDurable(string(args[0])) || server.ownerOfTopic(string(args[0])) != "" {
+		return pubsub.Publish(server.hub, args)
+	}
+	topic := string(args[0])
+	// publishing and subscribing FROM offset of the same topic are serialized,
+	// so a resumed subscriber neither misses nor receives a message twice
+	// retained messages take memory just like write commands
+	if errReply := server.freeMemoryForWrite(); errReply != nil {
+		return errReply
+	}
+	server.topicLocker.Lock(topic)
+	defer server.topicLocker.UnLock(topic)
+	offset, err := server.appendTopic(topic, args[1])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return pubsub.PublishWithOffset(server.hub, args, []byte(strconv.FormatInt(offset, 10)))
+}
+
+// subscribeFrom subscribes a durable topic and replays retained messages since offset
+// SUBSCRIBE topic FROM offset, caller should make sure topic is durable
+func (server *Server) subscribeFrom(c slava.Connection, args [][]byte) slava.Reply {
+	topic := string(args[0])
+	from, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR offset is not an integer or out of range")
+	}
+	if owner := server.ownerOfTopic(topic); owner != "" {
+		return protocol.MakeErrReply("MOVED durable topic " + topic + " is served by " + owner)
+	}
+	server.topicLocker.Lock(topic)
+	defer server.topicLocker.UnLock(topic)
+	entries := server.readTopic(topic, from)
+	pubsub.SubscribeWithOffset(server.hub, c, topic)
+	for _, entry := range entries {
+		offset := []byte(strconv.FormatInt(entry.offset, 10))
+		_, _ = c.Write(pubsub.MakeMessage(topic, entry.message, offset))
+	}
+	return &protocol.NoReply{}
+}
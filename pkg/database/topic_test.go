@@ -0,0 +1,275 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+	"slava/config"
+	"slava/internal/aof"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+	"slava/pkg/pubsub"
+)
+
+func TestDurableTopic(t *testing.T) {
+	server := NewStandaloneServer()
+	server.topics = parseTopicConfig([]string{"events.*"}, 3, 0)
+	conn := connection.NewFakeConn()
+
+	var offsets []int64
+	for i := 0; i < 5; i++ {
+		server.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg"+strconv.Itoa(i)))
+		entries := server.readTopic("events.order", 0)
+		offsets = append(offsets, entries[len(entries)-1].offset)
+	}
+	// only the latest 3 messages are retained
+	entries := server.readTopic("events.order", 0)
+	if len(entries) != 3 || string(entries[0].message) != "msg2" {
+		t.Fatalf("expected msg2..msg4 retained, actual %d entries", len(entries))
+	}
+
+	sub := connection.NewFakeConn()
+	server.Exec(sub, utils.ToCmdLine("subscribe", "events.order", "FROM", strconv.FormatInt(offsets[3], 10)))
+	expected := "*3\r\n$9\r\nsubscribe\r\n$12\r\nevents.order\r\n:1\r\n"
+	for i := 3; i < 5; i++ {
+		offset := []byte(strconv.FormatInt(offsets[i], 10))
+		expected += string(pubsub.MakeMessage("events.order", []byte("msg"+strconv.Itoa(i)), offset))
+	}
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+
+	// live messages carry offset as well, but only for subscribers resumed FROM offset
+	plainSub := connection.NewFakeConn()
+	server.Exec(plainSub, utils.ToCmdLine("subscribe", "events.order"))
+	plainSub.Clean()
+	sub.Clean()
+	reply := server.Exec(conn, utils.ToCmdLine("publish", "events.order", "live"))
+	if code := reply.(*protocol.IntReply).Code; code != 2 {
+		t.Errorf("expected 2 receivers, actual %d", code)
+	}
+	expected = string(pubsub.MakeMessage("events.order", []byte("live"), nil))
+	if string(plainSub.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, plainSub.Bytes())
+	}
+	entries = server.readTopic("events.order", 0)
+	offset := []byte(strconv.FormatInt(entries[len(entries)-1].offset, 10))
+	expected = string(pubsub.MakeMessage("events.order", []byte("live"), offset))
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+
+	// FROM is an ordinary channel if the first channel is not a durable topic
+	sub.Clean()
+	server.Exec(sub, utils.ToCmdLine("subscribe", "news", "FROM", "0"))
+	expected = "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:2\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$4\r\nFROM\r\n:3\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\n0\r\n:4\r\n"
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, sub.Bytes())
+	}
+	server.Exec(conn, utils.ToCmdLine("publish", "news", "hello"))
+	if _, ok := server.topicDB().GetEntity("news"); ok {
+		t.Errorf("messages of non-durable topic should not be retained")
+	}
+}
+
+// topicOffsets returns offsets of retained messages of topic
+func topicOffsets(server *Server, topic string) []int64 {
+	var offsets []int64
+	for _, entry := range server.readTopic(topic, 0) {
+		offsets = append(offsets, entry.offset)
+	}
+	return offsets
+}
+
+func assertOffsets(t *testing.T, expected []int64, actual []int64) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected offsets %v, actual %v", expected, actual)
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("expected offsets %v, actual %v", expected, actual)
+		}
+	}
+}
+
+func TestTopicOutOfKeyspace(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	server.topics = parseTopicConfig([]string{"events.*"}, 3, 0)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg"))
+	expected := topicOffsets(server, "events.order")
+
+	if size := server.Exec(conn, utils.ToCmdLine("dbsize")).(*protocol.IntReply).Code; size != 0 {
+		t.Errorf("expected retained log invisible to user, actual %d keys", size)
+	}
+	server.Exec(conn, utils.ToCmdLine("del", "events.order"))
+	server.Exec(conn, utils.ToCmdLine("swapdb", "0", "1"))
+	server.Exec(conn, utils.ToCmdLine("flushall"))
+	assertOffsets(t, expected, topicOffsets(server, "events.order"))
+}
+
+func TestTopicPersistence(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		setupAofConfig(t, t.TempDir(), "appendonly.aof")
+		config.Properties.AofUseRdbPreamble = preamble
+		config.Properties.DurableTopics = []string{"events.*"}
+		config.Properties.TopicRetentionSize = 3
+		server := NewStandaloneServer()
+		conn := connection.NewFakeConn()
+		for i := 0; i < 3; i++ {
+			server.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg"+strconv.Itoa(i)))
+		}
+		// base file holds the log, and following messages are in incr file
+		if reply := server.Exec(conn, utils.ToCmdLine("rewriteaof")); !protocol.IsOKReply(reply) {
+			t.Fatalf("expected OK, actual %s", reply.ToBytes())
+		}
+		for i := 3; i < 5; i++ {
+			server.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg"+strconv.Itoa(i)))
+		}
+		expected := topicOffsets(server, "events.order")
+		server.Close()
+
+		loaded := NewStandaloneServer()
+		// trimmed by retention during replaying
+		assertOffsets(t, expected, topicOffsets(loaded, "events.order"))
+		loaded.Close()
+	}
+
+	// rdb
+	filename := config.Properties.RDBFilename
+	config.Properties.RDBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	defer func() {
+		config.Properties.RDBFilename = filename
+	}()
+	config.Properties.AppendOnly = false
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg"))
+	server.Exec(conn, utils.ToCmdLine("publish", "events.pay", "msg"))
+	if reply := server.Exec(conn, utils.ToCmdLine("save")); !protocol.IsOKReply(reply) {
+		t.Fatalf("expected OK, actual %s", reply.ToBytes())
+	}
+	loaded := NewStandaloneServer()
+	defer loaded.Close()
+	for _, topic := range []string{"events.order", "events.pay"} {
+		assertOffsets(t, topicOffsets(server, topic), topicOffsets(loaded, topic))
+	}
+	if size := loaded.Exec(conn, utils.ToCmdLine("dbsize")).(*protocol.IntReply).Code; size != 0 {
+		t.Errorf("expected retained logs not loaded into db, actual %d keys", size)
+	}
+	server.Close()
+}
+
+type topicListener struct {
+	cmdLines chan aof.CmdLine
+}
+
+func (listener *topicListener) Callback(cmdLines []aof.CmdLine) {
+	for _, cmdLine := range cmdLines {
+		listener.cmdLines <- cmdLine
+	}
+}
+
+func TestTopicReplication(t *testing.T) {
+	setupAofConfig(t, t.TempDir(), "appendonly.aof")
+	config.Properties.DurableTopics = []string{"events.*"}
+	master := NewStandaloneServer()
+	defer master.Close()
+	conn := connection.NewFakeConn()
+	master.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg0"))
+
+	// full sync
+	rdbFilename := filepath.Join(t.TempDir(), "sync.rdb")
+	listener := &topicListener{cmdLines: make(chan aof.CmdLine, 16)}
+	if err := master.persister.Rewrite2RDBForReplication(rdbFilename, listener, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer master.persister.RemoveListener(listener)
+	file, err := os.Open(rdbFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	aux := MakeAuxiliaryServer()
+	if err := aux.loadRDB(core.NewDecoder(file)); err != nil {
+		t.Fatal(err)
+	}
+	config.Properties.AppendDirname = filepath.Join(t.TempDir(), "slave")
+	slave := NewStandaloneServer()
+	defer slave.Close()
+	slave.loadAuxiliary(aux)
+	assertOffsets(t, topicOffsets(master, "events.order"), topicOffsets(slave, "events.order"))
+
+	// incremental sync, messages published after full sync are propagated as _topic.append
+	master.Exec(conn, utils.ToCmdLine("publish", "events.order", "msg1"))
+	atomic.StoreInt32(&slave.role, slaveRole)
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	timeout := time.After(time.Second)
+	for len(topicOffsets(slave, "events.order")) < 2 {
+		select {
+		case cmdLine := <-listener.cmdLines:
+			slave.Exec(masterConn, cmdLine)
+		case <-timeout:
+			t.Fatal("propagation timeout")
+		}
+	}
+	assertOffsets(t, topicOffsets(master, "events.order"), topicOffsets(slave, "events.order"))
+	// users can't append to retained logs of slave
+	reply := slave.Exec(connection.NewFakeConn(), utils.ToCmdLine(aof.TopicAppend, "events.order", "1:x"))
+	if !protocol.IsErrorReply(reply) {
+		t.Errorf("expected READONLY, actual %s", reply.ToBytes())
+	}
+}
+
+func TestReplicationRDBFailed(t *testing.T) {
+	setupAofConfig(t, t.TempDir(), "appendonly.aof")
+	config.Properties.DurableTopics = []string{"events.*"}
+	master := NewStandaloneServer()
+	defer master.Close()
+	listener := &topicListener{cmdLines: make(chan aof.CmdLine, 16)}
+	rdbFilename := filepath.Join(t.TempDir(), "missing", "sync.rdb")
+	if err := master.persister.Rewrite2RDBForReplication(rdbFilename, listener, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	master.Exec(connection.NewFakeConn(), utils.ToCmdLine("publish", "events.order", "msg0"))
+	// commands before the snapshot are passed to listeners before it is taken
+	if err := master.persister.Rewrite2RDBForReplication(filepath.Join(t.TempDir(), "sync.rdb"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.cmdLines) != 0 {
+		t.Error("expected listener removed after failure")
+	}
+}
+
+func TestTopicAppendInternal(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	server.topics = parseTopicConfig([]string{"events.*"}, 3, 0)
+	entry := encodeTopicEntry(1, []byte("msg"))
+	// _topic.append comes from aof or master only
+	reply := server.Exec(connection.NewFakeConn(), utils.ToCmdLine(aof.TopicAppend, "events.order", entry))
+	if !protocol.IsErrorReply(reply) {
+		t.Errorf("expected error, actual %s", reply.ToBytes())
+	}
+	if offsets := topicOffsets(server, "events.order"); len(offsets) != 0 {
+		t.Errorf("expected nothing appended, actual %v", offsets)
+	}
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	reply = server.Exec(masterConn, utils.ToCmdLine(aof.TopicAppend, "events.order", entry))
+	if !protocol.IsOKReply(reply) {
+		t.Errorf("expected OK, actual %s", reply.ToBytes())
+	}
+	assertOffsets(t, []int64{1}, topicOffsets(server, "events.order"))
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
-	"strings"
 )
 
 // StartMulti 开启事务
@@ -27,94 +30,224 @@ func DiscardMulti(conn slava.Connection) slava.Reply {
 	return protocol.MakeOkReply()
 }
 
-// exec提交事务
-func execMulti(db *DB, conn slava.Connection) slava.Reply {
+// execExec 提交事务
+func (server *Server) execExec(conn slava.Connection) slava.Reply {
 	if !conn.InMultiState() {
-		return protocol.MakeErrReply("ERR EXEC without Multi")
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
 	}
 	defer conn.SetMultiState(false)
 	if len(conn.GetTxErrors()) > 0 {
 		return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
 	}
-	cmdLines := conn.GetQueuedCmdLine()
-	return db.ExecMulti(conn, conn.GetWatching(), cmdLines)
+	return server.execMulti(conn, conn.GetWatching(), conn.GetQueuedCmdLine())
 }
 
-// ExecMulti 以原子性和隔离性方式执行多命令事务
-func (db *DB) ExecMulti(conn slava.Connection, watching map[string]uint32, cmdLines []CmdLine) slava.Reply {
-	// prepare
-	writeKeys := make([]string, 0, len(cmdLines)) // 提升性能，预分配内存
-	readKeys := make([]string, 0, len(cmdLines))
+// 事务可以跨越多个分数据库, 事务中的SELECT会改变之后命令所在的分数据库,
+// watching 中的key带有分数据库的编号, 格式为 "<dbIndex> <key>"
+
+func makeWatchKey(dbIndex int, key string) string {
+	return strconv.Itoa(dbIndex) + " " + key
+}
+
+func parseWatchKey(watchKey string) (int, string) {
+	i := strings.IndexByte(watchKey, ' ')
+	dbIndex, _ := strconv.Atoi(watchKey[:i])
+	return dbIndex, watchKey[i+1:]
+}
+
+// execWatch 监控当前分数据库中的key
+func (server *Server) execWatch(conn slava.Connection, args [][]byte) slava.Reply {
+	if conn.InMultiState() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	db, errReply := server.selectDB(conn.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	watching := conn.GetWatching()
+	for _, bkey := range args {
+		key := string(bkey)
+		watching[makeWatchKey(conn.GetDBIndex(), key)] = db.GetVersion(key)
+	}
+	return protocol.MakeOkReply()
+}
+
+// txKeys 记录事务在每个分数据库中需要加锁的key
+type txKeys struct {
+	writeKeys map[int][]string
+	readKeys  map[int][]string
+}
+
+func (tk *txKeys) addWrite(dbIndex int, keys ...string) {
+	tk.writeKeys[dbIndex] = append(tk.writeKeys[dbIndex], keys...)
+}
+
+func (tk *txKeys) addRead(dbIndex int, keys ...string) {
+	tk.readKeys[dbIndex] = append(tk.readKeys[dbIndex], keys...)
+}
+
+// dbIndexes returns indexes of involved databases in ascending order,
+// databases must be locked in this order to avoid dead lock with other transactions
+func (tk *txKeys) dbIndexes() []int {
+	indexes := make([]int, 0, len(tk.writeKeys)+len(tk.readKeys))
+	for i := range tk.writeKeys {
+		indexes = append(indexes, i)
+	}
+	for i := range tk.readKeys {
+		if _, ok := tk.writeKeys[i]; !ok {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// txCmd 是事务中的一条命令和它所在的分数据库
+type txCmd struct {
+	dbIndex int
+	cmdLine CmdLine
+}
+
+// txUndo 是需要在某个分数据库中执行的回滚命令
+type txUndo struct {
+	db       *DB
+	cmdLines []CmdLine
+}
+
+// prepareTx 分析事务中每条命令所在的分数据库以及读写的key
+func (server *Server) prepareTx(dbIndex int, cmdLines []CmdLine) ([]*txCmd, *txKeys) {
+	keys := &txKeys{
+		writeKeys: make(map[int][]string),
+		readKeys:  make(map[int][]string),
+	}
+	cmds := make([]*txCmd, 0, len(cmdLines))
 	for _, cmdLine := range cmdLines {
 		cmdName := strings.ToLower(string(cmdLine[0]))
-		cmd := cmdTable[cmdName]
-		prepare := cmd.prepare
-		write, read := prepare(cmdLine[1:])
-		writeKeys = append(writeKeys, write...)
-		readKeys = append(readKeys, read...)
-	}
-	// set Watch
-	watchingKeys := make([]string, 0, len(watching))
-	for key := range watching {
-		watchingKeys = append(watchingKeys, key)
-	}
-	// 将监控的key加入到readKeys的集合中，对writekeys和readkeys进行加锁，他们可能会存在相同的key
-	readKeys = append(readKeys, watchingKeys...)
-	db.locker.RWLocks(writeKeys, readKeys)
-	defer db.locker.RWUnLocks(writeKeys, readKeys)
-	// 如果watch keys 有发生改变，则回滚事务，并退出
-	if isWatchingChanged(db, watching) { // 说明监控的key有别的线程修改过，则回滚
+		switch cmdName {
+		case "select":
+			// index has been checked before enqueue
+			dbIndex, _ = strconv.Atoi(string(cmdLine[1]))
+		case "move":
+			keys.addWrite(dbIndex, string(cmdLine[1]))
+			if destIndex, errReply := parseMoveDest(server, dbIndex, cmdLine[1:]); errReply == nil {
+				keys.addWrite(destIndex, string(cmdLine[1]))
+			}
+		case "copy":
+			keys.addRead(dbIndex, string(cmdLine[1]))
+			if destIndex, _, errReply := parseCopyOptions(server, dbIndex, cmdLine[1:]); errReply == nil {
+				keys.addWrite(destIndex, string(cmdLine[2]))
+			}
+		default:
+			write, read := GetRelatedKeys(cmdLine)
+			keys.addWrite(dbIndex, write...)
+			keys.addRead(dbIndex, read...)
+		}
+		cmds = append(cmds, &txCmd{dbIndex: dbIndex, cmdLine: cmdLine})
+	}
+	return cmds, keys
+}
+
+// execTxCmd 执行事务中的一条命令并返回它的回滚命令, 调用者需要持有锁
+func (server *Server) execTxCmd(cmd *txCmd) (slava.Reply, []*txUndo) {
+	db := server.mustSelectDB(cmd.dbIndex)
+	args := cmd.cmdLine[1:]
+	switch strings.ToLower(string(cmd.cmdLine[0])) {
+	case "select":
+		return protocol.MakeOkReply(), nil
+	case "move":
+		destIndex, errReply := parseMoveDest(server, cmd.dbIndex, args)
+		if errReply != nil {
+			return errReply, nil
+		}
+		key := string(args[0])
+		destDB := server.mustSelectDB(destIndex)
+		undo := []*txUndo{
+			{db: db, cmdLines: RollbackGivenKeys(db, key)},
+			{db: destDB, cmdLines: RollbackGivenKeys(destDB, key)},
+		}
+		return moveWithLock(server, cmd.dbIndex, destIndex, args), undo
+	case "copy":
+		destIndex, _, errReply := parseCopyOptions(server, cmd.dbIndex, args)
+		if errReply != nil {
+			return errReply, nil
+		}
+		destDB := server.mustSelectDB(destIndex)
+		undo := []*txUndo{{db: destDB, cmdLines: RollbackGivenKeys(destDB, string(args[1]))}}
+		return copyWithLock(server, cmd.dbIndex, args), undo
+	}
+	undo := []*txUndo{{db: db, cmdLines: db.GetUndoLogs(cmd.cmdLine)}}
+	return db.execWithLock(cmd.cmdLine), undo
+}
+
+// execMulti 以原子性和隔离性方式执行多命令事务, 事务可以跨越多个分数据库
+func (server *Server) execMulti(conn slava.Connection, watching map[string]uint32, cmdLines []CmdLine) slava.Reply {
+	server.swapMu.RLock()
+	defer server.swapMu.RUnlock()
+	// prepare
+	cmds, keys := server.prepareTx(conn.GetDBIndex(), cmdLines)
+	// 将监控的key加入到对应分数据库的readKeys中
+	for watchKey := range watching {
+		dbIndex, key := parseWatchKey(watchKey)
+		keys.addRead(dbIndex, key)
+	}
+	// 按分数据库编号从小到大加锁
+	dbIndexes := keys.dbIndexes()
+	for _, i := range dbIndexes {
+		server.mustSelectDB(i).RWLocks(keys.writeKeys[i], keys.readKeys[i])
+	}
+	aborted := false
+	defer func() {
+		// keyspace events are published after all keys are unlocked, those of a rolled back transaction are dropped
+		var events []*keyEvent
+		for _, i := range dbIndexes {
+			events = append(events, server.mustSelectDB(i).rwUnLocksTakeEvents(keys.writeKeys[i], keys.readKeys[i])...)
+		}
+		if !aborted {
+			publishEvents(events)
+		}
+	}()
+	// 如果watch keys 有发生改变，则放弃执行事务
+	if server.isWatchingChanged(watching) {
 		return protocol.MakeEmptyMultiBulkReply()
 	}
-	// 如果监控的key没有被别的线程修改则执行其中的事务
-	results := make([]slava.Reply, 0, len(cmdLines)) // 记录所有事务所有指令的返回结果
-	aborted := false                                 // 取消事务，开始先默认false
-	undoCmdLines := make([][]CmdLine, 0, len(cmdLines))
-	for _, cmdLine := range cmdLines {
-		undoCmdLines = append(undoCmdLines, db.GetUndoLogs(cmdLine))
-		result := db.execWithLock(cmdLine)
+	results := make([]slava.Reply, 0, len(cmds)) // 记录所有事务所有指令的返回结果
+	undoLogs := make([][]*txUndo, 0, len(cmds))
+	for _, cmd := range cmds {
+		result, undo := server.execTxCmd(cmd)
 		if protocol.IsErrorReply(result) {
-			aborted = true // 如果事务执行出现错误，则aborted变成true
 			// 执行出错的命令，不回滚
-			undoCmdLines = undoCmdLines[:len(undoCmdLines)-1]
-			break // 只要在事务中有一个命令行出错则停止循环
+			aborted = true
+			break
 		}
-		// 没有出错则将result加入到结果的数组中
+		undoLogs = append(undoLogs, undo)
 		results = append(results, result)
 	}
-	// 如果中止标志部位true则返回结果，如果中止标志为true，则要进行回滚
 	if !aborted {
-		db.addVersion(writeKeys...)
+		for _, i := range dbIndexes {
+			server.mustSelectDB(i).addVersion(keys.writeKeys[i]...)
+		}
+		// SELECT in transaction changes the selected database of connection
+		if len(cmds) > 0 {
+			conn.SelectDB(cmds[len(cmds)-1].dbIndex)
+		}
 		return protocol.MakeMultiRawReply(results)
 	}
-	// 如果种植条件aborted为true则要进行回滚
-	size := len(undoCmdLines)
-	for i := size - 1; i >= 0; i-- {
-		curCmdLines := undoCmdLines[i]
-		if len(curCmdLines) == 0 {
-			continue
-		}
-		for _, cmdLine := range curCmdLines {
-			db.execWithLock(cmdLine)
+	// 从后往前执行回滚命令
+	for i := len(undoLogs) - 1; i >= 0; i-- {
+		for _, undo := range undoLogs[i] {
+			for _, cmdLine := range undo.cmdLines {
+				undo.db.execWithLock(cmdLine)
+			}
 		}
 	}
 	return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
 }
 
-// Watch 监控key
-func Watch(db *DB, conn slava.Connection, args [][]byte) slava.Reply {
-	watching := conn.GetWatching()
-	for _, bkey := range args {
-		key := string(bkey)
-		watching[key] = db.GetVersion(key)
-	}
-	return protocol.MakeOkReply()
-}
-
-func isWatchingChanged(db *DB, watching map[string]uint32) bool {
-	for key, val := range watching {
-		currentVersion := db.GetVersion(key)
-		if currentVersion != val { // 说明修改过
+func (server *Server) isWatchingChanged(watching map[string]uint32) bool {
+	for watchKey, val := range watching {
+		dbIndex, key := parseWatchKey(watchKey)
+		db, errReply := server.selectDB(dbIndex)
+		if errReply != nil || db.GetVersion(key) != val { // 说明修改过
 			return true
 		}
 	}
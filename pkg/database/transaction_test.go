@@ -0,0 +1,307 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"slava/internal/aof"
+	"slava/internal/interface/database"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+	"slava/pkg/datastruct/graph"
+	"slava/pkg/datastruct/suggest"
+)
+
+func assertReply(t *testing.T, actual slava.Reply, expected slava.Reply) {
+	t.Helper()
+	if !utils.BytesEquals(actual.ToBytes(), expected.ToBytes()) {
+		t.Errorf("expected %q, actual %q", expected.ToBytes(), actual.ToBytes())
+	}
+}
+
+func TestMultiDBTransaction(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("move", "K1", "1")), protocol.MakeQueuedReply())
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("select", "1")), protocol.MakeQueuedReply())
+	server.Exec(conn, utils.ToCmdLine("copy", "K1", "K2", "db", "2"))
+	server.Exec(conn, utils.ToCmdLine("set", "K3", "V3"))
+	reply := server.Exec(conn, utils.ToCmdLine("exec"))
+	if protocol.IsErrorReply(reply) {
+		t.Fatalf("exec failed: %s", reply.ToBytes())
+	}
+	if conn.GetDBIndex() != 1 {
+		t.Errorf("SELECT in transaction should change selected db, actual %d", conn.GetDBIndex())
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("get", "K1")), protocol.MakeBulkReply([]byte("V1")))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("get", "K3")), protocol.MakeBulkReply([]byte("V3")))
+	server.Exec(conn, utils.ToCmdLine("select", "2"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("get", "K2")), protocol.MakeBulkReply([]byte("V1")))
+	server.Exec(conn, utils.ToCmdLine("select", "0"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("exists", "K1")), protocol.MakeIntReply(0))
+}
+
+func TestMultiDBWatch(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("watch", "K1"))
+	server.Exec(conn, utils.ToCmdLine("select", "0"))
+	// the same key in another database should not affect watching
+	server.Exec(other, utils.ToCmdLine("set", "K1", "V0"))
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("set", "K2", "V2"))
+	reply := server.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := reply.(*protocol.EmptyMultiBulkReply); ok {
+		t.Errorf("transaction should not be aborted by key in other database")
+	}
+
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("watch", "K1"))
+	server.Exec(other, utils.ToCmdLine("select", "1"))
+	server.Exec(other, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("set", "K2", "V2"))
+	reply = server.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := reply.(*protocol.EmptyMultiBulkReply); !ok {
+		t.Errorf("transaction should be aborted, actual %s", reply.ToBytes())
+	}
+}
+
+func TestSwapDBInvalidatesWatch(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	// K1 is written once in both databases
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V0"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("select", "0"))
+
+	server.Exec(conn, utils.ToCmdLine("watch", "K1"))
+	server.Exec(connection.NewFakeConn(), utils.ToCmdLine("swapdb", "0", "1"))
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("set", "K2", "V2"))
+	reply := server.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := reply.(*protocol.EmptyMultiBulkReply); !ok {
+		t.Errorf("transaction should be aborted by swapdb, actual %s", reply.ToBytes())
+	}
+}
+
+func TestMultiDBRollback(t *testing.T) {
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("set", "K1", "V1"))
+	server.Exec(conn, utils.ToCmdLine("expire", "K1", "100"))
+	server.Exec(conn, utils.ToCmdLine("zadd", "Z", "1", "a"))
+
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	server.Exec(conn, utils.ToCmdLine("move", "K1", "1"))
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "K2", "V2"))
+	server.Exec(conn, utils.ToCmdLine("select", "0"))
+	server.Exec(conn, utils.ToCmdLine("get", "Z")) // wrong type
+	reply := server.Exec(conn, utils.ToCmdLine("exec"))
+	if !protocol.IsErrorReply(reply) {
+		t.Fatalf("transaction should fail, actual %s", reply.ToBytes())
+	}
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("get", "K1")), protocol.MakeBulkReply([]byte("V1")))
+	if ttl := server.Exec(conn, utils.ToCmdLine("ttl", "K1")).(*protocol.IntReply).Code; ttl <= 0 {
+		t.Errorf("ttl should be restored, actual %d", ttl)
+	}
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("dbsize")), protocol.MakeIntReply(0))
+}
+
+// loadRollbackFixture resets server to contain keys of every type
+func loadRollbackFixture(server *Server, conn slava.Connection) {
+	for _, cmdLine := range [][]string{
+		{"flushall"},
+		// db 1 is the destination of MOVE and COPY
+		{"select", "1"},
+		{"set", "counter", "1"},
+		{"select", "0"},
+		{"set", "str", "hello"},
+		{"expire", "str", "100"},
+		{"set", "counter", "10"},
+		{"rpush", "list", "a", "b", "c"},
+		{"rpush", "fail", "x"},
+		{"zadd", "zset", "1", "a", "2", "b", "3", "c"},
+		{"sadd", "set", "1", "2", "3"},
+		{"hmset", "hash", "f1", "v1", "f2", "v2"},
+		{"graph.addedge", "graph", "a", "b"},
+		{"sug.add", "sug", "hello", "1"},
+	} {
+		if reply := server.Exec(conn, utils.ToCmdLine(cmdLine...)); protocol.IsErrorReply(reply) {
+			panic(fmt.Sprintf("load fixture failed: %v %s", cmdLine, reply.ToBytes()))
+		}
+	}
+}
+
+// snapshotKeyspace serializes every key and its ttl in the given db
+func snapshotKeyspace(server *Server, dbIndex int) map[string]string {
+	result := make(map[string]string)
+	server.ForEach(dbIndex, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		var value string
+		if g, ok := entity.Data.(*graph.Graph); ok {
+			// graph marshal is not ordered
+			var edges []string
+			g.ForEachEdge(func(from string, to string, props map[string]string) bool {
+				edges = append(edges, from+"->"+to)
+				return true
+			})
+			sort.Strings(edges)
+			value = strings.Join(edges, ",")
+		} else {
+			value = string(aof.EntityToCmd(key, entity).ToBytes())
+		}
+		if expiration != nil {
+			value += fmt.Sprintf(" ttl:%d", expiration.UnixNano()/int64(time.Millisecond))
+		}
+		result[key] = value
+		return true
+	})
+	return result
+}
+
+func TestRollback(t *testing.T) {
+	g := graph.Make()
+	g.AddEdge("x", "y", nil)
+	trie := suggest.Make()
+	trie.Add("world", 2, false, nil)
+
+	cases := map[string][]string{
+		"del":              {"str", "list", "set", "nonexistent"},
+		"unlink":           {"str", "zset", "hash", "nonexistent"},
+		"expire":           {"counter", "10"},
+		"expireat":         {"str", "2000000000"},
+		"pexpire":          {"list", "10000"},
+		"pexpireat":        {"str", "2000000000000"},
+		"persist":          {"str"},
+		"rename":           {"str", "counter"},
+		"renamenx":         {"str", "str2"},
+		"rpush":            {"list", "d", "e"},
+		"lpush":            {"newlist", "d", "e"},
+		"rpop":             {"list"},
+		"lpop":             {"list"},
+		"zadd":             {"zset", "10", "a", "4", "d"},
+		"zincrby":          {"zset", "5", "b"},
+		"zpopmin":          {"zset", "2"},
+		"zrem":             {"zset", "a", "c"},
+		"zremrangebyscore": {"zset", "1", "2"},
+		"zremrangebyrank":  {"zset", "0", "-1"},
+		"sadd":             {"set", "4", "x"},
+		"hmset":            {"hash", "f1", "new", "f3", "v3"},
+		"set":              {"str", "new", "EX", "10"},
+		"setnx":            {"newstr", "v"},
+		"setex":            {"counter", "10", "v"},
+		"psetex":           {"newstr", "10000", "v"},
+		"mset":             {"str", "v1", "newstr", "v2"},
+		"msetnx":           {"newstr", "v1", "newstr2", "v2"},
+		"getex":            {"str", "PERSIST"},
+		"getset":           {"counter", "v"},
+		"getdel":           {"str"},
+		"incr":             {"counter"},
+		"incrby":           {"newcounter", "5"},
+		"incrbyfloat":      {"counter", "1.5"},
+		"decr":             {"counter"},
+		"decrby":           {"counter", "100"},
+		"append":           {"str", " world"},
+		"setrange":         {"str", "2", "LLO"},
+		"setbit":           {"str", "1", "0"},
+		"graph.addedge":    {"graph", "b", "c"},
+		"graph.deledge":    {"graph", "a", "b"},
+		"graph.setnode":    {"graph", "a", "name", "A"},
+		aof.GraphRestore:   {"graph", string(g.Marshal())},
+		"move":             {"str", "1"},
+		"copy":             {"str", "counter", "DB", "1", "REPLACE"},
+		"select":           {"1"},
+		"sug.add":          {"sug", "help", "2"},
+		"sug.del":          {"sug", "hello"},
+		aof.SugRestore:     {"newsug", string(trie.Marshal())},
+	}
+
+	// commands handled by Server which could not be rolled back are refused in MULTI
+	refused := map[string][]string{
+		"flushall": nil,
+		"flushdb":  nil,
+		"swapdb":   {"0", "1"},
+		"id.next":  nil,
+	}
+
+	for name, cmd := range cmdTable {
+		if cmd.flags&flagWrite == 0 {
+			continue
+		}
+		if cmd.undo == nil {
+			t.Errorf("write command %s has no undo function", name)
+		}
+		if _, ok := cases[name]; !ok {
+			t.Errorf("write command %s is not covered by rollback test", name)
+		}
+	}
+	for name, cmd := range sysCmdTable {
+		if cmd.flags&flagWrite == 0 {
+			continue
+		}
+		_, covered := cases[name]
+		if _, ok := refused[name]; !ok && !covered {
+			t.Errorf("write command %s is not covered by rollback test", name)
+		}
+	}
+
+	server := NewStandaloneServer()
+	conn := connection.NewFakeConn()
+	// internal commands are accepted from master only
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	for name, args := range cases {
+		cmdLine := utils.ToCmdLine2(name, args...)
+		c := conn
+		if isInternalCommand(name) {
+			c = masterConn
+		}
+		// the command itself should succeed, otherwise there is nothing to rollback
+		loadRollbackFixture(server, conn)
+		if reply := server.Exec(c, cmdLine); protocol.IsErrorReply(reply) {
+			t.Errorf("%s failed: %s", name, reply.ToBytes())
+			continue
+		}
+
+		loadRollbackFixture(server, conn)
+		before := []map[string]string{snapshotKeyspace(server, 0), snapshotKeyspace(server, 1)}
+		server.Exec(c, utils.ToCmdLine("multi"))
+		server.Exec(c, cmdLine)
+		// write in the db selected by the command, then fail in db 0
+		server.Exec(c, utils.ToCmdLine("set", "str", "changed"))
+		server.Exec(c, utils.ToCmdLine("select", "0"))
+		server.Exec(c, utils.ToCmdLine("get", "fail")) // wrong type
+		if reply := server.Exec(c, utils.ToCmdLine("exec")); !protocol.IsErrorReply(reply) {
+			t.Errorf("%s: transaction should fail, actual %s", name, reply.ToBytes())
+			continue
+		}
+		after := []map[string]string{snapshotKeyspace(server, 0), snapshotKeyspace(server, 1)}
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: keyspace is not restored\nbefore: %v\nafter:  %v", name, before, after)
+		}
+	}
+
+	for name, args := range refused {
+		server.Exec(conn, utils.ToCmdLine("multi"))
+		if reply := server.Exec(conn, utils.ToCmdLine2(name, args...)); !protocol.IsErrorReply(reply) {
+			t.Errorf("%s should be refused in MULTI, actual %s", name, reply.ToBytes())
+		}
+		server.Exec(conn, utils.ToCmdLine("discard"))
+	}
+}
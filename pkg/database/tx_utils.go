@@ -3,6 +3,7 @@ package database
 import (
 	"strconv"
 
+	"slava/internal/aof"
 	"slava/internal/utils"
 )
 
@@ -36,11 +37,19 @@ func RollbackFirstKey(db *DB, args [][]byte) []CmdLine {
 func RollbackGivenKeys(db *DB, keys ...string) []CmdLine {
 	var undoCmdLine [][][]byte
 	for _, key := range keys {
-		_, ok := db.GetEntity(key)
+		entity, ok := db.GetEntity(key)
+		undoCmdLine = append(undoCmdLine, utils.ToCmdLine("DEL", key))
 		if !ok {
-			undoCmdLine = append(undoCmdLine, utils.ToCmdLine("DEL", key))
-		} else {
-			// TODO
+			continue
+		}
+		// restore value and ttl of existed key
+		if cmd := aof.EntityToCmd(key, entity); cmd != nil {
+			// string value may be modified in place by commands like SETRANGE, so the undo log keeps a copy
+			args := make(CmdLine, len(cmd.Args))
+			for i, arg := range cmd.Args {
+				args[i] = append([]byte(nil), arg...)
+			}
+			undoCmdLine = append(undoCmdLine, args, toTTLCmd(db, key).Args)
 		}
 	}
 	return undoCmdLine
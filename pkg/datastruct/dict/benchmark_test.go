@@ -5,8 +5,6 @@ import (
 	"strconv"
 	"sync"
 	"testing"
-
-	"slava/internal/data"
 )
 
 // 读与写各自操作数
@@ -23,7 +21,7 @@ func BenchmarkTestConcurrentMap(b *testing.B) {
 		}
 
 		// 新建一个ConcurrentMap
-		pMap := MakeConcurrent(data.DataDictSize)
+		pMap := MakeConcurrent(1 << 16)
 
 		// set到map中
 		for k, v := range testKV {
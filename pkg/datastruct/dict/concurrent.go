@@ -2,11 +2,12 @@ package dict
 
 import (
 	"math"
+	"math/bits"
 	"math/rand"
+	"runtime"
 	db "slava/internal/interface/database"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	. "slava/internal/data"
 )
@@ -15,21 +16,53 @@ import (
 
 //解决并发读写map的思路是加锁，或者把一个map切分成若干个小map，对key进行哈希。
 
-// 实现内存KV存储数据库将采用分段锁策略，将key分散到固定数量的shard中避免rehash操作。
+// 实现内存KV存储数据库将采用分段锁策略，将key分散到若干个shard中。
 // shard是有锁保护的map，当shard进行rehash时会阻塞shard内的读写，但是不会对别的shard造成影响
 
+// shard的数量会随key的数量伸缩, 与redis的渐进式rehash相同, 扩容/缩容时同时存在新旧两张shard表,
+// 每次写操作顺带将一个旧shard中的key迁移到新表, 迁移只锁住该旧shard, 不会阻塞整个dict
+// 已迁移的旧shard会被标记为migrated, 读写时若发现shard已迁移则到新表中查找
+
 // 不使用sync.Map的原因，sync.Map适用于读多写少的场景
 // m.dirty刚被提升后会将m.read复制到新的m.dirty中，在数据量较大的情况下复制操作会阻塞所有协程，存在较大的隐患
 
+const (
+	// shardLoad is the average number of keys per shard after resizing
+	shardLoad = 4
+	// the table grows if there are more than growLoad keys per shard on average,
+	// and shrinks if there are more than twice as many shards as keys
+	growLoad = 16
+)
+
 type ConcurrentDict struct {
-	table      []*shard // 表示所有的map的集合
-	count      int32
-	shardCount int // shard的数量，即map的数量
+	table atomic.Value // *shardTable
+	count int32
+	// minShardCount is the initial number of shards, the table never shrinks below it
+	minShardCount int
+	// resizeMu serializes starting and finishing resizes, it never blocks reading and writing keys
+	resizeMu sync.Mutex
+	// iterators is number of running ForEach and Scan, rehashing is paused while iterating
+	// so that keys won't be moved to a visited shard or visited twice
+	iterators int32
+	// rehashers is number of goroutines migrating shards
+	rehashers int32
+}
+
+// shardTable 在rehash期间, old为旧表, shards为新表, 非rehash期间old为nil
+type shardTable struct {
+	shards []*shard
+	old    []*shard
+	// rehashIdx is index of the next old shard to be migrated
+	rehashIdx int32
+	// migrated is number of old shards migrated
+	migrated int32
 }
 
 type shard struct {
 	m     map[string]interface{}
 	mutex sync.RWMutex
+	// migrated is true after keys in shard have been moved to the new table
+	migrated bool
 }
 
 func computeCapacity(param int) (size int) {
@@ -48,18 +81,22 @@ func computeCapacity(param int) (size int) {
 	return n + 1
 }
 
-// 初始化ConcurrentDict
-func MakeConcurrent(param int) *ConcurrentDict {
-	shardCount := computeCapacity(param)
-	table := make([]*shard, shardCount)
+func makeShards(shardCount int) []*shard {
+	shards := make([]*shard, shardCount)
 	for i := 0; i < shardCount; i++ {
-		table[i] = &shard{m: make(map[string]interface{})}
+		shards[i] = &shard{m: make(map[string]interface{})}
 	}
+	return shards
+}
+
+// 初始化ConcurrentDict, param为初始的shard数量
+func MakeConcurrent(param int) *ConcurrentDict {
+	shardCount := computeCapacity(param)
 	cd := &ConcurrentDict{
-		table:      table,
-		count:      0,
-		shardCount: shardCount,
+		count:         0,
+		minShardCount: shardCount,
 	}
+	cd.table.Store(&shardTable{shards: makeShards(shardCount)})
 	return cd
 }
 
@@ -74,37 +111,62 @@ func fnv32(key string) uint32 {
 	return hash
 }
 
-// 定位到某个shard中
-func (dict *ConcurrentDict) spread(hashCode uint32) uint32 {
+func (dict *ConcurrentDict) loadTable() *shardTable {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	tableSize := uint32(len(dict.table))
-	return (tableSize - 1) & hashCode
+	return dict.table.Load().(*shardTable)
 }
 
-// 获得对应的shard，也就是拿到相应的map
-func (dict *ConcurrentDict) getShard(index uint32) *shard {
-	if dict == nil {
-		panic("dict is nil")
+// 定位到某个shard中
+func spread(shards []*shard, hashCode uint32) *shard {
+	return shards[uint32(len(shards)-1)&hashCode]
+}
+
+// lock locks shard, returns false and unlocks it if the shard has been migrated
+func (s *shard) lock(write bool) bool {
+	if write {
+		s.mutex.Lock()
+	} else {
+		s.mutex.RLock()
+	}
+	if !s.migrated {
+		return true
+	}
+	s.unlock(write)
+	return false
+}
+
+func (s *shard) unlock(write bool) {
+	if write {
+		s.mutex.Unlock()
+	} else {
+		s.mutex.RUnlock()
+	}
+}
+
+// lockShard 获得key所在的shard并加锁, rehash期间key可能在旧表或新表中
+// 若读到的是过时的表, 其中的shard一定已被标记为migrated, 重新读取表即可
+func (dict *ConcurrentDict) lockShard(key string, write bool) *shard {
+	hashCode := fnv32(key)
+	for {
+		table := dict.loadTable()
+		if table.old != nil {
+			if sh := spread(table.old, hashCode); sh.lock(write) {
+				return sh
+			}
+		}
+		if sh := spread(table.shards, hashCode); sh.lock(write) {
+			return sh
+		}
 	}
-	return dict.table[index]
 }
 
 // 下面实现Dict的接口
 
 func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	// 先计算该key的哈希值
-	hashCode := fnv32(key)
-	// 定位到某个shard
-	index := dict.spread(hashCode)
-	// 找到相应的shard
-	sh := dict.getShard(index)
-	// 对map进行读取的时候加读锁
-	sh.mutex.RLock()
+	// 找到key所在的shard, 对map进行读取的时候加读锁
+	sh := dict.lockShard(key, false)
 	// 解锁
 	defer sh.mutex.RUnlock()
 	// 获取数据
@@ -121,17 +183,9 @@ func (dict *ConcurrentDict) Len() int {
 
 // 往dict中加入数据
 func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	// 先获得key的哈希值
-	hashCode := fnv32(key)
-	// 获取shard的index
-	index := dict.spread(hashCode)
-	// 获得对应的shard
-	sh := dict.getShard(index)
-	// 加锁,写锁
-	sh.mutex.Lock()
+	defer dict.rehashStep()
+	// 获得对应的shard并加写锁
+	sh := dict.lockShard(key, true)
 	// 解锁
 	defer sh.mutex.Unlock()
 	// 判断是否存在key值
@@ -147,13 +201,8 @@ func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
 
 // 不存在的时候添加啊
 func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	hashcode := fnv32(key)
-	index := dict.spread(hashcode)
-	sh := dict.getShard(index)
-	sh.mutex.Lock()
+	defer dict.rehashStep()
+	sh := dict.lockShard(key, true)
 	defer sh.mutex.Unlock()
 	if _, ok := sh.m[key]; ok {
 		return 0
@@ -165,13 +214,7 @@ func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int
 
 // 存在的时候修改，存在则修改并返回1
 func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	hashCode := fnv32(key)
-	index := dict.spread(hashCode)
-	sh := dict.getShard(index)
-	sh.mutex.Lock()
+	sh := dict.lockShard(key, true)
 	defer sh.mutex.Unlock()
 	if _, ok := sh.m[key]; ok {
 		sh.m[key] = val
@@ -182,13 +225,8 @@ func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int
 
 // 删除节点
 func (dict *ConcurrentDict) Remove(key string) (result int) {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	hashCode := fnv32(key)
-	index := dict.spread(hashCode)
-	sh := dict.getShard(index)
-	sh.mutex.Lock()
+	defer dict.rehashStep()
+	sh := dict.lockShard(key, true)
 	defer sh.mutex.Unlock()
 	if _, ok := sh.m[key]; ok {
 		delete(sh.m, key)
@@ -198,23 +236,178 @@ func (dict *ConcurrentDict) Remove(key string) (result int) {
 	return 0
 }
 
-// 遍历节点
+// rehashStep 在写操作之后执行, rehash期间迁移一个旧shard, 否则检查是否需要扩容或缩容
+// 调用时不能持有任何shard的锁
+func (dict *ConcurrentDict) rehashStep() {
+	table := dict.loadTable()
+	if table.old == nil {
+		dict.resizeIfNeeded(table)
+		return
+	}
+	atomic.AddInt32(&dict.rehashers, 1)
+	defer atomic.AddInt32(&dict.rehashers, -1)
+	if atomic.LoadInt32(&dict.iterators) > 0 {
+		return
+	}
+	i := int(atomic.AddInt32(&table.rehashIdx, 1) - 1)
+	if i >= len(table.old) {
+		return
+	}
+	migrate(table.old[i], table.shards)
+	if int(atomic.AddInt32(&table.migrated, 1)) == len(table.old) {
+		dict.resizeMu.Lock()
+		// the table may have been replaced by Clear
+		if dict.loadTable() == table {
+			dict.table.Store(&shardTable{shards: table.shards})
+		}
+		dict.resizeMu.Unlock()
+	}
+}
+
+// migrate moves keys in sh into shards of new table, and marks sh as migrated
+func migrate(sh *shard, shards []*shard) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	for key, val := range sh.m {
+		dst := spread(shards, fnv32(key))
+		dst.mutex.Lock()
+		dst.m[key] = val
+		dst.mutex.Unlock()
+	}
+	sh.m = nil
+	sh.migrated = true
+}
+
+// resizeIfNeeded starts rehashing if the table is too crowded or too sparse
+func (dict *ConcurrentDict) resizeIfNeeded(table *shardTable) {
+	count := dict.Len()
+	shardCount := len(table.shards)
+	var size int
+	if count > shardCount*growLoad {
+		size = computeCapacity(count / shardLoad)
+	} else if shardCount > dict.minShardCount && count*2 < shardCount {
+		size = computeCapacity(count / shardLoad)
+		if size < dict.minShardCount {
+			size = dict.minShardCount
+		}
+	}
+	if size == 0 || size == shardCount {
+		return
+	}
+	dict.resizeMu.Lock()
+	defer dict.resizeMu.Unlock()
+	// another goroutine has started resizing
+	if dict.loadTable() != table {
+		return
+	}
+	dict.table.Store(&shardTable{shards: makeShards(size), old: table.shards})
+}
+
+// pauseRehash waits for running migrations and prevents new ones until resumeRehash
+// migrations are short, so it only waits for a shard being moved
+func (dict *ConcurrentDict) pauseRehash() {
+	atomic.AddInt32(&dict.iterators, 1)
+	for atomic.LoadInt32(&dict.rehashers) > 0 {
+		runtime.Gosched()
+	}
+}
+
+func (dict *ConcurrentDict) resumeRehash() {
+	atomic.AddInt32(&dict.iterators, -1)
+}
+
+// 遍历节点, 遍历期间暂停rehash, 旧表中未迁移的key和新表中的key各被访问一次
 func (dict *ConcurrentDict) ForEach(consumer Consumer) {
-	if dict == nil {
-		panic("dict is nil")
+	dict.pauseRehash()
+	defer dict.resumeRehash()
+	table := dict.loadTable()
+	for _, shards := range [][]*shard{table.old, table.shards} {
+		for _, s := range shards {
+			if !s.forEach(consumer) {
+				return
+			}
+		}
 	}
-	for _, s := range dict.table {
-		s.mutex.RLock()
-		for key, value := range s.m {
-			b := consumer(key, value)
-			if !b {
-				break
+}
+
+func (s *shard) forEach(consumer Consumer) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for key, value := range s.m {
+		if !consumer(key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan 与 redis 的 SCAN 相同, 从cursor处开始遍历至少一个shard, 直到访问了count个key, 返回下一个cursor, 返回0表示遍历完成
+// consumer 返回 false 时停止遍历并返回0
+// cursor 按二进制逆序递增, 在两次调用之间 shard 表扩容或缩容时, 从开始到结束一直存在的key至少被返回一次, 但可能被返回多次
+func (dict *ConcurrentDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	dict.pauseRehash()
+	defer dict.resumeRehash()
+	table := dict.loadTable()
+	visited := 0
+	visit := func(s *shard) bool {
+		return s.forEach(func(key string, val interface{}) bool {
+			visited++
+			return consumer(key, val)
+		})
+	}
+	for {
+		if table.old == nil {
+			mask := uint64(len(table.shards) - 1)
+			if !visit(table.shards[cursor&mask]) {
+				return 0
+			}
+			cursor = nextCursor(cursor, mask)
+		} else {
+			// 旧表先于新表访问, 迁移已暂停, 所以两张表中对应的shard包含了cursor处的全部key
+			small, large := table.old, table.shards
+			if len(small) > len(large) {
+				small, large = large, small
+			}
+			smallMask, largeMask := uint64(len(small)-1), uint64(len(large)-1)
+			visitLarge := func() bool {
+				// visit all shards in large table that are expansions of the shard in small table
+				v := cursor
+				for {
+					if !visit(large[v&largeMask]) {
+						return false
+					}
+					v = nextCursor(v, largeMask)
+					if v&(smallMask^largeMask) == 0 {
+						cursor = v
+						return true
+					}
+				}
+			}
+			smallShard := small[cursor&smallMask]
+			var ok bool
+			if len(table.old) == len(small) {
+				ok = visit(smallShard) && visitLarge()
+			} else {
+				ok = visitLarge() && visit(smallShard)
+			}
+			if !ok {
+				return 0
 			}
 		}
-		s.mutex.RUnlock()
+		if cursor == 0 || visited >= count {
+			return cursor
+		}
 	}
 }
 
+// nextCursor increments the reversed bits of cursor masked by mask
+func nextCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
 // 返回所有的key
 
 func (dict *ConcurrentDict) Keys() []string {
@@ -236,26 +429,8 @@ func (dict *ConcurrentDict) Keys() []string {
 		}
 		return true
 	})
-	return keys
-}
-
-// 第二种写法
-//func (dict *ConcurrentDict) Keys() []string {
-//	if dict == nil {
-//		panic("dict is nil")
-//	}
-//	i := 0
-//	keys := make([]string, dict.Len())
-//	for _, s := range dict.table {
-//		s.mutex.RLock()
-//		for key := range s.m {
-//			keys[i] = key
-//			i++
-//		}
-//		s.mutex.RUnlock()
-//	}
-//	return keys
-//}
+	return keys[:i]
+}
 
 // 设置一个函数，随机从shard里面去一个key出来
 
@@ -288,6 +463,16 @@ func (s *shard) RandomKeyFromShardWithCount() (string, uint32) {
 	return "", 0
 }
 
+// randomShard 随机选择一个shard, rehash期间从新旧两张表中选择, 已迁移的shard为空
+func (dict *ConcurrentDict) randomShard() *shard {
+	table := dict.loadTable()
+	i := rand.Intn(len(table.old) + len(table.shards))
+	if i < len(table.old) {
+		return table.old[i]
+	}
+	return table.shards[i-len(table.old)]
+}
+
 // 随机从dict中取limit个keys，可能会包含重复值
 // 随机数的取法上做了变化，允许选择相同的shard
 // 采用
@@ -298,18 +483,13 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	}
 	// 结果
 	result := make([]string, limit)
-	// 一共含有多少个shard
-	shardCount := dict.shardCount
-	// 添加随机种子
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 0; i < limit; {
-		// 获取shard,可能获取到的shard是没有任何元素的，下面需要进一步判断
-		sh := dict.getShard(uint32(nR.Intn(shardCount)))
-		// 如果shard没有初始化
-		if sh == nil {
-			continue
+		// keys may be removed concurrently, stop if dict becomes empty
+		if dict.Len() == 0 {
+			return result[:i]
 		}
-		key := sh.RandomKeyFromShard()
+		// 获取shard,可能获取到的shard是没有任何元素的，下面需要进一步判断
+		key := dict.randomShard().RandomKeyFromShard()
 		// 选到的shard里面可能是什么都没有存储有的，所以key可能为”“
 		// 如果key为空则不进行i++，继续选择随机数，然后继续随机生成shard，进行取值
 		if key != "" {
@@ -331,16 +511,14 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	result := make([]string, limit)
 	// 定义一个map，用来存储已经拿出来的key
 	existKeyMap := make(map[string]struct{}, limit)
-	// 产生随机不重复的数字
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// 产生随机不重复的数字, 每次新建随机源的开销远大于采样本身, 所以使用全局随机源
 	for i := 0; i < limit; {
-		sh := dict.getShard(uint32(nR.Intn(dict.shardCount)))
-		// 如果sh==nil跳过
-		if sh == nil {
-			continue
+		// keys may be removed concurrently, stop if there are not enough keys left
+		if dict.Len() <= i {
+			return result[:i]
 		}
 		// 随机生成一个key
-		key := sh.RandomKeyFromShard()
+		key := dict.randomShard().RandomKeyFromShard()
 		// 判断key的值
 		if key != "" {
 			if _, exist := existKeyMap[key]; !exist { // 如果当前的key没有在map中则添加，说明没有遍历过该key
@@ -353,10 +531,13 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	return result
 }
 
-// 清空dict，则直接新建一个dict即可，旧的dict让GC回收
+// 清空dict，则直接新建一张shard表即可，旧的表让GC回收
 
 func (dict *ConcurrentDict) Clear() {
-	*dict = *MakeConcurrent(dict.shardCount)
+	dict.resizeMu.Lock()
+	defer dict.resizeMu.Unlock()
+	dict.table.Store(&shardTable{shards: makeShards(dict.minShardCount)})
+	atomic.StoreInt32(&dict.count, 0)
 }
 
 /*
@@ -376,14 +557,10 @@ func (dict *ConcurrentDict) RandomDistinctKeysWithTime(limit int) map[string]int
 
 	if limit >= dict.Len() {
 		keyLruMap := make(map[string]int32, dict.Len())
-		i := 0
 		dict.ForEach(func(key string, val interface{}) bool {
 			// 不考虑新key加入问题
 			entity := val.(*db.DataEntity)
-			if i < len(keyLruMap) {
-				keyLruMap[key] = entity.Lru
-				i++
-			}
+			keyLruMap[key] = entity.Lru
 			return true
 		})
 		return keyLruMap
@@ -392,15 +569,12 @@ func (dict *ConcurrentDict) RandomDistinctKeysWithTime(limit int) map[string]int
 	// 定义一个map，用来存储已经拿出来的key
 	keyLruMap := make(map[string]int32, limit)
 	// 产生随机不重复的数字
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 0; i < limit; {
-		sh := dict.getShard(uint32(nR.Intn(dict.shardCount)))
-		// 如果sh==nil跳过
-		if sh == nil {
-			continue
+		if dict.Len() <= i {
+			break
 		}
 		// 随机生成一个key
-		key, lru := sh.RandomKeyFromShardWithTime()
+		key, lru := dict.randomShard().RandomKeyFromShardWithTime()
 		// 判断key的值
 		if key != "" {
 			if _, exist := keyLruMap[key]; !exist { // 如果当前的key没有在map中则添加，说明没有遍历过该key
@@ -419,14 +593,10 @@ func (dict *ConcurrentDict) RandomDistinctKeysWithCount(limit int) map[string]ui
 
 	if limit >= dict.Len() {
 		keyLfuMap := make(map[string]uint32, dict.Len())
-		i := 0
 		dict.ForEach(func(key string, val interface{}) bool {
 			// 不考虑新key加入问题
 			entity := val.(*db.DataEntity)
-			if i < len(keyLfuMap) {
-				keyLfuMap[key] = entity.Lfu
-				i++
-			}
+			keyLfuMap[key] = entity.Lfu
 			return true
 		})
 		return keyLfuMap
@@ -435,15 +605,12 @@ func (dict *ConcurrentDict) RandomDistinctKeysWithCount(limit int) map[string]ui
 	// 定义一个map，用来存储已经拿出来的key
 	keyLfuMap := make(map[string]uint32, limit)
 	// 产生随机不重复的数字
-	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 0; i < limit; {
-		sh := dict.getShard(uint32(nR.Intn(dict.shardCount)))
-		// 如果sh==nil跳过
-		if sh == nil {
-			continue
+		if dict.Len() <= i {
+			break
 		}
 		// 随机生成一个key
-		key, lfu := sh.RandomKeyFromShardWithCount()
+		key, lfu := dict.randomShard().RandomKeyFromShardWithCount()
 		// 判断key的值
 		if key != "" {
 			if _, exist := keyLfuMap[key]; !exist { // 如果当前的key没有在map中则添加，说明没有遍历过该key
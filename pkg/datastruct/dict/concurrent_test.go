@@ -296,3 +296,75 @@ func TestConcurrentDict_Keys(t *testing.T) {
 		t.Errorf("expect %d keys, actual: %d", size, len(d.Keys()))
 	}
 }
+
+func TestConcurrentResize(t *testing.T) {
+	d := MakeConcurrent(0)
+	count := 10000
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < count; i += 4 {
+				key := "k" + strconv.Itoa(i)
+				d.Put(key, i)
+				if val, ok := d.Get(key); !ok || val.(int) != i {
+					t.Errorf("expected %s=%d during rehashing", key, i)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if shards := len(d.loadTable().shards); shards <= 16 {
+		t.Errorf("expected table grown, actual %d shards", shards)
+	}
+	if len(d.Keys()) != count || d.Len() != count {
+		t.Errorf("expected %d keys, actual %d", count, len(d.Keys()))
+	}
+
+	for i := 0; i < count-10; i++ {
+		d.Remove("k" + strconv.Itoa(i))
+	}
+	// every write migrates a shard, shrinking may take a few rounds of rehashing
+	for i := 0; i < 1000; i++ {
+		d.Remove("none")
+	}
+	if shards := len(d.loadTable().shards); shards != 16 {
+		t.Errorf("expected table shrunk to 16 shards, actual %d", shards)
+	}
+	for i := count - 10; i < count; i++ {
+		if _, ok := d.Get("k" + strconv.Itoa(i)); !ok {
+			t.Errorf("k%d lost after shrinking", i)
+		}
+	}
+}
+
+func TestConcurrentScan(t *testing.T) {
+	d := MakeConcurrent(0)
+	count := 1000
+	for i := 0; i < count; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	// keys existing during the whole iteration are returned even if the table grows between calls
+	seen := make(map[string]bool)
+	var cursor uint64
+	next := count
+	for {
+		cursor = d.Scan(cursor, 10, func(key string, val interface{}) bool {
+			seen[key] = true
+			return true
+		})
+		if cursor == 0 {
+			break
+		}
+		for i := 0; i < 100; i++ {
+			d.Put("k"+strconv.Itoa(next), next)
+			next++
+		}
+	}
+	for i := 0; i < count; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Errorf("k%d is not returned by scan", i)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package dict
+
+import (
+	"math/rand"
+)
+
+// ListpackDict 是一个紧凑的dict，entry较少时直接存放在切片中，超过阈值之后转换为ConcurrentDict
+// ListpackDict is not thread safe before converted, it is protected by key lock of database
+type ListpackDict struct {
+	keys []string
+	vals []interface{}
+	// full is not nil after converted to hashtable
+	full *ConcurrentDict
+
+	maxEntries int
+	maxValue   int
+}
+
+// MakeListpack creates a compact dict which converts to hashtable when entries more than maxEntries
+// or length of key/value longer than maxValue
+func MakeListpack(maxEntries int, maxValue int) *ListpackDict {
+	d := &ListpackDict{
+		maxEntries: maxEntries,
+		maxValue:   maxValue,
+	}
+	if maxEntries <= 0 {
+		d.full = MakeConcurrent(4)
+	}
+	return d
+}
+
+// Encoding returns listpack or hashtable
+func (d *ListpackDict) Encoding() string {
+	if d.full != nil {
+		return "hashtable"
+	}
+	return "listpack"
+}
+
+func (d *ListpackDict) indexOf(key string) int {
+	for i, k := range d.keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *ListpackDict) fits(key string, val interface{}) bool {
+	if len(key) > d.maxValue {
+		return false
+	}
+	if bytes, ok := val.([]byte); ok && len(bytes) > d.maxValue {
+		return false
+	}
+	return true
+}
+
+func (d *ListpackDict) convert() {
+	full := MakeConcurrent(4)
+	for i, k := range d.keys {
+		full.Put(k, d.vals[i])
+	}
+	d.full = full
+	d.keys = nil
+	d.vals = nil
+}
+
+// Get returns the binding value and whether the key is exist
+func (d *ListpackDict) Get(key string) (val interface{}, exists bool) {
+	if d.full != nil {
+		return d.full.Get(key)
+	}
+	i := d.indexOf(key)
+	if i < 0 {
+		return nil, false
+	}
+	return d.vals[i], true
+}
+
+// Len returns the number of dict
+func (d *ListpackDict) Len() int {
+	if d.full != nil {
+		return d.full.Len()
+	}
+	return len(d.keys)
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (d *ListpackDict) Put(key string, val interface{}) (result int) {
+	if d.full != nil {
+		return d.full.Put(key, val)
+	}
+	i := d.indexOf(key)
+	if !d.fits(key, val) || (i < 0 && len(d.keys) >= d.maxEntries) {
+		d.convert()
+		return d.full.Put(key, val)
+	}
+	if i >= 0 {
+		d.vals[i] = val
+		return 0
+	}
+	d.keys = append(d.keys, key)
+	d.vals = append(d.vals, val)
+	return 1
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (d *ListpackDict) PutIfAbsent(key string, val interface{}) (result int) {
+	if _, exists := d.Get(key); exists {
+		return 0
+	}
+	return d.Put(key, val)
+}
+
+// PutIfExists puts value if the key is exist and returns the number of inserted key-value
+func (d *ListpackDict) PutIfExists(key string, val interface{}) (result int) {
+	if _, exists := d.Get(key); !exists {
+		return 0
+	}
+	d.Put(key, val)
+	return 1
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (d *ListpackDict) Remove(key string) (result int) {
+	if d.full != nil {
+		return d.full.Remove(key)
+	}
+	i := d.indexOf(key)
+	if i < 0 {
+		return 0
+	}
+	d.keys = append(d.keys[:i], d.keys[i+1:]...)
+	d.vals = append(d.vals[:i], d.vals[i+1:]...)
+	return 1
+}
+
+// ForEach traversal the dict
+func (d *ListpackDict) ForEach(consumer Consumer) {
+	if d.full != nil {
+		d.full.ForEach(consumer)
+		return
+	}
+	for i, k := range d.keys {
+		if !consumer(k, d.vals[i]) {
+			return
+		}
+	}
+}
+
+// Scan visits keys from cursor like ConcurrentDict.Scan, a listpack is visited in a single call
+func (d *ListpackDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	if d.full != nil {
+		return d.full.Scan(cursor, count, consumer)
+	}
+	d.ForEach(consumer)
+	return 0
+}
+
+// Keys returns all keys in dict
+func (d *ListpackDict) Keys() []string {
+	if d.full != nil {
+		return d.full.Keys()
+	}
+	keys := make([]string, len(d.keys))
+	copy(keys, d.keys)
+	return keys
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (d *ListpackDict) RandomKeys(limit int) []string {
+	if d.full != nil {
+		return d.full.RandomKeys(limit)
+	}
+	if len(d.keys) == 0 {
+		return nil
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = d.keys[rand.Intn(len(d.keys))]
+	}
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (d *ListpackDict) RandomDistinctKeys(limit int) []string {
+	if d.full != nil {
+		return d.full.RandomDistinctKeys(limit)
+	}
+	if limit > len(d.keys) {
+		limit = len(d.keys)
+	}
+	result := make([]string, 0, limit)
+	for _, i := range rand.Perm(len(d.keys))[:limit] {
+		result = append(result, d.keys[i])
+	}
+	return result
+}
+
+// Clear removes all keys in dict
+func (d *ListpackDict) Clear() {
+	if d.full != nil {
+		d.full.Clear()
+		return
+	}
+	d.keys = nil
+	d.vals = nil
+}
@@ -0,0 +1,294 @@
+package graph
+
+import (
+	"sort"
+)
+
+// Direction decides which edges are followed during traversal
+type Direction uint8
+
+const (
+	// Out follows edges from the node to others
+	Out Direction = iota
+	// In follows edges from others to the node
+	In
+	// Both follows edges regardless of direction
+	Both
+)
+
+type node struct {
+	props map[string]string
+	// out maps target node to properties of edge
+	out map[string]map[string]string
+	// in records source nodes of edges point to this node
+	in map[string]struct{}
+}
+
+func makeNode() *node {
+	return &node{
+		out: make(map[string]map[string]string),
+		in:  make(map[string]struct{}),
+	}
+}
+
+// Graph is a directed property graph stored as adjacency maps
+type Graph struct {
+	nodes     map[string]*node
+	edgeCount int
+}
+
+// Hop is a node reached in traversal and its distance from start
+type Hop struct {
+	Node  string
+	Depth int
+}
+
+// Make creates a new empty graph
+func Make() *Graph {
+	return &Graph{
+		nodes: make(map[string]*node),
+	}
+}
+
+// NodeCount returns number of nodes
+func (g *Graph) NodeCount() int {
+	return len(g.nodes)
+}
+
+// EdgeCount returns number of edges
+func (g *Graph) EdgeCount() int {
+	return g.edgeCount
+}
+
+func (g *Graph) getOrMakeNode(name string) *node {
+	n, ok := g.nodes[name]
+	if !ok {
+		n = makeNode()
+		g.nodes[name] = n
+	}
+	return n
+}
+
+// removeIfIsolated removes node without any edge or property
+func (g *Graph) removeIfIsolated(name string) {
+	n, ok := g.nodes[name]
+	if ok && len(n.out) == 0 && len(n.in) == 0 && len(n.props) == 0 {
+		delete(g.nodes, name)
+	}
+}
+
+// AddEdge adds an edge or merges properties into existed edge, returns true if the edge is new
+func (g *Graph) AddEdge(from string, to string, props map[string]string) bool {
+	src := g.getOrMakeNode(from)
+	dest := g.getOrMakeNode(to)
+	edgeProps, exists := src.out[to]
+	if !exists {
+		edgeProps = make(map[string]string, len(props))
+		src.out[to] = edgeProps
+		dest.in[from] = struct{}{}
+		g.edgeCount++
+	}
+	for k, v := range props {
+		edgeProps[k] = v
+	}
+	return !exists
+}
+
+// RemoveEdge removes an edge, returns true if the edge existed
+func (g *Graph) RemoveEdge(from string, to string) bool {
+	src, ok := g.nodes[from]
+	if !ok {
+		return false
+	}
+	if _, exists := src.out[to]; !exists {
+		return false
+	}
+	delete(src.out, to)
+	delete(g.nodes[to].in, from)
+	g.edgeCount--
+	g.removeIfIsolated(from)
+	g.removeIfIsolated(to)
+	return true
+}
+
+// GetEdge returns properties of edge
+func (g *Graph) GetEdge(from string, to string) (map[string]string, bool) {
+	src, ok := g.nodes[from]
+	if !ok {
+		return nil, false
+	}
+	props, exists := src.out[to]
+	return props, exists
+}
+
+// SetNode sets properties of node, the node would be created if not exists
+func (g *Graph) SetNode(name string, props map[string]string) {
+	n := g.getOrMakeNode(name)
+	if n.props == nil {
+		n.props = make(map[string]string, len(props))
+	}
+	for k, v := range props {
+		n.props[k] = v
+	}
+}
+
+// GetNode returns properties of node
+func (g *Graph) GetNode(name string) (map[string]string, bool) {
+	n, ok := g.nodes[name]
+	if !ok {
+		return nil, false
+	}
+	return n.props, true
+}
+
+// HasNode returns whether node exists
+func (g *Graph) HasNode(name string) bool {
+	_, ok := g.nodes[name]
+	return ok
+}
+
+// adjacent returns sorted neighbors of node in the given direction
+func (g *Graph) adjacent(n *node, dir Direction) []string {
+	var result []string
+	switch dir {
+	case Out:
+		result = make([]string, 0, len(n.out))
+		for k := range n.out {
+			result = append(result, k)
+		}
+	case In:
+		result = make([]string, 0, len(n.in))
+		for k := range n.in {
+			result = append(result, k)
+		}
+	default:
+		result = make([]string, 0, len(n.out)+len(n.in))
+		for k := range n.out {
+			result = append(result, k)
+		}
+		for k := range n.in {
+			if _, ok := n.out[k]; !ok {
+				result = append(result, k)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Neighbors returns at most limit adjacent nodes sorted by name, limit <= 0 means no limit
+func (g *Graph) Neighbors(name string, dir Direction, limit int) []string {
+	n, ok := g.nodes[name]
+	if !ok {
+		return nil
+	}
+	result := g.adjacent(n, dir)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// BFS returns nodes within maxDepth hops from start (start itself excluded) in breadth first order
+// traversal stops after limit nodes were found, limit <= 0 means no limit
+func (g *Graph) BFS(start string, maxDepth int, dir Direction, limit int) []Hop {
+	if _, ok := g.nodes[start]; !ok {
+		return nil
+	}
+	visited := map[string]struct{}{start: {}}
+	frontier := []string{start}
+	var result []Hop
+	for depth := 1; depth <= maxDepth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, cur := range frontier {
+			for _, neighbor := range g.adjacent(g.nodes[cur], dir) {
+				if _, seen := visited[neighbor]; seen {
+					continue
+				}
+				visited[neighbor] = struct{}{}
+				result = append(result, Hop{Node: neighbor, Depth: depth})
+				if limit > 0 && len(result) >= limit {
+					return result
+				}
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+	}
+	return result
+}
+
+// ShortestPath returns nodes on one of the shortest paths from `from` to `to` including both ends,
+// returns nil if `to` is unreachable within maxDepth hops
+func (g *Graph) ShortestPath(from string, to string, maxDepth int, dir Direction) []string {
+	if _, ok := g.nodes[from]; !ok {
+		return nil
+	}
+	if _, ok := g.nodes[to]; !ok {
+		return nil
+	}
+	if from == to {
+		return []string{from}
+	}
+	parent := map[string]string{from: ""}
+	frontier := []string{from}
+	for depth := 1; depth <= maxDepth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, cur := range frontier {
+			for _, neighbor := range g.adjacent(g.nodes[cur], dir) {
+				if _, seen := parent[neighbor]; seen {
+					continue
+				}
+				parent[neighbor] = cur
+				if neighbor == to {
+					path := make([]string, depth+1)
+					for i, n := depth, to; i >= 0; i-- {
+						path[i] = n
+						n = parent[n]
+					}
+					return path
+				}
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// CommonNeighbors returns nodes adjacent to both a and b sorted by name, limit <= 0 means no limit
+func (g *Graph) CommonNeighbors(a string, b string, dir Direction, limit int) []string {
+	nodeA, ok := g.nodes[a]
+	if !ok {
+		return nil
+	}
+	nodeB, ok := g.nodes[b]
+	if !ok {
+		return nil
+	}
+	set := make(map[string]struct{})
+	for _, n := range g.adjacent(nodeB, dir) {
+		set[n] = struct{}{}
+	}
+	var result []string
+	for _, n := range g.adjacent(nodeA, dir) {
+		if _, ok := set[n]; ok {
+			result = append(result, n)
+			if limit > 0 && len(result) >= limit {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// ForEachEdge visits all edges, returns false in consumer to break
+func (g *Graph) ForEachEdge(consumer func(from string, to string, props map[string]string) bool) {
+	for from, n := range g.nodes {
+		for to, props := range n.out {
+			if !consumer(from, to, props) {
+				return
+			}
+		}
+	}
+}
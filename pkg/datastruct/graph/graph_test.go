@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func makeTestGraph() *Graph {
+	g := Make()
+	g.AddEdge("a", "b", nil)
+	g.AddEdge("a", "c", nil)
+	g.AddEdge("b", "d", map[string]string{"since": "2020"})
+	g.AddEdge("c", "d", nil)
+	g.AddEdge("d", "e", nil)
+	return g
+}
+
+func TestTraversal(t *testing.T) {
+	g := makeTestGraph()
+	if g.NodeCount() != 5 || g.EdgeCount() != 5 {
+		t.Fatalf("unexpected size %d nodes %d edges", g.NodeCount(), g.EdgeCount())
+	}
+	hops := g.BFS("a", 2, Out, 0)
+	expected := []Hop{{"b", 1}, {"c", 1}, {"d", 2}}
+	if !reflect.DeepEqual(hops, expected) {
+		t.Errorf("bfs expected %v, actual %v", expected, hops)
+	}
+	if hops = g.BFS("a", 3, Out, 2); len(hops) != 2 {
+		t.Errorf("bfs limit not work: %v", hops)
+	}
+	path := g.ShortestPath("a", "e", 10, Out)
+	if !reflect.DeepEqual(path, []string{"a", "b", "d", "e"}) {
+		t.Errorf("unexpected path %v", path)
+	}
+	if path = g.ShortestPath("e", "a", 10, Out); path != nil {
+		t.Errorf("expected unreachable, actual %v", path)
+	}
+	if path = g.ShortestPath("e", "a", 10, Both); len(path) != 4 {
+		t.Errorf("unexpected path %v", path)
+	}
+	common := g.CommonNeighbors("b", "c", Out, 0)
+	if !reflect.DeepEqual(common, []string{"d"}) {
+		t.Errorf("unexpected common neighbors %v", common)
+	}
+	if in := g.Neighbors("d", In, 0); !reflect.DeepEqual(in, []string{"b", "c"}) {
+		t.Errorf("unexpected in neighbors %v", in)
+	}
+}
+
+func TestRemoveEdge(t *testing.T) {
+	g := makeTestGraph()
+	if !g.RemoveEdge("d", "e") {
+		t.Error("expected edge removed")
+	}
+	if g.RemoveEdge("d", "e") {
+		t.Error("expected edge not exist")
+	}
+	if g.HasNode("e") {
+		t.Error("isolated node should be removed")
+	}
+	if g.EdgeCount() != 4 {
+		t.Errorf("expected 4 edges, actual %d", g.EdgeCount())
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	g := makeTestGraph()
+	g.SetNode("a", map[string]string{"name": "alice"})
+	g2, err := Unmarshal(g.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, g2) {
+		t.Error("graph changed after marshal")
+	}
+	if _, err = Unmarshal(g.Marshal()[:20]); err == nil {
+		t.Error("expected error for truncated payload")
+	}
+}
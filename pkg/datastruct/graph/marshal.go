@@ -0,0 +1,137 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Magic is the prefix of serialized graph, Unmarshal rejects data without it
+var Magic = []byte("SLVGRAPH\x01")
+
+var errCorrupted = errors.New("corrupted graph payload")
+
+// IsMarshaled returns whether data is produced by Marshal
+func IsMarshaled(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(s)))
+	buf.Write(lenBuf[:n])
+	buf.WriteString(s)
+}
+
+func writeProps(buf *bytes.Buffer, props map[string]string) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(props)))
+	buf.Write(lenBuf[:n])
+	for k, v := range props {
+		writeString(buf, k)
+		writeString(buf, v)
+	}
+}
+
+// Marshal serializes graph into bytes
+func (g *Graph) Marshal() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(Magic)
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(g.nodes)))
+	buf.Write(lenBuf[:n])
+	for name, nd := range g.nodes {
+		writeString(buf, name)
+		writeProps(buf, nd.props)
+	}
+	n = binary.PutUvarint(lenBuf[:], uint64(g.edgeCount))
+	buf.Write(lenBuf[:n])
+	g.ForEachEdge(func(from string, to string, props map[string]string) bool {
+		writeString(buf, from)
+		writeString(buf, to)
+		writeProps(buf, props)
+		return true
+	})
+	return buf.Bytes()
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", errCorrupted
+	}
+	if size > uint64(r.Len()) {
+		return "", errCorrupted
+	}
+	b := make([]byte, size)
+	_, _ = r.Read(b)
+	return string(b), nil
+}
+
+func readProps(r *bytes.Reader) (map[string]string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, errCorrupted
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	props := make(map[string]string, size)
+	for i := uint64(0); i < size; i++ {
+		k, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		props[k] = v
+	}
+	return props, nil
+}
+
+// Unmarshal deserializes graph from bytes produced by Marshal
+func Unmarshal(data []byte) (*Graph, error) {
+	if !IsMarshaled(data) {
+		return nil, errCorrupted
+	}
+	r := bytes.NewReader(data[len(Magic):])
+	g := Make()
+	nodeCount, err := binary.ReadUvarint(r)
+	if err != nil || nodeCount > uint64(r.Len()) {
+		return nil, errCorrupted
+	}
+	for i := uint64(0); i < nodeCount; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		props, err := readProps(r)
+		if err != nil {
+			return nil, err
+		}
+		nd := g.getOrMakeNode(name)
+		nd.props = props
+	}
+	edgeCount, err := binary.ReadUvarint(r)
+	if err != nil || edgeCount > uint64(r.Len()) {
+		return nil, errCorrupted
+	}
+	for i := uint64(0); i < edgeCount; i++ {
+		from, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		to, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		props, err := readProps(r)
+		if err != nil {
+			return nil, err
+		}
+		g.AddEdge(from, to, props)
+	}
+	return g, nil
+}
@@ -181,3 +181,18 @@ func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
 		}
 	}
 }
+
+// 锁定所有的槽, 用于需要等待所有正在执行的写命令结束的场景, 如创建快照
+// 与RWLocks一样按照从小到大的顺序上锁
+func (locks *Locks) LockAll() {
+	for _, mu := range locks.table {
+		mu.Lock()
+	}
+}
+
+// 释放所有的槽, 按照从大到小的顺序解锁
+func (locks *Locks) UnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].Unlock()
+	}
+}
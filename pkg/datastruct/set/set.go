@@ -1,10 +1,35 @@
 package set
 
-import "slava/pkg/datastruct/dict"
+import (
+	"math/rand"
+	"sort"
+	"strconv"
 
-// 基于哈希表的数据结构
+	"slava/pkg/datastruct/dict"
+)
+
+const (
+	encodingHashtable uint8 = iota
+	encodingIntset
+	encodingListpack
+)
+
+// Limits are thresholds to convert compact encodings
+type Limits struct {
+	MaxIntsetEntries   int
+	MaxListpackEntries int
+	MaxListpackValue   int
+}
+
+// 基于哈希表的数据结构, 元素较少时使用intset或者listpack
 type Set struct {
 	dict dict.Dict
+	// intset is sorted integers, used when all members are integers
+	intset []int64
+	// listpack keeps small members in a slice
+	listpack []string
+	encoding uint8
+	limits   Limits
 }
 
 // 使用make建立一个新的set
@@ -19,29 +44,183 @@ func Make(members ...string) *Set {
 	return set
 }
 
+// MakeCompact creates a set starts with intset encoding and converts to listpack or hashtable past the limits
+func MakeCompact(limits Limits, members ...string) *Set {
+	set := &Set{
+		encoding: encodingIntset,
+		limits:   limits,
+	}
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// Encoding returns intset, listpack or hashtable
+func (set *Set) Encoding() string {
+	switch set.encoding {
+	case encodingIntset:
+		return "intset"
+	case encodingListpack:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// parseInt returns integer only if val is in canonical form, so that it could be restored from intset
+func parseInt(val string) (int64, bool) {
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != val {
+		return 0, false
+	}
+	return v, true
+}
+
+func (set *Set) searchInt(v int64) (int, bool) {
+	i := sort.Search(len(set.intset), func(i int) bool {
+		return set.intset[i] >= v
+	})
+	return i, i < len(set.intset) && set.intset[i] == v
+}
+
+func (set *Set) indexOf(val string) int {
+	for i, m := range set.listpack {
+		if m == val {
+			return i
+		}
+	}
+	return -1
+}
+
+// convert moves members into the given encoding
+func (set *Set) convert(encoding uint8) {
+	members := set.ToSlice()
+	set.intset = nil
+	set.listpack = nil
+	set.encoding = encoding
+	if encoding == encodingListpack {
+		set.listpack = members
+		return
+	}
+	set.dict = dict.MakeConcurrent(4)
+	for _, m := range members {
+		set.dict.Put(m, nil)
+	}
+}
+
 // Add adds member into set
 func (set *Set) Add(val string) int {
+	switch set.encoding {
+	case encodingIntset:
+		if v, ok := parseInt(val); ok {
+			i, exists := set.searchInt(v)
+			if exists {
+				return 0
+			}
+			if len(set.intset) < set.limits.MaxIntsetEntries {
+				set.intset = append(set.intset, 0)
+				copy(set.intset[i+1:], set.intset[i:])
+				set.intset[i] = v
+				return 1
+			}
+		}
+		if len(set.intset)+1 <= set.limits.MaxListpackEntries && len(val) <= set.limits.MaxListpackValue {
+			set.convert(encodingListpack)
+		} else {
+			set.convert(encodingHashtable)
+		}
+		return set.Add(val)
+	case encodingListpack:
+		if set.indexOf(val) >= 0 {
+			return 0
+		}
+		if len(set.listpack) >= set.limits.MaxListpackEntries || len(val) > set.limits.MaxListpackValue {
+			set.convert(encodingHashtable)
+			return set.Add(val)
+		}
+		set.listpack = append(set.listpack, val)
+		return 1
+	}
 	return set.dict.Put(val, nil)
 }
 
 // Remove removes member from set
 func (set *Set) Remove(val string) int {
+	switch set.encoding {
+	case encodingIntset:
+		v, ok := parseInt(val)
+		if !ok {
+			return 0
+		}
+		i, exists := set.searchInt(v)
+		if !exists {
+			return 0
+		}
+		set.intset = append(set.intset[:i], set.intset[i+1:]...)
+		return 1
+	case encodingListpack:
+		i := set.indexOf(val)
+		if i < 0 {
+			return 0
+		}
+		set.listpack = append(set.listpack[:i], set.listpack[i+1:]...)
+		return 1
+	}
 	return set.dict.Remove(val)
 }
 
 // Has returns true if the val exists in the set
 func (set *Set) Has(val string) bool {
+	switch set.encoding {
+	case encodingIntset:
+		v, ok := parseInt(val)
+		if !ok {
+			return false
+		}
+		_, exists := set.searchInt(v)
+		return exists
+	case encodingListpack:
+		return set.indexOf(val) >= 0
+	}
 	_, exists := set.dict.Get(val)
 	return exists
 }
 
 // Len returns number of members in the set
 func (set *Set) Len() int {
+	switch set.encoding {
+	case encodingIntset:
+		return len(set.intset)
+	case encodingListpack:
+		return len(set.listpack)
+	}
 	return set.dict.Len()
 }
 
+// Ints returns sorted members if set is in intset encoding
+func (set *Set) Ints() ([]int64, bool) {
+	if set.encoding != encodingIntset {
+		return nil, false
+	}
+	ints := make([]int64, len(set.intset))
+	copy(ints, set.intset)
+	return ints, true
+}
+
 // ToSlice convert set to []string
 func (set *Set) ToSlice() []string {
+	switch set.encoding {
+	case encodingIntset:
+		slice := make([]string, len(set.intset))
+		for i, v := range set.intset {
+			slice[i] = strconv.FormatInt(v, 10)
+		}
+		return slice
+	case encodingListpack:
+		slice := make([]string, len(set.listpack))
+		copy(slice, set.listpack)
+		return slice
+	}
 	slice := make([]string, set.Len())
 	i := 0
 	set.dict.ForEach(func(key string, val interface{}) bool {
@@ -59,6 +238,14 @@ func (set *Set) ToSlice() []string {
 
 // ForEach visits each member in the set
 func (set *Set) ForEach(consumer func(member string) bool) {
+	if set.encoding != encodingHashtable {
+		for _, member := range set.ToSlice() {
+			if !consumer(member) {
+				return
+			}
+		}
+		return
+	}
 	set.dict.ForEach(func(key string, val interface{}) bool {
 		return consumer(key)
 	})
@@ -115,10 +302,31 @@ func (set *Set) Diff(another *Set) *Set {
 
 // RandomMembers randomly returns keys of the given number, may contain duplicated key
 func (set *Set) RandomMembers(limit int) []string {
+	if set.encoding != encodingHashtable {
+		members := set.ToSlice()
+		if len(members) == 0 {
+			return nil
+		}
+		result := make([]string, limit)
+		for i := range result {
+			result[i] = members[rand.Intn(len(members))]
+		}
+		return result
+	}
 	return set.dict.RandomKeys(limit)
 }
 
 // RandomDistinctMembers randomly returns keys of the given number, won't contain duplicated key
 func (set *Set) RandomDistinctMembers(limit int) []string {
+	if set.encoding != encodingHashtable {
+		members := set.ToSlice()
+		if limit > len(members) {
+			limit = len(members)
+		}
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		return members[:limit]
+	}
 	return set.dict.RandomDistinctKeys(limit)
 }
@@ -185,3 +185,30 @@ func TestRandomDistinctMembers(t *testing.T) {
 		t.Error("Exist duplicated member")
 	}
 }
+
+func TestCompactEncoding(t *testing.T) {
+	limits := Limits{MaxIntsetEntries: 3, MaxListpackEntries: 4, MaxListpackValue: 8}
+	s := MakeCompact(limits, "3", "1", "2")
+	if s.Encoding() != "intset" || !reflect.DeepEqual(s.ToSlice(), []string{"1", "2", "3"}) {
+		t.Errorf("unexpected intset %s %v", s.Encoding(), s.ToSlice())
+	}
+	if s.Has("01") {
+		t.Error("01 is not a member")
+	}
+	s.Add("4")
+	if s.Encoding() != "listpack" || s.Len() != 4 {
+		t.Errorf("expected listpack after intset full, actual %s", s.Encoding())
+	}
+	s.Add("a")
+	if s.Encoding() != "hashtable" || s.Len() != 5 || !s.Has("4") {
+		t.Errorf("expected hashtable after listpack full, actual %s", s.Encoding())
+	}
+	s = MakeCompact(limits, "1", "a")
+	if s.Encoding() != "listpack" {
+		t.Errorf("expected listpack with non integer member, actual %s", s.Encoding())
+	}
+	s.Add("member-too-long")
+	if s.Encoding() != "hashtable" {
+		t.Errorf("expected hashtable with long member, actual %s", s.Encoding())
+	}
+}
@@ -0,0 +1,104 @@
+package sortedset
+
+import "sort"
+
+// listpack keeps elements of small sorted set in a slice sorted by score then member,
+// it has the same order as skiplist so that rank could be computed by index
+
+func elementLess(score float64, member string, e *Element) bool {
+	if score != e.Score {
+		return score < e.Score
+	}
+	return member < e.Member
+}
+
+// MakeCompact makes a new SortedSet in listpack encoding, it converts to skiplist
+// when there are more than maxEntries members or a member is longer than maxValue
+func MakeCompact(maxEntries int, maxValue int) *SortedSet {
+	if maxEntries <= 0 {
+		return Make()
+	}
+	return &SortedSet{
+		listpack:   make([]*Element, 0),
+		maxEntries: maxEntries,
+		maxValue:   maxValue,
+	}
+}
+
+// Encoding returns listpack or skiplist
+func (sortedSet *SortedSet) Encoding() string {
+	if sortedSet.isCompact() {
+		return "listpack"
+	}
+	return "skiplist"
+}
+
+func (sortedSet *SortedSet) isCompact() bool {
+	return sortedSet.skiplist == nil
+}
+
+// convert moves elements from listpack into skiplist
+func (sortedSet *SortedSet) convert() {
+	elements := sortedSet.listpack
+	sortedSet.listpack = nil
+	sortedSet.dict = make(map[string]*Element, len(elements))
+	sortedSet.skiplist = makeSkiplist()
+	for _, e := range elements {
+		sortedSet.dict[e.Member] = e
+		sortedSet.skiplist.insert(e.Member, e.Score)
+	}
+}
+
+func (sortedSet *SortedSet) lpIndexOf(member string) int {
+	for i, e := range sortedSet.listpack {
+		if e.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+func (sortedSet *SortedSet) lpRemoveAt(i int) {
+	sortedSet.listpack = append(sortedSet.listpack[:i], sortedSet.listpack[i+1:]...)
+}
+
+func (sortedSet *SortedSet) lpInsert(member string, score float64) {
+	lp := sortedSet.listpack
+	i := sort.Search(len(lp), func(i int) bool {
+		return elementLess(score, member, lp[i])
+	})
+	lp = append(lp, nil)
+	copy(lp[i+1:], lp[i:])
+	lp[i] = &Element{
+		Member: member,
+		Score:  score,
+	}
+	sortedSet.listpack = lp
+}
+
+// lpFirstInRange returns index of first element within border, or len(listpack) if not found
+func (sortedSet *SortedSet) lpFirstInRange(min *ScoreBorder, max *ScoreBorder) int {
+	for i, e := range sortedSet.listpack {
+		if min.less(e.Score) {
+			if max.greater(e.Score) {
+				return i
+			}
+			break
+		}
+	}
+	return len(sortedSet.listpack)
+}
+
+// lpLastInRange returns index of last element within border, or -1 if not found
+func (sortedSet *SortedSet) lpLastInRange(min *ScoreBorder, max *ScoreBorder) int {
+	for i := len(sortedSet.listpack) - 1; i >= 0; i-- {
+		e := sortedSet.listpack[i]
+		if max.greater(e.Score) {
+			if min.less(e.Score) {
+				return i
+			}
+			break
+		}
+	}
+	return -1
+}
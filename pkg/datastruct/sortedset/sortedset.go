@@ -8,6 +8,10 @@ import (
 type SortedSet struct {
 	dict     map[string]*Element
 	skiplist *skiplist
+	// listpack is used instead of dict and skiplist while the set is small, see MakeCompact
+	listpack   []*Element
+	maxEntries int
+	maxValue   int
 }
 
 // Make makes a new SortedSet
@@ -20,6 +24,21 @@ func Make() *SortedSet {
 
 // Add puts member into set,  and returns whether has inserted new node
 func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	if sortedSet.isCompact() {
+		i := sortedSet.lpIndexOf(member)
+		if i >= 0 {
+			if sortedSet.listpack[i].Score != score {
+				sortedSet.lpRemoveAt(i)
+				sortedSet.lpInsert(member, score)
+			}
+			return false
+		}
+		if len(sortedSet.listpack) < sortedSet.maxEntries && len(member) <= sortedSet.maxValue {
+			sortedSet.lpInsert(member, score)
+			return true
+		}
+		sortedSet.convert()
+	}
 	element, ok := sortedSet.dict[member]
 	sortedSet.dict[member] = &Element{
 		Member: member,
@@ -38,11 +57,21 @@ func (sortedSet *SortedSet) Add(member string, score float64) bool {
 
 // Len returns number of members in set
 func (sortedSet *SortedSet) Len() int64 {
+	if sortedSet.isCompact() {
+		return int64(len(sortedSet.listpack))
+	}
 	return int64(len(sortedSet.dict))
 }
 
 // Get returns the given member
 func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	if sortedSet.isCompact() {
+		i := sortedSet.lpIndexOf(member)
+		if i < 0 {
+			return nil, false
+		}
+		return sortedSet.listpack[i], true
+	}
 	element, ok = sortedSet.dict[member]
 	if !ok {
 		return nil, false
@@ -52,6 +81,14 @@ func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
 
 // Remove removes the given member from set
 func (sortedSet *SortedSet) Remove(member string) bool {
+	if sortedSet.isCompact() {
+		i := sortedSet.lpIndexOf(member)
+		if i < 0 {
+			return false
+		}
+		sortedSet.lpRemoveAt(i)
+		return true
+	}
 	v, ok := sortedSet.dict[member]
 	if ok {
 		sortedSet.skiplist.remove(member, v.Score)
@@ -63,6 +100,16 @@ func (sortedSet *SortedSet) Remove(member string) bool {
 
 // GetRank returns the rank of the given member, sort by ascending order, rank starts from 0
 func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	if sortedSet.isCompact() {
+		i := sortedSet.lpIndexOf(member)
+		if i < 0 {
+			return -1
+		}
+		if desc {
+			return int64(len(sortedSet.listpack) - 1 - i)
+		}
+		return int64(i)
+	}
 	element, ok := sortedSet.dict[member]
 	if !ok {
 		return -1
@@ -85,6 +132,18 @@ func (sortedSet *SortedSet) ForEach(start int64, stop int64, desc bool, consumer
 	if stop < start || stop > size {
 		panic("illegal end " + strconv.FormatInt(stop, 10))
 	}
+	if sortedSet.isCompact() {
+		for i := start; i < stop; i++ {
+			idx := i
+			if desc {
+				idx = size - 1 - i
+			}
+			if !consumer(sortedSet.listpack[idx]) {
+				break
+			}
+		}
+		return
+	}
 
 	// find start node
 	var node *node
@@ -150,6 +209,20 @@ func (sortedSet *SortedSet) Count(min *ScoreBorder, max *ScoreBorder) int64 {
 
 // ForEachByScore visits members which score within the given border
 func (sortedSet *SortedSet) ForEachByScore(min *ScoreBorder, max *ScoreBorder, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	if sortedSet.isCompact() {
+		lp := sortedSet.listpack
+		first, last := sortedSet.lpFirstInRange(min, max), sortedSet.lpLastInRange(min, max)
+		for i := int64(0); first+int(offset+i) <= last && (i < limit || limit < 0); i++ {
+			idx := first + int(offset+i)
+			if desc {
+				idx = last - int(offset+i)
+			}
+			if !consumer(lp[idx]) {
+				break
+			}
+		}
+		return
+	}
 	// find start node
 	var node *node
 	if desc {
@@ -204,6 +277,14 @@ func (sortedSet *SortedSet) RangeByScore(min *ScoreBorder, max *ScoreBorder, off
 
 // RemoveByScore removes members which score within the given border
 func (sortedSet *SortedSet) RemoveByScore(min *ScoreBorder, max *ScoreBorder) int64 {
+	if sortedSet.isCompact() {
+		first, last := sortedSet.lpFirstInRange(min, max), sortedSet.lpLastInRange(min, max)
+		if first > last {
+			return 0
+		}
+		sortedSet.listpack = append(sortedSet.listpack[:first], sortedSet.listpack[last+1:]...)
+		return int64(last - first + 1)
+	}
 	removed := sortedSet.skiplist.RemoveRangeByScore(min, max, 0)
 	for _, element := range removed {
 		delete(sortedSet.dict, element.Member)
@@ -212,6 +293,18 @@ func (sortedSet *SortedSet) RemoveByScore(min *ScoreBorder, max *ScoreBorder) in
 }
 
 func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	if sortedSet.isCompact() {
+		if len(sortedSet.listpack) == 0 {
+			return nil
+		}
+		if count > len(sortedSet.listpack) {
+			count = len(sortedSet.listpack)
+		}
+		removed := make([]*Element, count)
+		copy(removed, sortedSet.listpack[:count])
+		sortedSet.listpack = append(sortedSet.listpack[:0], sortedSet.listpack[count:]...)
+		return removed
+	}
 	first := sortedSet.skiplist.getFirstInScoreRange(negativeInfBorder, positiveInfBorder)
 	if first == nil {
 		return nil
@@ -230,6 +323,17 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 // RemoveByRank removes member ranking within [start, stop)
 // sort by ascending order and rank starts from 0
 func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	if sortedSet.isCompact() {
+		size := int64(len(sortedSet.listpack))
+		if stop > size {
+			stop = size
+		}
+		if start < 0 || start >= stop {
+			return 0
+		}
+		sortedSet.listpack = append(sortedSet.listpack[:start], sortedSet.listpack[stop:]...)
+		return stop - start
+	}
 	removed := sortedSet.skiplist.RemoveRangeByRank(start+1, stop+1)
 	for _, element := range removed {
 		delete(sortedSet.dict, element.Member)
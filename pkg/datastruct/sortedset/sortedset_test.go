@@ -205,3 +205,47 @@ func TestRemoveByRank(t *testing.T) {
 		t.Error("RemoveByRank wrong")
 	}
 }
+
+func TestListpackEncoding(t *testing.T) {
+	compact, full := MakeCompact(8, 16), Make()
+	members := []string{"a", "b", "c", "d", "e", "f"}
+	for i, m := range members {
+		compact.Add(m, float64(i%3))
+		full.Add(m, float64(i%3))
+	}
+	compact.Add("c", 10)
+	full.Add("c", 10)
+	if compact.Encoding() != "listpack" {
+		t.Fatalf("expected listpack, actual %s", compact.Encoding())
+	}
+	if !reflect.DeepEqual(compact.Range(0, compact.Len(), true), full.Range(0, full.Len(), true)) {
+		t.Error("range differs from skiplist")
+	}
+	min, max := &ScoreBorder{Value: 1}, &ScoreBorder{Value: 10, Exclude: true}
+	if !reflect.DeepEqual(compact.RangeByScore(min, max, 1, -1, true), full.RangeByScore(min, max, 1, -1, true)) {
+		t.Error("range by score differs from skiplist")
+	}
+	for _, m := range members {
+		if compact.GetRank(m, true) != full.GetRank(m, true) {
+			t.Errorf("rank of %s differs from skiplist", m)
+		}
+	}
+	if !reflect.DeepEqual(compact.PopMin(2), full.PopMin(2)) {
+		t.Error("pop min differs from skiplist")
+	}
+	if compact.RemoveByRank(0, 2) != full.RemoveByRank(0, 2) || compact.Len() != full.Len() {
+		t.Error("remove by rank differs from skiplist")
+	}
+
+	compact.Add("a-member-longer-than-16", 1)
+	if compact.Encoding() != "skiplist" {
+		t.Errorf("expected skiplist after long member, actual %s", compact.Encoding())
+	}
+	compact = MakeCompact(2, 16)
+	compact.Add("a", 1)
+	compact.Add("b", 2)
+	compact.Add("c", 3)
+	if compact.Encoding() != "skiplist" || compact.GetRank("c", false) != 2 {
+		t.Error("expected skiplist after too many members")
+	}
+}
@@ -0,0 +1,98 @@
+package suggest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Magic is the prefix of serialized trie, Unmarshal rejects data without it
+var Magic = []byte("SLVSUGG\x01")
+
+var errCorrupted = errors.New("corrupted suggestion payload")
+
+// IsMarshaled returns whether data is produced by Marshal
+func IsMarshaled(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	buf.Write(lenBuf[:n])
+	buf.Write(b)
+}
+
+// Marshal serializes trie into bytes
+func (t *Trie) Marshal() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(Magic)
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(t.size))
+	buf.Write(lenBuf[:n])
+	var scoreBuf [8]byte
+	t.ForEach(func(entry *Entry) bool {
+		writeBytes(buf, []byte(entry.Value))
+		binary.BigEndian.PutUint64(scoreBuf[:], math.Float64bits(entry.Score))
+		buf.Write(scoreBuf[:])
+		if entry.Payload == nil {
+			buf.WriteByte(0)
+		} else {
+			buf.WriteByte(1)
+			writeBytes(buf, entry.Payload)
+		}
+		return true
+	})
+	return buf.Bytes()
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, errCorrupted
+	}
+	b := make([]byte, size)
+	_, _ = r.Read(b)
+	return b, nil
+}
+
+// Unmarshal deserializes trie from bytes produced by Marshal
+func Unmarshal(data []byte) (*Trie, error) {
+	if !IsMarshaled(data) {
+		return nil, errCorrupted
+	}
+	r := bytes.NewReader(data[len(Magic):])
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, errCorrupted
+	}
+	t := Make()
+	var scoreBuf [8]byte
+	for i := uint64(0); i < size; i++ {
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := r.Read(scoreBuf[:]); n != 8 {
+			return nil, errCorrupted
+		}
+		score := math.Float64frombits(binary.BigEndian.Uint64(scoreBuf[:]))
+		if math.IsNaN(score) {
+			return nil, errCorrupted
+		}
+		flag, err := r.ReadByte()
+		if err != nil {
+			return nil, errCorrupted
+		}
+		var payload []byte
+		if flag == 1 {
+			payload, err = readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t.Add(string(value), score, false, payload)
+	}
+	return t, nil
+}
@@ -0,0 +1,287 @@
+package suggest
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Entry is a suggestion stored in Trie
+type Entry struct {
+	Value   string
+	Score   float64
+	Payload []byte
+}
+
+type node struct {
+	children map[rune]*node
+	entry    *Entry
+	// best is the highest score in subtree, Suggest uses it to visit subtrees with higher scores first
+	best float64
+}
+
+// Trie is a suggestion dictionary supports prefix and fuzzy prefix lookup
+type Trie struct {
+	root *node
+	size int
+}
+
+// Make creates a new empty Trie
+func Make() *Trie {
+	return &Trie{
+		root: &node{best: math.Inf(-1)},
+	}
+}
+
+// Len returns number of suggestions
+func (t *Trie) Len() int {
+	return t.size
+}
+
+// Add puts suggestion into trie and returns its score after updating,
+// if incr is true score would be added to existed score instead of replacing it,
+// payload is kept unchanged if given payload is nil
+func (t *Trie) Add(value string, score float64, incr bool, payload []byte) float64 {
+	n := t.root
+	path := []*node{n}
+	for _, r := range value {
+		if n.children == nil {
+			n.children = make(map[rune]*node)
+		}
+		child, ok := n.children[r]
+		if !ok {
+			child = &node{best: math.Inf(-1)}
+			n.children[r] = child
+		}
+		n = child
+		path = append(path, n)
+	}
+	if n.entry == nil {
+		n.entry = &Entry{Value: value}
+		t.size++
+	} else if incr {
+		score += n.entry.Score
+	}
+	n.entry.Score = score
+	if payload != nil {
+		n.entry.Payload = payload
+	}
+	updateBest(path)
+	return score
+}
+
+// updateBest recomputes best of nodes on path from root after score in the last node changed
+func updateBest(path []*node) {
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		best := math.Inf(-1)
+		if n.entry != nil {
+			best = n.entry.Score
+		}
+		for _, child := range n.children {
+			if child.best > best {
+				best = child.best
+			}
+		}
+		if i < len(path)-1 && best == n.best {
+			// ancestors are not affected
+			return
+		}
+		n.best = best
+	}
+}
+
+// Get returns suggestion with exact value
+func (t *Trie) Get(value string) (*Entry, bool) {
+	n := t.root
+	for _, r := range value {
+		child, ok := n.children[r]
+		if !ok {
+			return nil, false
+		}
+		n = child
+	}
+	if n.entry == nil {
+		return nil, false
+	}
+	return n.entry, true
+}
+
+// Remove deletes suggestion, returns true if it existed
+func (t *Trie) Remove(value string) bool {
+	runes := []rune(value)
+	path := make([]*node, 0, len(runes)+1)
+	n := t.root
+	path = append(path, n)
+	for _, r := range runes {
+		child, ok := n.children[r]
+		if !ok {
+			return false
+		}
+		n = child
+		path = append(path, n)
+	}
+	if n.entry == nil {
+		return false
+	}
+	n.entry = nil
+	t.size--
+	// prune nodes which have no entry and no child
+	i := len(runes)
+	for ; i > 0; i-- {
+		cur := path[i]
+		if cur.entry != nil || len(cur.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, runes[i-1])
+	}
+	updateBest(path[:i+1])
+	return true
+}
+
+// ForEach visits all suggestions, returns false in consumer to break
+func (t *Trie) ForEach(consumer func(entry *Entry) bool) {
+	walk(t.root, consumer)
+}
+
+func walk(n *node, consumer func(entry *Entry) bool) bool {
+	if n.entry != nil && !consumer(n.entry) {
+		return false
+	}
+	for _, child := range n.children {
+		if !walk(child, consumer) {
+			return false
+		}
+	}
+	return true
+}
+
+// Suggest returns at most max suggestions starts with prefix, ordered by score descending.
+// If fuzzy is true, suggestions whose prefix is within Levenshtein distance 1 of the given prefix are returned as well.
+// Subtrees are visited in order of their best score, so only nodes which may hold top max suggestions are visited
+func (t *Trie) Suggest(prefix string, fuzzy bool, max int) []*Entry {
+	queue := &searchQueue{}
+	if fuzzy {
+		target := []rune(prefix)
+		row := make([]int, len(target)+1)
+		for i := range row {
+			row[i] = i
+		}
+		fuzzyWalk(t.root, "", target, row, queue)
+	} else {
+		n := t.root
+		for _, r := range prefix {
+			child, ok := n.children[r]
+			if !ok {
+				return nil
+			}
+			n = child
+		}
+		queue.pushNode(n, prefix)
+	}
+	var result []*Entry
+	for queue.Len() > 0 && (max <= 0 || len(result) < max) {
+		item := heap.Pop(queue).(*searchItem)
+		if item.node == nil {
+			result = append(result, item.entry)
+			continue
+		}
+		if item.node.entry != nil {
+			heap.Push(queue, &searchItem{
+				score: item.node.entry.Score,
+				key:   item.key,
+				entry: item.node.entry,
+			})
+		}
+		for r, child := range item.node.children {
+			queue.pushNode(child, item.key+string(r))
+		}
+	}
+	return result
+}
+
+// searchItem is either a subtree or a suggestion waiting to be visited by Suggest
+type searchItem struct {
+	score float64
+	// key is path of subtree or value of suggestion,
+	// path of subtree is less than or equal to any value in it, so ties of score are broken by value correctly
+	key   string
+	node  *node
+	entry *Entry
+}
+
+// searchQueue is a priority queue pops item with higher score and less key first
+type searchQueue []*searchItem
+
+func (q searchQueue) Len() int { return len(q) }
+
+func (q searchQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+	if q[i].key != q[j].key {
+		return q[i].key < q[j].key
+	}
+	// expand subtree before emitting suggestion of same score and key
+	return q[i].node != nil && q[j].node == nil
+}
+
+func (q searchQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *searchQueue) Push(x interface{}) { *q = append(*q, x.(*searchItem)) }
+
+func (q *searchQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return item
+}
+
+func (q *searchQueue) pushNode(n *node, path string) {
+	if n.entry == nil && len(n.children) == 0 {
+		// empty root
+		return
+	}
+	heap.Push(q, &searchItem{
+		score: n.best,
+		key:   path,
+		node:  n,
+	})
+}
+
+// fuzzyWalk walks trie with a row of Levenshtein matrix between path from root and target,
+// once the path is within distance 1 of target the whole subtree matches and is pushed into queue
+func fuzzyWalk(n *node, path string, target []rune, row []int, queue *searchQueue) {
+	if row[len(target)] <= 1 {
+		queue.pushNode(n, path)
+		return
+	}
+	minDist := row[0]
+	for _, d := range row {
+		if d < minDist {
+			minDist = d
+		}
+	}
+	if minDist > 1 {
+		return
+	}
+	for r, child := range n.children {
+		next := make([]int, len(row))
+		next[0] = row[0] + 1
+		for i := 1; i < len(row); i++ {
+			cost := 1
+			if target[i-1] == r {
+				cost = 0
+			}
+			next[i] = minInt(minInt(next[i-1]+1, row[i]+1), row[i-1]+cost)
+		}
+		fuzzyWalk(child, path+string(r), target, next, queue)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,129 @@
+package suggest
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func values(entries []*Entry) []string {
+	result := make([]string, len(entries))
+	for i, e := range entries {
+		result[i] = e.Value
+	}
+	return result
+}
+
+func TestSuggest(t *testing.T) {
+	trie := Make()
+	trie.Add("hello", 1, false, nil)
+	trie.Add("help", 3, false, []byte("p"))
+	trie.Add("helium", 2, false, nil)
+	trie.Add("world", 5, false, nil)
+	if trie.Len() != 4 {
+		t.Fatalf("expected 4, actual %d", trie.Len())
+	}
+	if actual := values(trie.Suggest("hel", false, 0)); !reflect.DeepEqual(actual, []string{"help", "helium", "hello"}) {
+		t.Errorf("unexpected suggestions %v", actual)
+	}
+	if actual := values(trie.Suggest("hel", false, 2)); len(actual) != 2 {
+		t.Errorf("max not work: %v", actual)
+	}
+	if score := trie.Add("hello", 5, true, nil); score != 6 {
+		t.Errorf("expected score 6, actual %f", score)
+	}
+	if actual := values(trie.Suggest("hel", false, 1)); !reflect.DeepEqual(actual, []string{"hello"}) {
+		t.Errorf("unexpected suggestions %v", actual)
+	}
+	// one substitution
+	if actual := values(trie.Suggest("hal", true, 0)); len(actual) != 3 {
+		t.Errorf("unexpected fuzzy suggestions %v", actual)
+	}
+	// one insertion
+	if actual := values(trie.Suggest("wrld", true, 0)); !reflect.DeepEqual(actual, []string{"world"}) {
+		t.Errorf("unexpected fuzzy suggestions %v", actual)
+	}
+	if actual := trie.Suggest("wxyz", true, 0); len(actual) != 0 {
+		t.Errorf("expected no suggestion, actual %v", values(actual))
+	}
+}
+
+func TestSuggestTopK(t *testing.T) {
+	trie := Make()
+	scores := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		b := make([]byte, 1+rand.Intn(5))
+		for j := range b {
+			b[j] = 'a' + byte(rand.Intn(3))
+		}
+		value := string(b)
+		switch rand.Intn(4) {
+		case 0:
+			trie.Remove(value)
+			delete(scores, value)
+		default:
+			// few distinct scores to check ties
+			scores[value] = trie.Add(value, float64(rand.Intn(10)-5), rand.Intn(2) == 0, nil)
+		}
+	}
+	for _, prefix := range []string{"", "a", "ab", "cc"} {
+		var expected []string
+		for value := range scores {
+			if strings.HasPrefix(value, prefix) {
+				expected = append(expected, value)
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool {
+			if scores[expected[i]] != scores[expected[j]] {
+				return scores[expected[i]] > scores[expected[j]]
+			}
+			return expected[i] < expected[j]
+		})
+		if len(expected) > 10 {
+			expected = expected[:10]
+		}
+		if actual := values(trie.Suggest(prefix, false, 10)); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("prefix %q: expected %v, actual %v", prefix, expected, actual)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	trie := Make()
+	trie.Add("abc", 1, false, nil)
+	trie.Add("ab", 1, false, nil)
+	if !trie.Remove("abc") || trie.Remove("abc") {
+		t.Error("unexpected remove result")
+	}
+	if _, ok := trie.Get("ab"); !ok {
+		t.Error("ab should not be removed")
+	}
+	trie.Remove("ab")
+	if len(trie.root.children) != 0 || trie.Len() != 0 {
+		t.Error("empty nodes should be pruned")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	trie := Make()
+	trie.Add("hello", 1.5, false, []byte("payload"))
+	trie.Add("你好", 2, false, nil)
+	trie2, err := Unmarshal(trie.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trie, trie2) {
+		t.Error("trie changed after marshal")
+	}
+}
+
+func TestUnmarshalNaN(t *testing.T) {
+	trie := Make()
+	trie.Add("hello", math.NaN(), false, nil)
+	if _, err := Unmarshal(trie.Marshal()); err == nil {
+		t.Error("expected error for NaN score")
+	}
+}
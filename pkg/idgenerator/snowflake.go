@@ -1,8 +1,9 @@
 package idgenerator
 
 import (
+	"errors"
+	"fmt"
 	"hash/fnv"
-	"log"
 	"sync"
 	"time"
 )
@@ -15,49 +16,105 @@ const (
 	timeLeft    uint8 = 22
 	nodeLeft    uint8 = 10
 	nodeMask    int64 = -1 ^ (-1 << uint64(timeLeft-nodeLeft))
+
+	// MaxNodeID is the largest node id that fits into an ID
+	MaxNodeID = nodeMask
 )
 
+// ErrClockMovedBackwards is returned when the clock moved backwards further than the generator is willing to wait
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
 // IDGenerator generates unique uint64 ID using snowflake algorithm
 type IDGenerator struct {
 	mu        *sync.Mutex
 	lastStamp int64
 	nodeID    int64
 	sequence  int64
-	epoch     time.Time
+	// maxBackward is how long NextID waits for the clock to catch up after a rollback, 0 means fail at once
+	maxBackward time.Duration
+	// now returns milliseconds since epoch0, use a variable to allow injecting a stub for testing
+	now func() int64
 }
 
-// MakeGenerator creates a new IDGenerator
+// MakeGenerator creates a new IDGenerator, node id is derived from hash of the given node name
 func MakeGenerator(node string) *IDGenerator {
 	fnv64 := fnv.New64()
 	_, _ = fnv64.Write([]byte(node))
 	nodeID := int64(fnv64.Sum64()) & nodeMask
+	return makeGenerator(nodeID)
+}
 
-	var curTime = time.Now()
-	epoch := curTime.Add(time.Unix(epoch0/1000, (epoch0%1000)*1000000).Sub(curTime))
+// MakeGeneratorWithNodeID creates a new IDGenerator with the given node id
+func MakeGeneratorWithNodeID(nodeID int64) (*IDGenerator, error) {
+	if nodeID < 0 || nodeID > MaxNodeID {
+		return nil, fmt.Errorf("node id must between 0 and %d", MaxNodeID)
+	}
+	return makeGenerator(nodeID), nil
+}
 
+func makeGenerator(nodeID int64) *IDGenerator {
 	return &IDGenerator{
 		mu:        &sync.Mutex{},
 		lastStamp: -1,
 		nodeID:    nodeID,
 		sequence:  1,
-		epoch:     epoch,
+		now:       wallClock,
+	}
+}
+
+// wallClock reads wall clock rather than monotonic clock, so that rollback of system time could be detected
+func wallClock() int64 {
+	return time.Now().UnixMilli() - epoch0
+}
+
+// Seed makes generator return ids greater than the given one, such as the last id generated before restart,
+// then clock rolled back during restart is detected as well
+func (w *IDGenerator) Seed(id int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	timestamp := id >> timeLeft
+	if timestamp > w.lastStamp {
+		w.lastStamp = timestamp
+		w.sequence = id & maxSequence
+	} else if timestamp == w.lastStamp && id&maxSequence > w.sequence {
+		w.sequence = id & maxSequence
 	}
 }
 
-// NextID returns next unique ID
-func (w *IDGenerator) NextID() int64 {
+// SetMaxBackward sets how long the generator waits for the clock to catch up after the clock moved backwards
+func (w *IDGenerator) SetMaxBackward(d time.Duration) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.maxBackward = d
+}
+
+// NodeID returns the node id embedded in generated IDs
+func (w *IDGenerator) NodeID() int64 {
+	return w.nodeID
+}
 
-	timestamp := time.Since(w.epoch).Nanoseconds() / 1000000
+// NextID returns next unique ID, or ErrClockMovedBackwards if the clock rolled back too far
+func (w *IDGenerator) NextID() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	timestamp := w.now()
 	if timestamp < w.lastStamp {
-		log.Fatal("can not generate id")
+		backward := time.Duration(w.lastStamp-timestamp) * time.Millisecond
+		if backward > w.maxBackward {
+			return 0, fmt.Errorf("%w: refusing to generate id for %d milliseconds", ErrClockMovedBackwards, backward.Milliseconds())
+		}
+		// wait until the clock catches up, the lock is held so no one else would take the same timestamp
+		for timestamp < w.lastStamp {
+			time.Sleep(time.Duration(w.lastStamp-timestamp) * time.Millisecond)
+			timestamp = w.now()
+		}
 	}
 	if w.lastStamp == timestamp {
 		w.sequence = (w.sequence + 1) & maxSequence
 		if w.sequence == 0 {
 			for timestamp <= w.lastStamp {
-				timestamp = time.Since(w.epoch).Nanoseconds() / 1000000
+				timestamp = w.now()
 			}
 		}
 	} else {
@@ -66,5 +123,14 @@ func (w *IDGenerator) NextID() int64 {
 	w.lastStamp = timestamp
 	id := (timestamp << timeLeft) | (w.nodeID << nodeLeft) | w.sequence
 	//fmt.Printf("%d %d %d\n", timestamp, w.sequence, id)
-	return id
+	return id, nil
+}
+
+// Decode splits an ID into its generation time, node id and sequence
+func Decode(id int64) (time.Time, int64, int64) {
+	timestamp := id >> timeLeft
+	nodeID := (id >> nodeLeft) & nodeMask
+	sequence := id & maxSequence
+	ms := timestamp + epoch0
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), nodeID, sequence
 }
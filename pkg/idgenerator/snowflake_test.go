@@ -1,13 +1,20 @@
 package idgenerator
 
-import "testing"
+import (
+	"errors"
+	"testing"
+	"time"
+)
 
 func TestMGenerator(t *testing.T) {
 	gen := MakeGenerator("a")
 	ids := make(map[int64]struct{})
 	size := int(1e6)
 	for i := 0; i < size; i++ {
-		id := gen.NextID()
+		id, err := gen.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
 		_, ok := ids[id]
 		if ok {
 			t.Errorf("duplicated id: %d, time: %d, seq: %d", id, gen.lastStamp, gen.sequence)
@@ -15,3 +22,80 @@ func TestMGenerator(t *testing.T) {
 		ids[id] = struct{}{}
 	}
 }
+
+func TestDecode(t *testing.T) {
+	gen, err := MakeGeneratorWithNodeID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().Add(-time.Millisecond)
+	id, err := gen.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, node, seq := Decode(id)
+	if node != 42 {
+		t.Errorf("expected node 42, actual %d", node)
+	}
+	if seq != gen.sequence {
+		t.Errorf("expected sequence %d, actual %d", gen.sequence, seq)
+	}
+	if ts.Before(before) || ts.After(time.Now()) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	if _, err := MakeGeneratorWithNodeID(MaxNodeID + 1); err == nil {
+		t.Error("expected error for node id out of range")
+	}
+}
+
+func TestClockBackward(t *testing.T) {
+	gen, _ := MakeGeneratorWithNodeID(1)
+	var now int64 = 1000
+	gen.now = func() int64 {
+		return now
+	}
+	first, _ := gen.NextID()
+	now = 990
+	if _, err := gen.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("expected ErrClockMovedBackwards, actual %v", err)
+	}
+
+	gen.SetMaxBackward(time.Second)
+	calls := 0
+	gen.now = func() int64 {
+		calls++
+		if calls > 1 {
+			return 1001
+		}
+		return 990
+	}
+	id, err := gen.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= first {
+		t.Errorf("id should increase after clock catches up, first %d, actual %d", first, id)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	last, _ := MakeGenerator("a").NextID()
+	// clock rolled back during restart
+	gen := MakeGenerator("a")
+	gen.now = func() int64 {
+		return last>>timeLeft - 100
+	}
+	gen.Seed(last)
+	if _, err := gen.NextID(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("expected ErrClockMovedBackwards, actual %v", err)
+	}
+
+	gen = MakeGenerator("a")
+	gen.now = func() int64 {
+		return last >> timeLeft
+	}
+	gen.Seed(last)
+	if id, err := gen.NextID(); err != nil || id <= last {
+		t.Errorf("expected id greater than %d, actual %d, %v", last, id, err)
+	}
+}
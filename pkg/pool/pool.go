@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -41,21 +42,39 @@ func New(factory func() (interface{}, error), finalizer func(x interface{}), cfg
 	}
 }
 
-// getOnNoIdle try to create a new item or waiting for items being returned
+// getOnNoIdle try to create a new item or waiting for items being returned until ctx is done
 // invoker should have pool.mu
-func (pool *Pool) getOnNoIdle() (interface{}, error) {
+func (pool *Pool) getOnNoIdle(ctx context.Context) (interface{}, error) {
 	// Items reach the capacity of the pool, cannot create a new item
 	if pool.activeCount >= pool.MaxActive {
 		// waiting for item being returned
 		req := make(chan interface{}, 1)
 		pool.waitingReqs = append(pool.waitingReqs, req)
 		pool.mu.Unlock()
-		x, ok := <-req
-		// No item can be arranged for the request (reach the item limit)
-		if !ok {
-			return nil, ErrMax
+		select {
+		case x, ok := <-req:
+			// No item can be arranged for the request (reach the item limit)
+			if !ok {
+				return nil, ErrMax
+			}
+			return x, nil
+		case <-ctx.Done():
+			pool.mu.Lock()
+			waiting := false
+			for i, r := range pool.waitingReqs {
+				if r == req {
+					pool.waitingReqs = append(pool.waitingReqs[:i], pool.waitingReqs[i+1:]...)
+					waiting = true
+					break
+				}
+			}
+			pool.mu.Unlock()
+			if !waiting {
+				// an item has been arranged to the request just before giving up, return it
+				pool.Put(<-req)
+			}
+			return nil, ctx.Err()
 		}
-		return x, nil
 	}
 
 	// create a new item
@@ -74,6 +93,11 @@ func (pool *Pool) getOnNoIdle() (interface{}, error) {
 
 // Get try to get an idle item from pool
 func (pool *Pool) Get() (interface{}, error) {
+	return pool.GetContext(context.Background())
+}
+
+// GetContext is like Get but gives up waiting for an item being returned when ctx is done
+func (pool *Pool) GetContext(ctx context.Context) (interface{}, error) {
 	pool.mu.Lock()
 	if pool.closed {
 		pool.mu.Unlock()
@@ -86,7 +110,7 @@ func (pool *Pool) Get() (interface{}, error) {
 		return item, nil
 	default:
 		// no pooled item, create one
-		return pool.getOnNoIdle()
+		return pool.getOnNoIdle(ctx)
 	}
 }
 
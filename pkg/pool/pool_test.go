@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -129,6 +130,40 @@ func TestPool_Waiting(t *testing.T) {
 	}
 }
 
+func TestPool_GetContext(t *testing.T) {
+	factory := func() (interface{}, error) {
+		return &mockConn{
+			open: true,
+		}, nil
+	}
+	finalizer := func(x interface{}) {
+		c := x.(*mockConn)
+		c.open = false
+	}
+	pool := New(factory, finalizer, Config{
+		MaxIdle:   1,
+		MaxActive: 1,
+	})
+	x, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, actual %v", err)
+	}
+	// the request given up should not take the returned item
+	pool.Put(x)
+	if len(pool.waitingReqs) != 0 {
+		t.Errorf("expected no waiting request, actual %d", len(pool.waitingReqs))
+	}
+	y, err := pool.Get()
+	if err != nil || y != x {
+		t.Errorf("expected the returned item, actual %v %v", y, err)
+	}
+}
+
 func TestPool_CreateErr(t *testing.T) {
 	makeErr := true
 	factory := func() (interface{}, error) {
@@ -1,6 +1,8 @@
 package pubsub
 
 import (
+	"sync"
+
 	"slava/pkg/datastruct/dict"
 	"slava/pkg/datastruct/lock"
 )
@@ -11,12 +13,36 @@ type Hub struct {
 	subs dict.Dict
 	// lock channel
 	subsLocker *lock.Locks
+	// shard channel -> list(*Client), shard channels are subscribed by SSUBSCRIBE
+	shardSubs dict.Dict
+	// channel -> set of clients, clients resumed by SUBSCRIBE FROM receive offset of messages of durable topics
+	offsetSubs dict.Dict
+
+	// patternIndex groups patterns by their literal prefix, so PUBLISH only checks
+	// patterns whose prefix is a prefix of the channel instead of all patterns
+	// literal prefix -> pattern -> *patternSubs
+	patternIndex map[string]map[string]*patternSubs
+	patternCount int
+	patternMu    sync.RWMutex
 }
 
 // MakeHub creates new hub
 func MakeHub() *Hub {
 	return &Hub{
-		subs:       dict.MakeConcurrent(4),
-		subsLocker: lock.Make(16),
+		subs:         dict.MakeConcurrent(4),
+		subsLocker:   lock.Make(16),
+		shardSubs:    dict.MakeConcurrent(4),
+		offsetSubs:   dict.MakeConcurrent(4),
+		patternIndex: make(map[string]map[string]*patternSubs),
+	}
+}
+
+// IsEmpty returns whether no one subscribes any channel, it is cheap enough to be called on every write
+func (hub *Hub) IsEmpty() bool {
+	if hub.subs.Len() > 0 {
+		return false
 	}
+	hub.patternMu.RLock()
+	defer hub.patternMu.RUnlock()
+	return hub.patternCount == 0
 }
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hdt3213/godis/datastruct/list"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/datastruct/dict"
+	"slava/pkg/wildcard"
+)
+
+// channels returns active channels in subs matching the given pattern, empty pattern matches all
+func channels(subs dict.Dict, pattern string) ([][]byte, error) {
+	var matcher *wildcard.Pattern
+	if pattern != "" {
+		var err error
+		matcher, err = wildcard.CompilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var result []string
+	subs.ForEach(func(channel string, raw interface{}) bool {
+		if raw.(*list.LinkedList).Len() > 0 && (matcher == nil || matcher.IsMatch(channel)) {
+			result = append(result, channel)
+		}
+		return true
+	})
+	sort.Strings(result)
+	reply := make([][]byte, len(result))
+	for i, channel := range result {
+		reply[i] = []byte(channel)
+	}
+	return reply, nil
+}
+
+// numSub returns channel and its subscribers count in pairs
+func numSub(hub *Hub, subs dict.Dict, args [][]byte) slava.Reply {
+	result := make([]slava.Reply, 0, 2*len(args))
+	for _, arg := range args {
+		channel := string(arg)
+		count := 0
+		hub.subsLocker.RLock(channel)
+		if raw, ok := subs.Get(channel); ok {
+			count = raw.(*list.LinkedList).Len()
+		}
+		hub.subsLocker.RUnLock(channel)
+		result = append(result, protocol.MakeBulkReply(arg), protocol.MakeIntReply(int64(count)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// numPat returns the number of unique patterns subscribed by clients
+func numPat(hub *Hub) int {
+	hub.patternMu.RLock()
+	defer hub.patternMu.RUnlock()
+	count := 0
+	for _, group := range hub.patternIndex {
+		count += len(group)
+	}
+	return count
+}
+
+// PubSub inspects state of hub
+// pubsub channels [pattern]
+// pubsub numsub [channel ...]
+// pubsub numpat
+// pubsub shardchannels [pattern]
+// pubsub shardnumsub [channel ...]
+func PubSub(hub *Hub, args [][]byte) slava.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels", "shardchannels":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("pubsub|" + subCmd)
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1])
+		}
+		subs := hub.subs
+		if subCmd == "shardchannels" {
+			subs = hub.shardSubs
+		}
+		result, err := channels(subs, pattern)
+		if err != nil {
+			return protocol.MakeErrReply("ERR illegal pattern: " + err.Error())
+		}
+		if len(result) == 0 {
+			return protocol.MakeEmptyMultiBulkReply()
+		}
+		return protocol.MakeMultiBulkReply(result)
+	case "numsub":
+		return numSub(hub, hub.subs, args[1:])
+	case "shardnumsub":
+		return numSub(hub, hub.shardSubs, args[1:])
+	case "numpat":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return protocol.MakeIntReply(int64(numPat(hub)))
+	case "help":
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("CHANNELS [<pattern>]"),
+			[]byte("    Return the currently active channels matching a <pattern> (default: '*')."),
+			[]byte("NUMPAT"),
+			[]byte("    Return number of subscriptions to patterns."),
+			[]byte("NUMSUB [<channel> ...]"),
+			[]byte("    Return the number of subscribers for the specified channels, excluding"),
+			[]byte("    pattern subscriptions(default: no channels)."),
+			[]byte("SHARDCHANNELS [<pattern>]"),
+			[]byte("    Return the currently active shard level channels matching a <pattern> (default: '*')."),
+			[]byte("SHARDNUMSUB [<shardchannel> ...]"),
+			[]byte("    Return the number of subscribers for the specified shard level channel(s)"),
+		})
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+}
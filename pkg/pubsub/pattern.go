@@ -0,0 +1,173 @@
+package pubsub
+
+import (
+	"github.com/hdt3213/godis/datastruct/list"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/pkg/wildcard"
+)
+
+var (
+	_psubscribe         = "psubscribe"
+	_punsubscribe       = "punsubscribe"
+	pmessageBytes       = []byte("pmessage")
+	pUnSubscribeNothing = []byte("*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:0\r\n")
+)
+
+// patternSubs holds subscribers of a pattern
+type patternSubs struct {
+	pattern     *wildcard.Pattern
+	subscribers *list.LinkedList
+}
+
+// literalPrefix returns the prefix of pattern before the first special character,
+// any channel matching the pattern must start with it
+func literalPrefix(pattern string) string {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[', '\\':
+			return pattern[:i]
+		}
+	}
+	return pattern
+}
+
+/*
+ * invoker should hold hub.patternMu
+ * return: is new subscribed
+ */
+func psubscribe0(hub *Hub, pattern string, compiled *wildcard.Pattern, client slava.Connection) bool {
+	client.PSubscribe(pattern)
+
+	prefix := literalPrefix(pattern)
+	group, ok := hub.patternIndex[prefix]
+	if !ok {
+		group = make(map[string]*patternSubs)
+		hub.patternIndex[prefix] = group
+	}
+	ps, ok := group[pattern]
+	if !ok {
+		ps = &patternSubs{
+			pattern:     compiled,
+			subscribers: list.Make(),
+		}
+		group[pattern] = ps
+	}
+	if ps.subscribers.Contains(client) {
+		return false
+	}
+	ps.subscribers.Add(client)
+	hub.patternCount++
+	return true
+}
+
+/*
+ * invoker should hold hub.patternMu
+ * return: is actually un-subscribe
+ */
+func punsubscribe0(hub *Hub, pattern string, client slava.Connection) bool {
+	client.PUnSubscribe(pattern)
+
+	prefix := literalPrefix(pattern)
+	group, ok := hub.patternIndex[prefix]
+	if !ok {
+		return false
+	}
+	ps, ok := group[pattern]
+	if !ok {
+		return false
+	}
+	removed := ps.subscribers.RemoveAllByVal(client)
+	hub.patternCount -= removed
+	if ps.subscribers.Len() == 0 {
+		// clean
+		delete(group, pattern)
+		if len(group) == 0 {
+			delete(hub.patternIndex, prefix)
+		}
+	}
+	return removed > 0
+}
+
+// PSubscribe puts the given connection into the given patterns
+func PSubscribe(hub *Hub, c slava.Connection, args [][]byte) slava.Reply {
+	patterns := make([]string, len(args))
+	compiled := make([]*wildcard.Pattern, len(args))
+	for i, b := range args {
+		pattern, err := wildcard.CompilePattern(string(b))
+		if err != nil {
+			return protocol.MakeErrReply("ERR illegal pattern: " + err.Error())
+		}
+		patterns[i] = string(b)
+		compiled[i] = pattern
+	}
+
+	hub.patternMu.Lock()
+	defer hub.patternMu.Unlock()
+
+	for i, pattern := range patterns {
+		psubscribe0(hub, pattern, compiled[i], c)
+		// redis replies for every given pattern even if it has been subscribed
+		_, _ = c.Write(makeMsg(_psubscribe, pattern, int64(c.SubsCount())))
+	}
+	return &protocol.NoReply{}
+}
+
+// PUnSubscribe removes the given connection from the given patterns, or from all patterns if no one is given
+func PUnSubscribe(hub *Hub, c slava.Connection, args [][]byte) slava.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, b := range args {
+			patterns[i] = string(b)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+
+	hub.patternMu.Lock()
+	defer hub.patternMu.Unlock()
+
+	if len(patterns) == 0 {
+		_, _ = c.Write(pUnSubscribeNothing)
+		return &protocol.NoReply{}
+	}
+
+	for _, pattern := range patterns {
+		punsubscribe0(hub, pattern, c)
+		_, _ = c.Write(makeMsg(_punsubscribe, pattern, int64(c.SubsCount())))
+	}
+	return &protocol.NoReply{}
+}
+
+// publishToPatterns sends msg to clients subscribing patterns matching the channel, returns number of receivers
+func publishToPatterns(hub *Hub, channel string, message []byte) int {
+	hub.patternMu.RLock()
+	defer hub.patternMu.RUnlock()
+
+	if hub.patternCount == 0 {
+		return 0
+	}
+	count := 0
+	// only patterns whose literal prefix is a prefix of channel could match
+	for i := 0; i <= len(channel); i++ {
+		group, ok := hub.patternIndex[channel[:i]]
+		if !ok {
+			continue
+		}
+		for pattern, ps := range group {
+			if !ps.pattern.IsMatch(channel) {
+				continue
+			}
+			replyArgs := [][]byte{pmessageBytes, []byte(pattern), []byte(channel), message}
+			payload := protocol.MakeMultiBulkReply(replyArgs).ToBytes()
+			ps.subscribers.ForEach(func(i int, c interface{}) bool {
+				client, _ := c.(slava.Connection)
+				_, _ = client.Write(payload)
+				return true
+			})
+			count += ps.subscribers.Len()
+		}
+	}
+	return count
+}
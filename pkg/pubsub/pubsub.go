@@ -4,7 +4,6 @@ import (
 	"github.com/hdt3213/godis/datastruct/list"
 	"slava/internal/interface/slava"
 	"slava/internal/protocol"
-	"slava/internal/utils"
 	"strconv"
 )
 
@@ -12,7 +11,7 @@ var (
 	_subscribe         = "subscribe"
 	_unsubscribe       = "unsubscribe"
 	messageBytes       = []byte("message")
-	unSubscribeNothing = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\n:0\r\n")
+	unSubscribeNothing = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
 )
 
 func makeMsg(t string, channel string, code int64) []byte {
@@ -37,9 +36,7 @@ func subscribe0(hub *Hub, channel string, client slava.Connection) bool {
 		subscribers = list.Make()
 		hub.subs.Put(channel, subscribers)
 	}
-	if subscribers.Contains(func(a interface{}) bool {
-		return a == client
-	}) {
+	if subscribers.Contains(client) {
 		return false
 	}
 	subscribers.Add(client)
@@ -53,13 +50,19 @@ func subscribe0(hub *Hub, channel string, client slava.Connection) bool {
 func unsubscribe0(hub *Hub, channel string, client slava.Connection) bool {
 	client.UnSubscribe(channel)
 
+	if raw, ok := hub.offsetSubs.Get(channel); ok {
+		offsetSubs, _ := raw.(map[slava.Connection]struct{})
+		delete(offsetSubs, client)
+		if len(offsetSubs) == 0 {
+			hub.offsetSubs.Remove(channel)
+		}
+	}
+
 	// remove from hub.subs
 	raw, ok := hub.subs.Get(channel)
 	if ok {
 		subscribers, _ := raw.(*list.LinkedList)
-		subscribers.RemoveAllByVal(func(a interface{}) bool {
-			return utils.Equals(a, client)
-		})
+		subscribers.RemoveAllByVal(client)
 
 		if subscribers.Len() == 0 {
 			// clean
@@ -81,24 +84,55 @@ func Subscribe(hub *Hub, c slava.Connection, args [][]byte) slava.Reply {
 	defer hub.subsLocker.UnLocks(channels...)
 
 	for _, channel := range channels {
-		if subscribe0(hub, channel, c) {
-			_, _ = c.Write(makeMsg(_subscribe, channel, int64(c.SubsCount())))
-		}
+		subscribe0(hub, channel, c)
+		// redis replies for every given channel even if it has been subscribed
+		_, _ = c.Write(makeMsg(_subscribe, channel, int64(c.SubsCount())))
 	}
 	return &protocol.NoReply{}
 }
 
-// UnsubscribeAll removes the given connection from all subscribing channel
+// SubscribeWithOffset puts the given connection into the given channel, the connection receives
+// offset of messages published by PublishWithOffset as the 4th element of message
+func SubscribeWithOffset(hub *Hub, c slava.Connection, channel string) slava.Reply {
+	hub.subsLocker.Lock(channel)
+	defer hub.subsLocker.UnLock(channel)
+
+	subscribe0(hub, channel, c)
+	raw, ok := hub.offsetSubs.Get(channel)
+	var offsetSubs map[slava.Connection]struct{}
+	if ok {
+		offsetSubs, _ = raw.(map[slava.Connection]struct{})
+	} else {
+		offsetSubs = make(map[slava.Connection]struct{})
+		hub.offsetSubs.Put(channel, offsetSubs)
+	}
+	offsetSubs[c] = struct{}{}
+	_, _ = c.Write(makeMsg(_subscribe, channel, int64(c.SubsCount())))
+	return &protocol.NoReply{}
+}
+
+// UnsubscribeAll removes the given connection from all subscribing channels and patterns
 func UnsubscribeAll(hub *Hub, c slava.Connection) {
 	channels := c.GetChannels()
 
 	hub.subsLocker.Locks(channels...)
-	defer hub.subsLocker.UnLocks(channels...)
-
 	for _, channel := range channels {
 		unsubscribe0(hub, channel, c)
 	}
+	hub.subsLocker.UnLocks(channels...)
+
+	shardChannels := c.GetShardChannels()
+	hub.subsLocker.Locks(shardChannels...)
+	for _, channel := range shardChannels {
+		sunsubscribe0(hub, channel, c)
+	}
+	hub.subsLocker.UnLocks(shardChannels...)
 
+	hub.patternMu.Lock()
+	defer hub.patternMu.Unlock()
+	for _, pattern := range c.GetPatterns() {
+		punsubscribe0(hub, pattern, c)
+	}
 }
 
 // UnSubscribe removes the given connection from the given channel
@@ -122,37 +156,74 @@ func UnSubscribe(db *Hub, c slava.Connection, args [][]byte) slava.Reply {
 	}
 
 	for _, channel := range channels {
-		if unsubscribe0(db, channel, c) {
-			_, _ = c.Write(makeMsg(_unsubscribe, channel, int64(c.SubsCount())))
-		}
+		unsubscribe0(db, channel, c)
+		_, _ = c.Write(makeMsg(_unsubscribe, channel, int64(c.SubsCount())))
 	}
 	return &protocol.NoReply{}
 }
 
-// Publish send msg to all subscribing client
+// Publish send msg to all subscribing client, including clients subscribing matched patterns
 func Publish(hub *Hub, args [][]byte) slava.Reply {
 	if len(args) != 2 {
 		return &protocol.ArgNumErrReply{Cmd: "publish"}
 	}
 	channel := string(args[0])
 	message := args[1]
+	count := publishToChannel(hub, channel, message, nil)
+	count += publishToPatterns(hub, channel, message)
+	return protocol.MakeIntReply(int64(count))
+}
+
+// PublishWithOffset is Publish for durable topics, clients subscribing the channel by SubscribeWithOffset
+// receive offset of the message as the 4th element so that they can resume from it,
+// other clients receive normal message and pmessage which have 3 and 4 elements as standard clients expect
+func PublishWithOffset(hub *Hub, args [][]byte, offset []byte) slava.Reply {
+	if len(args) != 2 {
+		return &protocol.ArgNumErrReply{Cmd: "publish"}
+	}
+	channel := string(args[0])
+	message := args[1]
+	count := publishToChannel(hub, channel, message, offset)
+	count += publishToPatterns(hub, channel, message)
+	return protocol.MakeIntReply(int64(count))
+}
+
+// MakeMessage returns message frame sent to subscribers, offset is omitted if nil
+func MakeMessage(channel string, message []byte, offset []byte) []byte {
+	replyArgs := [][]byte{messageBytes, []byte(channel), message}
+	if offset != nil {
+		replyArgs = append(replyArgs, offset)
+	}
+	return protocol.MakeMultiBulkReply(replyArgs).ToBytes()
+}
 
+// publishToChannel sends msg to clients subscribing the channel, returns number of receivers
+func publishToChannel(hub *Hub, channel string, message []byte, offset []byte) int {
 	hub.subsLocker.Lock(channel)
 	defer hub.subsLocker.UnLock(channel)
 
 	raw, ok := hub.subs.Get(channel)
 	if !ok {
-		return protocol.MakeIntReply(0)
+		return 0
 	}
 	subscribers, _ := raw.(*list.LinkedList)
+	msg := MakeMessage(channel, message, nil)
+	var offsetSubs map[slava.Connection]struct{}
+	var offsetMsg []byte
+	if offset != nil {
+		if raw, ok := hub.offsetSubs.Get(channel); ok {
+			offsetSubs, _ = raw.(map[slava.Connection]struct{})
+			offsetMsg = MakeMessage(channel, message, offset)
+		}
+	}
 	subscribers.ForEach(func(i int, c interface{}) bool {
 		client, _ := c.(slava.Connection)
-		replyArgs := make([][]byte, 3)
-		replyArgs[0] = messageBytes
-		replyArgs[1] = []byte(channel)
-		replyArgs[2] = message
-		_, _ = client.Write(protocol.MakeMultiBulkReply(replyArgs).ToBytes())
+		if _, ok := offsetSubs[client]; ok {
+			_, _ = client.Write(offsetMsg)
+		} else {
+			_, _ = client.Write(msg)
+		}
 		return true
 	})
-	return protocol.MakeIntReply(int64(subscribers.Len()))
+	return subscribers.Len()
 }
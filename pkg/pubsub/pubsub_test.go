@@ -0,0 +1,167 @@
+package pubsub
+
+import (
+	"testing"
+
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+	"slava/internal/utils"
+	"slava/pkg/connection"
+)
+
+func TestLiteralPrefix(t *testing.T) {
+	cases := map[string]string{
+		"news.*":    "news.",
+		"news":      "news",
+		"*":         "",
+		"h?llo":     "h",
+		"h[ae]llo":  "h",
+		"a\\*b*":    "a",
+		"__key*__:": "__key",
+	}
+	for pattern, expected := range cases {
+		if actual := literalPrefix(pattern); actual != expected {
+			t.Errorf("prefix of %s: expected %s, actual %s", pattern, expected, actual)
+		}
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	hub := MakeHub()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	Subscribe(hub, c1, utils.ToCmdLine("news.tech"))
+	PSubscribe(hub, c1, utils.ToCmdLine("news.*", "news.*", "*"))
+	expected := string(makeMsg(_subscribe, "news.tech", 1)) +
+		string(makeMsg(_psubscribe, "news.*", 2)) +
+		string(makeMsg(_psubscribe, "news.*", 2)) +
+		string(makeMsg(_psubscribe, "*", 3))
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, c1.Bytes())
+	}
+	PSubscribe(hub, c2, utils.ToCmdLine("sport.*"))
+	c1.Clean()
+	c2.Clean()
+
+	reply := Publish(hub, utils.ToCmdLine("news.tech", "hello"))
+	if code := reply.(*protocol.IntReply).Code; code != 3 {
+		t.Errorf("expected 3 receivers, actual %d", code)
+	}
+	if len(c2.Bytes()) != 0 {
+		t.Errorf("sport.* should not receive message of news.tech")
+	}
+	expected = string(protocol.MakeMultiBulkReply(utils.ToCmdLine("message", "news.tech", "hello")).ToBytes())
+	if string(c1.Bytes()[:len(expected)]) != expected {
+		t.Errorf("message should be delivered to channel subscribers first, actual %q", c1.Bytes())
+	}
+	for _, pattern := range []string{"news.*", "*"} {
+		pmessage := protocol.MakeMultiBulkReply(utils.ToCmdLine("pmessage", pattern, "news.tech", "hello")).ToBytes()
+		expected += string(pmessage)
+	}
+	if len(c1.Bytes()) != len(expected) {
+		t.Errorf("expected %q, actual %q", expected, c1.Bytes())
+	}
+
+	c1.Clean()
+	PUnSubscribe(hub, c1, utils.ToCmdLine("news.*"))
+	expected = string(makeMsg(_punsubscribe, "news.*", 2))
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, c1.Bytes())
+	}
+
+	UnsubscribeAll(hub, c1)
+	UnsubscribeAll(hub, c2)
+	if !hub.IsEmpty() || len(hub.patternIndex) != 0 {
+		t.Errorf("hub should be empty after all clients leave")
+	}
+	if reply := Publish(hub, utils.ToCmdLine("news.tech", "hello")); reply.(*protocol.IntReply).Code != 0 {
+		t.Errorf("nobody should receive the message")
+	}
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	hub := MakeHub()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	Subscribe(hub, c1, utils.ToCmdLine("news.tech", "news.sport", "weather"))
+	Subscribe(hub, c2, utils.ToCmdLine("news.tech"))
+	PSubscribe(hub, c1, utils.ToCmdLine("news.*", "*"))
+	PSubscribe(hub, c2, utils.ToCmdLine("news.*"))
+
+	reply := PubSub(hub, utils.ToCmdLine("channels", "news.*"))
+	expected := protocol.MakeMultiBulkReply(utils.ToCmdLine("news.sport", "news.tech"))
+	if string(reply.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected %q, actual %q", expected.ToBytes(), reply.ToBytes())
+	}
+	reply = PubSub(hub, utils.ToCmdLine("numsub", "news.tech", "weather", "nothing"))
+	expected2 := protocol.MakeMultiRawReply([]slava.Reply{
+		protocol.MakeBulkReply([]byte("news.tech")), protocol.MakeIntReply(2),
+		protocol.MakeBulkReply([]byte("weather")), protocol.MakeIntReply(1),
+		protocol.MakeBulkReply([]byte("nothing")), protocol.MakeIntReply(0),
+	})
+	if string(reply.ToBytes()) != string(expected2.ToBytes()) {
+		t.Errorf("expected %q, actual %q", expected2.ToBytes(), reply.ToBytes())
+	}
+	reply = PubSub(hub, utils.ToCmdLine("numpat"))
+	if code := reply.(*protocol.IntReply).Code; code != 2 {
+		t.Errorf("expected 2 patterns, actual %d", code)
+	}
+
+	UnSubscribe(hub, c1, utils.ToCmdLine("weather"))
+	reply = PubSub(hub, utils.ToCmdLine("channels"))
+	expected = protocol.MakeMultiBulkReply(utils.ToCmdLine("news.sport", "news.tech"))
+	if string(reply.ToBytes()) != string(expected.ToBytes()) {
+		t.Errorf("expected %q, actual %q", expected.ToBytes(), reply.ToBytes())
+	}
+	reply = PubSub(hub, utils.ToCmdLine("shardchannels"))
+	if _, ok := reply.(*protocol.EmptyMultiBulkReply); !ok {
+		t.Errorf("expected empty shard channels, actual %q", reply.ToBytes())
+	}
+	if reply = PubSub(hub, utils.ToCmdLine("unknown")); !protocol.IsErrorReply(reply) {
+		t.Errorf("unknown subcommand should be rejected")
+	}
+}
+
+func TestSSubscribe(t *testing.T) {
+	hub := MakeHub()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	SSubscribe(hub, c1, utils.ToCmdLine("orders", "users"))
+	Subscribe(hub, c2, utils.ToCmdLine("orders"))
+	expected := string(makeMsg(_ssubscribe, "orders", 1)) + string(makeMsg(_ssubscribe, "users", 2))
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, c1.Bytes())
+	}
+	c1.Clean()
+	c2.Clean()
+
+	// shard channels and channels are isolated from each other
+	reply := SPublish(hub, utils.ToCmdLine("orders", "hello"))
+	if code := reply.(*protocol.IntReply).Code; code != 1 {
+		t.Errorf("expected 1 receiver, actual %d", code)
+	}
+	expected = string(protocol.MakeMultiBulkReply(utils.ToCmdLine("smessage", "orders", "hello")).ToBytes())
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, c1.Bytes())
+	}
+	if len(c2.Bytes()) != 0 {
+		t.Errorf("subscriber of channel should not receive shard message")
+	}
+	reply = PubSub(hub, utils.ToCmdLine("shardchannels"))
+	expected = string(protocol.MakeMultiBulkReply(utils.ToCmdLine("orders", "users")).ToBytes())
+	if string(reply.ToBytes()) != expected {
+		t.Errorf("expected %q, actual %q", expected, reply.ToBytes())
+	}
+
+	c1.Clean()
+	SUnSubscribe(hub, c1, nil)
+	if len(c1.GetShardChannels()) != 0 || hub.shardSubs.Len() != 0 {
+		t.Errorf("all shard channels should be unsubscribed")
+	}
+	c1.Clean()
+	SUnSubscribe(hub, c1, nil)
+	if string(c1.Bytes()) != string(sUnSubscribeNothing) {
+		t.Errorf("expected %q, actual %q", sUnSubscribeNothing, c1.Bytes())
+	}
+	UnsubscribeAll(hub, c2)
+}
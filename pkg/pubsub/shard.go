@@ -0,0 +1,126 @@
+package pubsub
+
+import (
+	"github.com/hdt3213/godis/datastruct/list"
+	"slava/internal/interface/slava"
+	"slava/internal/protocol"
+)
+
+var (
+	_ssubscribe         = "ssubscribe"
+	_sunsubscribe       = "sunsubscribe"
+	smessageBytes       = []byte("smessage")
+	sUnSubscribeNothing = []byte("*3\r\n$12\r\nsunsubscribe\r\n$-1\r\n:0\r\n")
+)
+
+/*
+ * invoker should lock channel
+ * return: is new subscribed
+ */
+func ssubscribe0(hub *Hub, channel string, client slava.Connection) bool {
+	client.SSubscribe(channel)
+
+	raw, ok := hub.shardSubs.Get(channel)
+	var subscribers *list.LinkedList
+	if ok {
+		subscribers, _ = raw.(*list.LinkedList)
+	} else {
+		subscribers = list.Make()
+		hub.shardSubs.Put(channel, subscribers)
+	}
+	if subscribers.Contains(client) {
+		return false
+	}
+	subscribers.Add(client)
+	return true
+}
+
+/*
+ * invoker should lock channel
+ * return: is actually un-subscribe
+ */
+func sunsubscribe0(hub *Hub, channel string, client slava.Connection) bool {
+	client.SUnSubscribe(channel)
+
+	raw, ok := hub.shardSubs.Get(channel)
+	if ok {
+		subscribers, _ := raw.(*list.LinkedList)
+		subscribers.RemoveAllByVal(client)
+
+		if subscribers.Len() == 0 {
+			hub.shardSubs.Remove(channel)
+		}
+		return true
+	}
+	return false
+}
+
+// SSubscribe puts the given connection into the given shard channels
+// shard channels are isolated from channels of SUBSCRIBE, messages are only published by SPUBLISH
+func SSubscribe(hub *Hub, c slava.Connection, args [][]byte) slava.Reply {
+	channels := make([]string, len(args))
+	for i, b := range args {
+		channels[i] = string(b)
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	for _, channel := range channels {
+		ssubscribe0(hub, channel, c)
+		_, _ = c.Write(makeMsg(_ssubscribe, channel, int64(len(c.GetShardChannels()))))
+	}
+	return &protocol.NoReply{}
+}
+
+// SUnSubscribe removes the given connection from the given shard channels, or all shard channels if no one given
+func SUnSubscribe(hub *Hub, c slava.Connection, args [][]byte) slava.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, b := range args {
+			channels[i] = string(b)
+		}
+	} else {
+		channels = c.GetShardChannels()
+	}
+
+	hub.subsLocker.Locks(channels...)
+	defer hub.subsLocker.UnLocks(channels...)
+
+	if len(channels) == 0 {
+		_, _ = c.Write(sUnSubscribeNothing)
+		return &protocol.NoReply{}
+	}
+
+	for _, channel := range channels {
+		sunsubscribe0(hub, channel, c)
+		_, _ = c.Write(makeMsg(_sunsubscribe, channel, int64(len(c.GetShardChannels()))))
+	}
+	return &protocol.NoReply{}
+}
+
+// SPublish sends msg to clients subscribing the shard channel, patterns are not matched
+func SPublish(hub *Hub, args [][]byte) slava.Reply {
+	if len(args) != 2 {
+		return &protocol.ArgNumErrReply{Cmd: "spublish"}
+	}
+	channel := string(args[0])
+	message := args[1]
+
+	hub.subsLocker.Lock(channel)
+	defer hub.subsLocker.UnLock(channel)
+
+	raw, ok := hub.shardSubs.Get(channel)
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	subscribers, _ := raw.(*list.LinkedList)
+	subscribers.ForEach(func(i int, c interface{}) bool {
+		client, _ := c.(slava.Connection)
+		replyArgs := [][]byte{smessageBytes, []byte(channel), message}
+		_, _ = client.Write(protocol.MakeMultiBulkReply(replyArgs).ToBytes())
+		return true
+	})
+	return protocol.MakeIntReply(int64(subscribers.Len()))
+}